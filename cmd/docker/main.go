@@ -72,7 +72,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := vicbackends.Init(cli.portLayerAddr); err != nil {
+	if err := vicbackends.Init(cli.portLayerAddr, &vchConfig); err != nil {
 		log.Fatalf("failed to initialize backend: %s", err)
 	}
 
@@ -24,6 +24,9 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -41,6 +44,19 @@ import (
 // (1024 NULL bytes)
 const DigestSHA256EmptyTar = string(dlayer.DigestSHA256EmptyTar)
 
+const (
+	// MediaTypeManifestV1 is the media type of a signed schema 1 manifest
+	MediaTypeManifestV1 = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// MediaTypeManifestV2 is the media type of a schema 2 manifest
+	MediaTypeManifestV2 = "application/vnd.docker.distribution.manifest.v2+json"
+	// MediaTypeManifestList is the media type of a manifest list
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// the platform containerVMs run on, used to pick a manifest from a manifest list
+	platformOS           = "linux"
+	platformArchitecture = "amd64"
+)
+
 // FSLayer is a container struct for BlobSums defined in an image manifest
 type FSLayer struct {
 	// BlobSum is the tarsum of the referenced filesystem image layer
@@ -52,14 +68,44 @@ type History struct {
 	V1Compatibility string `json:"v1Compatibility"`
 }
 
-// Manifest represents the Docker Manifest file
+// Platform describes the platform the image a manifest list entry refers to runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Descriptor references a blob or a manifest by its digest
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Digest    string    `json:"digest"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Manifest represents the Docker Manifest file.  It holds the fields of
+// schema 1 and schema 2 manifests, and of manifest lists, as the schema is
+// only known once the manifest has been fetched.
 type Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType,omitempty"`
+
 	Name     string    `json:"name"`
 	Tag      string    `json:"tag"`
 	Digest   string    `json:"digest,omitempty"`
 	FSLayers []FSLayer `json:"fsLayers"`
 	History  []History `json:"history"`
 	// ignoring signatures
+
+	// schema 2
+	Config Descriptor   `json:"config"`
+	Layers []Descriptor `json:"layers"`
+
+	// manifest list
+	Manifests []Descriptor `json:"manifests"`
+
+	// imageConfig is the image config blob referenced by a schema 2 manifest
+	imageConfig []byte
 }
 
 // LearnRegistryURL returns the registry URL after making sure that it responds to queries
@@ -80,6 +126,8 @@ func LearnRegistryURL(options ImageCOptions) (string, error) {
 			Username:           options.username,
 			Password:           options.password,
 			InsecureSkipVerify: options.insecureSkipVerify,
+			RootCAs:            options.rootCAs,
+			ClientCertificates: options.clientCertificates,
 		})
 		headers, err := fetcher.Head(url)
 		if err != nil {
@@ -112,7 +160,10 @@ func LearnAuthURL(options ImageCOptions) (*url.URL, error) {
 	if err != nil {
 		return nil, err
 	}
-	url.Path = path.Join(url.Path, options.image, "manifests", options.tag)
+	// without an image the registry itself is asked, as when logging in
+	if options.image != "" {
+		url.Path = path.Join(url.Path, options.image, "manifests", options.tag)
+	}
 
 	log.Debugf("URL: %s", url)
 
@@ -121,11 +172,17 @@ func LearnAuthURL(options ImageCOptions) (*url.URL, error) {
 		Username:           options.username,
 		Password:           options.password,
 		InsecureSkipVerify: options.insecureSkipVerify,
+		RootCAs:            options.rootCAs,
+		ClientCertificates: options.clientCertificates,
 	})
 	// We expect docker registry to return a 401 to us - with a WWW-Authenticate header
 	// We parse that header and learn the OAuth endpoint to fetch OAuth token.
 	_, err = fetcher.Fetch(url)
 	if err != nil && fetcher.IsStatusUnauthorized() {
+		// a registry using basic auth has refused the credentials
+		if fetcher.AuthURL() == nil {
+			return nil, err
+		}
 		return fetcher.AuthURL(), nil
 	}
 
@@ -155,9 +212,15 @@ func FetchToken(url *url.URL) (*Token, error) {
 		Username:           options.username,
 		Password:           options.password,
 		InsecureSkipVerify: options.insecureSkipVerify,
+		RootCAs:            options.rootCAs,
+		ClientCertificates: options.clientCertificates,
 	})
 	tokenFileName, err := fetcher.Fetch(url)
 	if err != nil {
+		// the token service refuses credentials it doesn't accept
+		if fetcher.IsStatusUnauthorized() && options.username != "" {
+			return nil, fmt.Errorf("unauthorized: incorrect username or password")
+		}
 		return nil, err
 	}
 
@@ -186,6 +249,31 @@ func FetchToken(url *url.URL) (*Token, error) {
 	return token, nil
 }
 
+// Login checks the credentials given to imagec against the registry, using
+// the token service of the registry if it has one
+func Login(options ImageCOptions) error {
+	defer trace.End(trace.Begin(options.registry))
+
+	registry, err := LearnRegistryURL(options)
+	if err != nil {
+		return err
+	}
+	options.registry = registry
+
+	url, err := LearnAuthURL(options)
+	if err != nil {
+		return err
+	}
+
+	// the registry has accepted the credentials itself
+	if url == nil {
+		return nil
+	}
+
+	_, err = FetchToken(url)
+	return err
+}
+
 // FetchImageBlob fetches the image blob
 func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) (string, error) {
 	defer trace.End(trace.Begin(options.image + "/" + image.layer.BlobSum))
@@ -211,13 +299,33 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) (string, error)
 		Password:           options.password,
 		Token:              options.token,
 		InsecureSkipVerify: options.insecureSkipVerify,
+		RootCAs:            options.rootCAs,
+		ClientCertificates: options.clientCertificates,
 	})
-	imageFileName, err := fetcher.FetchWithProgress(url, image.String())
+
+	// Ensure the parent directory exists
+	destination := path.Join(DestinationDirectory(), id)
+	err = os.MkdirAll(destination, 0755)
 	if err != nil {
 		return diffID, err
 	}
 
-	// Cleanup function for the error case
+	// Only one pull at a time may write the partial download of a layer
+	lock, err := lockLayer(destination, id)
+	if err != nil {
+		return diffID, err
+	}
+	defer lock.Close()
+
+	// The blob is downloaded next to its final destination so that a pull
+	// that fails part way through can be resumed by the next one
+	imageFileName := path.Join(destination, id+".tar.partial")
+	err = fetcher.FetchWithProgress(url, image.String(), imageFileName)
+	if err != nil {
+		return diffID, err
+	}
+
+	// Cleanup function for the error case, the download can't be trusted
 	defer func() {
 		if err != nil {
 			os.Remove(imageFileName)
@@ -260,6 +368,12 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) (string, error)
 
 	diffID = fmt.Sprintf("sha256:%x", diffIDSum.Sum(nil))
 
+	// schema 2 layers are named after the diffIDs listed in the image config
+	if image.diffID != "" && diffID != image.diffID {
+		err = fmt.Errorf("Failed to validate layer diffID. Expected %s got %s", image.diffID, diffID)
+		return diffID, err
+	}
+
 	// this isn't an empty layer, so we need to calculate the size
 	if diffID != string(DigestSHA256EmptyTar) {
 		var layerSize int64
@@ -293,14 +407,7 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) (string, error)
 
 	log.Infof("diffID for layer %s: %s", id, diffID)
 
-	// Ensure the parent directory exists
-	destination := path.Join(DestinationDirectory(), id)
-	err = os.MkdirAll(destination, 0755)
-	if err != nil {
-		return diffID, err
-	}
-
-	// Move(rename) the temporary file to its final destination
+	// Move(rename) the downloaded file to its final destination
 	err = os.Rename(string(imageFileName), path.Join(destination, id+".tar"))
 	if err != nil {
 		return diffID, err
@@ -317,15 +424,165 @@ func FetchImageBlob(options ImageCOptions, image *ImageWithMeta) (string, error)
 	return diffID, nil
 }
 
-// FetchImageManifest fetches the image manifest file
+// partialExpiry is how long a partial download is kept for a later pull to resume
+const partialExpiry = 24 * time.Hour
+
+// lockLayer takes an exclusive lock on the download of layer id into destination, waiting for any
+// other pull of the same layer to finish with it.  Closing the returned file releases the lock.  A
+// partial download that has been left for longer than partialExpiry is discarded rather than
+// resumed.
+func lockLayer(destination, id string) (*os.File, error) {
+	lock, err := os.OpenFile(path.Join(destination, id+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	removeStalePartial(path.Join(destination, id+".tar.partial"))
+
+	return lock, nil
+}
+
+// removeStalePartial removes a partial download that hasn't been written for partialExpiry
+func removeStalePartial(partial string) {
+	fi, err := os.Stat(partial)
+	if err != nil || time.Since(fi.ModTime()) < partialExpiry {
+		return
+	}
+
+	log.Infof("Removing stale partial download %s", partial)
+	if err = os.Remove(partial); err != nil {
+		log.Warnf("Failed to remove stale partial download %s: %s", partial, err)
+	}
+}
+
+// RemoveStalePartials removes the partial downloads under dir that have been abandoned for longer
+// than partialExpiry, skipping any whose layer another pull currently holds.
+func RemoveStalePartials(dir string) error {
+	defer trace.End(trace.Begin(dir))
+
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the destination doesn't exist until the first pull
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(file, ".tar.partial") {
+			return nil
+		}
+
+		lock, err := os.OpenFile(strings.TrimSuffix(file, ".tar.partial")+".lock", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			log.Warnf("Failed to open the lock for %s: %s", file, err)
+			return nil
+		}
+		defer lock.Close()
+
+		if syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+			removeStalePartial(file)
+		}
+
+		return nil
+	})
+}
+
+// FetchImageManifest fetches the image manifest file.  A manifest list is
+// resolved to the manifest for the platform containerVMs run on.
 func FetchImageManifest(options ImageCOptions) (*Manifest, error) {
 	defer trace.End(trace.Begin(options.image + "/" + options.tag))
 
-	url, err := url.Parse(options.registry)
+	manifest, content, manifestFileName, err := fetchManifest(options, options.tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cleanup function for the error case
+	defer func() {
+		if err != nil {
+			os.Remove(manifestFileName)
+		}
+	}()
+
+	// the image is known by the digest of the list rather than of the manifest picked from it
+	var digest string
+	if manifest.MediaType == MediaTypeManifestList {
+		digest = string(ddigest.FromBytes(content))
+		os.Remove(manifestFileName)
+
+		manifest, manifestFileName, err = resolveManifestList(options, manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case manifest.SchemaVersion == 1:
+		if manifest.Name != options.image {
+			err = fmt.Errorf("name doesn't match what was requested, expected: %s, downloaded: %s", options.image, manifest.Name)
+			return nil, err
+		}
+
+		if manifest.Tag != options.tag {
+			err = fmt.Errorf("tag doesn't match what was requested, expected: %s, downloaded: %s", options.tag, manifest.Tag)
+			return nil, err
+		}
+
+		digest, err = getManifestDigest(content)
+		if err != nil {
+			return nil, err
+		}
+	case manifest.SchemaVersion == 2 && manifest.MediaType == MediaTypeManifestV2:
+		if digest == "" {
+			digest = string(ddigest.FromBytes(content))
+		}
+
+		// schema 2 manifests don't carry the name and tag they were fetched by
+		manifest.Name = options.image
+		manifest.Tag = options.tag
+
+		manifest.imageConfig, err = FetchImageConfig(options, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err = fmt.Errorf("unsupported manifest schema %d (%s)", manifest.SchemaVersion, manifest.MediaType)
+		return nil, err
+	}
+
+	manifest.Digest = digest
+
+	// Ensure the parent directory exists
+	destination := DestinationDirectory()
+	err = os.MkdirAll(destination, 0755)
 	if err != nil {
 		return nil, err
 	}
-	url.Path = path.Join(url.Path, options.image, "manifests", options.tag)
+
+	// Move(rename) the temporary file to its final destination
+	err = os.Rename(string(manifestFileName), path.Join(destination, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// fetchManifest fetches the manifest for the given tag or digest, asking for
+// any of the schemas imagec understands.  It returns the parsed manifest, its
+// content and the temporary file holding it.
+func fetchManifest(options ImageCOptions, reference string) (*Manifest, []byte, string, error) {
+	url, err := url.Parse(options.registry)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	url.Path = path.Join(url.Path, options.image, "manifests", reference)
 
 	log.Debugf("URL: %s", url)
 
@@ -335,61 +592,110 @@ func FetchImageManifest(options ImageCOptions) (*Manifest, error) {
 		Password:           options.password,
 		Token:              options.token,
 		InsecureSkipVerify: options.insecureSkipVerify,
+		RootCAs:            options.rootCAs,
+		ClientCertificates: options.clientCertificates,
+		Accept: []string{
+			MediaTypeManifestList,
+			MediaTypeManifestV2,
+			MediaTypeManifestV1,
+		},
 	})
 	manifestFileName, err := fetcher.Fetch(url)
 	if err != nil {
-		return nil, err
+		return nil, nil, "", err
 	}
 
-	// Cleanup function for the error case
-	defer func() {
-		if err != nil {
-			os.Remove(manifestFileName)
-		}
-	}()
-
 	// Read the entire file into []byte for json.Unmarshal
 	content, err := ioutil.ReadFile(manifestFileName)
 	if err != nil {
-		return nil, err
+		os.Remove(manifestFileName)
+		return nil, nil, "", err
 	}
 
 	manifest := &Manifest{}
 
 	err = json.Unmarshal(content, manifest)
 	if err != nil {
-		return nil, err
+		os.Remove(manifestFileName)
+		return nil, nil, "", err
+	}
+
+	return manifest, content, manifestFileName, nil
+}
+
+// resolveManifestList fetches the manifest for the platform containerVMs run on
+// from a manifest list
+func resolveManifestList(options ImageCOptions, list *Manifest) (*Manifest, string, error) {
+	var descriptor *Descriptor
+	for i := range list.Manifests {
+		m := &list.Manifests[i]
+		if m.Platform != nil && m.Platform.OS == platformOS && m.Platform.Architecture == platformArchitecture {
+			descriptor = m
+			break
+		}
+	}
+
+	if descriptor == nil {
+		return nil, "", fmt.Errorf("%s:%s has no manifest for %s/%s", options.image, options.tag, platformOS, platformArchitecture)
+	}
+	log.Debugf("Using manifest %s for %s/%s", descriptor.Digest, platformOS, platformArchitecture)
+
+	manifest, content, manifestFileName, err := fetchManifest(options, descriptor.Digest)
+	if err != nil {
+		return nil, "", err
 	}
 
-	if manifest.Name != options.image {
-		return nil, fmt.Errorf("name doesn't match what was requested, expected: %s, downloaded: %s", options.image, manifest.Name)
+	if d := string(ddigest.FromBytes(content)); d != descriptor.Digest {
+		os.Remove(manifestFileName)
+		return nil, "", fmt.Errorf("Failed to validate manifest checksum. Expected %s got %s", descriptor.Digest, d)
 	}
 
-	if manifest.Tag != options.tag {
-		return nil, fmt.Errorf("tag doesn't match what was requested, expected: %s, downloaded: %s", options.tag, manifest.Tag)
+	if manifest.MediaType != MediaTypeManifestV2 {
+		os.Remove(manifestFileName)
+		return nil, "", fmt.Errorf("unsupported manifest %s in manifest list", manifest.MediaType)
 	}
 
-	digest, err := getManifestDigest(content)
+	return manifest, manifestFileName, nil
+}
+
+// FetchImageConfig fetches the image config blob a schema 2 manifest refers to
+func FetchImageConfig(options ImageCOptions, config Descriptor) ([]byte, error) {
+	defer trace.End(trace.Begin(options.image + "/" + config.Digest))
+
+	url, err := url.Parse(options.registry)
 	if err != nil {
 		return nil, err
 	}
+	url.Path = path.Join(url.Path, options.image, "blobs", config.Digest)
 
-	manifest.Digest = digest
+	log.Debugf("URL: %s", url)
 
-	// Ensure the parent directory exists
-	destination := DestinationDirectory()
-	err = os.MkdirAll(destination, 0755)
+	fetcher := NewURLFetcher(FetcherOptions{
+		Timeout:            options.timeout,
+		Username:           options.username,
+		Password:           options.password,
+		Token:              options.token,
+		InsecureSkipVerify: options.insecureSkipVerify,
+		RootCAs:            options.rootCAs,
+		ClientCertificates: options.clientCertificates,
+	})
+	configFileName, err := fetcher.Fetch(url)
 	if err != nil {
 		return nil, err
 	}
+	defer os.Remove(configFileName)
 
-	// Move(rename) the temporary file to its final destination
-	err = os.Rename(string(manifestFileName), path.Join(destination, "manifest.json"))
+	content, err := ioutil.ReadFile(configFileName)
 	if err != nil {
 		return nil, err
 	}
 
-	return manifest, nil
+	// the image ID is the digest of the config so it has to be what was asked for
+	if d := string(ddigest.FromBytes(content)); d != config.Digest {
+		return nil, fmt.Errorf("Failed to validate image config checksum. Expected %s got %s", config.Digest, d)
+	}
+
+	return content, nil
 }
 
 func getManifestDigest(content []byte) (string, error) {
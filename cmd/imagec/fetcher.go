@@ -16,12 +16,14 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -40,7 +42,7 @@ import (
 // Fetcher interface
 type Fetcher interface {
 	Fetch(url *url.URL) (string, error)
-	FetchWithProgress(url *url.URL, ID string) (string, error)
+	FetchWithProgress(url *url.URL, ID string, file string) error
 
 	Head(url *url.URL) (http.Header, error)
 
@@ -51,6 +53,17 @@ type Fetcher interface {
 	AuthURL() *url.URL
 }
 
+const (
+	// maxDownloadAttempts bounds how often a failed download is resumed
+	maxDownloadAttempts = 5
+
+	// downloadBackoff is the wait before resuming a failed download, doubling with each attempt
+	downloadBackoff = time.Second
+
+	// maxRedirects bounds how many redirects are followed for a single request
+	maxRedirects = 10
+)
+
 // Token represents https://docs.docker.com/registry/spec/auth/token/
 type Token struct {
 	// An opaque Bearer token that clients should supply to subsequent requests in the Authorization header.
@@ -68,7 +81,15 @@ type FetcherOptions struct {
 
 	InsecureSkipVerify bool
 
+	// RootCAs validates the registry, the system CAs are used if it's nil
+	RootCAs *x509.CertPool
+	// ClientCertificates are presented to registries that ask for a certificate
+	ClientCertificates []tls.Certificate
+
 	Token *Token
+
+	// Accept lists the media types sent in the Accept header of GET requests
+	Accept []string
 }
 
 // URLFetcher struct
@@ -87,9 +108,14 @@ func NewURLFetcher(options FetcherOptions) Fetcher {
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: options.InsecureSkipVerify,
+			RootCAs:            options.RootCAs,
+			Certificates:       options.ClientCertificates,
 		},
 	}
-	client := &http.Client{Transport: tr}
+	client := &http.Client{
+		Transport:     tr,
+		CheckRedirect: checkRedirect,
+	}
 
 	return &URLFetcher{
 		client:  client,
@@ -107,14 +133,116 @@ func (u *URLFetcher) Fetch(url *url.URL) (string, error) {
 	return u.fetch(ctx, url, "")
 }
 
-// FetchWithProgress fetches a web page from url and stores in a temporary file while showing a progress bar.
-func (u *URLFetcher) FetchWithProgress(url *url.URL, ID string) (string, error) {
+// FetchWithProgress fetches a blob from url into file while showing a progress bar.  The download
+// continues from whatever file already holds, so a download that fails part way through is resumed
+// rather than started over, as is one left behind by an earlier run.
+func (u *URLFetcher) FetchWithProgress(url *url.URL, ID string, file string) error {
 	defer trace.End(trace.Begin(url.String()))
 
 	ctx, cancel := context.WithTimeout(context.Background(), u.options.Timeout)
 	defer cancel()
 
-	return u.fetch(ctx, url, ID)
+	backoff := downloadBackoff
+	for attempt := 1; ; attempt++ {
+		err := u.resume(ctx, url, ID, file)
+		if err == nil {
+			return nil
+		}
+
+		// there's no point in asking again for what the registry refused
+		if attempt == maxDownloadAttempts || u.IsStatusUnauthorized() || u.IsStatusNotFound() {
+			return err
+		}
+
+		log.Warnf("Download of %s failed, retrying in %s: %s", url, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// resume requests the part of the blob at url that file doesn't hold yet and appends it to file
+func (u *URLFetcher) resume(ctx context.Context, url *url.URL, ID string, file string) error {
+	u.StatusCode = 0
+
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	fi, err := out.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fi.Size()
+
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	u.setBasicAuth(req)
+
+	u.setAuthToken(req)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := ctxhttp.Do(ctx, u.client, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	u.StatusCode = res.StatusCode
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		log.Debugf("Resuming download of %s at %d", url, offset)
+	case http.StatusOK:
+		// the range wasn't honoured so start over
+		if offset > 0 {
+			log.Debugf("Restarting download of %s", url)
+			if err = out.Truncate(0); err != nil {
+				return err
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file may already hold the whole blob, otherwise it holds something else
+		if res.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		if err = out.Truncate(0); err != nil {
+			return err
+		}
+		return fmt.Errorf("Partial download of %s doesn't match, starting over", url)
+	case http.StatusNotFound:
+		return fmt.Errorf("Not found: %d, URL: %s", u.StatusCode, url)
+	default:
+		return fmt.Errorf("Unexpected http code: %d, URL: %s", u.StatusCode, url)
+	}
+
+	in := res.Body
+	// stream progress as json - only if we have an ID and a Content-Length header
+	if hdr := res.Header.Get("Content-Length"); ID != "" && hdr != "" {
+		cl, cerr := strconv.ParseInt(hdr, 10, 64)
+		if cerr != nil {
+			return cerr
+		}
+
+		in = progress.NewProgressReader(
+			ioutils.NewCancelReadCloser(ctx, res.Body), po, cl, ID, "Downloading",
+		)
+		defer in.Close()
+	}
+
+	_, err = io.Copy(out, in)
+	return err
 }
 
 func (u *URLFetcher) fetch(ctx context.Context, url *url.URL, ID string) (string, error) {
@@ -127,6 +255,10 @@ func (u *URLFetcher) fetch(ctx context.Context, url *url.URL, ID string) (string
 
 	u.setAuthToken(req)
 
+	for _, accept := range u.options.Accept {
+		req.Header.Add("Accept", accept)
+	}
+
 	res, err := ctxhttp.Do(ctx, u.client, req)
 	if err != nil {
 		return "", err
@@ -140,7 +272,19 @@ func (u *URLFetcher) fetch(ctx context.Context, url *url.URL, ID string) (string
 		if hdr == "" {
 			return "", fmt.Errorf("www-authenticate header is missing")
 		}
-		u.OAuthEndpoint, err = u.extractQueryParams(hdr, url)
+		// a registry using basic auth has no token service to turn to
+		if strings.HasPrefix(strings.ToLower(hdr), "basic") {
+			if u.options.Username != "" {
+				return "", fmt.Errorf("unauthorized: incorrect username or password")
+			}
+			return "", fmt.Errorf("Authentication required")
+		}
+		// the registry itself is asked when logging in, rather than one of its repositories
+		repository := url
+		if path.Base(url.Path) == "v2" {
+			repository = nil
+		}
+		u.OAuthEndpoint, err = u.extractQueryParams(hdr, repository)
 		if err != nil {
 			return "", err
 		}
@@ -151,7 +295,6 @@ func (u *URLFetcher) fetch(ctx context.Context, url *url.URL, ID string) (string
 		return "", fmt.Errorf("Not found: %d, URL: %s", u.StatusCode, url)
 	}
 
-	// FIXME: handle StatusTemporaryRedirect and StatusFound
 	if !u.IsStatusOK() {
 		return "", fmt.Errorf("Unexpected http code: %d, URL: %s", u.StatusCode, url)
 	}
@@ -242,6 +385,32 @@ func (u *URLFetcher) setAuthToken(req *http.Request) {
 	}
 }
 
+// checkRedirect carries the headers of the original request over to the redirect, such as the
+// Range of a resumed download.  The credentials are dropped when the redirect leaves the registry,
+// as blob storage such as S3 rejects requests that carry them alongside its own signed URLs.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	orig := via[0]
+	for key, value := range orig.Header {
+		if key == "Authorization" && req.URL.Host != orig.URL.Host {
+			continue
+		}
+		if _, ok := req.Header[key]; !ok {
+			req.Header[key] = value
+		}
+	}
+
+	if req.URL.Host != orig.URL.Host {
+		req.Header.Del("Authorization")
+	}
+
+	log.Debugf("Following redirect to %s", req.URL)
+	return nil
+}
+
 func (u *URLFetcher) extractQueryParams(hdr string, repository *url.URL) (*url.URL, error) {
 	tokens := strings.Split(hdr, " ")
 	if len(tokens) != 2 || strings.ToLower(tokens[0]) != "bearer" {
@@ -269,7 +438,7 @@ func (u *URLFetcher) extractQueryParams(hdr string, repository *url.URL) (*url.U
 	if service == "" {
 		return nil, fmt.Errorf("missing service in bearer auth challenge")
 	}
-	// The scope can be empty if we're not getting a token for a specific repo
+	// The scope can be empty if we're not getting a token for a specific repo, such as when logging in
 	if scope == "" && repository != nil {
 		return nil, fmt.Errorf("missing scope in bearer auth challenge")
 	}
@@ -16,6 +16,8 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -33,18 +35,23 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 
+	ddigest "github.com/docker/distribution/digest"
 	docker "github.com/docker/docker/image"
+	dockerV1 "github.com/docker/docker/image/v1"
 	dockerLayer "github.com/docker/docker/layer"
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/progress"
 	"github.com/docker/docker/pkg/streamformatter"
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/reference"
+	"github.com/docker/engine-api/types"
 
+	"github.com/vmware/vic/lib/apiservers/portlayer/client/storage"
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
 	"github.com/vmware/vic/lib/guest"
 	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/pkg/i18n"
+	"github.com/vmware/vic/pkg/registry"
 
 	"github.com/pkg/profile"
 )
@@ -74,6 +81,11 @@ type ImageCOptions struct {
 	username string
 	password string
 
+	credentialsStdin bool
+
+	// the registry to check the credentials against, instead of pulling
+	login string
+
 	token *Token
 
 	timeout time.Duration
@@ -87,6 +99,19 @@ type ImageCOptions struct {
 	standalone bool
 	resolv     bool
 
+	// comma separated registries images may, or may not, be pulled from
+	registryWhitelist string
+	registryBlacklist string
+
+	// comma separated registries reached without verifying their certificate, or over http
+	insecureRegistries string
+
+	// directory holding the certificates for registries, laid out as docker's certs.d
+	registryCerts string
+
+	rootCAs            *x509.CertPool
+	clientCertificates []tls.Certificate
+
 	profiling string
 	tracing   bool
 }
@@ -123,6 +148,12 @@ const (
 
 	// DefaultTokenExpirationDuration specifies the default token expiration
 	DefaultTokenExpirationDuration = 60 * time.Second
+
+	// maxWriteAttempts bounds how often a layer rejected by the portlayer is written
+	maxWriteAttempts = 3
+
+	// maxConcurrentDownloads bounds how many layers are downloaded at once
+	maxConcurrentDownloads = 3
 )
 
 func init() {
@@ -145,6 +176,8 @@ func init() {
 
 	flag.StringVar(&options.username, "username", "", i18n.T("Username"))
 	flag.StringVar(&options.password, "password", "", i18n.T("Password"))
+	flag.BoolVar(&options.credentialsStdin, "credentials-stdin", false, i18n.T("Read the registry credentials as JSON from stdin"))
+	flag.StringVar(&options.login, "login", "", i18n.T("Check the credentials against the given registry instead of pulling"))
 
 	flag.DurationVar(&options.timeout, "timeout", DefaultHTTPTimeout, i18n.T("HTTP timeout"))
 
@@ -154,6 +187,11 @@ func init() {
 	flag.BoolVar(&options.insecureAllowHTTP, "insecure-allow-http", false, i18n.T("Uses unencrypted connections when fetching images"))
 	flag.BoolVar(&options.standalone, "standalone", false, i18n.T("Disable port-layer integration"))
 
+	flag.StringVar(&options.registryWhitelist, "registry-whitelist", "", i18n.T("Comma separated registries that images may be pulled from"))
+	flag.StringVar(&options.registryBlacklist, "registry-blacklist", "", i18n.T("Comma separated registries that images may not be pulled from"))
+	flag.StringVar(&options.insecureRegistries, "insecure-registries", "", i18n.T("Comma separated registries that are reached without verifying their certificate, or over http"))
+	flag.StringVar(&options.registryCerts, "registry-certs", "", i18n.T("Directory holding a directory of certificates per registry, laid out as docker's certs.d"))
+
 	flag.BoolVar(&options.resolv, "resolv", false, i18n.T("Return the name of the vmdk from given reference"))
 
 	flag.StringVar(&options.profiling, "profile.mode", "", i18n.T("Enable profiling mode, one of [cpu, mem, block]"))
@@ -177,6 +215,10 @@ func ParseReference() error {
 		}
 	}
 
+	if err = CheckRegistryPolicy(ref.Hostname()); err != nil {
+		return err
+	}
+
 	options.registry = DefaultDockerURL
 	if ref.Hostname() != reference.DefaultHostname {
 		options.registry = ref.Hostname()
@@ -187,6 +229,107 @@ func ParseReference() error {
 	return nil
 }
 
+// ReadCredentials reads the registry credentials from r, in the JSON form of
+// the docker AuthConfig.  Credentials passed this way don't show up on the
+// command line of imagec.
+func ReadCredentials(r io.Reader) error {
+	authConfig := types.AuthConfig{}
+	if err := json.NewDecoder(r).Decode(&authConfig); err != nil {
+		return fmt.Errorf("Failed to read credentials: %s", err)
+	}
+
+	options.username = authConfig.Username
+	options.password = authConfig.Password
+
+	return nil
+}
+
+// ParseLogin parses the -login parameter, a registry given the way docker
+// login takes it, and populates the options struct
+func ParseLogin() error {
+	server := options.login
+	if strings.Contains(server, "://") {
+		u, err := url.Parse(server)
+		if err != nil {
+			return err
+		}
+		server = u.Host
+	} else {
+		server = strings.SplitN(server, "/", 2)[0]
+	}
+
+	if server == "" {
+		return fmt.Errorf("invalid registry %q", options.login)
+	}
+
+	if err := CheckRegistryPolicy(server); err != nil {
+		return err
+	}
+
+	options.registry = server
+	if registry.IsDockerHub(server) {
+		options.registry = DefaultDockerURL
+	}
+
+	options.image = ""
+	options.tag = ""
+
+	return nil
+}
+
+// CheckRegistryPolicy returns an error if the -registry-whitelist and
+// -registry-blacklist parameters don't allow pulling from the registry
+func CheckRegistryPolicy(host string) error {
+	var whitelist, blacklist []string
+	if options.registryWhitelist != "" {
+		whitelist = strings.Split(options.registryWhitelist, ",")
+	}
+	if options.registryBlacklist != "" {
+		blacklist = strings.Split(options.registryBlacklist, ",")
+	}
+
+	policy := registry.Policy{}
+	var err error
+
+	if policy.Whitelist, err = registry.ParseEntries(whitelist); err != nil {
+		return err
+	}
+	if policy.Blacklist, err = registry.ParseEntries(blacklist); err != nil {
+		return err
+	}
+
+	return policy.Allowed(host)
+}
+
+// ConfigureRegistryTLS sets up the TLS settings for options.registry from
+// the -insecure-registries and -registry-certs parameters
+func ConfigureRegistryTLS() error {
+	if options.insecureRegistries != "" {
+		insecure, err := registry.ParseEntries(strings.Split(options.insecureRegistries, ","))
+		if err != nil {
+			return err
+		}
+
+		if registry.Contains(insecure, options.registry) {
+			log.Infof("Registry %s is insecure", options.registry)
+			options.insecureSkipVerify = true
+			options.insecureAllowHTTP = true
+		}
+	}
+
+	if options.registryCerts == "" {
+		return nil
+	}
+
+	var err error
+	options.rootCAs, options.clientCertificates, err = registry.LoadCertificates(options.registryCerts, options.registry)
+	if err != nil {
+		return fmt.Errorf("Failed to load certificates for %s: %s", options.registry, err)
+	}
+
+	return nil
+}
+
 // DestinationDirectory returns the path of the output directory
 func DestinationDirectory() string {
 	u, _ := url.Parse(options.registry)
@@ -227,6 +370,60 @@ func DestinationDirectory() string {
 
 // ImagesToDownload creates a slice of ImageWithMeta for the images that needs to be downloaded
 func ImagesToDownload(manifest *Manifest, storeName string) ([]*ImageWithMeta, error) {
+	var images []*ImageWithMeta
+	var err error
+
+	if manifest.SchemaVersion == 2 {
+		images, err = schema2Images(manifest, storeName)
+	} else {
+		images, err = schema1Images(manifest, storeName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// return early if -standalone set
+	if options.standalone {
+		return images, nil
+	}
+
+	// Create the image store just in case
+	err = CreateImageStore(storeName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create image store: %s", err)
+	}
+
+	// Get the list of known images from the storage layer
+	existingImages, err := ListImages(storeName, images)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to obtain list of images: %s", err)
+	}
+	for i := range existingImages {
+		log.Debugf("Existing image: %#v", existingImages[i])
+	}
+
+	// iterate from parent to children
+	// so that we can delete from the slice
+	// while iterating over it
+	for i := len(images) - 1; i >= 0; i-- {
+		ID := images[i].ID
+		// Check whether storage layer knows this image ID
+		if _, ok := existingImages[ID]; ok {
+			log.Debugf("%s already exists", ID)
+			// update the progress before deleting it from the slice
+			progress.Update(po, images[i].String(), "Already exists")
+
+			// delete existing image from images
+			images = append(images[:i], images[i+1:]...)
+		}
+	}
+
+	return images, nil
+}
+
+// schema1Images creates the ImageWithMeta slice for the layers of a schema 1 manifest, which
+// carries the v1 ID of each layer in its history
+func schema1Images(manifest *Manifest, storeName string) ([]*ImageWithMeta, error) {
 	images := make([]*ImageWithMeta, len(manifest.FSLayers))
 
 	v1 := docker.V1Image{}
@@ -260,46 +457,93 @@ func ImagesToDownload(manifest *Manifest, storeName string) ([]*ImageWithMeta, e
 		log.Debugf("Manifest image: %#v", images[i])
 	}
 
-	// return early if -standalone set
-	if options.standalone {
-		return images, nil
-	}
+	return images, nil
+}
 
-	// Create the image store just in case
-	err := CreateImageStore(storeName)
+// schema2Images creates the ImageWithMeta slice for the layers of a schema 2 manifest.  The layers
+// are named from the diffIDs in the image config, the way docker names them for v1 compatibility,
+// and are ordered from children to parent as they are in a schema 1 manifest.
+func schema2Images(manifest *Manifest, storeName string) ([]*ImageWithMeta, error) {
+	img, err := docker.NewFromJSON(manifest.imageConfig)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create image store: %s", err)
+		return nil, fmt.Errorf("Failed to unmarshall image config: %s", err)
 	}
 
-	// Get the list of known images from the storage layer
-	existingImages, err := ListImages(storeName, images)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to obtain list of images: %s", err)
+	if len(img.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("Image config lists %d layers, manifest lists %d", len(img.RootFS.DiffIDs), len(manifest.Layers))
 	}
-	for i := range existingImages {
-		log.Debugf("Existing image: %#v", existingImages[i])
+
+	ids, err := layerIDs(img)
+	if err != nil {
+		return nil, err
 	}
 
-	// iterate from parent to children
-	// so that we can delete from the slice
-	// while iterating over it
-	for i := len(images) - 1; i >= 0; i-- {
-		ID := images[i].ID
-		// Check whether storage layer knows this image ID
-		if _, ok := existingImages[ID]; ok {
-			log.Debugf("%s already exists", ID)
-			// update the progress before deleting it from the slice
-			progress.Update(po, images[i].String(), "Already exists")
+	images := make([]*ImageWithMeta, len(ids))
+	parent := "scratch"
+	for i, id := range ids {
+		// only the top layer carries the image config
+		v1 := docker.V1Image{}
+		if i == len(ids)-1 {
+			v1 = img.V1Image
+		}
+		v1.ID = id
+		if parent != "scratch" {
+			v1.Parent = parent
+		}
 
-			// delete existing image from images
-			images = append(images[:i], images[i+1:]...)
+		meta, err := json.Marshal(v1)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshall image history: %s", err)
+		}
+
+		layerParent := parent
+		images[len(ids)-1-i] = &ImageWithMeta{
+			Image: &models.Image{
+				ID:     id,
+				Parent: &layerParent,
+				Store:  storeName,
+			},
+			meta:   string(meta),
+			layer:  FSLayer{BlobSum: manifest.Layers[i].Digest},
+			diffID: img.RootFS.DiffIDs[i].String(),
 		}
+		log.Debugf("Manifest image: %#v", images[len(ids)-1-i])
+
+		parent = id
 	}
 
 	return images, nil
 }
 
-// DownloadImageBlobs downloads the image blobs concurrently
+// layerIDs returns the v1 IDs of the layers of an image, from parent to
+// children, as docker derives them from the diffIDs and the image config
+func layerIDs(img *docker.Image) ([]string, error) {
+	rootFS := docker.NewRootFS()
+	ids := make([]string, 0, len(img.RootFS.DiffIDs))
+
+	var parent ddigest.Digest
+	for i, diffID := range img.RootFS.DiffIDs {
+		rootFS.Append(diffID)
+
+		v1Image := docker.V1Image{}
+		if i == len(img.RootFS.DiffIDs)-1 {
+			v1Image = img.V1Image
+		}
+
+		id, err := dockerV1.CreateID(v1Image, rootFS.ChainID(), parent)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create layer ID: %s", err)
+		}
+
+		ids = append(ids, id.Hex())
+		parent = id
+	}
+
+	return ids, nil
+}
+
+// DownloadImageBlobs downloads the image blobs concurrently, at most
+// maxConcurrentDownloads at a time
 func DownloadImageBlobs(images []*ImageWithMeta) error {
 	var wg sync.WaitGroup
 
@@ -309,10 +553,16 @@ func DownloadImageBlobs(images []*ImageWithMeta) error {
 	// so that portlayer can extract each layer
 	// on top of previous one
 	results := make(chan error, len(images))
+	slots := make(chan struct{}, maxConcurrentDownloads)
 	for i := len(images) - 1; i >= 0; i-- {
+		progress.Update(po, images[i].String(), "Waiting")
+
 		go func(image *ImageWithMeta) {
 			defer wg.Done()
 
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
 			diffID, err := FetchImageBlob(options, image)
 			if err != nil {
 				results <- fmt.Errorf("%s/%s returned %s", options.image, image.layer.BlobSum, err)
@@ -348,29 +598,19 @@ func WriteImageBlobs(images []*ImageWithMeta) error {
 	for i := len(images) - 1; i >= 0; i-- {
 		image := images[i]
 
-		id := image.Image.ID
-		f, err := os.Open(path.Join(destination, id, id+".tar"))
-		if err != nil {
-			return fmt.Errorf("Failed to open file: %s", err)
-		}
-		defer f.Close()
-
-		fi, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("Failed to stat file: %s", err)
+		err := WriteImageBlob(image, destination)
+		for attempt := 1; err != nil && attempt < maxWriteAttempts; attempt++ {
+			// the portlayer rejects a layer that doesn't match its checksum, and as the local
+			// copy may be what is corrupt it is fetched again before retrying
+			if _, ok := err.(*storage.WriteImageUnprocessableEntity); !ok {
+				break
+			}
+			log.Warnf("Fetching %s again after a failed write: %s", image.ID, err)
+			if err = RefetchImageBlob(image, destination); err != nil {
+				break
+			}
+			err = WriteImageBlob(image, destination)
 		}
-
-		in := progress.NewProgressReader(
-			ioutils.NewCancelReadCloser(context.Background(), f),
-			po,
-			fi.Size(),
-			image.String(),
-			"Extracting",
-		)
-		defer in.Close()
-
-		// Write the image
-		err = WriteImage(image, in)
 		if err != nil {
 			return fmt.Errorf("Failed to write to image store: %s", err)
 		}
@@ -382,6 +622,48 @@ func WriteImageBlobs(images []*ImageWithMeta) error {
 	return nil
 }
 
+// RefetchImageBlob discards the downloaded blob of an image and downloads it again
+func RefetchImageBlob(image *ImageWithMeta, destination string) error {
+	id := image.Image.ID
+	if err := os.Remove(path.Join(destination, id, id+".tar")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove file: %s", err)
+	}
+
+	// the download is verified against the diffID learned the first time
+	if _, err := FetchImageBlob(options, image); err != nil {
+		return fmt.Errorf("%s/%s returned %s", options.image, image.layer.BlobSum, err)
+	}
+
+	return nil
+}
+
+// WriteImageBlob writes a single downloaded image blob to the storage layer
+func WriteImageBlob(image *ImageWithMeta, destination string) error {
+	id := image.Image.ID
+	f, err := os.Open(path.Join(destination, id, id+".tar"))
+	if err != nil {
+		return fmt.Errorf("Failed to open file: %s", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to stat file: %s", err)
+	}
+
+	in := progress.NewProgressReader(
+		ioutils.NewCancelReadCloser(context.Background(), f),
+		po,
+		fi.Size(),
+		image.String(),
+		"Extracting",
+	)
+	defer in.Close()
+
+	// Write the image
+	return WriteImage(image, in)
+}
+
 // CreateImageConfig constructs the image metadata from layers that compose the image
 func CreateImageConfig(images []*ImageWithMeta, manifest *Manifest) error {
 
@@ -389,6 +671,10 @@ func CreateImageConfig(images []*ImageWithMeta, manifest *Manifest) error {
 		return nil
 	}
 
+	if manifest.SchemaVersion == 2 {
+		return createSchema2ImageConfig(images, manifest)
+	}
+
 	imageLayer := images[0] // the layer that represents the actual image
 	image := docker.V1Image{}
 	rootFS := docker.NewRootFS()
@@ -464,6 +750,62 @@ func CreateImageConfig(images []*ImageWithMeta, manifest *Manifest) error {
 	return nil
 }
 
+// createSchema2ImageConfig constructs the image metadata from the image config
+// of a schema 2 manifest, which the image ID is the digest of
+func createSchema2ImageConfig(images []*ImageWithMeta, manifest *Manifest) error {
+	imageLayer := images[0] // the layer that represents the actual image
+
+	img, err := docker.NewFromJSON(manifest.imageConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshall image config: %s", err)
+	}
+
+	ids, err := layerIDs(img)
+	if err != nil {
+		return err
+	}
+
+	diffIDs := make(map[string]string)
+	for i, diffID := range img.RootFS.DiffIDs {
+		diffIDs[diffID.String()] = ids[i]
+	}
+
+	var size int64
+	for _, layer := range images {
+		size += layer.size
+	}
+
+	// calculate image ID
+	sum := fmt.Sprintf("%x", sha256.Sum256(manifest.imageConfig))
+	log.Infof("Image ID: sha256:%s", sum)
+
+	// prepare metadata
+	result := img.V1Image
+	if parent := *imageLayer.Parent; parent != "scratch" {
+		result.Parent = parent
+	}
+	result.Size = size
+	metaData := metadata.ImageConfig{
+		V1Image: result,
+		ImageID: sum,
+		Digest:  manifest.Digest,
+		Tag:     options.tag,
+		Name:    manifest.Name,
+		DiffIDs: diffIDs,
+		History: img.History,
+	}
+
+	blob, err := json.Marshal(metaData)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal image metadata: %s", err)
+	}
+
+	// store metadata
+	imageLayer.meta = string(blob)
+
+	return nil
+}
+
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -521,11 +863,39 @@ func main() {
 		log.SetOutput(io.MultiWriter(os.Stdout, f))
 	}
 
+	if options.credentialsStdin {
+		if err = ReadCredentials(os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Check the credentials rather than pulling if asked to
+	if options.login != "" {
+		if err = ParseLogin(); err != nil {
+			log.Fatal(err)
+		}
+
+		if err = ConfigureRegistryTLS(); err != nil {
+			log.Fatal(err)
+		}
+
+		if err = Login(options); err != nil {
+			log.Fatalf("Login to %s failed: %s", options.login, err)
+		}
+
+		progress.Message(po, "", "Login Succeeded")
+		return
+	}
+
 	// Parse the -reference parameter
 	if err = ParseReference(); err != nil {
 		log.Fatalf(err.Error())
 	}
 
+	if err = ConfigureRegistryTLS(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Host is either the host's UUID (if run on vsphere) or the hostname of
 	// the system (if run standalone)
 	host, err := guest.UUID()
@@ -601,6 +971,11 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Partial downloads abandoned by earlier pulls would otherwise accumulate
+	if err := RemoveStalePartials(options.destination); err != nil {
+		log.Warnf("Failed to remove stale partial downloads: %s", err)
+	}
+
 	// Fetch the blobs from registry
 	if err := DownloadImageBlobs(images); err != nil {
 		log.Fatalf(err.Error())
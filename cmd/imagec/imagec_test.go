@@ -19,6 +19,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"encoding/pem"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -26,11 +27,16 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/docker/distribution/digest"
+
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
+	"github.com/vmware/vic/lib/metadata"
 )
 
 const (
@@ -78,6 +84,34 @@ func TestParseReference(t *testing.T) {
 	}
 }
 
+func TestParseReferencePolicy(t *testing.T) {
+	defer func() {
+		options.registryWhitelist = ""
+		options.registryBlacklist = ""
+	}()
+
+	options.registryWhitelist = "docker.io,*.example.com"
+	options.registryBlacklist = "bad.example.com"
+
+	options.reference = "busybox"
+	if err := ParseReference(); err != nil {
+		t.Error(err)
+	}
+
+	options.reference = "registry.example.com:5000/library/busybox"
+	if err := ParseReference(); err != nil {
+		t.Error(err)
+	}
+
+	// should fail
+	for _, ref := range []string{"bad.example.com/library/busybox", "example.org/library/busybox"} {
+		options.reference = ref
+		if err := ParseReference(); err == nil {
+			t.Errorf("Expected %s to be refused", ref)
+		}
+	}
+}
+
 func TestLearnRegistryURL(t *testing.T) {
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -104,6 +138,70 @@ func TestLearnRegistryURL(t *testing.T) {
 	}
 }
 
+func TestConfigureRegistryTLS(t *testing.T) {
+	s := httptest.NewTLSServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			http.Error(w, "You shall not pass", http.StatusUnauthorized)
+		}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "registry-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options.registry = s.URL[8:]
+	options.image = Image
+	options.tag = Tag
+	options.insecureAllowHTTP = false
+	options.insecureSkipVerify = false
+	defer func() {
+		options.registryCerts = ""
+		options.insecureRegistries = ""
+		options.rootCAs = nil
+		options.clientCertificates = nil
+		options.insecureSkipVerify = false
+	}()
+
+	// should fail, the server's certificate isn't signed by a known CA
+	if _, err = LearnRegistryURL(options); err == nil {
+		t.Error("Expected the server's certificate to be refused")
+	}
+
+	// should pass once the server's certificate is given as the registry's CA
+	if err = os.MkdirAll(filepath.Join(dir, options.registry), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.TLS.Certificates[0].Certificate[0]})
+	if err = ioutil.WriteFile(filepath.Join(dir, options.registry, "ca.crt"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options.registryCerts = dir
+	if err = ConfigureRegistryTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LearnRegistryURL(options); err != nil {
+		t.Error(err)
+	}
+
+	// an insecure registry isn't verified
+	options.registryCerts = ""
+	options.rootCAs = nil
+	options.insecureRegistries = "other.example.com," + options.registry
+	if err = ConfigureRegistryTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if !options.insecureSkipVerify || !options.insecureAllowHTTP {
+		t.Errorf("Expected %s to be insecure", options.registry)
+	}
+	if _, err = LearnRegistryURL(options); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestLearnAuthURL(t *testing.T) {
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -127,6 +225,30 @@ func TestLearnAuthURL(t *testing.T) {
 	}
 }
 
+func TestExtractQueryParams(t *testing.T) {
+	fetcher := &URLFetcher{}
+	hdr := "Bearer realm=\"https://auth.docker.io/token\",service=\"registry.docker.io\""
+
+	repository, err := url.Parse("https://registry-1.docker.io/v2/library/photon/manifests/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a token for a repository needs a scope
+	if _, err = fetcher.extractQueryParams(hdr, repository); err == nil {
+		t.Errorf("Expected a challenge without a scope to be rejected for a repository")
+	}
+
+	// logging in isn't for any repository
+	auth, err := fetcher.extractQueryParams(hdr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.String() != "https://auth.docker.io/token?service=registry.docker.io" {
+		t.Errorf("Returned url %s is different than expected", auth)
+	}
+}
+
 func TestFetchToken(t *testing.T) {
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -157,6 +279,61 @@ func TestFetchToken(t *testing.T) {
 	}
 }
 
+func TestLogin(t *testing.T) {
+	var realm string
+	s := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/v2/":
+				w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+				w.Header().Set("www-authenticate", "Bearer realm=\""+realm+"\",service=\"registry.example.com\"")
+				http.Error(w, "You shall not pass", http.StatusUnauthorized)
+			case "/token":
+				if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+					http.Error(w, "Bad credentials", http.StatusUnauthorized)
+					return
+				}
+				body, err := json.Marshal(&Token{Token: OAuthToken})
+				if err != nil {
+					t.Error(err)
+				}
+				w.Write(body)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer s.Close()
+	realm = s.URL + "/token"
+
+	defer func() {
+		options.login = ""
+		options.username = ""
+		options.password = ""
+		options.insecureAllowHTTP = false
+	}()
+
+	options.login = "http://" + s.URL[7:] + "/v1/"
+	options.insecureAllowHTTP = true
+	if err := ParseLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if options.registry != s.URL[7:] || options.image != "" {
+		t.Errorf("Returned registry %s is different than expected", options.registry)
+	}
+
+	if err := ReadCredentials(strings.NewReader(`{"username":"user","password":"secret"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Login(options); err != nil {
+		t.Error(err)
+	}
+
+	options.password = "wrong"
+	if err := Login(options); err == nil {
+		t.Errorf("Expected login with the wrong password to fail")
+	}
+}
+
 func TestFetchImageManifest(t *testing.T) {
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -224,6 +401,93 @@ func TestFetchImageManifest(t *testing.T) {
 	}
 }
 
+func TestFetchImageManifestSchema2(t *testing.T) {
+	config := `{"architecture":"amd64","os":"linux","config":{"Cmd":["sh"]},"rootfs":{"type":"layers","diff_ids":["sha256:1f1f9635040c465c7f7b32a396e56e26c1396dbadfbed744b0aab9337a24ad5a","sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"]},"history":[{"created_by":"ADD file"},{"created_by":"CMD [\"sh\"]"}]}`
+	configDigest := string(digest.FromBytes([]byte(config)))
+
+	manifest := `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestV2 + `","config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":1,"digest":"` + configDigest + `"},"layers":[{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":1,"digest":"sha256:0000000000000000000000000000000000000000000000000000000000000001"},{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":1,"digest":"sha256:0000000000000000000000000000000000000000000000000000000000000002"}]}`
+	manifestDigest := string(digest.FromBytes([]byte(manifest)))
+
+	list := `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestList + `","manifests":[{"mediaType":"` + MediaTypeManifestV2 + `","size":1,"digest":"sha256:0000000000000000000000000000000000000000000000000000000000000003","platform":{"architecture":"arm","os":"linux"}},{"mediaType":"` + MediaTypeManifestV2 + `","size":1,"digest":"` + manifestDigest + `","platform":{"architecture":"amd64","os":"linux"}}]}`
+	listDigest := string(digest.FromBytes([]byte(list)))
+
+	s := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/" + Image + "/manifests/" + Tag:
+				if !strings.Contains(strings.Join(r.Header["Accept"], ","), MediaTypeManifestList) {
+					http.Error(w, "manifest list not accepted", http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(list))
+			case "/" + Image + "/manifests/" + manifestDigest:
+				w.Write([]byte(manifest))
+			case "/" + Image + "/blobs/" + configDigest:
+				w.Write([]byte(config))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer s.Close()
+
+	options.registry = s.URL
+	options.image = Image
+	options.tag = Tag
+	options.token = &Token{Token: OAuthToken}
+	options.standalone = true
+	defer func() { options.standalone = false }()
+
+	// create a temporary directory
+	dir, err := ioutil.TempDir("", "imagec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options.destination = dir
+
+	m, err := FetchImageManifest(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != listDigest {
+		t.Errorf("Returned digest %s is different than expected %s", m.Digest, listDigest)
+	}
+	if m.Name != Image || m.Tag != Tag || len(m.Layers) != 2 {
+		t.Errorf("Returned manifest %#v is different than expected", m)
+	}
+
+	images, err := ImagesToDownload(m, Storename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 layers, got %d", len(images))
+	}
+	if *images[1].Parent != "scratch" || *images[0].Parent != images[1].ID {
+		t.Errorf("Layers are not chained: %#v", images)
+	}
+	if images[0].diffID != "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" ||
+		images[0].layer.BlobSum != "sha256:0000000000000000000000000000000000000000000000000000000000000002" {
+		t.Errorf("Top layer %#v is different than expected", images[0])
+	}
+
+	if err = CreateImageConfig(images, m); err != nil {
+		t.Fatal(err)
+	}
+
+	imageConfig := metadata.ImageConfig{}
+	if err = json.Unmarshal([]byte(images[0].meta), &imageConfig); err != nil {
+		t.Fatal(err)
+	}
+	if "sha256:"+imageConfig.ImageID != configDigest {
+		t.Errorf("Image ID %s is different than expected %s", imageConfig.ImageID, configDigest)
+	}
+	if imageConfig.DiffIDs[images[1].diffID] != images[1].ID || len(imageConfig.History) != 2 {
+		t.Errorf("Image config %#v is different than expected", imageConfig)
+	}
+}
+
 func TestFetchImageBlob(t *testing.T) {
 	// create a tar archive from our dummy data
 	r := strings.NewReader(LayerContent)
@@ -324,6 +588,219 @@ func TestFetchImageBlob(t *testing.T) {
 	}
 }
 
+func TestRefetchImageBlob(t *testing.T) {
+	// a layer holding our dummy data
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: LayerID, Mode: 0644, Size: int64(len(LayerContent))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(LayerContent)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer := buf.Bytes()
+
+	s := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(layer)
+		}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "imagec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options.registry = s.URL
+	options.image = Image
+	options.tag = Tag
+	options.token = &Token{Token: OAuthToken}
+	options.destination = dir
+
+	parent := "scratch"
+	image := &ImageWithMeta{
+		Image: &models.Image{
+			ID:     LayerID,
+			Parent: &parent,
+			Store:  Storename,
+		},
+		meta:   LayerHistory,
+		layer:  FSLayer{BlobSum: digest.FromBytes(layer).String()},
+		diffID: digest.FromBytes(layer).String(),
+	}
+
+	// the local copy of the layer has been corrupted since it was downloaded
+	destination := DestinationDirectory()
+	file := path.Join(destination, LayerID, LayerID+".tar")
+	if err = os.MkdirAll(path.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(file, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = RefetchImageBlob(image, destination); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, layer) {
+		t.Errorf("Layer was not fetched again")
+	}
+
+	// a layer that no longer matches what was first downloaded is refused
+	image.diffID = DigestSHA256EmptyTar
+	if err = RefetchImageBlob(image, destination); err == nil {
+		t.Errorf("Expected a changed layer to be refused")
+	}
+}
+
+func TestFetchWithProgressResume(t *testing.T) {
+	content := strings.Repeat(LayerContent, 100)
+
+	// blob storage serves ranges and rejects the registry credentials
+	storage := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "" {
+				http.Error(w, "Only one auth mechanism allowed", http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get("Range") == "" {
+				http.Error(w, "Expected a range", http.StatusBadRequest)
+				return
+			}
+			http.ServeContent(w, r, "blob", time.Time{}, strings.NewReader(content))
+		}))
+	defer storage.Close()
+
+	registry := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+OAuthToken {
+				http.Error(w, "You shall not pass", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, storage.URL+"/blob", http.StatusTemporaryRedirect)
+		}))
+	defer registry.Close()
+
+	dir, err := ioutil.TempDir("", "imagec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// half of the blob is left over from an earlier attempt
+	file := path.Join(dir, LayerID+".tar.partial")
+	if err = ioutil.WriteFile(file, []byte(content[:len(content)/2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(registry.URL + "/" + Image + "/blobs/" + DigestSHA256LayerContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := NewURLFetcher(FetcherOptions{
+		Timeout: 10 * time.Second,
+		Token:   &Token{Token: OAuthToken},
+	})
+	if err = fetcher.FetchWithProgress(u, LayerID, file); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(downloaded) != content {
+		t.Errorf("Downloaded %d bytes that differ from the %d expected", len(downloaded), len(content))
+	}
+
+	// a complete download is left as it is
+	if err = fetcher.FetchWithProgress(u, LayerID, file); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err = ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(downloaded) != content {
+		t.Errorf("Complete download was modified")
+	}
+}
+
+func TestRemoveStalePartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	partial := func(id string, age time.Duration) string {
+		layer := path.Join(dir, "https", "registry", "v2", Image, Tag, id)
+		if err := os.MkdirAll(layer, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		file := path.Join(layer, id+".tar.partial")
+		if err := ioutil.WriteFile(file, []byte(LayerContent), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		modified := time.Now().Add(-age)
+		if err := os.Chtimes(file, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	stale := partial("stale", 2*partialExpiry)
+	fresh := partial("fresh", 0)
+
+	// a pull that holds the layer keeps its partial download, however old
+	lock, err := lockLayer(path.Dir(partial("held", 0)), "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+	held := partial("held", 2*partialExpiry)
+
+	if err = RemoveStalePartials(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale partial download %s was kept", stale)
+	}
+	for _, file := range []string{fresh, held} {
+		if _, err = os.Stat(file); err != nil {
+			t.Errorf("Partial download %s was removed: %s", file, err)
+		}
+	}
+
+	// the stale partial of a layer is discarded when its pull takes the lock
+	lock.Close()
+	lock, err = lockLayer(path.Dir(held), "held")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(held); !os.IsNotExist(err) {
+		t.Errorf("Stale partial download %s was resumed", held)
+	}
+
+	// a missing destination is fine
+	if err = RemoveStalePartials(path.Join(dir, "missing")); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestPingPortLayer(t *testing.T) {
 	s := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
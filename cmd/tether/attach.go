@@ -18,7 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
+	"sync"
 	"syscall"
 
 	log "github.com/Sirupsen/logrus"
@@ -50,6 +50,15 @@ type attachServerSSH struct {
 	config    *tether.ExecutorConfig
 	sshConfig *ssh.ServerConfig
 
+	// reload triggers a reload of the tether configuration, used when the
+	// configuration has been modified while the tether is running (e.g. exec)
+	reload func()
+
+	// m guards cancel, which is set by the serving goroutine and called by stop
+	m sync.Mutex
+	// cancel aborts any in progress attempt to establish the backchannel
+	cancel context.CancelFunc
+
 	enabled bool
 }
 
@@ -145,6 +154,12 @@ func (t *attachServerSSH) stop() {
 	}
 
 	t.enabled = false
+	t.m.Lock()
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+	t.m.Unlock()
 	if t.conn != nil {
 		(*t.conn).Close()
 		t.conn = nil
@@ -152,10 +167,31 @@ func (t *attachServerSSH) stop() {
 }
 
 // run should not be called directly, but via start
-// run will establish an ssh server listening on the backchannel
+// run will establish an ssh server listening on the backchannel, re-establishing it
+// if the connection is lost (e.g. if the port layer restarts) until the server is stopped
 func (t *attachServerSSH) run() error {
 	defer trace.End(trace.Begin("main attach server loop"))
 
+	for t.enabled {
+		if err := t.serve(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serve establishes a single ssh connection over the backchannel and services it until
+// the connection is lost
+func (t *attachServerSSH) serve() error {
+	defer trace.End(trace.Begin("attach server connection"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	t.m.Lock()
+	t.cancel = cancel
+	t.m.Unlock()
+
 	var sConn *ssh.ServerConn
 	var chans <-chan ssh.NewChannel
 	var reqs <-chan *ssh.Request
@@ -163,8 +199,12 @@ func (t *attachServerSSH) run() error {
 
 	// keep waiting for the connection to establish
 	for t.enabled && sConn == nil {
+		if t.conn == nil {
+			return errors.New("no connection available for backchannel")
+		}
+
 		// wait for backchannel to establish
-		err = backchannel(context.Background(), t.conn)
+		err = backchannel(ctx, t.conn)
 		if err != nil {
 			detail := fmt.Sprintf("failed to establish backchannel: %s", err)
 			log.Error(detail)
@@ -220,9 +260,11 @@ func (t *attachServerSSH) run() error {
 		reason := ""
 		if !ok {
 			reason = "is unknown"
-		} else if session.Cmd.Process == nil {
-			reason = "process has not been launched"
-		} else if session.Cmd.Process.Signal(syscall.Signal(0)) != nil {
+		} else if session.Outwriter == nil {
+			// exec sessions may be waiting on the first attach to launch, so
+			// we only require that the streams have been configured
+			reason = "is not ready"
+		} else if session.Cmd.Process != nil && session.Cmd.Process.Signal(syscall.Signal(0)) != nil {
 			reason = "process has exited"
 		}
 
@@ -244,7 +286,6 @@ func (t *attachServerSSH) run() error {
 
 		// bind the channel to the Session
 		log.Debugf("binding reader/writers for channel for %s", sessionid)
-		session.Outwriter.Add(channel)
 		session.Reader.Add(channel)
 
 		// cleanup on detach from the session
@@ -255,19 +296,22 @@ func (t *attachServerSSH) run() error {
 
 		// tty's merge stdout and stderr so we don't bind an additional reader in that case
 		// but we need to do so for non-tty
-		if session.Pty == nil {
+		if !session.Tty {
 			session.Errwriter.Add(channel.Stderr())
 
 			// no good way to function chain, so reimplement appropriately
 			detach = func() {
 				session.Outwriter.Remove(channel)
 				session.Reader.Remove(channel)
-				session.Errwriter.Remove(channel)
+				session.Errwriter.Remove(channel.Stderr())
 			}
 		}
+
+		// the Outwriter is bound last as that may trigger the launch of a pending session
+		session.Outwriter.Add(channel)
 		log.Debugf("reader/writers bound for channel for %s", sessionid)
 
-		go t.channelMux(requests, session.Cmd.Process, session.Pty, detach)
+		go t.channelMux(requests, session, detach)
 	}
 
 	log.Info("incoming attach channel closed")
@@ -296,6 +340,14 @@ func (t *attachServerSSH) globalMux(reqchan <-chan *ssh.Request) {
 			msg := attach.ContainersMsg{IDs: keys}
 			payload = msg.Marshal()
 
+		case attach.ReloadReq:
+			if t.reload == nil {
+				ok = false
+				payload = []byte("reload is not supported")
+				break
+			}
+			pendingFn = t.reload
+
 		default:
 			ok = false
 			payload = []byte("unknown global request type: " + req.Type)
@@ -317,7 +369,7 @@ func (t *attachServerSSH) globalMux(reqchan <-chan *ssh.Request) {
 	}
 }
 
-func (t *attachServerSSH) channelMux(in <-chan *ssh.Request, process *os.Process, pty *os.File, detach func()) {
+func (t *attachServerSSH) channelMux(in <-chan *ssh.Request, session *tether.SessionConfig, detach func()) {
 	defer trace.End(trace.Begin("start attach server channel request handler"))
 
 	var err error
@@ -325,6 +377,11 @@ func (t *attachServerSSH) channelMux(in <-chan *ssh.Request, process *os.Process
 		var pendingFn func()
 		ok := true
 
+		// the process may not have been launched when the channel was accepted
+		// so these are resolved per request
+		process := session.Cmd.Process
+		pty := session.Pty
+
 		switch req.Type {
 		case attach.WindowChangeReq:
 			msg := attach.WindowChangeMsg{}
@@ -343,6 +400,9 @@ func (t *attachServerSSH) channelMux(in <-chan *ssh.Request, process *os.Process
 			if err = msg.Unmarshal(req.Payload); err != nil {
 				ok = false
 				log.Errorf(err.Error())
+			} else if process == nil {
+				ok = false
+				log.Errorf("illegal signal request for session %s that has not been launched", session.ID)
 			} else {
 				log.Infof("Sending signal %s to container process, pid=%d\n", string(msg.Signal), process.Pid)
 				err = signalProcess(process, msg.Signal)
@@ -123,9 +123,20 @@ func resizePty(pty uintptr, winSize *attach.WindowChangeMsg) error {
 }
 
 func signalProcess(process *os.Process, sig ssh.Signal) error {
-	signal := attach.Signals[sig]
+	msg := attach.SignalMsg{Signal: sig}
+	signal := msg.Signum()
 	defer trace.End(trace.Begin(fmt.Sprintf("signal process %d: %d", process.Pid, signal)))
 
+	if signal == 0 {
+		return fmt.Errorf("unknown signal %s", sig)
+	}
+
+	// session processes lead their own process group so deliver the signal to the whole group,
+	// falling back to the process alone if that's not the case
 	s := syscall.Signal(signal)
+	if err := syscall.Kill(-process.Pid, s); err == nil {
+		return nil
+	}
+
 	return process.Signal(s)
 }
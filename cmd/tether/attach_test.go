@@ -39,6 +39,7 @@ import (
 	"github.com/vmware/vic/lib/portlayer/attach"
 	"github.com/vmware/vic/lib/tether"
 	"github.com/vmware/vic/pkg/serial"
+	"github.com/vmware/vic/pkg/vsphere/extraconfig"
 )
 
 type testAttachServer struct {
@@ -643,6 +644,138 @@ func TestAttachInvalid(t *testing.T) {
 //
 /////////////////////////////////////////////////////////////////////////////////////
 
+/////////////////////////////////////////////////////////////////////////////////////
+// TestAttachExec adds a session to a running tether and attaches to it once
+// the tether has reloaded its configuration
+//
+func TestAttachExec(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	testServer, _ := server.(*testAttachServer)
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "attachexec",
+			Name: "tether_test_executor",
+		},
+
+		Sessions: map[string]metadata.SessionConfig{
+			"attachexec": metadata.SessionConfig{
+				Common: metadata.Common{
+					ID:   "attachexec",
+					Name: "tether_test_session",
+				},
+				Tty:    false,
+				Attach: true,
+				Cmd: metadata.Cmd{
+					Path: "/usr/bin/tee",
+					// grep, matching everything, reading from stdin
+					Args: []string{"/usr/bin/tee", pathPrefix + "/tee.out"},
+					Env:  []string{},
+					Dir:  "/",
+				},
+			},
+		},
+		Key: genKey(),
+	}
+
+	store := map[string]string{}
+	tthr, src, conn := startAttachTetherWithStore(t, &cfg, store)
+	defer tthr.Stop()
+
+	// wait for updates to occur
+	<-testServer.updated
+
+	if !testServer.enabled {
+		t.Error("attach server was not enabled")
+		return
+	}
+
+	containerConfig := &ssh.ClientConfig{
+		User: "daemon",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			return nil
+		},
+	}
+
+	// create the SSH client from the mocked connection
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "notappliable", containerConfig)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sshConn.Close()
+
+	attachClient := ssh.NewClient(sshConn, chans, reqs)
+
+	_, err = attach.SSHAttach(attachClient, "exec")
+	if !assert.Error(t, err, "Expected attach to unknown exec session to fail") {
+		return
+	}
+
+	// add the exec session to the configuration of the running tether
+	cfg.Sessions["exec"] = metadata.SessionConfig{
+		Common: metadata.Common{
+			ID:   "exec",
+			Name: "tether_test_exec",
+		},
+		Tty:    false,
+		Attach: true,
+		Cmd: metadata.Cmd{
+			Path: "/usr/bin/tee",
+			Args: []string{"/usr/bin/tee", pathPrefix + "/tee2.out"},
+			Env:  []string{},
+			Dir:  "/",
+		},
+	}
+	extraconfig.Encode(extraconfig.MapSink(store), cfg)
+
+	if !assert.NoError(t, attach.SSHReload(attachClient)) {
+		return
+	}
+
+	// the reload is asynchronous so retry until the session is known
+	var sshSession attach.SessionInteraction
+	for i := 0; i < 50; i++ {
+		sshSession, err = attach.SSHAttach(attachClient, "exec")
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	stdout := sshSession.Stdout()
+
+	testBytes := []byte("hello exec!\n")
+	// read from session into buffer
+	buf := &bytes.Buffer{}
+	done := make(chan bool)
+	go func() { io.CopyN(buf, stdout, int64(len(testBytes))); done <- true }()
+
+	// write something to echo
+	sshSession.Stdin().Write(testBytes)
+
+	// wait for the close to propogate
+	<-done
+	sshSession.Stdin().Close()
+
+	if !assert.Equal(t, buf.Bytes(), testBytes) {
+		return
+	}
+
+	// the exec session reports its launch via its own keys
+	started, err := src("guestinfo..sessions|exec.started")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "true", started)
+	}
+}
+
+//
+/////////////////////////////////////////////////////////////////////////////////////
+
 // Start the tether, start a mock esx serial to tcp connection, start the
 // attach server, try to Get() the tether's attached session.
 /*
@@ -68,6 +68,7 @@ func main() {
 	// create the tether and register the attach extension
 	tthr := tether.New(src, sink, &operations{})
 	tthr.Register("Attach", sshserver)
+	sshserver.reload = tthr.Reload
 
 	err = tthr.Start()
 	if err != nil {
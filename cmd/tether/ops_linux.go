@@ -25,6 +25,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/vmware/vic/lib/tether"
 	"github.com/vmware/vic/pkg/dio"
+	"github.com/vmware/vic/pkg/iolog"
 	"github.com/vmware/vic/pkg/trace"
 )
 
@@ -50,13 +51,13 @@ func (t *operations) Log() (io.Writer, error) {
 }
 
 // sessionLogWriter returns a writer that will persist the session output
-func (t *operations) SessionLog(session *tether.SessionConfig) (dio.DynamicMultiWriter, error) {
+func (t *operations) SessionLog(session *tether.SessionConfig) (dio.DynamicMultiWriter, dio.DynamicMultiWriter, error) {
 	defer trace.End(trace.Begin("configure session log writer"))
 
 	if t.logging {
 		detail := "unable to log more than one session concurrently"
 		log.Error(detail)
-		return nil, errors.New(detail)
+		return nil, nil, errors.New(detail)
 	}
 
 	t.logging = true
@@ -67,9 +68,13 @@ func (t *operations) SessionLog(session *tether.SessionConfig) (dio.DynamicMulti
 	if err != nil {
 		detail := fmt.Sprintf("failed to open serial port for session log: %s", err)
 		log.Error(detail)
-		return nil, errors.New(detail)
+		return nil, nil, errors.New(detail)
 	}
 
-	// use multi-writer so it goes to both screen and session log
-	return dio.MultiWriter(f, os.Stdout), nil
+	// record timestamped entries tagged with the stream so that the session log can be filtered
+	// and demultiplexed when read back, and use multi-writers so the output also goes to the screen
+	stdout := dio.MultiWriter(iolog.NewWriter(f, iolog.Stdout), os.Stdout)
+	stderr := dio.MultiWriter(iolog.NewWriter(f, iolog.Stderr), os.Stderr)
+
+	return stdout, stderr, nil
 }
@@ -21,6 +21,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"testing"
@@ -57,6 +58,8 @@ type Mocker struct {
 
 	// session output gets logged here
 	SessionLogBuffer bytes.Buffer
+	// session error output gets logged here
+	SessionErrLogBuffer bytes.Buffer
 
 	// the hostname of the system
 	Hostname string
@@ -83,7 +86,12 @@ func (t *Mocker) Stop() error {
 // Reload implements the extension method
 func (t *Mocker) Reload(config *tether.ExecutorConfig) error {
 	// the tether has definitely finished it's startup by the time we hit this
-	close(t.Started)
+	// the config may be reloaded multiple times, so only signal the first
+	select {
+	case <-t.Started:
+	default:
+		close(t.Started)
+	}
 	return nil
 }
 
@@ -105,8 +113,8 @@ func (t *Mocker) Log() (io.Writer, error) {
 	return &t.LogBuffer, nil
 }
 
-func (t *Mocker) SessionLog(session *tether.SessionConfig) (dio.DynamicMultiWriter, error) {
-	return dio.MultiWriter(&t.SessionLogBuffer, os.Stdout), nil
+func (t *Mocker) SessionLog(session *tether.SessionConfig) (dio.DynamicMultiWriter, dio.DynamicMultiWriter, error) {
+	return dio.MultiWriter(&t.SessionLogBuffer, os.Stdout), dio.MultiWriter(&t.SessionErrLogBuffer, os.Stderr), nil
 }
 
 func (t *Mocker) HandleSessionExit(config *tether.ExecutorConfig, session *tether.SessionConfig) bool {
@@ -138,7 +146,7 @@ func (t *Mocker) Apply(endpoint *metadata.NetworkEndpoint) error {
 
 // MountLabel performs a mount with the source treated as a disk label
 // This assumes that /dev/disk/by-label is being populated, probably by udev
-func (t *Mocker) MountLabel(label, target string, ctx context.Context) error {
+func (t *Mocker) MountLabel(label, target string, readOnly bool, ctx context.Context) error {
 	defer trace.End(trace.Begin(fmt.Sprintf("mocking mounting %s on %s", label, target)))
 
 	if t.Mounts == nil {
@@ -149,6 +157,31 @@ func (t *Mocker) MountLabel(label, target string, ctx context.Context) error {
 	return nil
 }
 
+// MountTarget mounts a network filesystem given as a URL on target
+func (t *Mocker) MountTarget(source url.URL, target string, readOnly bool, ctx context.Context) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("mocking mounting %s on %s", source.String(), target)))
+
+	if t.Mounts == nil {
+		t.Mounts = make(map[string]string)
+	}
+
+	// only the server and path matter, the user info doesn't survive the extraconfig round trip
+	t.Mounts[source.Host+source.Path] = target
+	return nil
+}
+
+// Unmount unmounts the filesystem mounted on target
+func (t *Mocker) Unmount(target string) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("mocking unmounting %s", target)))
+
+	for label, mounted := range t.Mounts {
+		if mounted == target {
+			delete(t.Mounts, label)
+		}
+	}
+	return nil
+}
+
 // Fork triggers vmfork and handles the necessary pre/post OS level operations
 func (t *Mocker) Fork() error {
 	defer trace.End(trace.Begin("mocking fork"))
@@ -166,7 +199,12 @@ func TestMain(m *testing.M) {
 }
 
 func StartAttachTether(t *testing.T, cfg *metadata.ExecutorConfig) (tether.Tether, extraconfig.DataSource, net.Conn) {
-	store := map[string]string{}
+	return startAttachTetherWithStore(t, cfg, map[string]string{})
+}
+
+// startAttachTetherWithStore allows the caller to retain access to the backing store so
+// that the configuration can be modified while the tether is running
+func startAttachTetherWithStore(t *testing.T, cfg *metadata.ExecutorConfig, store map[string]string) (tether.Tether, extraconfig.DataSource, net.Conn) {
 	sink := extraconfig.MapSink(store)
 	src := extraconfig.MapSource(store)
 	extraconfig.Encode(sink, cfg)
@@ -175,6 +213,9 @@ func StartAttachTether(t *testing.T, cfg *metadata.ExecutorConfig) (tether.Tethe
 	tthr := tether.New(src, sink, &Mocked)
 	tthr.Register("mocker", &Mocked)
 	tthr.Register("Attach", server)
+	if ts, ok := server.(*testAttachServer); ok {
+		ts.reload = tthr.Reload
+	}
 
 	// run the tether to service the attach
 	go func() {
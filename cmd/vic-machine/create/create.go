@@ -19,6 +19,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -56,6 +57,11 @@ type Create struct {
 
 	tlsGenerate bool
 
+	registryWhitelist  cli.StringSlice
+	registryBlacklist  cli.StringSlice
+	insecureRegistries cli.StringSlice
+	volumeStores       cli.StringSlice
+
 	osType  string
 	logfile string
 
@@ -112,6 +118,11 @@ func (c *Create) Flags() []cli.Flag {
 			Usage:       "Container datastore name - defaults to image datastore",
 			Destination: &c.ContainerDatastoreName,
 		},
+		cli.StringSliceFlag{
+			Name:  "volume-store",
+			Value: &c.volumeStores,
+			Usage: "Datastore path or NFS export to create volumes in, with the name docker refers to it by, e.g. datastore1/volumes:default or nfs://host/export:shared - may be repeated",
+		},
 		cli.StringFlag{
 			Name:        "name",
 			Value:       "docker-appliance",
@@ -182,12 +193,54 @@ func (c *Create) Flags() []cli.Flag {
 			Usage:       "vCPUs for the appliance VM",
 			Destination: &c.NumCPUs,
 		},
+		cli.StringSliceFlag{
+			Name:  "registry-whitelist",
+			Value: &c.registryWhitelist,
+			Usage: "Registry that images may be pulled from, e.g. registry.example.com:5000 or *.example.com - may be repeated, defaults to any registry",
+		},
+		cli.StringSliceFlag{
+			Name:  "registry-blacklist",
+			Value: &c.registryBlacklist,
+			Usage: "Registry that images may not be pulled from, takes precedence over the whitelist - may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "insecure-registry",
+			Value: &c.insecureRegistries,
+			Usage: "Registry that is reached without verifying its certificate, or over http - may be repeated",
+		},
+		cli.StringFlag{
+			Name:        "registry-certs",
+			Value:       "",
+			Usage:       "Directory holding a directory per registry, named host[:port], with CAs in .crt files and a client certificate and key in .cert and .key files",
+			Destination: &c.RegistryCertsPath,
+		},
 	}
 	flags = append(flags, c.TargetFlags()...)
 	flags = append(flags, c.DebugFlags()...)
 	return flags
 }
 
+// processVolumeStores parses the --volume-store values, given as
+// datastore/path:name or nfs://host/path:name, into the volume locations
+// keyed by name
+func (c *Create) processVolumeStores() error {
+	c.VolumeLocations = make(map[string]string)
+	for _, arg := range c.volumeStores {
+		i := strings.LastIndex(arg, ":")
+		if i <= 0 || i == len(arg)-1 {
+			return cli.NewExitError(fmt.Sprintf("--volume-store %s must be given as datastore/path:name or nfs://host/path:name", arg), 1)
+		}
+
+		name := arg[i+1:]
+		if _, ok := c.VolumeLocations[name]; ok {
+			return cli.NewExitError(fmt.Sprintf("--volume-store name %s is given more than once", name), 1)
+		}
+		c.VolumeLocations[name] = arg[:i]
+	}
+
+	return nil
+}
+
 func (c *Create) processParams() error {
 	if err := c.HasCredentials(); err != nil {
 		return err
@@ -216,6 +269,14 @@ func (c *Create) processParams() error {
 		return cli.NewExitError(fmt.Sprintf("Display name %s exceeds the permitted 31 characters limit. Please use a shorter -name parameter", c.DisplayName), 1)
 	}
 
+	if err := c.processVolumeStores(); err != nil {
+		return err
+	}
+
+	c.RegistryWhitelist = c.registryWhitelist
+	c.RegistryBlacklist = c.registryBlacklist
+	c.InsecureRegistries = c.insecureRegistries
+
 	// FIXME: add parameters for these configurations
 	c.osType = "linux"
 
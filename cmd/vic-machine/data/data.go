@@ -36,6 +36,7 @@ type Data struct {
 	DisplayName         string
 
 	ContainerDatastoreName string
+	VolumeLocations        map[string]string
 	ExternalNetworkName    string
 	ManagementNetworkName  string
 	BridgeNetworkName      string
@@ -44,6 +45,11 @@ type Data struct {
 	MappedNetworks        map[string]string
 	MappedNetworksGateway map[string]*net.IPNet
 
+	RegistryWhitelist  []string
+	RegistryBlacklist  []string
+	InsecureRegistries []string
+	RegistryCertsPath  string
+
 	NumCPUs  int
 	MemoryMB int
 
@@ -32,6 +32,7 @@ import (
 	"github.com/vmware/vic/lib/install/management"
 	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/pkg/errors"
+	"github.com/vmware/vic/pkg/registry"
 	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/session"
 
@@ -158,6 +159,7 @@ func (v *Validator) Validate(ctx context.Context, input *data.Data) (*metadata.V
 	v.network(ctx, input, conf)
 
 	v.certificate(ctx, input, conf)
+	v.registries(ctx, input, conf)
 
 	// Perform the higher level compatibility and consistency checks
 	v.compatibility(ctx, conf)
@@ -222,12 +224,36 @@ func (v *Validator) storage(ctx context.Context, input *data.Data, conf *metadat
 	defer trace.End(trace.Begin(""))
 
 	// Image Store
-	ds, err := v.datastoreHelper(ctx, input.ImageDatastoreName)
+	imageDSpath, ds, err := v.datastoreHelper(ctx, input.ImageDatastoreName)
 	v.NoteIssue(err)
-	conf.AddImageStore(ds)
+	if ds != nil {
+		// temporary until session is extracted
+		v.Session.Datastore = ds
+		v.Session.DatastorePath = imageDSpath.Host
+	}
+	conf.AddImageStore(imageDSpath)
+
+	// Volume Stores
+	for name, location := range input.VolumeLocations {
+		// NFS exports are mounted by the appliance and containerVMs, not found in vSphere
+		if u, err := url.Parse(location); err == nil && u.Scheme == "nfs" {
+			if u.Host == "" {
+				v.NoteIssue(fmt.Errorf("Error checking volume store %s: %s has no NFS server", name, location))
+				continue
+			}
+
+			conf.AddVolumeLocation(name, u)
+			continue
+		}
 
-	// TODO: add volume locations
+		dsURL, _, err := v.datastoreHelper(ctx, location)
+		if err != nil {
+			v.NoteIssue(fmt.Errorf("Error checking volume store %s: %s", name, err))
+			continue
+		}
 
+		conf.AddVolumeLocation(name, dsURL)
+	}
 }
 
 func (v *Validator) network(ctx context.Context, input *data.Data, conf *metadata.VirtualContainerHostConfigSpec) {
@@ -391,6 +417,51 @@ func (v *Validator) certificate(ctx context.Context, input *data.Data, conf *met
 	}
 }
 
+func (v *Validator) registries(ctx context.Context, input *data.Data, conf *metadata.VirtualContainerHostConfigSpec) {
+	defer trace.End(trace.Begin(""))
+
+	whitelist, err := registry.ParseEntries(input.RegistryWhitelist)
+	if err != nil {
+		v.NoteIssue(fmt.Errorf("Error parsing registry whitelist: %s", err))
+	}
+	conf.RegistryWhitelist = whitelist
+
+	blacklist, err := registry.ParseEntries(input.RegistryBlacklist)
+	if err != nil {
+		v.NoteIssue(fmt.Errorf("Error parsing registry blacklist: %s", err))
+	}
+	conf.RegistryBlacklist = blacklist
+
+	insecure, err := registry.ParseEntries(input.InsecureRegistries)
+	if err != nil {
+		v.NoteIssue(fmt.Errorf("Error parsing insecure registries: %s", err))
+	}
+	conf.InsecureRegistries = insecure
+
+	if input.RegistryCertsPath == "" {
+		return
+	}
+
+	certs, err := registry.ReadCertsDir(input.RegistryCertsPath)
+	if err != nil {
+		v.NoteIssue(fmt.Errorf("Error reading registry certificates: %s", err))
+		return
+	}
+
+	conf.RegistryCertificates = nil
+	for _, c := range certs {
+		log.Infof("Using certificates for registry %s", c.Registry)
+		conf.RegistryCertificates = append(conf.RegistryCertificates, metadata.RegistryCertificate{
+			Registry:               c.Registry,
+			CertificateAuthorities: c.CAs,
+			ClientCertificate: metadata.RawCertificate{
+				Key:  c.Key,
+				Cert: c.Cert,
+			},
+		})
+	}
+}
+
 func (v *Validator) compatibility(ctx context.Context, conf *metadata.VirtualContainerHostConfigSpec) {
 	defer trace.End(trace.Begin(""))
 
@@ -487,7 +558,9 @@ func (v *Validator) dpgHelper(ctx context.Context, path string) (string, error)
 	return moref.String(), nil
 }
 
-func (v *Validator) datastoreHelper(ctx context.Context, path string) (*url.URL, error) {
+// datastoreHelper resolves a datastore path, given as datastore/path or as a
+// ds:// URL, to a URL naming the datastore as its host
+func (v *Validator) datastoreHelper(ctx context.Context, path string) (*url.URL, *object.Datastore, error) {
 	defer trace.End(trace.Begin(path))
 
 	dsURL, err := url.Parse(path)
@@ -496,7 +569,7 @@ func (v *Validator) datastoreHelper(ctx context.Context, path string) (*url.URL,
 		pathElements := strings.Split(path, "/")
 		if pathElements[0] == "" {
 			// TODO: error about requiring datastore path and how to get a datastore list
-			return nil, errors.New("requires datastore name")
+			return nil, nil, errors.New("requires datastore name")
 		}
 
 		dsURL.Scheme = "ds://"
@@ -505,10 +578,14 @@ func (v *Validator) datastoreHelper(ctx context.Context, path string) (*url.URL,
 	}
 
 	// if a datastore name (e.g. "datastore1") is specifed with no decoration then this
-	// is interpreted as the Path
+	// is interpreted as the Path, as is a path on the datastore following the name
 	if dsURL.Host == "" && dsURL.Path != "" {
-		dsURL.Host = dsURL.Path
+		pathElements := strings.SplitN(dsURL.Path, "/", 2)
+		dsURL.Host = pathElements[0]
 		dsURL.Path = ""
+		if len(pathElements) > 1 {
+			dsURL.Path = pathElements[1]
+		}
 	}
 
 	stores, err := v.Session.Finder.DatastoreList(ctx, dsURL.Host)
@@ -516,21 +593,17 @@ func (v *Validator) datastoreHelper(ctx context.Context, path string) (*url.URL,
 		log.Debugf("no such datastore %#v", dsURL)
 		// TODO: error message about no such match and how to get a datastore list
 		// we return err directly here so we can check the type
-		return nil, err
+		return nil, nil, err
 	}
 	if len(stores) > 1 {
 		// TODO: error about required disabmiguation and list entries in nets
-		return nil, errors.New("ambiguous datastore " + dsURL.Host)
+		return nil, nil, errors.New("ambiguous datastore " + dsURL.Host)
 	}
 
-	// temporary until session is extracted
-	v.Session.Datastore = stores[0]
-	v.Session.DatastorePath = dsURL.Host
-
 	// FIXME: commented out until components can consume moid
 	// dsURL.Host = stores[0].Reference().Value
 
-	return dsURL, nil
+	return dsURL, stores[0], nil
 }
 
 func (v *Validator) resourcePoolHelper(ctx context.Context, path string) (*object.ResourcePool, error) {
@@ -20,11 +20,13 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
 
 	derr "github.com/docker/docker/errors"
+	"github.com/docker/docker/reference"
 	"github.com/vmware/vic/lib/apiservers/portlayer/client"
 	"github.com/vmware/vic/lib/apiservers/portlayer/client/storage"
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
@@ -105,6 +107,8 @@ func (c *ImageCache) Update(client *client.PortLayer) error {
 		}
 
 		if imageConfig.ImageID != "" {
+			// the layer holding the metadata is the top layer of the image
+			imageConfig.ID = layer.ID
 			c.cache[imageConfig.ImageID] = imageConfig
 		}
 	}
@@ -131,3 +135,64 @@ func (c *ImageCache) GetImages() ([]*metadata.ImageConfig, error) {
 
 	return result, nil
 }
+
+// GetImage returns the metadata of the image identified by its ID, a unique
+// prefix of its ID, or a name with an optional tag
+func (c *ImageCache) GetImage(idOrRef string) (*metadata.ImageConfig, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if CacheNotUpdated {
+		return nil, ErrCacheNotUpdated
+	}
+
+	id := strings.TrimPrefix(idOrRef, "sha256:")
+	if image, ok := c.cache[id]; ok {
+		return copyImage(image), nil
+	}
+
+	// names are matched on the repository name recorded by imagec, which is
+	// the remote name of the reference
+	if ref, err := reference.ParseNamed(idOrRef); err == nil {
+		tag := reference.DefaultTag
+		if tagged, ok := ref.(reference.NamedTagged); ok {
+			tag = tagged.Tag()
+		}
+
+		for _, image := range c.cache {
+			if (image.Name == ref.RemoteName() || image.Name == ref.Name()) && image.Tag == tag {
+				return copyImage(image), nil
+			}
+		}
+	}
+
+	var prefixed []*metadata.ImageConfig
+	for imageID, image := range c.cache {
+		if strings.HasPrefix(imageID, id) {
+			prefixed = append(prefixed, image)
+		}
+	}
+
+	switch len(prefixed) {
+	case 0:
+		return nil, fmt.Errorf("No such image: %s", idOrRef)
+	case 1:
+		return copyImage(prefixed[0]), nil
+	default:
+		return nil, fmt.Errorf("Multiple images match %s", idOrRef)
+	}
+}
+
+// RemoveImage removes the image with the given ID from the cache
+func (c *ImageCache) RemoveImage(id string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.cache, id)
+}
+
+func copyImage(image *metadata.ImageConfig) *metadata.ImageConfig {
+	newImage := new(metadata.ImageConfig)
+	*newImage = *image
+	return newImage
+}
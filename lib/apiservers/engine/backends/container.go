@@ -18,10 +18,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/context"
@@ -33,12 +38,18 @@ import (
 	"github.com/docker/docker/api/types/backend"
 	derr "github.com/docker/docker/errors"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/jsonlog"
 	"github.com/docker/docker/pkg/namesgenerator"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/docker/pkg/version"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
+	apinet "github.com/docker/engine-api/types/network"
 	"github.com/docker/engine-api/types/strslice"
+	timetypes "github.com/docker/engine-api/types/time"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 
 	viccontainer "github.com/vmware/vic/lib/apiservers/engine/backends/container"
 	"github.com/vmware/vic/lib/apiservers/portlayer/client"
@@ -48,6 +59,7 @@ import (
 	"github.com/vmware/vic/lib/apiservers/portlayer/client/storage"
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
 	"github.com/vmware/vic/lib/guest"
+	"github.com/vmware/vic/pkg/iolog"
 	"github.com/vmware/vic/pkg/trace"
 )
 
@@ -64,32 +76,205 @@ const (
 
 // ContainerExecCreate sets up an exec in a running container.
 func (c *Container) ContainerExecCreate(config *types.ExecConfig) (string, error) {
-	return "", fmt.Errorf("%s does not implement container.ContainerExecCreate", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecCreate"))
+
+	if len(config.Cmd) == 0 {
+		return "", derr.NewBadRequestError(fmt.Errorf("No exec command specified"))
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecCreate failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// resolve a name or short ID to the container so the exec is recorded against its full ID
+	info, err := getContainerInfo(config.Container)
+	if err != nil {
+		return "", err
+	}
+
+	// get a handle to the container
+	getRes, err := client.Containers.Get(containers.NewGetParams().WithID(info.ID))
+	if err != nil {
+		if _, ok := err.(*containers.GetNotFound); ok {
+			return "", derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", config.Container))
+		}
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
+	}
+
+	attach := config.AttachStdin || config.AttachStdout || config.AttachStderr
+	execConfig := &models.ExecCreateConfig{
+		Path:   config.Cmd[0],
+		Args:   config.Cmd[1:],
+		Tty:    &config.Tty,
+		Attach: &attach,
+		User:   &config.User,
+	}
+
+	execRes, err := client.Containers.ExecCreate(containers.NewExecCreateParams().WithHandle(getRes.Payload).WithExecConfig(execConfig))
+	if err != nil {
+		switch err := err.(type) {
+		case *containers.ExecCreateNotFound:
+			return "", derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", config.Container))
+
+		case *containers.ExecCreateDefault:
+			return "", derr.NewErrorWithStatusCode(fmt.Errorf(err.Payload.Message), err.Code())
+
+		default:
+			return "", derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
+		}
+	}
+
+	// commit the handle; this will reconfigure the vm with the new session
+	_, err = client.Containers.Commit(containers.NewCommitParams().WithHandle(execRes.Payload.Handle))
+	if err != nil {
+		if _, ok := err.(*containers.CommitNotFound); ok {
+			return "", derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", config.Container))
+		}
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
+	}
+
+	eid := execRes.Payload.ID
+	config.Container = info.ID
+	viccontainer.GetCache().SaveExec(eid, &viccontainer.VicExec{
+		ID:          eid,
+		ContainerID: info.ID,
+		Config:      config,
+	})
+
+	return eid, nil
 }
 
 // ContainerExecInspect returns low-level information about the exec
 // command. An error is returned if the exec cannot be found.
 func (c *Container) ContainerExecInspect(id string) (*backend.ExecInspect, error) {
-	return nil, fmt.Errorf("%s does not implement container.ContainerExecInspect", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecInspect"))
+
+	ve := viccontainer.GetCache().GetExec(id)
+	if ve == nil {
+		return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance: %s", id))
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecInspect failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	infoRes, err := client.Containers.ExecInspect(containers.NewExecInspectParams().WithID(ve.ContainerID).WithEid(id))
+	if err != nil {
+		if _, ok := err.(*containers.ExecInspectNotFound); ok {
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance: %s", id))
+		}
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
+	}
+
+	info := infoRes.Payload
+	inspect := &backend.ExecInspect{
+		ID:          id,
+		ContainerID: ve.ContainerID,
+		OpenStdin:   ve.Config.AttachStdin,
+		OpenStdout:  ve.Config.AttachStdout,
+		OpenStderr:  ve.Config.AttachStderr,
+		DetachKeys:  []byte(ve.Config.DetachKeys),
+		ProcessConfig: &backend.ExecProcessConfig{
+			Tty:        ve.Config.Tty,
+			Entrypoint: ve.Config.Cmd[0],
+			Arguments:  ve.Config.Cmd[1:],
+			User:       ve.Config.User,
+		},
+	}
+
+	if info.Running != nil {
+		inspect.Running = *info.Running
+	}
+
+	// the exit code is only meaningful once the process has stopped
+	if info.StopTime != nil && *info.StopTime != 0 && info.ExitCode != nil {
+		exitCode := int(*info.ExitCode)
+		inspect.ExitCode = &exitCode
+	}
+
+	return inspect, nil
 }
 
 // ContainerExecResize changes the size of the TTY of the process
 // running in the exec with the given name to the given height and
 // width.
 func (c *Container) ContainerExecResize(name string, height, width int) error {
-	return fmt.Errorf("%s does not implement container.ContainerExecResize", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecResize"))
+
+	if viccontainer.GetCache().GetExec(name) == nil {
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance: %s", name))
+	}
+
+	// exec sessions are addressed by their own ID for interaction
+	return c.ContainerResize(name, height, width)
 }
 
 // ContainerExecStart starts a previously set up exec instance. The
 // std streams are set up.
 func (c *Container) ContainerExecStart(name string, stdin io.ReadCloser, stdout io.Writer, stderr io.Writer) error {
-	return fmt.Errorf("%s does not implement container.ContainerExecStart", c.ProductName)
+	defer trace.End(trace.Begin("ContainerExecStart"))
+
+	ve := viccontainer.GetCache().GetExec(name)
+	if ve == nil {
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance: %s", name))
+	}
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerExecStart failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	_, err := client.Interaction.ExecStart(interaction.NewExecStartParams().WithID(ve.ContainerID).WithEid(name))
+	if err != nil {
+		switch err := err.(type) {
+		case *interaction.ExecStartNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf(err.Payload.Message))
+
+		case *interaction.ExecStartInternalServerError:
+			return derr.NewErrorWithStatusCode(fmt.Errorf(err.Payload.Message), http.StatusInternalServerError)
+
+		default:
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Unknown error from the interaction port layer: %s", err),
+				http.StatusInternalServerError)
+		}
+	}
+
+	if !ve.Config.AttachStdin {
+		stdin = nil
+	}
+	if !ve.Config.AttachStdout {
+		stdout = nil
+	}
+	if !ve.Config.AttachStderr {
+		stderr = nil
+	}
+
+	if stdin == nil && stdout == nil && stderr == nil {
+		// detached exec
+		return nil
+	}
+
+	return attachStreams(name, ve.Config.Tty, false, stdin, stdout, stderr, []byte(ve.Config.DetachKeys))
 }
 
 // ExecExists looks up the exec instance and returns a bool if it exists or not.
 // It will also return the error produced by `getConfig`
 func (c *Container) ExecExists(name string) (bool, error) {
-	return false, fmt.Errorf("%s does not implement container.ExecExists", c.ProductName)
+	defer trace.End(trace.Begin("ExecExists"))
+
+	if viccontainer.GetCache().GetExec(name) == nil {
+		return false, derr.NewRequestNotFoundError(fmt.Errorf("No such exec instance: %s", name))
+	}
+
+	return true, nil
 }
 
 // docker's container.copyBackend
@@ -166,6 +351,9 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 	if config.Config.WorkingDir == "" {
 		config.Config.WorkingDir = layer.Config.WorkingDir
 	}
+	if config.Config.User == "" {
+		config.Config.User = layer.Config.User
+	}
 	if len(config.Config.Entrypoint) == 0 {
 		config.Config.Entrypoint = layer.Config.Entrypoint
 	}
@@ -175,6 +363,19 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 	}
 	config.Config.Env = append(config.Config.Env, layer.Config.Env...)
 
+	// the image's exposed ports are published along with the container's by -P
+	for port := range layer.Config.ExposedPorts {
+		if config.Config.ExposedPorts == nil {
+			config.Config.ExposedPorts = make(map[nat.Port]struct{})
+		}
+		config.Config.ExposedPorts[port] = struct{}{}
+	}
+
+	ports, err := publishedPorts(config)
+	if err != nil {
+		return types.ContainerCreateResponse{}, derr.NewBadRequestError(err)
+	}
+
 	// Was a name provided - if not create a friendly name
 	if config.Name == "" {
 		//TODO: Assume we could have a name collison here : need to
@@ -209,6 +410,7 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 	// configure networking
 	netConf := toModelsNetworkConfig(config)
 	if netConf != nil {
+		netConf.Ports = ports
 		addContRes, err := client.Scopes.AddContainer(scopes.NewAddContainerParams().
 			WithScope(netConf.NetworkName).
 			WithConfig(&models.ScopesAddContainerConfig{
@@ -234,9 +436,19 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 		h = addContRes.Payload
 	}
 
+	// add the volumes, creating any that don't exist yet
+	vol := &Volume{ProductName: c.ProductName}
+	h, anonymous, err := vol.joinVolumes(h, config, layer.Config.Volumes)
+	if err != nil {
+		return types.ContainerCreateResponse{}, err
+	}
+
 	// commit the create op
 	_, err = client.Containers.Commit(containers.NewCommitParams().WithHandle(h))
 	if err != nil {
+		// the anonymous volumes belong to a container that was never created
+		removeVolumes(anonymous)
+
 		// FIXME: Containers.Commit returns more errors than it's swagger spec says.
 		// When no image exist, it also sends back non swagger errors.  We should fix
 		// this once Commit returns correct error codes.
@@ -247,8 +459,10 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 	// these are the parameters that the containers were actually created with
 	layer.Config.Cmd = config.Config.Cmd
 	layer.Config.WorkingDir = config.Config.WorkingDir
+	layer.Config.User = config.Config.User
 	layer.Config.Entrypoint = config.Config.Entrypoint
 	layer.Config.Env = config.Config.Env
+	layer.Config.ExposedPorts = config.Config.ExposedPorts
 	layer.Config.AttachStdin = config.Config.AttachStdin
 	layer.Config.AttachStdout = config.Config.AttachStdout
 	layer.Config.AttachStderr = config.Config.AttachStderr
@@ -270,7 +484,44 @@ func (c *Container) ContainerCreate(config types.ContainerCreateConfig) (types.C
 // for the container to exit.
 // If a signal is given, then just send it to the container and return.
 func (c *Container) ContainerKill(name string, sig uint64) error {
-	return fmt.Errorf("%s does not implement container.ContainerKill", c.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	if sig != 0 {
+		return c.containerSignal(name, sig)
+	}
+
+	if err := c.containerSignal(name, uint64(syscall.SIGKILL)); err != nil {
+		return err
+	}
+
+	// the process has been killed so there is nothing to wait for before stopping the container
+	return c.containerStop(name, 0, true)
+}
+
+// containerSignal sends the signal to the primary process of the container
+func (c *Container) containerSignal(name string, sig uint64) error {
+	//retrieve client to portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerKill failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	_, err := client.Containers.ContainerSignal(containers.NewContainerSignalParams().WithID(name).WithSignal(int64(sig)))
+	if err != nil {
+		switch err := err.(type) {
+		case *containers.ContainerSignalNotFound:
+			return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+
+		case *containers.ContainerSignalDefault:
+			return derr.NewErrorWithStatusCode(fmt.Errorf(err.Payload.Message), http.StatusInternalServerError)
+
+		default:
+			return derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+	}
+
+	return nil
 }
 
 // ContainerPause pauses a container
@@ -356,6 +607,16 @@ func (c *Container) ContainerRm(name string, config *types.ContainerRmConfig) er
 
 	//FIXME: currently the portlayer yaml does not support more params than the simple name param
 
+	// the mounts have to be known before the container is gone
+	var mounts []*models.ContainerMountInfo
+	if config != nil && config.RemoveVolume {
+		info, err := getContainerInfo(name)
+		if err != nil {
+			return err
+		}
+		mounts = info.Mounts
+	}
+
 	//call the remove directly on the name. No need for using a handle.
 	_, err := client.Containers.ContainerRemove(containers.NewContainerRemoveParams().WithID(name))
 	if err != nil {
@@ -364,6 +625,9 @@ func (c *Container) ContainerRm(name string, config *types.ContainerRmConfig) er
 		}
 		return derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
 	}
+
+	removeAnonymousVolumes(mounts)
+
 	return nil
 }
 
@@ -514,7 +778,8 @@ func (c *Container) containerStop(name string, seconds int, unbound bool) error
 
 	handle = stateChangeResponse.Payload
 
-	_, err = client.Containers.Commit(containers.NewCommitParams().WithHandle(handle))
+	wait := int32(seconds)
+	_, err = client.Containers.Commit(containers.NewCommitParams().WithHandle(handle).WithWait(&wait))
 	if err != nil {
 		if _, ok := err.(*containers.CommitNotFound); ok {
 			return derr.NewRequestNotFoundError(fmt.Errorf("server error from portlayer"))
@@ -541,7 +806,42 @@ func (c *Container) ContainerUpdate(name string, hostConfig *container.HostConfi
 // timeout, an error is returned. If you want to wait forever, supply
 // a negative duration for the timeout.
 func (c *Container) ContainerWait(name string, timeout time.Duration) (int, error) {
-	return 0, fmt.Errorf("%s does not implement container.ContainerWait", c.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	client := PortLayerClient()
+	if client == nil {
+		return -1, derr.NewErrorWithStatusCode(fmt.Errorf("container.ContainerWait failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	// the request is long-lived so it's bounded by the wait timeout rather than the client default
+	params := containers.NewContainerWaitParamsWithContext(context.Background()).WithID(name)
+	if timeout >= 0 {
+		ms := int64(timeout / time.Millisecond)
+		params = params.WithTimeout(&ms)
+	}
+
+	res, err := client.Containers.ContainerWait(params)
+	if err != nil {
+		switch err := err.(type) {
+		case *containers.ContainerWaitNotFound:
+			return -1, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+
+		case *containers.ContainerWaitRequestTimeout:
+			return -1, fmt.Errorf("Timed out: %v", timeout)
+
+		case *containers.ContainerWaitDefault:
+			return -1, derr.NewErrorWithStatusCode(fmt.Errorf(err.Payload.Message), http.StatusInternalServerError)
+
+		default:
+			return -1, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+	}
+
+	if res.Payload.ExitCode == nil {
+		return -1, nil
+	}
+	return int(*res.Payload.ExitCode), nil
 }
 
 // docker's container.monitorBackend
@@ -559,33 +859,235 @@ func (c *Container) ContainerInspect(name string, size bool, version version.Ver
 
 	defer trace.End(trace.Begin("ContainerInspect"))
 
-	// Look up the container info in the metadata cache
-	vc := viccontainer.GetCache().GetContainerByName(name)
-	if vc == nil {
-		return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+	info, err := getContainerInfo(name)
+	if err != nil {
+		return nil, err
 	}
 
-	// FIXME: Just set a bunch of dummy info needed to get docker attach working.
-	// Once we have event stream working (part of docker ps work), we need to go
-	// back and replace this.
+	state := containerState(info)
+
 	base := &types.ContainerJSONBase{
-		State: &types.ContainerState{Status: "running",
-			Running: true,
-			Paused:  false,
-		},
+		ID:      info.ID,
+		Path:    stringValue(info.Path),
+		Args:    info.Args,
+		State:   state,
+		Image:   stringValue(info.Image),
+		Name:    "/" + stringValue(info.Name),
+		Driver:  c.ProductName,
+		ExecIDs: []string{},
+	}
+
+	if info.Created != nil {
+		base.Created = time.Unix(*info.Created, 0).UTC().Format(time.RFC3339Nano)
+	}
+
+	if info.RestartCount != nil {
+		base.RestartCount = int(*info.RestartCount)
+	}
+
+	if policy := info.RestartPolicy; policy != nil {
+		base.HostConfig = &container.HostConfig{
+			RestartPolicy: container.RestartPolicy{
+				Name: stringValue(policy.Name),
+			},
+		}
+		if policy.MaximumRetryCount != nil {
+			base.HostConfig.RestartPolicy.MaximumRetryCount = int(*policy.MaximumRetryCount)
+		}
 	}
 
-	conJSON := &types.ContainerJSON{ContainerJSONBase: base, Config: vc.Config}
+	ports := portMap(info)
+
+	// a container that can't be started because another holds one of its host ports shows
+	// the binding it asks for, and why it doesn't have it
+	if info.State != "RUNNING" {
+		running, err := runningContainers()
+		if err != nil {
+			return nil, err
+		}
+
+		var conflicts []string
+		for port, bindings := range portConflicts(info, running) {
+			ports[port] = bindings
+			for _, binding := range bindings {
+				conflicts = append(conflicts, fmt.Sprintf("Bind for %s:%s failed: port is already allocated",
+					binding.HostIP, binding.HostPort))
+			}
+		}
+		sort.Strings(conflicts)
+		state.Error = strings.Join(conflicts, "; ")
+	}
+
+	conJSON := &types.ContainerJSON{
+		ContainerJSONBase: base,
+		Config:            containerConfig(info),
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{
+				Ports: ports,
+			},
+			Networks: endpointSettings(info),
+		},
+		Mounts: mountPoints(info),
+	}
 
 	log.Debugf("ContainerInspect json config = %+v\n", conJSON.Config)
 
 	return conJSON, nil
 }
 
+// getContainerInfo looks up a container by name, ID or ID prefix in the port layer, so that
+// containers the personality hasn't cached, such as those created before it was restarted,
+// are found
+func getContainerInfo(name string) (*models.ContainerInfo, error) {
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	infoRes, err := client.Containers.GetContainerInfo(containers.NewGetContainerInfoParams().WithID(name))
+	if err != nil {
+		if _, ok := err.(*containers.GetContainerInfoNotFound); ok {
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+		}
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer"), http.StatusInternalServerError)
+	}
+
+	return infoRes.Payload, nil
+}
+
+// containerConfig returns the configuration recorded for the container at create, falling back
+// to what the port layer knows
+func containerConfig(info *models.ContainerInfo) *container.Config {
+	if vc := viccontainer.GetCache().GetContainerByName(info.ID); vc != nil {
+		return vc.Config
+	}
+
+	return &container.Config{
+		Image:      stringValue(info.RepoName),
+		Cmd:        append(strslice.StrSlice{stringValue(info.Path)}, info.Args...),
+		Env:        info.Env,
+		WorkingDir: stringValue(info.WorkingDir),
+		Tty:        info.Tty != nil && *info.Tty,
+		Labels:     info.Labels,
+		User:       stringValue(info.User),
+	}
+}
+
 // ContainerLogs hooks up a container's stdout and stderr streams
 // configured with the given struct.
 func (c *Container) ContainerLogs(name string, config *backend.ContainerLogsConfig, started chan struct{}) error {
-	return fmt.Errorf("%s does not implement container.ContainerLogs", c.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	if !(config.ShowStdout || config.ShowStderr) {
+		return fmt.Errorf("You must choose at least one stream")
+	}
+
+	info, err := getContainerInfo(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := containers.NewContainerLogsParamsWithContext(ctx).WithID(info.ID).WithFollow(&config.Follow)
+
+	if config.Since != "" {
+		s, n, err := timetypes.ParseTimestamps(config.Since, 0)
+		if err != nil {
+			return err
+		}
+		since := time.Unix(s, n).UnixNano()
+		params = params.WithSince(&since)
+	}
+
+	if tail, err := strconv.ParseInt(config.Tail, 10, 64); err == nil && tail >= 0 {
+		params = params.WithTail(&tail)
+	}
+
+	transport := httptransport.New(PortLayerServer(), "/", []string{"http"})
+	plClient := client.New(transport, nil)
+	transport.Consumers["application/octet-stream"] = httpkit.ByteStreamConsumer()
+
+	// stop reading from the port layer if the client goes away
+	go func() {
+		select {
+		case <-config.Stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	pr, pw := io.Pipe()
+	errors := make(chan error, 1)
+	go func() {
+		_, err := plClient.Containers.ContainerLogs(params, pw)
+		pw.CloseWithError(err)
+		errors <- err
+	}()
+
+	wf := ioutils.NewWriteFlusher(config.OutStream)
+	defer wf.Close()
+	close(started)
+	wf.Flush()
+
+	var outStream io.Writer = wf
+	errStream := outStream
+	if info.Tty == nil || !*info.Tty {
+		errStream = stdcopy.NewStdWriter(outStream, stdcopy.Stderr)
+		outStream = stdcopy.NewStdWriter(outStream, stdcopy.Stdout)
+	}
+
+	r := iolog.NewReader(pr)
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			break
+		}
+
+		w := outStream
+		if entry.Stream == iolog.Stderr {
+			if !config.ShowStderr {
+				continue
+			}
+			w = errStream
+		} else if !config.ShowStdout {
+			continue
+		}
+
+		line := entry.Data
+		if config.Timestamps {
+			line = append([]byte(entry.Timestamp.Format(jsonlog.RFC3339NanoFixed)+" "), line...)
+		}
+
+		if _, err = w.Write(line); err != nil {
+			log.Debugf("Error writing logs for %s: %s", name, err)
+			break
+		}
+	}
+
+	// unblock the port layer request if we stopped reading early
+	cancel()
+	pr.Close()
+
+	err = <-errors
+	if err == nil || err == context.Canceled {
+		return nil
+	}
+
+	switch err := err.(type) {
+	case *containers.ContainerLogsNotFound:
+		return derr.NewRequestNotFoundError(fmt.Errorf("No such container: %s", name))
+
+	case *containers.ContainerLogsDefault:
+		return derr.NewErrorWithStatusCode(fmt.Errorf(err.Payload.Message), http.StatusInternalServerError)
+
+	default:
+		log.Debugf("Stopped reading logs for %s: %s", name, err)
+		return nil
+	}
 }
 
 // ContainerStats writes information about the container to the stream
@@ -605,7 +1107,88 @@ func (c *Container) ContainerTop(name string, psArgs string) (*types.ContainerPr
 
 // Containers returns the list of containers to show given the user's filtering.
 func (c *Container) Containers(config *types.ContainerListOptions) ([]*types.Container, error) {
-	return nil, fmt.Errorf("%s does not implement container.Containers", c.ProductName)
+	defer trace.End(trace.Begin("Containers"))
+
+	// Get an API client to the portlayer
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("container.Containers failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	params := containers.NewContainerListParams().WithAll(&config.All)
+
+	if labels := config.Filter.Get("label"); len(labels) > 0 {
+		params = params.WithLabel(labels)
+	}
+
+	// the port layer supports a single value for each of these filters
+	if ids := config.Filter.Get("id"); len(ids) > 0 {
+		params = params.WithID(&ids[0])
+	}
+	if names := config.Filter.Get("name"); len(names) > 0 {
+		params = params.WithName(&names[0])
+	}
+	if statuses := config.Filter.Get("status"); len(statuses) > 0 {
+		var state string
+		switch statuses[0] {
+		case "created":
+			state = "CREATED"
+		case "running":
+			state = "RUNNING"
+		case "exited":
+			state = "STOPPED"
+		default:
+			return nil, derr.NewBadRequestError(fmt.Errorf("Unrecognised filter value for status: %s", statuses[0]))
+		}
+		params = params.WithState(&state)
+		// filtering on state implies all
+		all := true
+		params = params.WithAll(&all)
+	}
+
+	listRes, err := client.Containers.ContainerList(params)
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer: %s", err), http.StatusInternalServerError)
+	}
+
+	list := make([]*types.Container, 0, len(listRes.Payload))
+	for _, info := range listRes.Payload {
+		state := containerState(info)
+
+		command := stringValue(info.Path)
+		if len(info.Args) > 0 {
+			command = fmt.Sprintf("%s %s", command, strings.Join(info.Args, " "))
+		}
+
+		con := &types.Container{
+			ID:      info.ID,
+			Names:   []string{"/" + stringValue(info.Name)},
+			Image:   stringValue(info.RepoName),
+			ImageID: stringValue(info.Image),
+			Command: command,
+			Labels:  info.Labels,
+			State:   state.Status,
+			Status:  containerStatus(state),
+			Ports:   summaryPorts(portMap(info)),
+			NetworkSettings: &types.SummaryNetworkSettings{
+				Networks: endpointSettings(info),
+			},
+			Mounts: mountPoints(info),
+		}
+
+		if info.Created != nil {
+			con.Created = *info.Created
+		}
+
+		list = append(list, con)
+
+		if config.Limit > 0 && len(list) == config.Limit {
+			break
+		}
+	}
+
+	return list, nil
 }
 
 // docker's container.attachBackend
@@ -643,10 +1226,10 @@ func (c *Container) ContainerAttach(prefixOrName string, ca *backend.ContainerAt
 	if !vc.Config.Tty && ca.MuxStreams {
 		// replace the stdout/stderr with Docker's multiplex stream
 		if ca.UseStdout {
-			clStderr = stdcopy.NewStdWriter(clStderr, stdcopy.Stderr)
+			clStdout = stdcopy.NewStdWriter(clStdout, stdcopy.Stdout)
 		}
 		if ca.UseStderr {
-			clStdout = stdcopy.NewStdWriter(clStdout, stdcopy.Stdout)
+			clStderr = stdcopy.NewStdWriter(clStderr, stdcopy.Stderr)
 		}
 	}
 
@@ -708,6 +1291,29 @@ func (c *Container) dockerContainerCreateParamsToPortlayer(cc types.ContainerCre
 	config.Tty = new(bool)
 	*config.Tty = cc.Config.Tty
 
+	// user and supplementary groups
+	config.User = new(string)
+	*config.User = cc.Config.User
+	if cc.HostConfig != nil {
+		config.Groups = cc.HostConfig.GroupAdd
+	}
+
+	// image reference as requested
+	config.RepoName = new(string)
+	*config.RepoName = cc.Config.Image
+
+	// labels
+	config.Labels = cc.Config.Labels
+
+	// restart policy
+	if cc.HostConfig != nil && cc.HostConfig.RestartPolicy.Name != "" {
+		retries := int32(cc.HostConfig.RestartPolicy.MaximumRetryCount)
+		config.RestartPolicy = &models.RestartPolicy{
+			Name:              &cc.HostConfig.RestartPolicy.Name,
+			MaximumRetryCount: &retries,
+		}
+	}
+
 	log.Printf("dockerContainerCreateParamsToPortlayer = %+v", config)
 
 	return containers.NewCreateParams().WithCreateConfig(config)
@@ -723,15 +1329,60 @@ func toModelsNetworkConfig(cc types.ContainerCreateConfig) *models.NetworkConfig
 	}
 	if cc.NetworkingConfig != nil {
 		if es, ok := cc.NetworkingConfig.EndpointsConfig[nc.NetworkName]; ok {
-			if es.IPAMConfig != nil {
-				nc.Address = &es.IPAMConfig.IPv4Address
-			}
+			nc.Address = endpointAddress(es.IPAMConfig)
 		}
 	}
 
 	return nc
 }
 
+// publishedPorts returns the ports to publish for the container on the VCH, in the
+// [hostPort:]containerPort/protocol form the port layer takes.  Ports given with -p keep their
+// host port, if any, and -P publishes the remaining exposed ports on host ports allocated by the
+// port layer when the container starts.
+func publishedPorts(cc types.ContainerCreateConfig) ([]string, error) {
+	if cc.HostConfig == nil {
+		return nil, nil
+	}
+
+	var ports []string
+	for port, bindings := range cc.HostConfig.PortBindings {
+		if start, end, err := port.Range(); err != nil || start != end {
+			return nil, fmt.Errorf("invalid port %s, port ranges are not supported", port)
+		}
+
+		for _, binding := range bindings {
+			if binding.HostIP != "" && binding.HostIP != "0.0.0.0" {
+				return nil, fmt.Errorf("invalid port %s, ports can only be published on all of the VCH's addresses", port)
+			}
+
+			spec := fmt.Sprintf("%d/%s", port.Int(), port.Proto())
+			if binding.HostPort != "" {
+				hostPort, err := nat.ParsePort(binding.HostPort)
+				if err != nil {
+					return nil, fmt.Errorf("invalid host port %s for port %s", binding.HostPort, port)
+				}
+				spec = fmt.Sprintf("%d:%s", hostPort, spec)
+			}
+
+			ports = append(ports, spec)
+		}
+	}
+
+	if cc.HostConfig.PublishAllPorts {
+		for port := range cc.Config.ExposedPorts {
+			if _, ok := cc.HostConfig.PortBindings[port]; ok {
+				continue
+			}
+
+			ports = append(ports, fmt.Sprintf("%d/%s", port.Int(), port.Proto()))
+		}
+	}
+
+	sort.Strings(ports)
+	return ports, nil
+}
+
 func (c *Container) imageExist(imageID string) (storeName string, err error) {
 	// Call the storage port layer to determine if the image currently exist
 	host, err := guest.UUID()
@@ -987,3 +1638,225 @@ func copyEscapable(dst io.Writer, src io.ReadCloser, keys []byte) (written int64
 	}
 	return written, err
 }
+
+// containerState converts the port layer container state to the docker representation
+func containerState(info *models.ContainerInfo) *types.ContainerState {
+	state := &types.ContainerState{}
+
+	switch info.State {
+	case "RUNNING":
+		state.Status = "running"
+		state.Running = true
+	case "CREATED":
+		state.Status = "created"
+	default:
+		state.Status = "exited"
+		if info.ExitCode != nil {
+			state.ExitCode = int(*info.ExitCode)
+		}
+	}
+
+	// docker reports the zero time for events that haven't happened
+	state.StartedAt = time.Time{}.Format(time.RFC3339Nano)
+	state.FinishedAt = state.StartedAt
+	if info.StartTime != nil && *info.StartTime != 0 {
+		state.StartedAt = time.Unix(*info.StartTime, 0).UTC().Format(time.RFC3339Nano)
+	}
+	if info.StopTime != nil && *info.StopTime != 0 {
+		state.FinishedAt = time.Unix(*info.StopTime, 0).UTC().Format(time.RFC3339Nano)
+	}
+
+	return state
+}
+
+// containerStatus generates the human readable status used by docker ps
+func containerStatus(state *types.ContainerState) string {
+	switch state.Status {
+	case "running":
+		started, err := time.Parse(time.RFC3339Nano, state.StartedAt)
+		if err != nil {
+			return "Up"
+		}
+		return fmt.Sprintf("Up %s", units.HumanDuration(time.Now().UTC().Sub(started)))
+	case "created":
+		return "Created"
+	default:
+		finished, err := time.Parse(time.RFC3339Nano, state.FinishedAt)
+		if err != nil || finished.IsZero() {
+			return fmt.Sprintf("Exited (%d)", state.ExitCode)
+		}
+		return fmt.Sprintf("Exited (%d) %s ago", state.ExitCode, units.HumanDuration(time.Now().UTC().Sub(finished)))
+	}
+}
+
+// endpointSettings converts the port layer network information to the docker representation
+func endpointSettings(info *models.ContainerInfo) map[string]*apinet.EndpointSettings {
+	endpoints := make(map[string]*apinet.EndpointSettings)
+	for _, n := range info.Networks {
+		endpoint := &apinet.EndpointSettings{
+			Gateway: stringValue(n.Gateway),
+		}
+
+		if n.Address != nil {
+			if ip, ipnet, err := net.ParseCIDR(*n.Address); err == nil {
+				if ip.To4() != nil {
+					endpoint.IPAddress = ip.String()
+					endpoint.IPPrefixLen, _ = ipnet.Mask.Size()
+				} else {
+					// docker reports IPv6 addresses and gateways separately
+					endpoint.GlobalIPv6Address = ip.String()
+					endpoint.GlobalIPv6PrefixLen, _ = ipnet.Mask.Size()
+					endpoint.IPv6Gateway, endpoint.Gateway = endpoint.Gateway, ""
+				}
+			}
+		}
+
+		endpoints[n.Name] = endpoint
+	}
+
+	return endpoints
+}
+
+// parsePortSpec splits a port as the port layer publishes it, [hostPort:]containerPort/protocol,
+// into the container port and the host port, if any
+func parsePortSpec(spec string) (nat.Port, string, error) {
+	var hostPort string
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		hostPort, spec = spec[:i], spec[i+1:]
+	}
+
+	proto, containerPort := nat.SplitProtoPort(spec)
+	port, err := nat.NewPort(proto, containerPort)
+	return port, hostPort, err
+}
+
+// portMap converts the ports the port layer publishes to the docker representation.  The host
+// ports are only reported while the container is running, as that's when they're bound.
+func portMap(info *models.ContainerInfo) nat.PortMap {
+	ports := make(nat.PortMap)
+	for _, n := range info.Networks {
+		for _, spec := range n.Ports {
+			port, hostPort, err := parsePortSpec(spec)
+			if err != nil {
+				log.Warnf("Ignoring invalid port %s on network %s", spec, n.Name)
+				continue
+			}
+
+			if hostPort == "" || info.State != "RUNNING" {
+				if _, ok := ports[port]; !ok {
+					ports[port] = nil
+				}
+				continue
+			}
+
+			ports[port] = append(ports[port], nat.PortBinding{HostIP: "0.0.0.0", HostPort: hostPort})
+		}
+	}
+
+	return ports
+}
+
+// portConflicts returns the bindings the container asks for whose host ports are held by one
+// of the running containers, which keeps the container from being started
+func portConflicts(info *models.ContainerInfo, running []*models.ContainerInfo) nat.PortMap {
+	held := make(map[string]bool)
+	for _, other := range running {
+		if other.ID == info.ID {
+			continue
+		}
+
+		for _, n := range other.Networks {
+			for _, spec := range n.Ports {
+				if port, hostPort, err := parsePortSpec(spec); err == nil && hostPort != "" {
+					held[hostPort+"/"+port.Proto()] = true
+				}
+			}
+		}
+	}
+
+	conflicts := make(nat.PortMap)
+	for _, n := range info.Networks {
+		for _, spec := range n.Ports {
+			port, hostPort, err := parsePortSpec(spec)
+			if err != nil || hostPort == "" || !held[hostPort+"/"+port.Proto()] {
+				continue
+			}
+
+			conflicts[port] = append(conflicts[port], nat.PortBinding{HostIP: "0.0.0.0", HostPort: hostPort})
+		}
+	}
+
+	return conflicts
+}
+
+// runningContainers lists the containers the port layer has running
+func runningContainers() ([]*models.ContainerInfo, error) {
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("failed to create a portlayer client"),
+			http.StatusInternalServerError)
+	}
+
+	listRes, err := client.Containers.ContainerList(containers.NewContainerListParams())
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("server error from portlayer: %s", err), http.StatusInternalServerError)
+	}
+
+	return listRes.Payload, nil
+}
+
+// summaryPorts converts a port map to the docker representation used in container lists
+func summaryPorts(ports nat.PortMap) []types.Port {
+	summary := []types.Port{}
+	for port, bindings := range ports {
+		if len(bindings) == 0 {
+			summary = append(summary, types.Port{PrivatePort: port.Int(), Type: port.Proto()})
+			continue
+		}
+
+		for _, binding := range bindings {
+			hostPort, _ := strconv.Atoi(binding.HostPort)
+			summary = append(summary, types.Port{
+				IP:          binding.HostIP,
+				PrivatePort: port.Int(),
+				PublicPort:  hostPort,
+				Type:        port.Proto(),
+			})
+		}
+	}
+
+	return summary
+}
+
+// mountPoints converts the port layer mount information to the docker representation
+func mountPoints(info *models.ContainerInfo) []types.MountPoint {
+	mounts := make([]types.MountPoint, 0, len(info.Mounts))
+	for _, m := range info.Mounts {
+		mode := stringValue(m.Mode)
+		rw := true
+		for _, opt := range strings.Split(mode, ",") {
+			if opt == "ro" {
+				rw = false
+			}
+		}
+
+		mounts = append(mounts, types.MountPoint{
+			Name:        stringValue(m.Name),
+			Driver:      volumeDriver,
+			Source:      stringValue(m.Source),
+			Destination: m.Destination,
+			Mode:        mode,
+			RW:          rw,
+		})
+	}
+
+	return mounts
+}
+
+// stringValue dereferences the optional string fields of the port layer models
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -18,6 +18,7 @@ import (
 	"sync"
 
 	"github.com/docker/docker/runconfig"
+	"github.com/docker/engine-api/types"
 	containertypes "github.com/docker/engine-api/types/container"
 )
 
@@ -30,17 +31,28 @@ type VicContainer struct {
 	Config      *containertypes.Config
 }
 
+// VicExec records the docker specific configuration of an exec instance
+type VicExec struct {
+	ID          string
+	ContainerID string
+	Config      *types.ExecConfig
+}
+
 // Tracks our container info from calls
 type Cache struct {
 	m sync.RWMutex
 
 	containerStore map[string]*VicContainer
+	execStore      map[string]*VicExec
 }
 
 var cache *Cache
 
 func init() {
-	cache = &Cache{containerStore: make(map[string]*VicContainer)}
+	cache = &Cache{
+		containerStore: make(map[string]*VicContainer),
+		execStore:      make(map[string]*VicExec),
+	}
 }
 
 func NewVicContainer() *VicContainer {
@@ -71,3 +83,21 @@ func (cc *Cache) SaveContainer(name string, container *VicContainer) {
 
 	cc.containerStore[name] = container
 }
+
+func (cc *Cache) GetExec(id string) *VicExec {
+	cc.m.RLock()
+	defer cc.m.RUnlock()
+
+	if exec, exist := cc.execStore[id]; exist {
+		return exec
+	}
+
+	return nil
+}
+
+func (cc *Cache) SaveExec(id string, exec *VicExec) {
+	cc.m.Lock()
+	defer cc.m.Unlock()
+
+	cc.execStore[id] = exec
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vicbackends
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/lib/apiservers/portlayer/models"
+)
+
+func TestPortConflicts(t *testing.T) {
+	running := []*models.ContainerInfo{
+		{
+			ID:    "web",
+			State: "RUNNING",
+			Networks: []*models.ContainerNetworkInfo{
+				{Name: "bridge", Ports: []string{"8080:80/tcp", "32768:443/tcp"}},
+			},
+		},
+	}
+
+	info := &models.ContainerInfo{
+		ID:    "other",
+		State: "STOPPED",
+		Networks: []*models.ContainerNetworkInfo{
+			{Name: "bridge", Ports: []string{"8080:8000/tcp", "8080:53/udp", "9000:9000/tcp", "22/tcp"}},
+		},
+	}
+
+	// only the host port held by the running container with the same protocol conflicts
+	expected := nat.PortMap{
+		"8000/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}},
+	}
+	assert.Equal(t, expected, portConflicts(info, running))
+
+	// a running container doesn't conflict with itself
+	assert.Empty(t, portConflicts(running[0], running))
+
+	// the bindings of a stopped container aren't reported
+	assert.Equal(t, nat.PortMap{"8000/tcp": nil, "53/udp": nil, "9000/tcp": nil, "22/tcp": nil}, portMap(info))
+}
@@ -15,21 +15,48 @@
 package vicbackends
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	"golang.org/x/net/context"
 
+	"github.com/docker/distribution/digest"
+	derr "github.com/docker/docker/errors"
+	docker "github.com/docker/docker/image"
+	"github.com/docker/docker/image/v1"
+	dockerLayer "github.com/docker/docker/layer"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/symlink"
 	"github.com/docker/docker/reference"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/container"
 	"github.com/docker/engine-api/types/registry"
+	"github.com/go-swagger/go-swagger/httpkit"
+	httptransport "github.com/go-swagger/go-swagger/httpkit/client"
+	"github.com/vmware/vic/lib/apiservers/portlayer/client"
+	"github.com/vmware/vic/lib/apiservers/portlayer/client/containers"
+	"github.com/vmware/vic/lib/apiservers/portlayer/client/storage"
+	"github.com/vmware/vic/lib/guest"
 	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/pkg/trace"
 )
 
 // byCreated is a temporary type used to sort a list of images by creation
@@ -40,20 +67,284 @@ func (r byCreated) Len() int           { return len(r) }
 func (r byCreated) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 func (r byCreated) Less(i, j int) bool { return r[i].Created < r[j].Created }
 
+const (
+	// scratchLayerID is the ID of the empty layer at the root of every image in the port layer
+	scratchLayerID = "scratch"
+
+	// imageMetaDataKey is the layer metadata holding the image config
+	imageMetaDataKey = "metaData"
+
+	// docker save archive layout
+	manifestFileName     = "manifest.json"
+	repositoriesFileName = "repositories"
+	layerFileName        = "layer.tar"
+	layerConfigFileName  = "json"
+	layerVersionFileName = "VERSION"
+)
+
+// manifestItem describes an image in the manifest of a docker save archive
+type manifestItem struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
 type Image struct {
 	ProductName string
 }
 
+// Commit creates a new image from the changes the container has made to the
+// filesystem of its image.  A running container carries on running while its
+// filesystem is captured.
 func (i *Image) Commit(name string, config *types.ContainerCommitConfig) (imageID string, err error) {
-	return "", fmt.Errorf("%s does not implement image.Commit", i.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	info, err := getContainerInfo(name)
+	if err != nil {
+		return "", err
+	}
+	containerConf := containerConfig(info)
+
+	host, err := guest.UUID()
+	if err != nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("image.Commit got unexpected error getting VCH UUID"),
+			http.StatusInternalServerError)
+	}
+
+	// the new layer is parented to the top layer of the container's image
+	var parent *metadata.ImageConfig
+	images, err := ImageCache().GetImages()
+	if err != nil {
+		return "", derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+	for _, image := range images {
+		if info.Image != nil && image.ID == *info.Image {
+			parent = image
+		}
+	}
+	if parent == nil {
+		return "", derr.NewRequestNotFoundError(fmt.Errorf("No such image for container %s", name))
+	}
+
+	transport := httptransport.New(PortLayerServer(), "/", []string{"http"})
+	plClient := client.New(transport, nil)
+	transport.Consumers["application/octet-stream"] = httpkit.ByteStreamConsumer()
+	transport.Producers["application/octet-stream"] = httpkit.ByteStreamProducer()
+
+	// the tar is spooled to disk as its digest has to be known before it's written to the store
+	f, err := ioutil.TempFile("", "docker-commit-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	digester := digest.Canonical.New()
+	params := containers.NewContainerTarParamsWithContext(context.Background()).WithID(info.ID).WithStoreName(host)
+	if _, err = plClient.Containers.ContainerTar(params, io.MultiWriter(f, digester.Hash())); err != nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("Failed to export container filesystem: %s", err), http.StatusInternalServerError)
+	}
+	diffID := dockerLayer.DiffID(digester.Digest())
+
+	size, err := layerSize(f.Name())
+	if err != nil {
+		return "", err
+	}
+
+	newConfig := config.Config
+	if newConfig == nil {
+		newConfig = &container.Config{}
+	}
+	if config.MergeConfigs {
+		mergeConfig(newConfig, containerConf)
+	}
+
+	layerID := stringid.GenerateRandomID()
+
+	// rebuild the rootfs of the parent from its chain of layers to derive the image ID
+	layers, err := imageLayers(plClient, host, parent.ID)
+	if err != nil {
+		return "", err
+	}
+
+	diffIDs := make(map[string]string)
+	layerDiffIDs := make(map[string]string)
+	for d, l := range parent.DiffIDs {
+		diffIDs[d] = l
+		layerDiffIDs[l] = d
+	}
+	diffIDs[diffID.String()] = layerID
+
+	rootFS := docker.NewRootFS()
+	for _, l := range layers {
+		if d, ok := layerDiffIDs[l]; ok {
+			rootFS.Append(dockerLayer.DiffID(d))
+		}
+	}
+	rootFS.Append(diffID)
+
+	h := docker.History{
+		Author:    config.Author,
+		Created:   time.Now().UTC(),
+		CreatedBy: strings.Join(containerConf.Cmd, " "),
+		Comment:   config.Comment,
+	}
+	history := append(append([]docker.History{}, parent.History...), h)
+
+	image := docker.Image{
+		V1Image: docker.V1Image{
+			Comment:         config.Comment,
+			Created:         h.Created,
+			Container:       info.ID,
+			ContainerConfig: *containerConf,
+			DockerVersion:   parent.DockerVersion,
+			Author:          config.Author,
+			Config:          newConfig,
+			Architecture:    runtime.GOARCH,
+			OS:              runtime.GOOS,
+		},
+		RootFS:  rootFS,
+		History: history,
+	}
+
+	blob, err := image.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+
+	imageConfig := &metadata.ImageConfig{
+		V1Image: image.V1Image,
+		ImageID: digest.FromBytes(blob).Hex(),
+		DiffIDs: diffIDs,
+		History: history,
+	}
+	imageConfig.Parent = parent.ID
+	imageConfig.Size = parent.Size + size
+
+	if config.Repo != "" {
+		ref, err := reference.WithName(config.Repo)
+		if err != nil {
+			return "", derr.NewBadRequestError(err)
+		}
+
+		imageConfig.Name = ref.RemoteName()
+		imageConfig.Tag = config.Tag
+		if imageConfig.Tag == "" {
+			imageConfig.Tag = reference.DefaultTag
+		}
+	}
+
+	meta, err := json.Marshal(imageConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if err = loadArchiveLayer(plClient, host, parent.ID, layerID, diffID, f.Name(), meta); err != nil {
+		return "", derr.NewErrorWithStatusCode(fmt.Errorf("Failed to write image: %s", err), http.StatusInternalServerError)
+	}
+
+	if err = ImageCache().Update(PortLayerClient()); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + imageConfig.ImageID, nil
 }
 
 func (i *Image) Exists(containerName string) bool {
 	return false
 }
 
+// ImageDelete removes the image and, when prune is set, walks down its layer chain removing every
+// layer that isn't shared with another image. The port layer refuses to remove layers that are
+// still the parent of another layer or in use by a container; a forced removal untags such an
+// image instead of failing.
 func (i *Image) ImageDelete(imageRef string, force, prune bool) ([]types.ImageDelete, error) {
-	return []types.ImageDelete{}, fmt.Errorf("%s does not implement image.Delete", i.ProductName)
+	defer trace.End(trace.Begin(imageRef))
+
+	image, err := ImageCache().GetImage(imageRef)
+	if err != nil {
+		return nil, derr.NewRequestNotFoundError(err)
+	}
+
+	host, err := guest.UUID()
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("image.ImageDelete got unexpected error getting VCH UUID"),
+			http.StatusInternalServerError)
+	}
+
+	// layers that are part of other images must be kept
+	images, err := ImageCache().GetImages()
+	if err != nil {
+		return nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	shared := make(map[string]bool)
+	for _, other := range images {
+		if other.ImageID == image.ImageID {
+			continue
+		}
+
+		shared[other.ID] = true
+		for _, layer := range other.DiffIDs {
+			shared[layer] = true
+		}
+	}
+
+	untagged := []types.ImageDelete{
+		{Untagged: fmt.Sprintf("%s:%s", image.Name, image.Tag)},
+	}
+
+	if shared[image.ID] {
+		if force {
+			ImageCache().RemoveImage(image.ImageID)
+			return untagged, nil
+		}
+		return nil, derr.NewRequestConflictError(fmt.Errorf("conflict: unable to delete %s - image has dependent child images", imageRef))
+	}
+
+	client := PortLayerClient()
+
+	var layers []types.ImageDelete
+	for id := image.ID; id != "" && id != scratchLayerID && !shared[id]; {
+		res, err := client.Storage.GetImage(storage.NewGetImageParams().WithStoreName(host).WithID(id))
+		if err != nil {
+			if _, ok := err.(*storage.GetImageNotFound); ok {
+				break
+			}
+			return nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+
+		if _, err = client.Storage.DeleteImage(storage.NewDeleteImageParams().WithStoreName(host).WithID(id)); err != nil {
+			switch err := err.(type) {
+			case *storage.DeleteImageLocked:
+				if id == image.ID {
+					if force {
+						ImageCache().RemoveImage(image.ImageID)
+						return untagged, nil
+					}
+					return nil, derr.NewRequestConflictError(fmt.Errorf("conflict: unable to delete %s - %s", imageRef, err.Payload.Message))
+				}
+				// a layer further down the chain is still referenced, leave it and its parents be
+				log.Debugf("Keeping layer %s: %s", id, err.Payload.Message)
+			case *storage.DeleteImageNotFound:
+			default:
+				return nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+			}
+			break
+		}
+
+		layers = append(layers, types.ImageDelete{Deleted: id})
+
+		if !prune || res.Payload.Parent == nil {
+			break
+		}
+		id = path.Base(*res.Payload.Parent)
+	}
+
+	ImageCache().RemoveImage(image.ImageID)
+
+	result := append(untagged, types.ImageDelete{Deleted: image.ImageID})
+	return append(result, layers...), nil
 }
 
 func (i *Image) ImageHistory(imageName string) ([]*types.ImageHistory, error) {
@@ -89,32 +380,197 @@ func (i *Image) TagImage(newTag reference.Named, imageName string) error {
 	return fmt.Errorf("%s does not implement image.Tag", i.ProductName)
 }
 
+// LoadImage loads the images in a docker save archive into the image store.
+// Layers already in the store are reused.
 func (i *Image) LoadImage(inTar io.ReadCloser, outStream io.Writer, quiet bool) error {
-	return fmt.Errorf("%s does not implement image.LoadImage", i.ProductName)
+	defer trace.End(trace.Begin(""))
+
+	if !quiet {
+		outStream = &streamformatter.StdoutFormatter{Writer: outStream, StreamFormatter: streamformatter.NewJSONStreamFormatter()}
+	}
+
+	host, err := guest.UUID()
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("image.LoadImage got unexpected error getting VCH UUID"),
+			http.StatusInternalServerError)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "docker-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the manifest comes last in the archive so it has to be staged before anything can be
+	// loaded, within the space left in tmp
+	space, err := newStagingSpace(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	decompressed, err := archive.DecompressStream(inTar)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	if err = archive.Untar(space.Reader(decompressed), tmpDir, &archive.TarOptions{NoLchown: true}); err != nil {
+		if space.full {
+			return derr.NewErrorWithStatusCode(fmt.Errorf("Not enough space to stage the archive for loading"),
+				http.StatusRequestEntityTooLarge)
+		}
+		return err
+	}
+
+	manifestPath, err := safePath(tmpDir, manifestFileName)
+	if err != nil {
+		return err
+	}
+
+	manifestFile, err := os.Open(manifestPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return derr.NewBadRequestError(fmt.Errorf("%s does not support loading archives without a manifest", i.ProductName))
+		}
+		return err
+	}
+	defer manifestFile.Close()
+
+	var manifest []manifestItem
+	if err = json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		return err
+	}
+
+	transport := httptransport.New(PortLayerServer(), "/", []string{"http"})
+	plClient := client.New(transport, nil)
+	transport.Consumers["application/octet-stream"] = httpkit.ByteStreamConsumer()
+	transport.Producers["application/octet-stream"] = httpkit.ByteStreamProducer()
+
+	for _, m := range manifest {
+		loaded, err := loadArchiveImage(plClient, host, tmpDir, m)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(outStream, "Loaded image: %s\n", loaded)
+	}
+
+	return ImageCache().Update(PortLayerClient())
 }
 
 func (i *Image) ImportImage(src string, newRef reference.Named, msg string, inConfig io.ReadCloser, outStream io.Writer, config *container.Config) error {
 	return fmt.Errorf("%s does not implement image.ImportImage", i.ProductName)
 }
 
+// ExportImage writes the named images to outStream as a docker save archive.
+// The layer tars are exported from the image store so the diff IDs, and with
+// them the image IDs, in the archive differ from those of the pulled images.
 func (i *Image) ExportImage(names []string, outStream io.Writer) error {
-	return fmt.Errorf("%s does not implement image.ExportImage", i.ProductName)
+	defer trace.End(trace.Begin(strings.Join(names, ",")))
+
+	host, err := guest.UUID()
+	if err != nil {
+		return derr.NewErrorWithStatusCode(fmt.Errorf("image.ExportImage got unexpected error getting VCH UUID"),
+			http.StatusInternalServerError)
+	}
+
+	images := make(map[string]*metadata.ImageConfig)
+	var size int64
+	for _, name := range names {
+		image, err := ImageCache().GetImage(name)
+		if err != nil {
+			return derr.NewRequestNotFoundError(err)
+		}
+		if _, ok := images[image.ImageID]; !ok {
+			size += image.Size
+		}
+		images[image.ImageID] = image
+	}
+
+	tmpDir, err := ioutil.TempDir("", "docker-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the archive is staged in tmp so that nothing is written until every layer has been
+	// exported.  Fail before exporting anything if the images clearly won't fit.
+	noSpace := derr.NewErrorWithStatusCode(fmt.Errorf("Not enough space to stage the archive for saving"),
+		http.StatusInternalServerError)
+	space, err := newStagingSpace(tmpDir)
+	if err != nil {
+		return err
+	}
+	if size > space.free {
+		return noSpace
+	}
+
+	transport := httptransport.New(PortLayerServer(), "/", []string{"http"})
+	plClient := client.New(transport, nil)
+	transport.Consumers["application/octet-stream"] = httpkit.ByteStreamConsumer()
+	transport.Producers["application/octet-stream"] = httpkit.ByteStreamProducer()
+
+	// layers shared between images are exported once
+	saved := make(map[string]dockerLayer.DiffID)
+	reposLegacy := make(map[string]map[string]string)
+
+	var manifest []manifestItem
+	for _, image := range images {
+		m, err := saveArchiveImage(plClient, host, tmpDir, space, image, saved)
+		if err != nil {
+			if space.full {
+				return noSpace
+			}
+			return err
+		}
+
+		if err = tagArchiveImage(&m, reposLegacy, image); err != nil {
+			return err
+		}
+
+		manifest = append(manifest, m)
+	}
+
+	if len(reposLegacy) > 0 {
+		if err = writeJSON(filepath.Join(tmpDir, repositoriesFileName), reposLegacy); err != nil {
+			return err
+		}
+	}
+
+	if err = writeJSON(filepath.Join(tmpDir, manifestFileName), manifest); err != nil {
+		return err
+	}
+
+	fs, err := archive.Tar(tmpDir, archive.Uncompressed)
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+
+	_, err = io.Copy(outStream, fs)
+	return err
 }
 
 func (i *Image) PullImage(ctx context.Context, ref reference.Named, metaHeaders map[string][]string, authConfig *types.AuthConfig, outStream io.Writer) error {
 	log.Printf("PullImage: ref = %+v, metaheaders = %+v\n", ref, metaHeaders)
 
+	policy := RegistryPolicy()
+	if err := policy.Allowed(ref.Hostname()); err != nil {
+		return derr.NewErrorWithStatusCode(err, http.StatusForbidden)
+	}
+
 	var cmdArgs []string
 
 	cmdArgs = append(cmdArgs, "-reference", ref.String())
 
-	if authConfig != nil {
-		if len(authConfig.Username) > 0 {
-			cmdArgs = append(cmdArgs, "-username", authConfig.Username)
-		}
-		if len(authConfig.Password) > 0 {
-			cmdArgs = append(cmdArgs, "-password", authConfig.Password)
-		}
+	// imagec enforces the policy too as it can be run on its own
+	cmdArgs = append(cmdArgs, imagecRegistryArgs()...)
+
+	credentials, err := imagecCredentials(authConfig)
+	if err != nil {
+		return err
+	}
+	if credentials != nil {
+		cmdArgs = append(cmdArgs, "-credentials-stdin")
 	}
 
 	portLayerServer := PortLayerServer()
@@ -129,11 +585,12 @@ func (i *Image) PullImage(ctx context.Context, ref reference.Named, metaHeaders
 	log.Printf("PullImage: cmd = %s %+v\n", Imagec, cmdArgs)
 
 	cmd := exec.Command(Imagec, cmdArgs...)
+	cmd.Stdin = credentials
 	cmd.Stdout = outStream
 	cmd.Stderr = outStream
 
 	// Execute
-	err := cmd.Start()
+	err = cmd.Start()
 
 	if err != nil {
 		log.Printf("Error starting %s - %s\n", Imagec, err)
@@ -162,6 +619,24 @@ func (i *Image) SearchRegistryForImages(ctx context.Context, term string, authCo
 
 // Utility functions
 
+// imagecCredentials returns the registry credentials in the form imagec reads
+// them from stdin, keeping them off its command line, or nil if there are none
+func imagecCredentials(authConfig *types.AuthConfig) (io.Reader, error) {
+	if authConfig == nil || authConfig.Username == "" {
+		return nil, nil
+	}
+
+	credentials, err := json.Marshal(types.AuthConfig{
+		Username: authConfig.Username,
+		Password: authConfig.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(credentials), nil
+}
+
 func convertV1ImageToDockerImage(image *metadata.ImageConfig) *types.Image {
 	var labels map[string]string
 	if image.Config != nil {
@@ -183,3 +658,476 @@ func convertV1ImageToDockerImage(image *metadata.ImageConfig) *types.Image {
 		Labels:      labels,
 	}
 }
+
+// loadArchiveImage writes the layers of the image described by m to the image
+// store, the last one carrying the image config, and returns the name the
+// image was loaded as
+func loadArchiveImage(plClient *client.PortLayer, host, dir string, m manifestItem) (string, error) {
+	configPath, err := safePath(dir, m.Config)
+	if err != nil {
+		return "", err
+	}
+
+	config, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := docker.NewFromJSON(config)
+	if err != nil {
+		return "", err
+	}
+
+	if expected, actual := len(m.Layers), len(img.RootFS.DiffIDs); expected != actual {
+		return "", fmt.Errorf("invalid manifest, layers length mismatch: expected %d, got %d", expected, actual)
+	}
+
+	imageConfig := &metadata.ImageConfig{
+		V1Image: img.V1Image,
+		ImageID: digest.FromBytes(config).Hex(),
+		DiffIDs: make(map[string]string),
+		History: img.History,
+	}
+
+	loaded := "sha256:" + imageConfig.ImageID
+	ref, err := archiveImageRef(m)
+	if err != nil {
+		return "", err
+	}
+	if ref != nil {
+		imageConfig.Name = ref.RemoteName()
+		if tagged, ok := ref.(reference.NamedTagged); ok {
+			imageConfig.Tag = tagged.Tag()
+		}
+		loaded = ref.String()
+	}
+
+	rootFS := docker.NewRootFS()
+	parent := scratchLayerID
+	var parentID digest.Digest
+
+	for i, diffID := range img.RootFS.DiffIDs {
+		rootFS.Append(diffID)
+
+		// layers are named the way docker names them when saving
+		v1Image := docker.V1Image{}
+		if i == len(img.RootFS.DiffIDs)-1 {
+			v1Image = img.V1Image
+		}
+		v1ID, err := v1.CreateID(v1Image, rootFS.ChainID(), parentID)
+		if err != nil {
+			return "", err
+		}
+
+		layerPath, err := safePath(dir, m.Layers[i])
+		if err != nil {
+			return "", err
+		}
+
+		size, err := layerSize(layerPath)
+		if err != nil {
+			return "", err
+		}
+
+		imageConfig.Size += size
+		imageConfig.DiffIDs[diffID.String()] = v1ID.Hex()
+
+		v1Image.ID = v1ID.Hex()
+		if parent != scratchLayerID {
+			v1Image.Parent = parent
+		}
+
+		var meta []byte
+		if i == len(img.RootFS.DiffIDs)-1 {
+			imageConfig.Parent = v1Image.Parent
+			meta, err = json.Marshal(imageConfig)
+		} else {
+			meta, err = json.Marshal(v1Image)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if err = loadArchiveLayer(plClient, host, parent, v1ID.Hex(), diffID, layerPath, meta); err != nil {
+			return "", err
+		}
+
+		parent = v1ID.Hex()
+		parentID = v1ID
+	}
+
+	return loaded, nil
+}
+
+// archiveImageRef returns the name an image in a docker save archive is loaded
+// as, or nil if it has none
+func archiveImageRef(m manifestItem) (reference.Named, error) {
+	if len(m.RepoTags) == 0 {
+		return nil, nil
+	}
+
+	ref, err := reference.ParseNamed(m.RepoTags[0])
+	if err != nil {
+		return nil, err
+	}
+	ref = reference.WithDefaultTag(ref)
+
+	// the image cache holds a single name for each image
+	if len(m.RepoTags) > 1 {
+		log.Warnf("Loading %s as %s only", m.RepoTags, ref.String())
+	}
+
+	return ref, nil
+}
+
+// loadArchiveLayer writes a layer tar to the image store unless the layer is
+// already there.  The port layer rejects the tar if it doesn't match diffID.
+func loadArchiveLayer(plClient *client.PortLayer, host, parent, id string, diffID dockerLayer.DiffID, layerPath string, meta []byte) error {
+	if _, err := plClient.Storage.GetImage(storage.NewGetImageParams().WithStoreName(host).WithID(id)); err == nil {
+		log.Debugf("Layer %s already exists", id)
+		return nil
+	}
+
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	key := imageMetaDataKey
+	blob := string(meta)
+
+	_, err = plClient.Storage.WriteImage(
+		storage.NewWriteImageParamsWithContext(context.Background()).
+			WithStoreName(host).
+			WithImageID(id).
+			WithParentID(parent).
+			WithSum(diffID.String()).
+			WithMetadatakey(&key).
+			WithMetadataval(&blob).
+			WithImageFile(f),
+	)
+	if err != nil {
+		if _, ok := err.(*storage.WriteImageUnprocessableEntity); ok {
+			return fmt.Errorf("invalid diffID for layer %s: expected %s", id, diffID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// saveArchiveImage exports the layers of the image into dir, along with an
+// image config that matches them, and returns its manifest entry
+func saveArchiveImage(plClient *client.PortLayer, host, dir string, space *stagingSpace, image *metadata.ImageConfig, saved map[string]dockerLayer.DiffID) (manifestItem, error) {
+	var m manifestItem
+
+	layers, err := imageLayers(plClient, host, image.ID)
+	if err != nil {
+		return m, err
+	}
+
+	if len(layers) == 0 {
+		return m, fmt.Errorf("empty export - not implemented")
+	}
+
+	rootFS := docker.NewRootFS()
+	parent := ""
+	for i, id := range layers {
+		v1Image := docker.V1Image{}
+		if i == len(layers)-1 {
+			v1Image = image.V1Image
+		}
+		v1Image.ID = id
+		v1Image.Parent = parent
+
+		diffID, ok := saved[id]
+		if !ok {
+			var err error
+			if diffID, err = saveArchiveLayer(plClient, host, dir, space, v1Image); err != nil {
+				return m, err
+			}
+			saved[id] = diffID
+		}
+
+		rootFS.Append(diffID)
+		m.Layers = append(m.Layers, path.Join(id, layerFileName))
+		parent = id
+	}
+
+	// the config is rebuilt the way imagec builds it
+	config := docker.Image{
+		V1Image: docker.V1Image{
+			Comment:         image.Comment,
+			Created:         image.Created,
+			Container:       image.Container,
+			ContainerConfig: image.ContainerConfig,
+			DockerVersion:   image.DockerVersion,
+			Author:          image.Author,
+			Config:          image.Config,
+			Architecture:    image.Architecture,
+			OS:              image.OS,
+		},
+		RootFS:  rootFS,
+		History: image.History,
+	}
+
+	blob, err := config.MarshalJSON()
+	if err != nil {
+		return m, err
+	}
+
+	m.Config = digest.FromBytes(blob).Hex() + ".json"
+	if err = ioutil.WriteFile(filepath.Join(dir, m.Config), blob, 0644); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// tagArchiveImage names the image in its manifest entry and in the legacy
+// repositories file of a docker save archive
+func tagArchiveImage(m *manifestItem, repos map[string]map[string]string, image *metadata.ImageConfig) error {
+	if image.Name == "" {
+		return nil
+	}
+
+	named, err := reference.ParseNamed(image.Name)
+	if err != nil {
+		return err
+	}
+	tagged, err := reference.WithTag(named, image.Tag)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := repos[named.Name()]; !ok {
+		repos[named.Name()] = make(map[string]string)
+	}
+	repos[named.Name()][image.Tag] = path.Dir(m.Layers[len(m.Layers)-1])
+	m.RepoTags = []string{tagged.String()}
+
+	return nil
+}
+
+// saveArchiveLayer exports a layer from the image store into its directory in
+// the archive, within the staging space, and returns the diff ID of the
+// exported tar
+func saveArchiveLayer(plClient *client.PortLayer, host, dir string, space *stagingSpace, v1Image docker.V1Image) (dockerLayer.DiffID, error) {
+	layerDir := filepath.Join(dir, v1Image.ID)
+	if err := os.Mkdir(layerDir, 0755); err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(layerDir, layerVersionFileName), []byte("1.0"), 0644); err != nil {
+		return "", err
+	}
+
+	if err := writeJSON(filepath.Join(layerDir, layerConfigFileName), v1Image); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(filepath.Join(layerDir, layerFileName))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digester := digest.Canonical.New()
+	params := storage.NewGetImageTarParamsWithContext(context.Background()).WithStoreName(host).WithID(v1Image.ID)
+	if _, err = plClient.Storage.GetImageTar(params, io.MultiWriter(space.Writer(f), digester.Hash())); err != nil {
+		return "", err
+	}
+
+	return dockerLayer.DiffID(digester.Digest()), nil
+}
+
+// imageLayers returns the IDs of the chain of layers ending with the given
+// layer, starting from the layer above scratch
+func imageLayers(plClient *client.PortLayer, host, id string) ([]string, error) {
+	var layers []string
+	for id != "" && id != scratchLayerID {
+		res, err := plClient.Storage.GetImage(storage.NewGetImageParams().WithStoreName(host).WithID(id))
+		if err != nil {
+			return nil, err
+		}
+		layers = append([]string{id}, layers...)
+
+		if res.Payload.Parent == nil {
+			break
+		}
+		id = path.Base(*res.Payload.Parent)
+	}
+
+	return layers, nil
+}
+
+// mergeConfig fills in what's missing from the config given for a commit
+// from the config of the container, the way docker does
+func mergeConfig(userConf, containerConf *container.Config) {
+	if userConf.User == "" {
+		userConf.User = containerConf.User
+	}
+
+	if len(userConf.ExposedPorts) == 0 {
+		userConf.ExposedPorts = containerConf.ExposedPorts
+	} else {
+		for port := range containerConf.ExposedPorts {
+			userConf.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	if len(userConf.Env) == 0 {
+		userConf.Env = containerConf.Env
+	} else {
+		set := make(map[string]bool)
+		for _, env := range userConf.Env {
+			set[strings.SplitN(env, "=", 2)[0]] = true
+		}
+		for _, env := range containerConf.Env {
+			if !set[strings.SplitN(env, "=", 2)[0]] {
+				userConf.Env = append(userConf.Env, env)
+			}
+		}
+	}
+
+	labels := make(map[string]string)
+	for k, v := range containerConf.Labels {
+		labels[k] = v
+	}
+	for k, v := range userConf.Labels {
+		labels[k] = v
+	}
+	userConf.Labels = labels
+
+	if len(userConf.Entrypoint) == 0 {
+		if len(userConf.Cmd) == 0 {
+			userConf.Cmd = containerConf.Cmd
+		}
+		if userConf.Entrypoint == nil {
+			userConf.Entrypoint = containerConf.Entrypoint
+		}
+	}
+
+	if userConf.WorkingDir == "" {
+		userConf.WorkingDir = containerConf.WorkingDir
+	}
+
+	if len(userConf.Volumes) == 0 {
+		userConf.Volumes = containerConf.Volumes
+	} else {
+		for k, v := range containerConf.Volumes {
+			userConf.Volumes[k] = v
+		}
+	}
+
+	if userConf.StopSignal == "" {
+		userConf.StopSignal = containerConf.StopSignal
+	}
+}
+
+// layerSize returns the size of the files in a layer tar
+func layerSize(layerPath string) (int64, error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var size int64
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += hdr.Size
+	}
+}
+
+// safePath returns the path within base, refusing paths that escape it
+func safePath(base, path string) (string, error) {
+	return symlink.FollowSymlinkInScope(filepath.Join(base, path), base)
+}
+
+// stagingSpace is the space left on the filesystem archives are staged on.  Staging
+// through its Reader or Writer fails once the space is used up, so that an archive
+// that won't fit is refused rather than filling the filesystem.
+type stagingSpace struct {
+	free int64
+	// full is set once staging has been refused
+	full bool
+}
+
+func newStagingSpace(dir string) (*stagingSpace, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return nil, err
+	}
+
+	return &stagingSpace{free: int64(st.Bavail) * int64(st.Bsize)}, nil
+}
+
+func (s *stagingSpace) use(n int) error {
+	if int64(n) > s.free {
+		s.full = true
+		return fmt.Errorf("no space left to stage %d bytes", n)
+	}
+
+	s.free -= int64(n)
+	return nil
+}
+
+// Reader returns a reader that counts what's read from r against the space
+func (s *stagingSpace) Reader(r io.Reader) io.Reader {
+	return &stagingReader{space: s, r: r}
+}
+
+// Writer returns a writer that counts what's written to w against the space
+func (s *stagingSpace) Writer(w io.Writer) io.Writer {
+	return &stagingWriter{space: s, w: w}
+}
+
+type stagingReader struct {
+	space *stagingSpace
+	r     io.Reader
+}
+
+func (s *stagingReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if serr := s.space.use(n); serr != nil {
+		return 0, serr
+	}
+
+	return n, err
+}
+
+type stagingWriter struct {
+	space *stagingSpace
+	w     io.Writer
+}
+
+func (s *stagingWriter) Write(p []byte) (int, error) {
+	if err := s.space.use(len(p)); err != nil {
+		return 0, err
+	}
+
+	return s.w.Write(p)
+}
+
+func writeJSON(name string, v interface{}) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if err = json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -15,110 +15,330 @@
 package vicbackends
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	v1 "github.com/docker/docker/image"
+	"github.com/docker/docker/reference"
 	"github.com/docker/engine-api/types/container"
+	"github.com/docker/engine-api/types/strslice"
+	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
-	"github.com/vmware/vic/lib/apiservers/portlayer/models"
+	"github.com/vmware/vic/lib/metadata"
 )
 
-func TestGetV1ImageMapEmpty(t *testing.T) {
-	images := []*models.Image{
-		&models.Image{
-			ID: "scratch",
+func TestConvertV1ImageToDockerImage(t *testing.T) {
+	now := time.Now()
+
+	image := &metadata.ImageConfig{
+		V1Image: v1.V1Image{
+			ID:      "deadbeef",
+			Size:    1024,
+			Created: now,
+			Parent:  "",
+			Config: &container.Config{
+				Labels: map[string]string{},
+			},
 		},
+		ImageID: "cafebabe",
+		Name:    "busybox",
+		Tag:     "latest",
+		Digest:  "sha256:12345",
 	}
-	v1Map := getV1MapFromImages(images)
-	assert.Empty(t, v1Map, "v1Map should be empty")
+	dockerImage := convertV1ImageToDockerImage(image)
+	assert.Equal(t, image.ImageID, dockerImage.ID, "Error: expected id %s, got %s", image.ImageID, dockerImage.ID)
+	assert.Equal(t, image.Size, dockerImage.VirtualSize, "Error: expected size %s, got %s", image.Size, dockerImage.VirtualSize)
+	assert.Equal(t, image.Created.Unix(), dockerImage.Created, "Error: expected created %s, got %s", image.Created, dockerImage.Created)
+	assert.Equal(t, image.Parent, dockerImage.ParentID, "Error: expected parent %s, got %s", image.Parent, dockerImage.ParentID)
+	assert.Equal(t, image.Config.Labels, dockerImage.Labels, "Error: expected labels %s, got %s", image.Config.Labels, dockerImage.Labels)
+	assert.Equal(t, []string{"busybox:latest"}, dockerImage.RepoTags)
+	assert.Equal(t, []string{"busybox:sha256:12345"}, dockerImage.RepoDigests)
 }
 
-func TestGetV1ImageMap(t *testing.T) {
-	p := "scratch"
-	s := "test_selflink"
-	images := []*models.Image{
-		&models.Image{
-			ID: "deadbeef",
-			Metadata: map[string]string{
-				"v1Compatibility": "{\"id\": \"deadbeef\", \"Size\":1024, \"parent\":\"\"}",
-			},
-			Parent:   &p,
-			SelfLink: &s,
-			Store:    "teststore",
-		},
+func TestSafePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "safepath")
+	if !assert.NoError(t, err) {
+		return
 	}
-	v1Map := getV1MapFromImages(images)
-	assert.NotEmpty(t, v1Map, "v1Map should not be empty")
-	if !assert.Equal(t, 1, len(v1Map), "len(v1Map) should be 1") {
+	defer os.RemoveAll(dir)
+
+	// an absolute link is resolved within the archive rather than the host
+	if !assert.NoError(t, os.Symlink("/layers", filepath.Join(dir, "link"))) {
 		return
 	}
+
+	p, err := safePath(dir, "abc/layer.tar")
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(dir, "abc/layer.tar"), p)
+	}
+
+	p, err = safePath(dir, "link/layer.tar")
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(dir, "layers/layer.tar"), p)
+	}
+
+	// a path that climbs out of the archive is refused
+	_, err = safePath(dir, "../../etc/passwd")
+	assert.Error(t, err)
 }
 
-func TestResolveImageSize(t *testing.T) {
-	p := ""
-	pp := "deadbeef"
-	s := "test_selflink"
-	images := []*models.Image{
-		&models.Image{
-			ID:       "scratch",
-			Metadata: map[string]string{},
-			Parent:   nil,
-			SelfLink: &s,
-			Store:    "teststore",
-		},
-		&models.Image{
-			ID: "deadbeef",
-			Metadata: map[string]string{
-				"v1Compatibility": "{\"id\": \"deadbeef\", \"Size\":1024, \"parent\":\"\"}",
-			},
-			Parent:   &p,
-			SelfLink: &s,
-			Store:    "teststore",
-		},
-		&models.Image{
-			ID: "test_id",
-			Metadata: map[string]string{
-				"v1Compatibility": "{\"id\": \"test_id\", \"Size\":1024, \"parent\":\"deadbeef\"}",
-			},
-			Parent:   &pp,
-			SelfLink: &s,
-			Store:    "teststore",
-		},
+func TestLayerSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layersize")
+	if !assert.NoError(t, err) {
+		return
 	}
-	v1Map := getV1MapFromImages(images)
-	assert.NotEmpty(t, v1Map, "v1Map should not be empty")
-	// minus the scratch image which gets skipped
-	if !assert.Equal(t, len(images)-1, len(v1Map), "len(v1Map) should be 1") {
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := []struct {
+		name string
+		body string
+	}{
+		{"etc/", ""},
+		{"etc/hostname", "vic"},
+		{"etc/motd", "hello"},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body))}
+		if f.body == "" {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if !assert.NoError(t, tw.WriteHeader(hdr)) {
+			return
+		}
+		if _, err = tw.Write([]byte(f.body)); !assert.NoError(t, err) {
+			return
+		}
+	}
+	if !assert.NoError(t, tw.Close()) {
 		return
 	}
 
-	// "test_id" image size should be the size of its parent ("deadbeef") plus its own size before resolution
-	expected := v1Map["deadbeef"].Size + v1Map["test_id"].Size
+	layer := filepath.Join(dir, "layer.tar")
+	if !assert.NoError(t, ioutil.WriteFile(layer, buf.Bytes(), 0644)) {
+		return
+	}
 
-	// perform the size resolution
-	resolveImageSizes(v1Map)
+	size, err := layerSize(layer)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len("vic")+len("hello")), size)
+	}
+
+	// a layer that isn't a tar is refused
+	garbage := filepath.Join(dir, "garbage.tar")
+	if !assert.NoError(t, ioutil.WriteFile(garbage, bytes.Repeat([]byte("x"), 1024), 0644)) {
+		return
+	}
+	_, err = layerSize(garbage)
+	assert.Error(t, err)
 
-	actual := v1Map["test_id"].Size
-	assert.Equal(t, expected, actual, "Error: expected size %d, got %d", expected, actual)
+	_, err = layerSize(filepath.Join(dir, "missing.tar"))
+	assert.Error(t, err)
 }
 
-func TestConvertV1ImageToDockerImage(t *testing.T) {
-	now := time.Now()
+func TestManifestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
 
-	v1Image := &v1.V1Image{
-		ID:      "deadbeef",
-		Size:    1024,
-		Created: now,
-		Parent:  "",
-		Config: &container.Config{
-			Labels: map[string]string{},
+	// names are held the way imagec records them when pulling
+	images := []*metadata.ImageConfig{
+		{Name: "library/busybox", Tag: "latest"},
+		{Name: "team/app", Tag: "1.0"},
+		// an image loaded without a name is saved without one
+		{},
+	}
+
+	repos := make(map[string]map[string]string)
+	var manifest []manifestItem
+	for i, image := range images {
+		m := manifestItem{
+			Config: fmt.Sprintf("config%d.json", i),
+			Layers: []string{"base/layer.tar", fmt.Sprintf("top%d/layer.tar", i)},
+		}
+		if !assert.NoError(t, tagArchiveImage(&m, repos, image)) {
+			return
+		}
+		manifest = append(manifest, m)
+	}
+
+	if !assert.NoError(t, writeJSON(filepath.Join(dir, manifestFileName), manifest)) {
+		return
+	}
+	if !assert.NoError(t, writeJSON(filepath.Join(dir, repositoriesFileName), repos)) {
+		return
+	}
+
+	// read the archive back the way docker load does
+	var loadedManifest []manifestItem
+	blob, err := ioutil.ReadFile(filepath.Join(dir, manifestFileName))
+	if !assert.NoError(t, err) || !assert.NoError(t, json.Unmarshal(blob, &loadedManifest)) {
+		return
+	}
+	assert.Equal(t, manifest, loadedManifest)
+
+	var loadedRepos map[string]map[string]string
+	blob, err = ioutil.ReadFile(filepath.Join(dir, repositoriesFileName))
+	if !assert.NoError(t, err) || !assert.NoError(t, json.Unmarshal(blob, &loadedRepos)) {
+		return
+	}
+	assert.Equal(t, map[string]map[string]string{
+		"busybox":  {"latest": "top0"},
+		"team/app": {"1.0": "top1"},
+	}, loadedRepos)
+
+	// the images are loaded under the names they were saved with
+	for i, image := range images {
+		ref, err := archiveImageRef(loadedManifest[i])
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if image.Name == "" {
+			assert.Nil(t, ref)
+			continue
+		}
+
+		if assert.NotNil(t, ref) {
+			assert.Equal(t, image.Name, ref.RemoteName())
+			if assert.Implements(t, (*reference.NamedTagged)(nil), ref) {
+				assert.Equal(t, image.Tag, ref.(reference.NamedTagged).Tag())
+			}
+		}
+	}
+
+	// only the first of several names is kept and a missing tag means latest
+	ref, err := archiveImageRef(manifestItem{RepoTags: []string{"busybox", "busybox:1.24"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "busybox:latest", ref.String())
+	}
+}
+
+func TestStagingSpace(t *testing.T) {
+	space := &stagingSpace{free: 8}
+
+	var buf bytes.Buffer
+	w := space.Writer(&buf)
+	_, err := w.Write([]byte("12345"))
+	assert.NoError(t, err)
+	assert.False(t, space.full)
+
+	_, err = w.Write([]byte("6789"))
+	assert.Error(t, err, "the write exceeds the space left")
+	assert.True(t, space.full)
+	assert.Equal(t, "12345", buf.String())
+
+	space = &stagingSpace{free: 4}
+	_, err = ioutil.ReadAll(space.Reader(bytes.NewReader([]byte("123456789"))))
+	assert.Error(t, err)
+	assert.True(t, space.full)
+}
+
+func TestMergeConfig(t *testing.T) {
+	containerConf := func() *container.Config {
+		return &container.Config{
+			User:         "nobody",
+			ExposedPorts: nat.PortSet{"80/tcp": {}},
+			Env:          []string{"PATH=/bin", "HOME=/root"},
+			Labels:       map[string]string{"tier": "web", "debug": "true"},
+			Cmd:          strslice.StrSlice{"nginx"},
+			Entrypoint:   strslice.StrSlice{"/entrypoint.sh"},
+			WorkingDir:   "/srv",
+			Volumes:      map[string]struct{}{"/data": {}},
+			StopSignal:   "SIGQUIT",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		user     *container.Config
+		expected *container.Config
+	}{
+		{
+			name:     "empty config takes the container's",
+			user:     &container.Config{},
+			expected: containerConf(),
+		},
+		{
+			name: "given values are kept",
+			user: &container.Config{
+				User:       "root",
+				WorkingDir: "/",
+				StopSignal: "SIGTERM",
+			},
+			expected: func() *container.Config {
+				c := containerConf()
+				c.User = "root"
+				c.WorkingDir = "/"
+				c.StopSignal = "SIGTERM"
+				return c
+			}(),
+		},
+		{
+			name: "ports, env, labels and volumes are merged",
+			user: &container.Config{
+				ExposedPorts: nat.PortSet{"443/tcp": {}},
+				Env:          []string{"PATH=/usr/bin", "LANG=C"},
+				Labels:       map[string]string{"debug": "false"},
+				Volumes:      map[string]struct{}{"/logs": {}},
+			},
+			expected: func() *container.Config {
+				c := containerConf()
+				c.ExposedPorts = nat.PortSet{"80/tcp": {}, "443/tcp": {}}
+				c.Env = []string{"PATH=/usr/bin", "LANG=C", "HOME=/root"}
+				c.Labels = map[string]string{"tier": "web", "debug": "false"}
+				c.Volumes = map[string]struct{}{"/data": {}, "/logs": {}}
+				return c
+			}(),
+		},
+		{
+			name: "a given cmd keeps the container's entrypoint",
+			user: &container.Config{
+				Cmd: strslice.StrSlice{"sh"},
+			},
+			expected: func() *container.Config {
+				c := containerConf()
+				c.Cmd = strslice.StrSlice{"sh"}
+				return c
+			}(),
+		},
+		{
+			name: "a given entrypoint drops the container's cmd",
+			user: &container.Config{
+				Entrypoint: strslice.StrSlice{"/bin/sh"},
+			},
+			expected: func() *container.Config {
+				c := containerConf()
+				c.Entrypoint = strslice.StrSlice{"/bin/sh"}
+				c.Cmd = nil
+				return c
+			}(),
 		},
+		{
+			name: "an empty entrypoint clears the container's",
+			user: &container.Config{
+				Entrypoint: strslice.StrSlice{},
+			},
+			expected: func() *container.Config {
+				c := containerConf()
+				c.Entrypoint = strslice.StrSlice{}
+				return c
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		mergeConfig(test.user, containerConf())
+		assert.Equal(t, test.expected, test.user, test.name)
 	}
-	dockerImage := convertV1ImageToDockerImage(v1Image)
-	assert.Equal(t, v1Image.ID, dockerImage.ID, "Error: expected id %s, got %s", v1Image.ID, dockerImage.ID)
-	assert.Equal(t, v1Image.Size, dockerImage.VirtualSize, "Error: expected size %s, got %s", v1Image.Size, dockerImage.VirtualSize)
-	assert.Equal(t, v1Image.Created.Unix(), dockerImage.Created, "Error: expected created %s, got %s", v1Image.Created, dockerImage.Created)
-	assert.Equal(t, v1Image.Parent, dockerImage.ParentID, "Error: expected parent %s, got %s", v1Image.Parent, dockerImage.ParentID)
-	assert.Equal(t, v1Image.Config.Labels, dockerImage.Labels, "Error: expected labels %s, got %s", v1Image.Config.Labels, dockerImage.Labels)
 }
@@ -128,6 +128,15 @@ func (n *Network) CreateNetwork(name, driver string, ipam apinet.IPAM, options m
 		}
 	}
 
+	// a network has a single subnet, so an IPv6 network is IPv6 only
+	if enableIPv6 != (subnet != nil && isIP6Subnet(*subnet)) {
+		if enableIPv6 {
+			return nil, fmt.Errorf("an IPv6 subnet is required for an IPv6 network")
+		}
+
+		return nil, fmt.Errorf("IPv6 subnet %s requires IPv6 to be enabled on the network", *subnet)
+	}
+
 	if driver == "" {
 		driver = "bridge"
 	}
@@ -175,8 +184,8 @@ func (n *Network) ConnectContainerToNetwork(containerName, networkName string, e
 
 	h := getRes.Payload
 	nc := &models.NetworkConfig{NetworkName: networkName}
-	if endpointConfig != nil && endpointConfig.IPAMConfig != nil && endpointConfig.IPAMConfig.IPv4Address != "" {
-		nc.Address = &endpointConfig.IPAMConfig.IPv4Address
+	if endpointConfig != nil {
+		nc.Address = endpointAddress(endpointConfig.IPAMConfig)
 	}
 
 	addConRes, err := client.Scopes.AddContainer(scopes.NewAddContainerParams().
@@ -344,6 +353,30 @@ func (n *network) Info() libnetwork.NetworkInfo {
 	return n
 }
 
+// isIP6Subnet returns true if the subnet, in CIDR notation, is an IPv6 subnet
+func isIP6Subnet(subnet string) bool {
+	ip, _, err := net.ParseCIDR(subnet)
+	return err == nil && ip.To4() == nil
+}
+
+// endpointAddress returns the address requested for an endpoint, either IPv4 or IPv6,
+// or nil if there isn't one
+func endpointAddress(cfg *apinet.EndpointIPAMConfig) *string {
+	if cfg == nil {
+		return nil
+	}
+
+	if cfg.IPv4Address != "" {
+		return &cfg.IPv4Address
+	}
+
+	if cfg.IPv6Address != "" {
+		return &cfg.IPv6Address
+	}
+
+	return nil
+}
+
 func (n *network) IpamConfig() (string, map[string]string, []*libnetwork.IpamConf, []*libnetwork.IpamConf) {
 	n.Lock()
 	defer n.Unlock()
@@ -366,6 +399,10 @@ func (n *network) IpamConfig() (string, map[string]string, []*libnetwork.IpamCon
 		confs[j] = conf
 	}
 
+	if n.ipv6() {
+		return "", make(map[string]string), nil, confs
+	}
+
 	return "", make(map[string]string), confs, nil
 }
 
@@ -386,13 +423,22 @@ func (n *network) IpamInfo() ([]*libnetwork.IpamInfo, []*libnetwork.IpamInfo) {
 
 		info.Pool = pool
 		if n.cfg.Gateway != nil {
-			info.Gateway = &net.IPNet{IP: net.ParseIP(*n.cfg.Gateway), Mask: net.CIDRMask(32, 32)}
+			gw := net.ParseIP(*n.cfg.Gateway)
+			bits := 8 * net.IPv6len
+			if gw.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			info.Gateway = &net.IPNet{IP: gw, Mask: net.CIDRMask(bits, bits)}
 		}
 
 		info.AuxAddresses = make(map[string]*net.IPNet)
 		infos = append(infos, info)
 	}
 
+	if n.ipv6() {
+		return nil, infos
+	}
+
 	return infos, nil
 }
 
@@ -405,7 +451,15 @@ func (n *network) Scope() string {
 }
 
 func (n *network) IPv6Enabled() bool {
-	return false
+	n.Lock()
+	defer n.Unlock()
+
+	return n.ipv6()
+}
+
+// ipv6 returns true if the network has an IPv6 subnet. The caller must hold the lock.
+func (n *network) ipv6() bool {
+	return n.cfg.Subnet != nil && isIP6Subnet(*n.cfg.Subnet)
 }
 
 func (n *network) Internal() bool {
@@ -15,16 +15,32 @@
 package vicbackends
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"os/exec"
 	"runtime"
+	"strings"
 
 	"golang.org/x/net/context"
 
+	log "github.com/Sirupsen/logrus"
+
+	derr "github.com/docker/docker/errors"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/engine-api/types"
 	"github.com/docker/engine-api/types/events"
 	"github.com/docker/engine-api/types/filters"
+
+	"github.com/vmware/vic/pkg/registry"
+	"github.com/vmware/vic/pkg/trace"
 )
 
+// defaultRegistryServer is the registry docker login uses when none is given
+const defaultRegistryServer = "https://index.docker.io/v1/"
+
 type System struct {
 	ProductName string
 }
@@ -42,6 +58,25 @@ func (s *System) SystemInfo() (*types.Info, error) {
 		Name:               Name,
 	}
 
+	// report the registries images may be pulled from
+	policy := RegistryPolicy()
+	whitelist := "all registries"
+	if len(policy.Whitelist) > 0 {
+		whitelist = strings.Join(registry.Hosts(policy.Whitelist), ", ")
+	}
+	blacklist := "none"
+	if len(policy.Blacklist) > 0 {
+		blacklist = strings.Join(registry.Hosts(policy.Blacklist), ", ")
+	}
+	insecure := "none"
+	if vchConfig != nil && len(vchConfig.InsecureRegistries) > 0 {
+		insecure = strings.Join(registry.Hosts(vchConfig.InsecureRegistries), ", ")
+	}
+	info.SystemStatus = append(info.SystemStatus,
+		[2]string{"Registry Whitelist", whitelist},
+		[2]string{"Registry Blacklist", blacklist},
+		[2]string{"Insecure Registries", insecure})
+
 	return info, nil
 }
 
@@ -86,6 +121,56 @@ func (s *System) UnsubscribeFromEvents(chan interface{}) {
 
 }
 
+// AuthenticateToRegistry checks the credentials against the registry with
+// imagec, which turns to the token service of the registry if it has one
 func (s *System) AuthenticateToRegistry(ctx context.Context, authConfig *types.AuthConfig) (string, string, error) {
-	return "", "", fmt.Errorf("%s does not implement System.AuthenticateToRegistry", s.ProductName)
+	defer trace.End(trace.Begin(authConfig.ServerAddress))
+
+	server := authConfig.ServerAddress
+	if server == "" {
+		server = defaultRegistryServer
+	}
+
+	credentials, err := imagecCredentials(authConfig)
+	if err != nil {
+		return "", "", err
+	}
+	if credentials == nil {
+		return "", "", derr.NewBadRequestError(fmt.Errorf("Username is required"))
+	}
+
+	cmdArgs := []string{"-login", server, "-credentials-stdin"}
+
+	// the registry policy and certificates apply to logins as they do to pulls
+	cmdArgs = append(cmdArgs, imagecRegistryArgs()...)
+
+	var out bytes.Buffer
+	cmd := exec.Command(Imagec, cmdArgs...)
+	cmd.Stdin = credentials
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err = cmd.Run(); err != nil {
+		log.Errorf("Login to %s failed: %s", server, err)
+		return "", "", derr.NewErrorWithStatusCode(imagecError(out.Bytes(), err), http.StatusUnauthorized)
+	}
+
+	return "Login Succeeded", "", nil
+}
+
+// imagecError returns the error imagec reported in its output, falling back
+// to the error it exited with
+func imagecError(out []byte, exitErr error) error {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		var msg jsonmessage.JSONMessage
+		if err := json.Unmarshal([]byte(lines[i]), &msg); err != nil {
+			continue
+		}
+		if msg.Error != nil {
+			return errors.New(msg.Error.Message)
+		}
+	}
+
+	return exitErr
 }
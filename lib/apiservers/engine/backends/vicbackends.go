@@ -16,12 +16,17 @@ package vicbackends
 
 import (
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	httptransport "github.com/go-swagger/go-swagger/httpkit/client"
 	"github.com/vmware/vic/lib/apiservers/engine/backends/cache"
 	"github.com/vmware/vic/lib/apiservers/portlayer/client"
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/pkg/registry"
 )
 
 const (
@@ -35,9 +40,14 @@ var (
 	portLayerServerAddr string
 
 	imageCache *cache.ImageCache
+
+	vchConfig *metadata.VirtualContainerHostConfigSpec
+
+	// registryCertsDir is where the registry certificates are written for imagec
+	registryCertsDir = filepath.Join(os.TempDir(), "registry-certs")
 )
 
-func Init(portLayerAddr string) error {
+func Init(portLayerAddr string, config *metadata.VirtualContainerHostConfigSpec) error {
 	_, _, err := net.SplitHostPort(portLayerAddr)
 	if err != nil {
 		return err
@@ -46,6 +56,11 @@ func Init(portLayerAddr string) error {
 	t := httptransport.New(portLayerAddr, "/", []string{"http"})
 	portLayerClient = client.New(t, nil)
 	portLayerServerAddr = portLayerAddr
+	vchConfig = config
+
+	if err = writeRegistryCerts(); err != nil {
+		return err
+	}
 
 	imageCache = cache.NewImageCache()
 
@@ -80,3 +95,62 @@ func PortLayerServer() string {
 func ImageCache() *cache.ImageCache {
 	return imageCache
 }
+
+// RegistryPolicy returns the policy deciding which registries the VCH may pull images from
+func RegistryPolicy() *registry.Policy {
+	if vchConfig == nil {
+		return &registry.Policy{}
+	}
+
+	return &registry.Policy{
+		Whitelist: vchConfig.RegistryWhitelist,
+		Blacklist: vchConfig.RegistryBlacklist,
+	}
+}
+
+// writeRegistryCerts writes the registry certificates from the VCH config to
+// registryCertsDir for imagec to pick up
+func writeRegistryCerts() error {
+	if vchConfig == nil || len(vchConfig.RegistryCertificates) == 0 {
+		return nil
+	}
+
+	var certs []registry.Certificates
+	for _, c := range vchConfig.RegistryCertificates {
+		certs = append(certs, registry.Certificates{
+			Registry: c.Registry,
+			CAs:      c.CertificateAuthorities,
+			Cert:     c.ClientCertificate.Cert,
+			Key:      c.ClientCertificate.Key,
+		})
+	}
+
+	return registry.WriteCertsDir(registryCertsDir, certs)
+}
+
+// imagecRegistryArgs returns the arguments passing the registry policy,
+// insecure registries and registry certificates to imagec
+func imagecRegistryArgs() []string {
+	policy := RegistryPolicy()
+
+	var args []string
+	if len(policy.Whitelist) > 0 {
+		args = append(args, "-registry-whitelist", strings.Join(registry.Hosts(policy.Whitelist), ","))
+	}
+	if len(policy.Blacklist) > 0 {
+		args = append(args, "-registry-blacklist", strings.Join(registry.Hosts(policy.Blacklist), ","))
+	}
+
+	if vchConfig == nil {
+		return args
+	}
+
+	if len(vchConfig.InsecureRegistries) > 0 {
+		args = append(args, "-insecure-registries", strings.Join(registry.Hosts(vchConfig.InsecureRegistries), ","))
+	}
+	if len(vchConfig.RegistryCertificates) > 0 {
+		args = append(args, "-registry-certs", registryCertsDir)
+	}
+
+	return args
+}
@@ -15,30 +15,190 @@
 package vicbackends
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
+	log "github.com/Sirupsen/logrus"
 	derr "github.com/docker/docker/errors"
-
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/utils"
+	"github.com/docker/docker/volume"
 	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/docker/go-units"
+
 	"github.com/vmware/vic/lib/apiservers/portlayer/client/storage"
+	"github.com/vmware/vic/lib/apiservers/portlayer/models"
 	"github.com/vmware/vic/pkg/trace"
 )
 
+const (
+	// volumeDriver is the driver volumes are reported as being created by
+	volumeDriver = "vsphere"
+
+	// defaultVolumeStore is the volume store volumes are created in unless
+	// the VolumeStore option names another
+	defaultVolumeStore = "default"
+
+	// defaultVolumeCapacityMB is the size of a volume unless the Capacity
+	// option gives another
+	defaultVolumeCapacityMB = 1024
+
+	// dockerMetadataKey is the port layer metadata key that docker's view of
+	// a volume is stored under
+	dockerMetadataKey = "DockerMetaData"
+)
+
+// volumeMetadata is what docker knows about a volume that the port layer
+// doesn't
+type volumeMetadata struct {
+	Driver     string
+	DriverOpts map[string]string
+	Labels     map[string]string
+	// Anonymous is set for volumes created for a container's VOLUME declarations, which are
+	// removed along with the container when asked to
+	Anonymous bool
+}
+
 type Volume struct {
 	ProductName string
 }
 
 func (v *Volume) Volumes(filter string) ([]*types.Volume, []string, error) {
-	return nil, make([]string, 0), fmt.Errorf("%s does not implement volume.Volumes", v.ProductName)
+	defer trace.End(trace.Begin("Volume.Volumes"))
+
+	client := PortLayerClient()
+	if client == nil {
+		return nil, nil, derr.NewErrorWithStatusCode(fmt.Errorf("Failed to get a portlayer client"), http.StatusInternalServerError)
+	}
+
+	warnings := make([]string, 0)
+	if args, err := filters.FromParam(filter); err == nil && args.Len() > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s does not support volume filters, all volumes are listed", v.ProductName))
+	}
+
+	res, err := client.Storage.ListVolumes(storage.NewListVolumesParams())
+	if err != nil {
+		if e, ok := err.(*storage.ListVolumesDefault); ok {
+			return nil, nil, derr.NewErrorWithStatusCode(errors.New(e.Payload.Message), http.StatusInternalServerError)
+		}
+		return nil, nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+	}
+
+	volumes := make([]*types.Volume, 0, len(res.Payload))
+	for _, vol := range res.Payload {
+		volumes = append(volumes, convertVolume(vol))
+	}
+
+	return volumes, warnings, nil
 }
 
 func (v *Volume) VolumeInspect(name string) (*types.Volume, error) {
-	return nil, fmt.Errorf("%s does not implement volume.VolumeInspect", v.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Failed to get a portlayer client"), http.StatusInternalServerError)
+	}
+
+	res, err := client.Storage.GetVolume(storage.NewGetVolumeParams().WithName(name))
+	if err != nil {
+		switch err := err.(type) {
+		case *storage.GetVolumeNotFound:
+			return nil, derr.NewRequestNotFoundError(fmt.Errorf("No such volume: %s", name))
+		case *storage.GetVolumeDefault:
+			return nil, derr.NewErrorWithStatusCode(errors.New(err.Payload.Message), http.StatusInternalServerError)
+		default:
+			return nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+	}
+
+	return convertVolume(res.Payload), nil
 }
 
+// VolumeCreate creates a volume in a volume store.  The VolumeStore option
+// names the store, and the Capacity option gives the size of the volume, eg
+// 2G.  As with docker, creating a volume that already exists returns it.
 func (v *Volume) VolumeCreate(name, driverName string, opts, labels map[string]string) (*types.Volume, error) {
-	return nil, fmt.Errorf("%s does not implement volume.VolumeCreate", v.ProductName)
+	defer trace.End(trace.Begin(name))
+
+	return v.volumeCreate(name, driverName, opts, labels, false)
+}
+
+func (v *Volume) volumeCreate(name, driverName string, opts, labels map[string]string, anonymous bool) (*types.Volume, error) {
+
+	client := PortLayerClient()
+	if client == nil {
+		return nil, derr.NewErrorWithStatusCode(fmt.Errorf("Failed to get a portlayer client"), http.StatusInternalServerError)
+	}
+
+	// local is the driver the docker client asks for by default
+	if driverName != "" && driverName != "local" && driverName != volumeDriver {
+		return nil, derr.NewBadRequestError(fmt.Errorf("%s does not support the %s volume driver", v.ProductName, driverName))
+	}
+
+	if name == "" {
+		name = stringid.GenerateRandomID()
+	}
+
+	// the name is used in paths on the volume store
+	if !utils.RestrictedVolumeNamePattern.MatchString(name) {
+		return nil, derr.NewBadRequestError(fmt.Errorf("%q includes invalid characters for a volume name, only %q are allowed", name, utils.RestrictedNameChars))
+	}
+
+	store := defaultVolumeStore
+	capacity := int64(defaultVolumeCapacityMB)
+	for k, val := range opts {
+		switch strings.ToLower(k) {
+		case "volumestore":
+			store = val
+		case "capacity":
+			size, err := units.RAMInBytes(val)
+			if err != nil || size < units.MiB {
+				return nil, derr.NewBadRequestError(fmt.Errorf("Invalid volume capacity %s", val))
+			}
+			capacity = size / units.MiB
+		default:
+			return nil, derr.NewBadRequestError(fmt.Errorf("Unknown volume option %s", k))
+		}
+	}
+
+	meta, err := json.Marshal(volumeMetadata{
+		Driver:     volumeDriver,
+		DriverOpts: opts,
+		Labels:     labels,
+		Anonymous:  anonymous,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req := &models.VolumeRequest{
+		Name:     name,
+		Store:    store,
+		Capacity: capacity,
+		Metadata: map[string]string{dockerMetadataKey: string(meta)},
+	}
+
+	res, err := client.Storage.CreateVolume(storage.NewCreateVolumeParams().WithVolumeRequest(req))
+	if err != nil {
+		switch err := err.(type) {
+		case *storage.CreateVolumeConflict:
+			return v.VolumeInspect(name)
+		case *storage.CreateVolumeNotFound:
+			return nil, derr.NewBadRequestError(fmt.Errorf("No volume store named %s", store))
+		case *storage.CreateVolumeDefault:
+			return nil, derr.NewErrorWithStatusCode(errors.New(err.Payload.Message), err.Code())
+		default:
+			return nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+		}
+	}
+
+	return convertVolume(res.Payload), nil
 }
 
 func (v *Volume) VolumeRm(name string) error {
@@ -49,7 +209,6 @@ func (v *Volume) VolumeRm(name string) error {
 		return derr.NewErrorWithStatusCode(fmt.Errorf("Failed to get a portlayer client"), http.StatusInternalServerError)
 	}
 
-	//FIXME: check whether this is a name or a UUID. UUID expected for now.
 	_, err := client.Storage.RemoveVolume(storage.NewRemoveVolumeParams().WithName(name))
 	if err != nil {
 		if _, ok := err.(*storage.RemoveVolumeNotFound); ok {
@@ -62,3 +221,193 @@ func (v *Volume) VolumeRm(name string) error {
 	}
 	return nil
 }
+
+// joinVolumes adds the volumes a container mounts to its handle, creating any that don't exist
+// yet, and returns the new handle along with the names of the anonymous volumes it created.
+// Named volumes come from the -v arguments, and anonymous volumes from the VOLUME declarations
+// of the config and the image.  The anonymous volumes are removed again if a join fails.
+func (v *Volume) joinVolumes(h string, config types.ContainerCreateConfig, imageVolumes map[string]struct{}) (_ string, _ []string, err error) {
+	defer trace.End(trace.Begin(h))
+
+	client := PortLayerClient()
+	if client == nil {
+		return "", nil, derr.NewErrorWithStatusCode(fmt.Errorf("Failed to get a portlayer client"), http.StatusInternalServerError)
+	}
+
+	var driver string
+	var binds []string
+	if config.HostConfig != nil {
+		driver = config.HostConfig.VolumeDriver
+		binds = config.HostConfig.Binds
+	}
+
+	// mount points by destination
+	mounts := make(map[string]*volume.MountPoint)
+	for _, bind := range binds {
+		mp, err := volume.ParseMountSpec(bind, driver)
+		if err != nil {
+			return "", nil, derr.NewBadRequestError(err)
+		}
+
+		if mp.Source != "" {
+			return "", nil, derr.NewBadRequestError(fmt.Errorf("%s does not support mounting host directories as volumes: %s", v.ProductName, bind))
+		}
+
+		if _, ok := mounts[mp.Destination]; ok {
+			return "", nil, derr.NewBadRequestError(fmt.Errorf("Duplicate mount point '%s'", mp.Destination))
+		}
+		mounts[mp.Destination] = mp
+	}
+
+	var anonymous []string
+	if config.Config != nil {
+		for dest := range config.Config.Volumes {
+			anonymous = append(anonymous, dest)
+		}
+	}
+	for dest := range imageVolumes {
+		anonymous = append(anonymous, dest)
+	}
+
+	for _, dest := range anonymous {
+		mp, err := volume.ParseMountSpec(dest, driver)
+		if err != nil {
+			return "", nil, derr.NewBadRequestError(err)
+		}
+
+		// a named volume takes the place of an anonymous one
+		if _, ok := mounts[mp.Destination]; !ok {
+			mounts[mp.Destination] = mp
+		}
+	}
+
+	var created []string
+	defer func() {
+		if err != nil {
+			removeVolumes(created)
+		}
+	}()
+
+	dests := make([]string, 0, len(mounts))
+	for dest := range mounts {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+
+	for _, dest := range dests {
+		mp := mounts[dest]
+
+		vol, err := v.volumeCreate(mp.Name, mp.Driver, nil, nil, mp.Name == "")
+		if err != nil {
+			return "", nil, err
+		}
+		if mp.Name == "" {
+			created = append(created, vol.Name)
+		}
+
+		mode := "rw"
+		if !mp.RW {
+			mode = "ro"
+		}
+		if !mp.CopyData {
+			mode += ",nocopy"
+		}
+
+		res, err := client.Storage.VolumeJoin(storage.NewVolumeJoinParams().WithName(vol.Name).WithJoinArgs(
+			&models.VolumeJoinConfig{
+				Handle:    h,
+				MountPath: mp.Destination,
+				Mode:      &mode,
+			}))
+		if err != nil {
+			switch err := err.(type) {
+			case *storage.VolumeJoinNotFound:
+				return "", nil, derr.NewRequestNotFoundError(errors.New(err.Payload.Message))
+			case *storage.VolumeJoinConflict:
+				return "", nil, derr.NewBadRequestError(fmt.Errorf("Volume %s is mounted more than once", vol.Name))
+			case *storage.VolumeJoinDefault:
+				return "", nil, derr.NewErrorWithStatusCode(errors.New(err.Payload.Message), http.StatusInternalServerError)
+			default:
+				return "", nil, derr.NewErrorWithStatusCode(err, http.StatusInternalServerError)
+			}
+		}
+
+		h = res.Payload
+	}
+
+	return h, created, nil
+}
+
+// removeAnonymousVolumes removes the anonymous volumes among the given mounts of a removed
+// container.  Named volumes are kept, and failures are logged as the container is already gone.
+func removeAnonymousVolumes(mounts []*models.ContainerMountInfo) {
+	client := PortLayerClient()
+	if client == nil {
+		log.Errorf("Failed to get a portlayer client to remove anonymous volumes")
+		return
+	}
+
+	for _, m := range mounts {
+		name := stringValue(m.Name)
+		if name == "" {
+			continue
+		}
+
+		res, err := client.Storage.GetVolume(storage.NewGetVolumeParams().WithName(name))
+		if err != nil {
+			log.Warnf("Failed to look up volume %s: %s", name, err)
+			continue
+		}
+
+		if !dockerMetadata(res.Payload).Anonymous {
+			continue
+		}
+
+		if _, err = client.Storage.RemoveVolume(storage.NewRemoveVolumeParams().WithName(name)); err != nil {
+			log.Warnf("Failed to remove anonymous volume %s: %s", name, err)
+		}
+	}
+}
+
+// removeVolumes removes the named volumes, logging any failures.  It cleans up the anonymous
+// volumes created for a container that failed to be created.
+func removeVolumes(names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	client := PortLayerClient()
+	if client == nil {
+		log.Errorf("Failed to get a portlayer client to remove volumes")
+		return
+	}
+
+	for _, name := range names {
+		if _, err := client.Storage.RemoveVolume(storage.NewRemoveVolumeParams().WithName(name)); err != nil {
+			log.Warnf("Failed to remove volume %s: %s", name, err)
+		}
+	}
+}
+
+// convertVolume converts a port layer volume to the docker view of it
+func convertVolume(vol *models.VolumeResponse) *types.Volume {
+	meta := dockerMetadata(vol)
+
+	return &types.Volume{
+		Name:   vol.Name,
+		Driver: volumeDriver,
+		Labels: meta.Labels,
+	}
+}
+
+// dockerMetadata returns docker's view of a port layer volume
+func dockerMetadata(vol *models.VolumeResponse) volumeMetadata {
+	meta := volumeMetadata{}
+	if m, ok := vol.Metadata[dockerMetadataKey]; ok {
+		if err := json.Unmarshal([]byte(m), &meta); err != nil {
+			log.Warnf("Failed to read the metadata of volume %s: %s", vol.Name, err)
+		}
+	}
+
+	return meta
+}
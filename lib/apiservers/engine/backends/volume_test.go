@@ -0,0 +1,52 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vicbackends
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/lib/apiservers/portlayer/models"
+)
+
+func TestDockerMetadata(t *testing.T) {
+	blob, err := json.Marshal(volumeMetadata{
+		Driver:    volumeDriver,
+		Labels:    map[string]string{"tier": "db"},
+		Anonymous: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	vol := &models.VolumeResponse{
+		Name:     "anon",
+		Metadata: map[string]string{dockerMetadataKey: string(blob)},
+	}
+	meta := dockerMetadata(vol)
+	assert.True(t, meta.Anonymous)
+	assert.Equal(t, map[string]string{"tier": "db"}, convertVolume(vol).Labels)
+
+	// volumes created before anonymous volumes were recorded are treated as named
+	vol.Metadata = map[string]string{dockerMetadataKey: `{"Driver":"vsphere"}`}
+	assert.False(t, dockerMetadata(vol).Anonymous)
+
+	vol.Metadata = nil
+	assert.False(t, dockerMetadata(vol).Anonymous)
+
+	vol.Metadata = map[string]string{dockerMetadataKey: "not json"}
+	assert.False(t, dockerMetadata(vol).Anonymous)
+}
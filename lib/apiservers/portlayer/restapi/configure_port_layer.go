@@ -95,6 +95,8 @@ func configureAPI(api *operations.PortLayerAPI) http.Handler {
 
 	api.JSONConsumer = httpkit.JSONConsumer()
 
+	api.BinProducer = httpkit.ByteStreamProducer()
+
 	api.JSONProducer = httpkit.JSONProducer()
 
 	api.TxtProducer = httpkit.TextProducer()
@@ -20,7 +20,13 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/go-swagger/go-swagger/httpkit"
 	middleware "github.com/go-swagger/go-swagger/httpkit/middleware"
 	"golang.org/x/net/context"
 
@@ -34,6 +40,8 @@ import (
 	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/options"
 	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/lib/portlayer/exec"
+	"github.com/vmware/vic/lib/portlayer/util"
+	"github.com/vmware/vic/pkg/iolog"
 	"github.com/vmware/vic/pkg/trace"
 )
 
@@ -44,6 +52,9 @@ type ContainersHandlersImpl struct {
 
 const (
 	serialOverLANPort = 2377
+
+	// createdTimeFormat is the format used by time.Time.String, which records the creation time
+	createdTimeFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
 )
 
 // Configure assigns functions to all the exec api handlers
@@ -54,6 +65,14 @@ func (handler *ContainersHandlersImpl) Configure(api *operations.PortLayerAPI, h
 	api.ContainersCommitHandler = containers.CommitHandlerFunc(handler.CommitHandler)
 	api.ContainersGetStateHandler = containers.GetStateHandlerFunc(handler.GetStateHandler)
 	api.ContainersContainerRemoveHandler = containers.ContainerRemoveHandlerFunc(handler.RemoveContainerHandler)
+	api.ContainersContainerListHandler = containers.ContainerListHandlerFunc(handler.ContainerListHandler)
+	api.ContainersGetContainerInfoHandler = containers.GetContainerInfoHandlerFunc(handler.GetContainerInfoHandler)
+	api.ContainersExecCreateHandler = containers.ExecCreateHandlerFunc(handler.ExecCreateHandler)
+	api.ContainersExecInspectHandler = containers.ExecInspectHandlerFunc(handler.ExecInspectHandler)
+	api.ContainersContainerSignalHandler = containers.ContainerSignalHandlerFunc(handler.ContainerSignalHandler)
+	api.ContainersContainerLogsHandler = containers.ContainerLogsHandlerFunc(handler.ContainerLogsHandler)
+	api.ContainersContainerWaitHandler = containers.ContainerWaitHandlerFunc(handler.ContainerWaitHandler)
+	api.ContainersContainerTarHandler = containers.ContainerTarHandlerFunc(handler.ContainerTarHandler)
 	handler.handlerCtx = handlerCtx
 }
 
@@ -108,9 +127,46 @@ func (handler *ContainersHandlersImpl) CreateHandler(params containers.CreatePar
 			},
 		},
 		Key: pem.EncodeToMemory(&privateKeyBlock),
+
+		ImageID:     *params.CreateConfig.Image,
+		Annotations: params.CreateConfig.Labels,
+	}
+	if params.CreateConfig.RepoName != nil {
+		m.RepoName = *params.CreateConfig.RepoName
+	}
+	if params.CreateConfig.User != nil || len(params.CreateConfig.Groups) > 0 {
+		session := m.Sessions[id]
+		if params.CreateConfig.User != nil {
+			session.User = *params.CreateConfig.User
+		}
+		session.Groups = params.CreateConfig.Groups
+		m.Sessions[id] = session
+	}
+	if policy := params.CreateConfig.RestartPolicy; policy != nil {
+		session := m.Sessions[id]
+		if policy.Name != nil {
+			session.Restart.Name = *policy.Name
+		}
+		if policy.MaximumRetryCount != nil {
+			session.Restart.MaximumRetryCount = int(*policy.MaximumRetryCount)
+		}
+		m.Sessions[id] = session
 	}
 	log.Infof("CreateHandler Metadata: %#v", m)
 
+	store, err := util.ImageStoreNameToURL(params.CreateConfig.ImageStore.Name)
+	if err != nil {
+		return containers.NewCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	// the image must not be deleted until the container has recorded that it uses it
+	imageLock.Lock()
+	defer imageLock.Unlock()
+
+	if _, err = storageLayer.GetImage(ctx, store, *params.CreateConfig.Image); err != nil {
+		return containers.NewCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
 	// Create new portlayer executor and call Create on it
 	h := exec.NewContainer(exec.ParseID(id))
 	// Create the executor.ExecutorCreateConfig
@@ -127,6 +183,11 @@ func (handler *ContainersHandlersImpl) CreateHandler(params containers.CreatePar
 		return containers.NewCreateNotFound().WithPayload(&models.Error{Message: err.Error()})
 	}
 
+	// the container otherwise only records its image when the handle is committed
+	h.Container.Lock()
+	h.Container.ExecConfig.ImageID = m.ImageID
+	h.Container.Unlock()
+
 	//  send the container id back to the caller
 	return containers.NewCreateOK().WithPayload(&models.ContainerCreatedInfo{ID: id, Handle: h.String()})
 }
@@ -198,7 +259,7 @@ func (handler *ContainersHandlersImpl) CommitHandler(params containers.CommitPar
 		return containers.NewCommitNotFound().WithPayload(&models.Error{Message: "container not found"})
 	}
 
-	if err := h.Commit(context.Background(), handler.handlerCtx.Session); err != nil {
+	if err := h.Commit(context.Background(), handler.handlerCtx.Session, params.Wait); err != nil {
 		return containers.NewCommitDefault(http.StatusServiceUnavailable).WithPayload(&models.Error{Message: err.Error()})
 	}
 
@@ -222,3 +283,586 @@ func (handler *ContainersHandlersImpl) RemoveContainerHandler(params containers.
 
 	return containers.NewContainerRemoveOK()
 }
+
+// ContainerListHandler returns the containers matching the supplied filters
+func (handler *ContainersHandlersImpl) ContainerListHandler(params containers.ContainerListParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ContainerListHandler"))
+
+	var nameFilter *regexp.Regexp
+	if params.Name != nil {
+		var err error
+		if nameFilter, err = regexp.Compile(*params.Name); err != nil {
+			return containers.NewContainerListDefault(http.StatusBadRequest).WithPayload(&models.Error{Message: err.Error()})
+		}
+	}
+
+	// the guests may have changed the state since we last looked
+	cons := exec.Containers()
+	exec.UpdateContainers(context.Background(), handler.handlerCtx.Session, cons)
+
+	list := []*models.ContainerInfo{}
+	for _, con := range cons {
+		info := convertContainerInfo(con)
+
+		if (params.All == nil || !*params.All) && info.State != "RUNNING" {
+			continue
+		}
+		if params.State != nil && info.State != *params.State {
+			continue
+		}
+		if params.ID != nil && !strings.HasPrefix(info.ID, *params.ID) {
+			continue
+		}
+		if nameFilter != nil && !nameFilter.MatchString(*info.Name) {
+			continue
+		}
+		if !matchLabels(info.Labels, params.Label) {
+			continue
+		}
+
+		list = append(list, info)
+	}
+
+	return containers.NewContainerListOK().WithPayload(list)
+}
+
+// GetContainerInfoHandler returns the configuration and state of a single container
+func (handler *ContainersHandlersImpl) GetContainerInfoHandler(params containers.GetContainerInfoParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.GetContainerInfoHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewGetContainerInfoNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return containers.NewGetContainerInfoOK().WithPayload(containerInfo(context.Background(), con))
+}
+
+// ContainerSignalHandler delivers a signal to the primary process, or a named exec session, of a container
+func (handler *ContainersHandlersImpl) ContainerSignalHandler(params containers.ContainerSignalParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ContainerSignalHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewContainerSignalNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	sid := con.ID.String()
+	if params.Session != nil && *params.Session != "" {
+		sid = *params.Session
+	}
+
+	con.Lock()
+	_, ok := con.ExecConfig.Sessions[sid]
+	con.Unlock()
+
+	if !ok {
+		return containers.NewContainerSignalNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("No such exec instance: %s", sid)})
+	}
+
+	if err := con.Signal(context.Background(), sid, params.Signal); err != nil {
+		return containers.NewContainerSignalDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return containers.NewContainerSignalOK()
+}
+
+// ContainerLogsHandler returns the output of the primary process of a container from its session log
+func (handler *ContainersHandlersImpl) ContainerLogsHandler(params containers.ContainerLogsParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ContainerLogsHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewContainerLogsNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	var since, until time.Time
+	if params.Since != nil {
+		since = time.Unix(0, *params.Since)
+	}
+	if params.Until != nil {
+		until = time.Unix(0, *params.Until)
+	}
+
+	tail := int64(-1)
+	if params.Tail != nil {
+		tail = *params.Tail
+	}
+
+	follow := params.Follow != nil && *params.Follow
+	session := handler.handlerCtx.Session
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var entries []*iolog.Entry
+	var stream io.ReadCloser
+	if tail < 0 {
+		// without a tail the log is streamed as it's read, following it if requested
+		if stream, err = con.LogReader(ctx, session, 0, follow); err != nil {
+			cancel()
+			return containers.NewContainerLogsDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+		}
+	} else {
+		// the existing output is read up front so that tail can be applied
+		rc, err := con.LogReader(ctx, session, 0, false)
+		if err != nil {
+			cancel()
+			return containers.NewContainerLogsDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		var offset int64
+		entries, offset, err = readLogEntries(rc, since, until, tail)
+		rc.Close()
+		if err != nil {
+			cancel()
+			return containers.NewContainerLogsDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		if follow {
+			if stream, err = con.LogReader(ctx, session, offset, true); err != nil {
+				cancel()
+				return containers.NewContainerLogsDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+			}
+		}
+	}
+
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer httpkit.Producer) {
+		defer cancel()
+
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.WriteHeader(http.StatusOK)
+
+		flush := func() {}
+		if f, ok := rw.(http.Flusher); ok {
+			flush = f.Flush
+		}
+
+		for _, entry := range entries {
+			if _, err := entry.WriteTo(rw); err != nil {
+				log.Debugf("Error writing log for container %s: %s", con.ID, err)
+				return
+			}
+		}
+		flush()
+
+		if stream == nil {
+			return
+		}
+		defer stream.Close()
+
+		// stop reading if the client goes away
+		if cn, ok := rw.(http.CloseNotifier); ok {
+			go func() {
+				select {
+				case <-cn.CloseNotify():
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+		r := iolog.NewReader(stream)
+		for {
+			entry, err := r.Next()
+			if err != nil {
+				// a partial entry at the end of a log that isn't followed is still being written
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					log.Debugf("Stopped reading log for container %s: %s", con.ID, err)
+				}
+				return
+			}
+
+			if !until.IsZero() && entry.Timestamp.After(until) {
+				return
+			}
+
+			if entry.Timestamp.Before(since) {
+				continue
+			}
+
+			if _, err := entry.WriteTo(rw); err != nil {
+				log.Debugf("Error writing log for container %s: %s", con.ID, err)
+				return
+			}
+			flush()
+		}
+	})
+}
+
+// ContainerWaitHandler waits for the primary process of a container to exit
+func (handler *ContainersHandlersImpl) ContainerWaitHandler(params containers.ContainerWaitParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ContainerWaitHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewContainerWaitNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	timeout := time.Duration(-1)
+	if params.Timeout != nil && *params.Timeout >= 0 {
+		timeout = time.Duration(*params.Timeout) * time.Millisecond
+	}
+
+	status, err := con.Wait(context.Background(), handler.handlerCtx.Session, timeout)
+	if err != nil {
+		if err == exec.ErrWaitTimeout {
+			return containers.NewContainerWaitRequestTimeout().WithPayload(&models.Error{Message: err.Error()})
+		}
+		return containers.NewContainerWaitDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	exitCode := int32(status)
+	return containers.NewContainerWaitOK().WithPayload(&models.ContainerWaitInfo{ExitCode: &exitCode})
+}
+
+// ContainerTarHandler returns the changes a container has made to its image as a layer tar, as
+// needed to commit the container as a new image
+func (handler *ContainersHandlersImpl) ContainerTarHandler(params containers.ContainerTarParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ContainerTarHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewContainerTarNotFound()
+	}
+
+	store, err := util.ImageStoreNameToURL(params.StoreName)
+	if err != nil {
+		return containers.NewContainerTarDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	con.Lock()
+	imageID := con.ExecConfig.ImageID
+	con.Unlock()
+
+	ctx := context.Background()
+	parent, err := storageLayer.GetImage(ctx, store, imageID)
+	if err != nil {
+		return containers.NewContainerTarNotFound()
+	}
+
+	disk, thaw, err := con.FreezeDisk(ctx)
+	if err != nil {
+		return containers.NewContainerTarDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	tar, err := storageLayer.GetDiskTar(ctx, parent, disk)
+	if err != nil {
+		thaw()
+		return containers.NewContainerTarDefault(http.StatusInternalServerError).WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer httpkit.Producer) {
+		// the disk has to be released before the snapshot can be removed
+		defer thaw()
+		defer tar.Close()
+
+		containers.NewContainerTarOK().WithPayload(tar).WriteResponse(rw, producer)
+	})
+}
+
+// readLogEntries returns the log entries written within since and until, where a zero time is
+// unbounded, limited to the last tail of them unless tail is negative. Only tail entries are held
+// while reading. The offset of the end of the last complete entry is also returned so that a log
+// still being written can be followed.
+func readLogEntries(r io.Reader, since, until time.Time, tail int64) ([]*iolog.Entry, int64, error) {
+	ring := &logRing{size: tail}
+
+	lr := iolog.NewReader(r)
+	for {
+		entry, err := lr.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a partial entry is still being written
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if entry.Timestamp.Before(since) || (!until.IsZero() && entry.Timestamp.After(until)) {
+			continue
+		}
+
+		ring.add(entry)
+	}
+
+	return ring.ordered(), lr.Offset(), nil
+}
+
+// logRing keeps the last size log entries added to it, or all of them if size is negative
+type logRing struct {
+	size    int64
+	entries []*iolog.Entry
+	// index of the oldest entry once the ring is full
+	next int
+}
+
+func (r *logRing) add(entry *iolog.Entry) {
+	if r.size == 0 {
+		return
+	}
+
+	if r.size < 0 || int64(len(r.entries)) < r.size {
+		r.entries = append(r.entries, entry)
+		return
+	}
+
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// ordered returns the entries oldest first
+func (r *logRing) ordered() []*iolog.Entry {
+	entries := make([]*iolog.Entry, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
+
+// findContainer resolves a container from its ID, a unique prefix of its ID, or its name
+func findContainer(idOrName string) (*exec.Container, error) {
+	var prefixed []*exec.Container
+	for _, con := range exec.Containers() {
+		con.Lock()
+		name := con.ExecConfig.Name
+		con.Unlock()
+
+		id := con.ID.String()
+		if id == idOrName || name == idOrName {
+			return con, nil
+		}
+
+		if strings.HasPrefix(id, idOrName) {
+			prefixed = append(prefixed, con)
+		}
+	}
+
+	switch len(prefixed) {
+	case 0:
+		return nil, fmt.Errorf("container %s not found", idOrName)
+	case 1:
+		return prefixed[0], nil
+	default:
+		return nil, fmt.Errorf("multiple containers match %s", idOrName)
+	}
+}
+
+// matchLabels checks that the labels satisfy all of the filters, each of which is either
+// a key that must be present or a key=value pair
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		value, ok := labels[parts[0]]
+		if !ok {
+			return false
+		}
+
+		if len(parts) == 2 && value != parts[1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containerInfo refreshes the container and converts the port layer view of it into its API representation
+func containerInfo(ctx context.Context, con *exec.Container) *models.ContainerInfo {
+	// the guest may have changed the state since we last looked
+	if err := con.Update(ctx); err != nil {
+		log.Debugf("Unable to update state for container %s: %s", con.ID, err)
+	}
+
+	return convertContainerInfo(con)
+}
+
+// convertContainerInfo converts the port layer view of a container, as last refreshed, into its
+// API representation
+func convertContainerInfo(con *exec.Container) *models.ContainerInfo {
+	// refreshes replace the config rather than modifying it, so a copy taken under the lock is stable
+	con.Lock()
+	ec := con.ExecConfig
+	running := con.State == exec.StateRunning
+	con.Unlock()
+
+	session := ec.Sessions[ec.ID]
+
+	state := "STOPPED"
+	switch {
+	case running:
+		state = "RUNNING"
+	case session.StartTime == 0:
+		state = "CREATED"
+	}
+
+	exitCode := int32(session.ExitStatus)
+	maxRetries := int32(session.Restart.MaximumRetryCount)
+	restartCount := int32(session.RestartCount)
+	info := &models.ContainerInfo{
+		ID:         con.ID.String(),
+		Name:       &ec.Name,
+		Image:      &ec.ImageID,
+		RepoName:   &ec.RepoName,
+		State:      state,
+		Path:       &session.Cmd.Path,
+		Env:        session.Cmd.Env,
+		WorkingDir: &session.Cmd.Dir,
+		Tty:        &session.Tty,
+		Labels:     ec.Annotations,
+		ExitCode:   &exitCode,
+		StartTime:  &session.StartTime,
+		StopTime:   &session.StopTime,
+
+		RestartPolicy: &models.RestartPolicy{
+			Name:              &session.Restart.Name,
+			MaximumRetryCount: &maxRetries,
+		},
+		RestartCount: &restartCount,
+		User:         &session.User,
+	}
+
+	// Args includes the command itself
+	if len(session.Cmd.Args) > 0 {
+		info.Args = session.Cmd.Args[1:]
+	}
+
+	if created, err := time.Parse(createdTimeFormat, ec.Created); err == nil {
+		unix := created.Unix()
+		info.Created = &unix
+	}
+
+	for name, endpoint := range ec.Networks {
+		network := &models.ContainerNetworkInfo{Name: name}
+
+		ip := endpoint.Assigned
+		if ip == nil && endpoint.Static != nil {
+			ip = endpoint.Static.IP
+		}
+		if ip != nil {
+			mask := endpoint.Network.Gateway.Mask
+			if endpoint.Static != nil {
+				mask = endpoint.Static.Mask
+			}
+			address := (&net.IPNet{IP: ip, Mask: mask}).String()
+			network.Address = &address
+		}
+
+		if endpoint.Network.Gateway.IP != nil {
+			gateway := endpoint.Network.Gateway.IP.String()
+			network.Gateway = &gateway
+		}
+
+		network.Ports = endpoint.Ports
+
+		info.Networks = append(info.Networks, network)
+	}
+
+	for name, mount := range ec.Mounts {
+		name := name
+		source := mount.Source.String()
+		mode := mount.Mode
+		info.Mounts = append(info.Mounts, &models.ContainerMountInfo{
+			Name:        &name,
+			Source:      &source,
+			Destination: mount.Path,
+			Mode:        &mode,
+		})
+	}
+
+	return info
+}
+
+// ExecCreateHandler adds an exec session to the container referenced by the handle
+func (handler *ContainersHandlersImpl) ExecCreateHandler(params containers.ExecCreateParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ExecCreateHandler"))
+
+	h := exec.GetHandle(params.Handle)
+	if h == nil {
+		return containers.NewExecCreateNotFound().WithPayload(&models.Error{Message: "container not found"})
+	}
+
+	if h.Container.State != exec.StateRunning {
+		return containers.NewExecCreateDefault(http.StatusConflict).WithPayload(&models.Error{Message: fmt.Sprintf("container %s is not running", h.ExecConfig.ID)})
+	}
+
+	config := params.ExecConfig
+	log.Debugf("Path: %#v", config.Path)
+	log.Debugf("Args: %#v", config.Args)
+	log.Debugf("Env: %#v", config.Env)
+	log.Debugf("WorkingDir: %#v", config.WorkingDir)
+
+	session := &metadata.SessionConfig{
+		Cmd: metadata.Cmd{
+			Env:  config.Env,
+			Path: config.Path,
+			Args: append([]string{config.Path}, config.Args...),
+		},
+	}
+	if config.WorkingDir != nil {
+		session.Cmd.Dir = *config.WorkingDir
+	}
+	if config.Tty != nil {
+		session.Tty = *config.Tty
+	}
+	if config.Attach != nil {
+		session.Attach = *config.Attach
+	}
+
+	// exec processes run as the container's user unless told otherwise
+	primary := h.ExecConfig.Sessions[h.ExecConfig.ID]
+	session.User = primary.User
+	session.Groups = primary.Groups
+	if config.User != nil && *config.User != "" {
+		session.User = *config.User
+	}
+
+	eid := h.AddExecSession(session)
+
+	return containers.NewExecCreateOK().WithPayload(&models.ExecCreatedInfo{ID: eid, Handle: h.String()})
+}
+
+// ExecInspectHandler returns the state of an exec session, as last published by the container
+func (handler *ContainersHandlersImpl) ExecInspectHandler(params containers.ExecInspectParams) middleware.Responder {
+	defer trace.End(trace.Begin("Containers.ExecInspectHandler"))
+
+	con, err := findContainer(params.ID)
+	if err != nil {
+		return containers.NewExecInspectNotFound().WithPayload(&models.Error{Message: err.Error()})
+	}
+
+	h := exec.GetContainer(con.ID)
+	if h == nil {
+		return containers.NewExecInspectNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("container %s not found", params.ID)})
+	}
+
+	session, ok := h.ExecConfig.Sessions[params.Eid]
+	if !ok {
+		return containers.NewExecInspectNotFound().WithPayload(&models.Error{Message: fmt.Sprintf("exec session %s not found", params.Eid)})
+	}
+
+	// only a running container can have updated the session state
+	if h.Container.State == exec.StateRunning {
+		current, err := h.Container.Refresh(context.Background())
+		if err != nil {
+			return containers.NewExecInspectDefault(http.StatusServiceUnavailable).WithPayload(&models.Error{Message: err.Error()})
+		}
+
+		if published, ok := current.Sessions[params.Eid]; ok {
+			session = published
+		}
+	}
+
+	running := h.Container.State == exec.StateRunning && session.Started == "true" && session.StopTime == 0
+	exitCode := int32(session.ExitStatus)
+
+	info := &models.ExecInfo{
+		ID:        params.Eid,
+		Path:      &session.Cmd.Path,
+		Args:      session.Cmd.Args,
+		Tty:       &session.Tty,
+		Attach:    &session.Attach,
+		Running:   &running,
+		Started:   &session.Started,
+		ExitCode:  &exitCode,
+		StartTime: &session.StartTime,
+		StopTime:  &session.StopTime,
+	}
+
+	return containers.NewExecInspectOK().WithPayload(info)
+}
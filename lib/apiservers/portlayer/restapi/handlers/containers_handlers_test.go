@@ -0,0 +1,272 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-swagger/go-swagger/httpkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/operations/containers"
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	spl "github.com/vmware/vic/lib/portlayer/storage"
+	"github.com/vmware/vic/pkg/iolog"
+	"github.com/vmware/vic/pkg/vsphere/extraconfig"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/simulator"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+)
+
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{
+		"com.example.tier": "web",
+		"debug":            "",
+	}
+
+	assert.True(t, matchLabels(labels, nil))
+	assert.True(t, matchLabels(labels, []string{"debug"}))
+	assert.True(t, matchLabels(labels, []string{"com.example.tier=web"}))
+	assert.True(t, matchLabels(labels, []string{"com.example.tier", "debug="}))
+
+	assert.False(t, matchLabels(labels, []string{"missing"}))
+	assert.False(t, matchLabels(labels, []string{"com.example.tier=db"}))
+	assert.False(t, matchLabels(labels, []string{"debug", "com.example.tier=db"}))
+	assert.False(t, matchLabels(nil, []string{"debug"}))
+}
+
+func TestReadLogEntries(t *testing.T) {
+	var buf bytes.Buffer
+
+	start := time.Unix(1470000000, 0)
+	for i := 0; i < 5; i++ {
+		entry := &iolog.Entry{
+			Stream:    iolog.Stdout,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Data:      []byte(fmt.Sprintf("line %d\n", i)),
+		}
+		entry.WriteTo(&buf)
+	}
+	size := int64(buf.Len())
+
+	// a partially written entry is left for a follower to pick up
+	buf.Write([]byte{byte(iolog.Stderr), 0, 0})
+
+	lines := func(entries []*iolog.Entry) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, string(e.Data))
+		}
+		return out
+	}
+
+	entries, offset, err := readLogEntries(bytes.NewReader(buf.Bytes()), time.Time{}, time.Time{}, -1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+	assert.Equal(t, size, offset)
+
+	entries, _, err = readLogEntries(bytes.NewReader(buf.Bytes()), time.Time{}, time.Time{}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 3\n", "line 4\n"}, lines(entries))
+
+	// the ring of kept entries wraps
+	entries, _, err = readLogEntries(bytes.NewReader(buf.Bytes()), time.Time{}, time.Time{}, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 2\n", "line 3\n", "line 4\n"}, lines(entries))
+
+	entries, _, err = readLogEntries(bytes.NewReader(buf.Bytes()), time.Time{}, time.Time{}, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, _, err = readLogEntries(bytes.NewReader(buf.Bytes()), start.Add(time.Second), start.Add(3*time.Second), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 1\n", "line 2\n", "line 3\n"}, lines(entries))
+
+	entries, _, err = readLogEntries(bytes.NewReader(buf.Bytes()), start.Add(time.Second), start.Add(3*time.Second), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 3\n"}, lines(entries))
+}
+
+func TestContainerTarHandler(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.ESX()
+	if !assert.NoError(t, model.Create()) {
+		return
+	}
+	defer model.Remove()
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	config := &session.Config{
+		Service:  "http://user:pass@" + server.URL.Host + server.URL.Path,
+		Insecure: true,
+	}
+
+	sess, err := session.NewSession(config).Connect(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	finder := find.NewFinder(sess.Vim25(), false)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	finder.SetDatacenter(dc)
+
+	folders, err := dc.Folders(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pool, err := finder.DefaultResourcePool(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// a containerVM created from testImageID, with a volume ahead of its own disk
+	ec := &metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "tarcontainer",
+			Name: "tarcontainer",
+		},
+		Sessions: map[string]metadata.SessionConfig{
+			"tarcontainer": {},
+		},
+		ImageID: testImageID,
+	}
+	cfg := make(map[string]string)
+	extraconfig.Encode(extraconfig.MapSink(cfg), ec)
+
+	disk := "[LocalDS_0] tarcontainer/tarcontainer.vmdk"
+	spec := types.VirtualMachineConfigSpec{
+		Name:    "tarcontainer",
+		GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+		Files: &types.VirtualMachineFileInfo{
+			VmPathName: "[LocalDS_0] tarcontainer",
+		},
+		ExtraConfig: extraconfig.OptionValueFromMap(cfg),
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationAdd,
+				Device: &types.VirtualDisk{
+					VirtualDevice: types.VirtualDevice{
+						Key: -1,
+						Backing: &types.VirtualDiskFlatVer2BackingInfo{
+							DiskMode: string(types.VirtualDiskModeIndependent_persistent),
+							VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+								FileName: "[LocalDS_0] VIC/volumes/volume/volume.vmdk",
+							},
+						},
+					},
+				},
+			},
+			&types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationAdd,
+				Device: &types.VirtualDisk{
+					VirtualDevice: types.VirtualDevice{
+						Key: -2,
+						Backing: &types.VirtualDiskFlatVer2BackingInfo{
+							DiskMode: string(types.VirtualDiskModePersistent),
+							Parent: &types.VirtualDiskFlatVer2BackingInfo{
+								VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+									FileName: fmt.Sprintf("[LocalDS_0] VIC/%s/images/%s/%[2]s.vmdk", testStoreName, testImageID),
+								},
+							},
+							VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+								FileName: disk,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return folders.VmFolder.CreateVM(ctx, spec, pool, nil)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	exec.Config.ResourcePool = pool
+	if !assert.NoError(t, exec.Sync(ctx, sess)) {
+		return
+	}
+
+	storageLayer = spl.NewLookupCache(&MockDataStore{})
+
+	handler := &ContainersHandlersImpl{}
+	params := containers.ContainerTarParams{
+		ID:        "tarcontainer",
+		StoreName: testStoreName,
+	}
+
+	// the image store doesn't exist yet
+	result := handler.ContainerTarHandler(params)
+	assert.IsType(t, &containers.ContainerTarNotFound{}, result)
+
+	_, err = storageLayer.CreateImageStore(ctx, testStoreName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := spl.Image{
+		ID:    "scratch",
+		Store: &testStoreURL,
+	}
+	_, err = storageLayer.WriteImage(ctx, &parent, testImageID, nil, testImageSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the mock datastore returns the path of the frozen disk as its tar
+	result = handler.ContainerTarHandler(params)
+	rw := httptest.NewRecorder()
+	result.WriteResponse(rw, httpkit.ByteStreamProducer())
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, disk, rw.Body.String())
+
+	params.ID = "missing"
+	result = handler.ContainerTarHandler(params)
+	assert.IsType(t, &containers.ContainerTarNotFound{}, result)
+
+	// exec sessions are found through a short ID as well as the full one
+	inspect := containers.ExecInspectParams{
+		ID:  "tarcon",
+		Eid: "tarcontainer",
+	}
+	result = handler.ExecInspectHandler(inspect)
+	if assert.IsType(t, &containers.ExecInspectOK{}, result) {
+		assert.Equal(t, "tarcontainer", result.(*containers.ExecInspectOK).Payload.ID)
+	}
+
+	inspect.ID = "missing"
+	result = handler.ExecInspectHandler(inspect)
+	assert.IsType(t, &containers.ExecInspectNotFound{}, result)
+}
@@ -31,6 +31,8 @@ import (
 	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/operations/interaction"
 	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/options"
 	"github.com/vmware/vic/lib/portlayer/attach"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/session"
 )
 
@@ -52,6 +54,7 @@ func (i *InteractionHandlersImpl) Configure(api *operations.PortLayerAPI, _ *Han
 	api.InteractionContainerSetStdinHandler = interaction.ContainerSetStdinHandlerFunc(i.ContainerSetStdinHandler)
 	api.InteractionContainerGetStdoutHandler = interaction.ContainerGetStdoutHandlerFunc(i.ContainerGetStdoutHandler)
 	api.InteractionContainerGetStderrHandler = interaction.ContainerGetStderrHandlerFunc(i.ContainerGetStderrHandler)
+	api.InteractionExecStartHandler = interaction.ExecStartHandlerFunc(i.ExecStartHandler)
 
 	ctx := context.Background()
 	sessionconfig := &session.Config{
@@ -75,6 +78,58 @@ func (i *InteractionHandlersImpl) Configure(api *operations.PortLayerAPI, _ *Han
 	if err := i.attachServer.Start(); err != nil {
 		log.Fatalf("Attach server unable to start: %s", err)
 	}
+
+	// allow exec to signal container processes when stopping containers
+	exec.Config.Interaction = i.attachServer
+}
+
+// ExecStartHandler launches a committed exec session in a running container. Streams for
+// sessions that allow attach are available via the exec session ID once this returns.
+func (i *InteractionHandlersImpl) ExecStartHandler(params interaction.ExecStartParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.Eid))
+
+	// the session is addressed through the container's full ID, whatever the caller used
+	con, err := findContainer(params.ID)
+	if err != nil {
+		retErr := &models.Error{Message: fmt.Sprintf("No such container: %s", params.ID)}
+		return interaction.NewExecStartNotFound().WithPayload(retErr)
+	}
+	id := con.ID.String()
+
+	h := exec.GetContainer(con.ID)
+	if h == nil {
+		retErr := &models.Error{Message: fmt.Sprintf("No such container: %s", params.ID)}
+		return interaction.NewExecStartNotFound().WithPayload(retErr)
+	}
+
+	session, ok := h.ExecConfig.Sessions[params.Eid]
+	if !ok {
+		retErr := &models.Error{Message: fmt.Sprintf("No such exec instance: %s", params.Eid)}
+		return interaction.NewExecStartNotFound().WithPayload(retErr)
+	}
+
+	ctx := context.Background()
+
+	// prompt the tether to pick up the new session
+	if err := i.attachServer.Reload(ctx, id); err != nil {
+		retErr := &models.Error{Message: fmt.Sprintf("Failed to reload container %s: %s", params.ID, err)}
+		return interaction.NewExecStartInternalServerError().WithPayload(retErr)
+	}
+
+	// attachable sessions are held by the tether until the first attach
+	if session.Attach {
+		if _, err := i.attachServer.Attach(ctx, id, params.Eid, interactionTimeout); err != nil {
+			retErr := &models.Error{Message: fmt.Sprintf("Failed to attach to exec instance %s: %s", params.Eid, err)}
+			return interaction.NewExecStartInternalServerError().WithPayload(retErr)
+		}
+	}
+
+	if err := h.Container.WaitForSession(ctx, params.Eid); err != nil {
+		retErr := &models.Error{Message: fmt.Sprintf("Failed to launch exec instance %s: %s", params.Eid, err)}
+		return interaction.NewExecStartInternalServerError().WithPayload(retErr)
+	}
+
+	return interaction.NewExecStartOK()
 }
 
 func (i *InteractionHandlersImpl) ContainerResizeHandler(params interaction.ContainerResizeParams) middleware.Responder {
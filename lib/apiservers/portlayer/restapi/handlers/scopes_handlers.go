@@ -16,10 +16,10 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 
+	log "github.com/Sirupsen/logrus"
 	middleware "github.com/go-swagger/go-swagger/httpkit/middleware"
 
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
@@ -59,6 +59,23 @@ func (handler *ScopesHandlersImpl) Configure(api *operations.PortLayerAPI, handl
 		log.Fatalf("failed to create network context: %s", err)
 	}
 
+	// rebind the running containers that were rediscovered at startup so that
+	// their addresses remain reserved
+	for _, con := range exec.Containers() {
+		if con.State != exec.StateRunning {
+			continue
+		}
+
+		h := exec.GetContainer(con.ID)
+		if h == nil || len(h.ExecConfig.Networks) == 0 {
+			continue
+		}
+
+		if _, err := netCtx.BindContainer(h); err != nil {
+			log.Errorf("failed to restore network endpoints for container %s: %s", con.ID, err)
+		}
+	}
+
 	handler.netCtx = netCtx
 	handler.handlerCtx = handlerCtx
 }
@@ -190,7 +207,7 @@ func (handler *ScopesHandlersImpl) ScopesAddContainer(params scopes.AddContainer
 			ip = &i
 		}
 
-		return handler.netCtx.AddContainer(h, params.Config.NetworkConfig.NetworkName, ip)
+		return handler.netCtx.AddContainer(h, params.Config.NetworkConfig.NetworkName, ip, params.Config.NetworkConfig.Ports)
 	}()
 
 	if err != nil {
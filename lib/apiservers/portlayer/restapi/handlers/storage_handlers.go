@@ -15,10 +15,16 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/go-swagger/go-swagger/httpkit"
 	"github.com/go-swagger/go-swagger/httpkit/middleware"
 	"github.com/go-swagger/go-swagger/swag"
 	"golang.org/x/net/context"
@@ -29,7 +35,9 @@ import (
 	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/options"
 	"github.com/vmware/vic/pkg/vsphere/session"
 
+	"github.com/vmware/vic/lib/portlayer/exec"
 	spl "github.com/vmware/vic/lib/portlayer/storage"
+	"github.com/vmware/vic/lib/portlayer/storage/nfs"
 	"github.com/vmware/vic/lib/portlayer/storage/vsphere"
 	"github.com/vmware/vic/lib/portlayer/util"
 	"github.com/vmware/vic/pkg/trace"
@@ -41,6 +49,13 @@ type StorageHandlersImpl struct{}
 var (
 	storageSession = &session.Session{}
 	storageLayer   = &spl.NameLookupCache{}
+
+	storageVolumeLayer spl.VolumeStorer
+
+	// imageLock serializes the deletion of images with the creation of containers from them, so that
+	// an image cannot be deleted between a container being created from it and the container
+	// recording that it uses it
+	imageLock sync.Mutex
 )
 
 // Configure assigns functions to all the storage api handlers
@@ -75,12 +90,49 @@ func (handler *StorageHandlersImpl) Configure(api *operations.PortLayerAPI, hand
 	// expensive metadata lookups.
 	storageLayer = spl.NewLookupCache(ds)
 
+	// Volume locations are either datastore directories or NFS exports
+	dsLocations := make(map[string]url.URL)
+	nfsLocations := make(map[string]url.URL)
+	for name, location := range spl.Config.VolumeLocations {
+		if location.Scheme == nfs.Scheme {
+			nfsLocations[name] = location
+		} else {
+			dsLocations[name] = location
+		}
+	}
+
+	vs, err := vsphere.NewVolumeStore(ctx, storageSession, dsLocations)
+	if err != nil {
+		log.Panicf("Cannot instantiate volume stores: %s", err)
+	}
+	stores := []spl.VolumeStorer{vs}
+
+	if len(nfsLocations) > 0 {
+		// an unreachable NFS server shouldn't stop the datastore volume
+		// stores from being used
+		nvs, err := nfs.NewVolumeStore(ctx, nfsLocations, nfs.NewMountServer())
+		if err != nil {
+			log.Errorf("Cannot instantiate NFS volume stores: %s", err)
+		} else {
+			stores = append(stores, nvs)
+		}
+	}
+
+	// The volume stores are fronted by a cache for the same reason
+	storageVolumeLayer = spl.NewVolumeLookupCache(stores...)
+
 	api.StorageCreateImageStoreHandler = storage.CreateImageStoreHandlerFunc(handler.CreateImageStore)
 	api.StorageGetImageHandler = storage.GetImageHandlerFunc(handler.GetImage)
 	api.StorageGetImageTarHandler = storage.GetImageTarHandlerFunc(handler.GetImageTar)
+	api.StorageDeleteImageHandler = storage.DeleteImageHandlerFunc(handler.DeleteImage)
 	api.StorageListImagesHandler = storage.ListImagesHandlerFunc(handler.ListImages)
 	api.StorageWriteImageHandler = storage.WriteImageHandlerFunc(handler.WriteImage)
+	api.StorageCreateVolumeHandler = storage.CreateVolumeHandlerFunc(handler.CreateVolume)
+	api.StorageGetVolumeHandler = storage.GetVolumeHandlerFunc(handler.GetVolume)
+	api.StorageListVolumesHandler = storage.ListVolumesHandlerFunc(handler.ListVolumes)
+	api.StorageListVolumeStoresHandler = storage.ListVolumeStoresHandlerFunc(handler.ListVolumeStores)
 	api.StorageRemoveVolumeHandler = storage.RemoveVolumeHandlerFunc(handler.RemoveVolume)
+	api.StorageVolumeJoinHandler = storage.VolumeJoinHandlerFunc(handler.VolumeJoin)
 }
 
 // CreateImageStore creates a new image store
@@ -127,9 +179,95 @@ func (handler *StorageHandlersImpl) GetImage(params storage.GetImageParams) midd
 	return storage.NewGetImageOK().WithPayload(result)
 }
 
+// DeleteImage deletes an image layer from a store
+func (handler *StorageHandlersImpl) DeleteImage(params storage.DeleteImageParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.ID))
+
+	u, err := util.ImageStoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	image, err := storageLayer.GetImage(context.TODO(), u, params.ID)
+	if err != nil {
+		e := &models.Error{Code: swag.Int64(http.StatusNotFound), Message: err.Error()}
+		return storage.NewDeleteImageNotFound().WithPayload(e)
+	}
+
+	imageLock.Lock()
+	defer imageLock.Unlock()
+
+	// the disk of a container is a child of the layer it was created from
+	for _, c := range exec.Containers() {
+		c.Lock()
+		inUse := c.ExecConfig.ImageID == image.ID
+		c.Unlock()
+
+		if inUse {
+			return storage.NewDeleteImageLocked().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusLocked),
+					Message: fmt.Sprintf("image %s is in use by container %s", image.ID, c.ID),
+				})
+		}
+	}
+
+	if err = storageLayer.DeleteImage(context.TODO(), image); err != nil {
+		if err == spl.ErrImageInUse {
+			return storage.NewDeleteImageLocked().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusLocked),
+					Message: fmt.Sprintf("image %s is the parent of other images", image.ID),
+				})
+		}
+
+		return storage.NewDeleteImageDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return storage.NewDeleteImageOK()
+}
+
 // GetImageTar returns an image tar file
 func (handler *StorageHandlersImpl) GetImageTar(params storage.GetImageTarParams) middleware.Responder {
-	return middleware.NotImplemented("operation storage.GetImageTar has not yet been implemented")
+	defer trace.End(trace.Begin(params.ID))
+
+	u, err := util.ImageStoreNameToURL(params.StoreName)
+	if err != nil {
+		return storage.NewGetImageTarDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	image, err := storageLayer.GetImage(context.TODO(), u, params.ID)
+	if err != nil {
+		return storage.NewGetImageTarNotFound()
+	}
+
+	tar, err := storageLayer.GetImageTar(context.TODO(), image)
+	if err != nil {
+		return storage.NewGetImageTarDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	// the tar holds the layer attached until it's closed
+	return middleware.ResponderFunc(func(rw http.ResponseWriter, producer httpkit.Producer) {
+		defer tar.Close()
+
+		storage.NewGetImageTarOK().WithPayload(tar).WriteResponse(rw, producer)
+	})
 }
 
 // ListImages returns a list of images in a store
@@ -184,6 +322,14 @@ func (handler *StorageHandlersImpl) WriteImage(params storage.WriteImageParams)
 
 	image, err := storageLayer.WriteImage(context.TODO(), parent, params.ImageID, meta, params.Sum, params.ImageFile)
 	if err != nil {
+		if _, ok := err.(*spl.DigestMismatchError); ok {
+			return storage.NewWriteImageUnprocessableEntity().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusUnprocessableEntity),
+					Message: err.Error(),
+				})
+		}
+
 		return storage.NewWriteImageDefault(http.StatusInternalServerError).WithPayload(
 			&models.Error{
 				Code:    swag.Int64(http.StatusInternalServerError),
@@ -194,10 +340,245 @@ func (handler *StorageHandlersImpl) WriteImage(params storage.WriteImageParams)
 	return storage.NewWriteImageCreated().WithPayload(i)
 }
 
-//RemoveVolume : Remove a Volume from existence
-func (handler *StorageHandlersImpl) RemoveVolume(storage.RemoveVolumeParams) middleware.Responder {
-	defer trace.End(trace.Begin("storage_handlers.RemoveVolume"))
-	return storage.NewRemoveVolumeOK() //TODO: this is just a stub for now.
+// CreateVolume creates a volume in a volume store
+func (handler *StorageHandlersImpl) CreateVolume(params storage.CreateVolumeParams) middleware.Responder {
+	req := params.VolumeRequest
+	defer trace.End(trace.Begin(req.Name))
+
+	if err := spl.ValidVolumeName(req.Name); err != nil {
+		return storage.NewCreateVolumeDefault(http.StatusBadRequest).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusBadRequest),
+				Message: err.Error(),
+			})
+	}
+
+	if req.Capacity <= 0 {
+		return storage.NewCreateVolumeDefault(http.StatusBadRequest).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusBadRequest),
+				Message: fmt.Sprintf("invalid volume capacity %dMB", req.Capacity),
+			})
+	}
+
+	stores, err := storageVolumeLayer.VolumeStoresList(context.TODO())
+	if err != nil {
+		return storage.NewCreateVolumeDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	store, ok := stores[req.Store]
+	if !ok {
+		return storage.NewCreateVolumeNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: fmt.Sprintf("volume store %s not found", req.Store),
+			})
+	}
+
+	info := make(map[string][]byte)
+	for k, v := range req.Metadata {
+		info[k] = []byte(v)
+	}
+
+	vol, err := storageVolumeLayer.VolumeCreate(context.TODO(), req.Name, &store, uint64(req.Capacity)*1024, info)
+	if err != nil {
+		if os.IsExist(err) {
+			return storage.NewCreateVolumeConflict().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusConflict),
+					Message: fmt.Sprintf("a volume named %s already exists", req.Name),
+				})
+		}
+
+		return storage.NewCreateVolumeDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return storage.NewCreateVolumeCreated().WithPayload(convertVolume(vol))
+}
+
+// GetVolume returns a volume by name
+func (handler *StorageHandlersImpl) GetVolume(params storage.GetVolumeParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.Name))
+
+	vol, err := storageVolumeLayer.VolumeGet(context.TODO(), params.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storage.NewGetVolumeNotFound().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusNotFound),
+					Message: fmt.Sprintf("no such volume %s", params.Name),
+				})
+		}
+
+		return storage.NewGetVolumeDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return storage.NewGetVolumeOK().WithPayload(convertVolume(vol))
+}
+
+// ListVolumes returns the volumes in all of the volume stores
+func (handler *StorageHandlersImpl) ListVolumes() middleware.Responder {
+	defer trace.End(trace.Begin(""))
+
+	vols, err := storageVolumeLayer.VolumesList(context.TODO())
+	if err != nil {
+		return storage.NewListVolumesDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	result := make([]*models.VolumeResponse, 0, len(vols))
+	for _, vol := range vols {
+		result = append(result, convertVolume(vol))
+	}
+
+	return storage.NewListVolumesOK().WithPayload(result)
+}
+
+// ListVolumeStores returns the names of the volume stores
+func (handler *StorageHandlersImpl) ListVolumeStores() middleware.Responder {
+	defer trace.End(trace.Begin(""))
+
+	stores, err := storageVolumeLayer.VolumeStoresList(context.TODO())
+	if err != nil {
+		return storage.NewListVolumeStoresDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return storage.NewListVolumeStoresOK().WithPayload(names)
+}
+
+// RemoveVolume removes a volume, provided it isn't attached to a container
+func (handler *StorageHandlersImpl) RemoveVolume(params storage.RemoveVolumeParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.Name))
+
+	vol, err := storageVolumeLayer.VolumeGet(context.TODO(), params.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storage.NewRemoveVolumeNotFound().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusNotFound),
+					Message: fmt.Sprintf("no such volume %s", params.Name),
+				})
+		}
+
+		return storage.NewRemoveVolumeInternalServerError().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	// containers mount volumes by name
+	for _, c := range exec.Containers() {
+		if _, ok := c.ExecConfig.Mounts[vol.ID]; ok {
+			return storage.NewRemoveVolumeConflict().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusConflict),
+					Message: fmt.Sprintf("volume %s is in use by container %s", vol.ID, c.ID),
+				})
+		}
+	}
+
+	if err = storageVolumeLayer.VolumeDestroy(context.TODO(), vol); err != nil {
+		if err == spl.ErrVolumeInUse {
+			return storage.NewRemoveVolumeConflict().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusConflict),
+					Message: fmt.Sprintf("volume %s is in use", vol.ID),
+				})
+		}
+
+		return storage.NewRemoveVolumeInternalServerError().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return storage.NewRemoveVolumeOK()
+}
+
+// VolumeJoin adds a volume to a container, to be mounted when it starts
+func (handler *StorageHandlersImpl) VolumeJoin(params storage.VolumeJoinParams) middleware.Responder {
+	defer trace.End(trace.Begin(params.Name))
+
+	h := exec.GetHandle(params.JoinArgs.Handle)
+	if h == nil {
+		return storage.NewVolumeJoinNotFound().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusNotFound),
+				Message: "container not found",
+			})
+	}
+
+	vol, err := storageVolumeLayer.VolumeGet(context.TODO(), params.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storage.NewVolumeJoinNotFound().WithPayload(
+				&models.Error{
+					Code:    swag.Int64(http.StatusNotFound),
+					Message: fmt.Sprintf("no such volume %s", params.Name),
+				})
+		}
+
+		return storage.NewVolumeJoinDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	if _, ok := h.ExecConfig.Mounts[vol.ID]; ok {
+		return storage.NewVolumeJoinConflict().WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusConflict),
+				Message: fmt.Sprintf("volume %s is already mounted in the container", vol.ID),
+			})
+	}
+
+	var mode string
+	if params.JoinArgs.Mode != nil {
+		mode = *params.JoinArgs.Mode
+	}
+
+	if strings.HasPrefix(vol.Device, nfs.Scheme+"://") {
+		h, err = nfs.VolumeJoin(h, vol, params.JoinArgs.MountPath, mode)
+	} else {
+		h, err = vsphere.VolumeJoin(h, vol, params.JoinArgs.MountPath, mode)
+	}
+	if err != nil {
+		return storage.NewVolumeJoinDefault(http.StatusInternalServerError).WithPayload(
+			&models.Error{
+				Code:    swag.Int64(http.StatusInternalServerError),
+				Message: err.Error(),
+			})
+	}
+
+	return storage.NewVolumeJoinOK().WithPayload(h.String())
 }
 
 // convert an SPL Image to a swagger-defined Image
@@ -230,3 +611,30 @@ func convertImage(image *spl.Image) *models.Image {
 		Store:    image.Store.String(),
 	}
 }
+
+// convert an SPL Volume to a swagger-defined VolumeResponse
+func convertVolume(vol *spl.Volume) *models.VolumeResponse {
+	var selfLink *string
+	if vol.SelfLink != nil {
+		l := vol.SelfLink.String()
+		selfLink = &l
+	}
+
+	storeName, err := util.VolumeStoreName(vol.Store)
+	if err != nil {
+		storeName = vol.Store.String()
+	}
+
+	meta := make(map[string]string)
+	for k, v := range vol.Info {
+		meta[k] = string(v)
+	}
+
+	return &models.VolumeResponse{
+		Name:     vol.ID,
+		Store:    storeName,
+		SelfLink: selfLink,
+		Label:    swag.String(vol.Label),
+		Metadata: meta,
+	}
+}
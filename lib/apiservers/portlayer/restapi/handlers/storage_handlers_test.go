@@ -17,18 +17,23 @@ package handlers
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/context"
 
+	"github.com/go-swagger/go-swagger/httpkit"
 	//"github.com/go-swagger/go-swagger/httpkit/middleware"
 	"github.com/go-swagger/go-swagger/swag"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/vic/lib/apiservers/portlayer/models"
 	"github.com/vmware/vic/lib/apiservers/portlayer/restapi/operations/storage"
+	"github.com/vmware/vic/lib/portlayer/exec"
 	spl "github.com/vmware/vic/lib/portlayer/storage"
 	"github.com/vmware/vic/lib/portlayer/util"
 )
@@ -71,7 +76,11 @@ func (c *MockDataStore) ListImageStores(ctx context.Context) ([]*url.URL, error)
 	return nil, nil
 }
 
-func (c *MockDataStore) WriteImage(ctx context.Context, parent *spl.Image, ID string, meta map[string][]byte, r io.Reader) (*spl.Image, error) {
+func (c *MockDataStore) WriteImage(ctx context.Context, parent *spl.Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*spl.Image, error) {
+	if ID != spl.Scratch.ID && sum != testImageSum {
+		return nil, &spl.DigestMismatchError{ID: ID, Expected: sum, Actual: testImageSum}
+	}
+
 	i := spl.Image{
 		ID:       ID,
 		Store:    parent.Store,
@@ -96,6 +105,18 @@ func (c *MockDataStore) ListImages(ctx context.Context, store *url.URL, IDs []st
 	return nil, fmt.Errorf("store (%s) doesn't exist", store.String())
 }
 
+func (c *MockDataStore) GetImageTar(ctx context.Context, image *spl.Image) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(image.ID)), nil
+}
+
+func (c *MockDataStore) GetDiskTar(ctx context.Context, parent *spl.Image, disk string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(disk)), nil
+}
+
+func (c *MockDataStore) DeleteImage(ctx context.Context, image *spl.Image) error {
+	return fmt.Errorf("store (%s) doesn't have image %s", image.Store.String(), image.ID)
+}
+
 func TestCreateImageStore(t *testing.T) {
 	storageLayer = spl.NewLookupCache(&MockDataStore{})
 
@@ -356,4 +377,95 @@ func TestWriteImage(t *testing.T) {
 	if !assert.Equal(t, expected, result) {
 		return
 	}
+
+	// a layer that doesn't match its sum is rejected
+	params.ImageID = "corrupt"
+	params.Sum = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	result = s.WriteImage(*params)
+	assert.IsType(t, &storage.WriteImageUnprocessableEntity{}, result)
+}
+
+func TestGetImageTar(t *testing.T) {
+	storageLayer = spl.NewLookupCache(&MockDataStore{})
+
+	s := &StorageHandlersImpl{}
+
+	params := storage.GetImageTarParams{
+		StoreName: testStoreName,
+		ID:        testImageID,
+	}
+
+	// the store doesn't exist yet
+	result := s.GetImageTar(params)
+	assert.IsType(t, &storage.GetImageTarNotFound{}, result)
+
+	_, err := storageLayer.CreateImageStore(context.TODO(), testStoreName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := spl.Image{
+		ID:    "scratch",
+		Store: &testStoreURL,
+	}
+	_, err = storageLayer.WriteImage(context.TODO(), &parent, testImageID, nil, testImageSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result = s.GetImageTar(params)
+	rw := httptest.NewRecorder()
+	result.WriteResponse(rw, httpkit.ByteStreamProducer())
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, testImageID, rw.Body.String())
+}
+
+func TestDeleteImageInUse(t *testing.T) {
+	storageLayer = spl.NewLookupCache(&MockDataStore{})
+
+	s := &StorageHandlersImpl{}
+
+	_, err := storageLayer.CreateImageStore(context.TODO(), testStoreName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent := spl.Image{
+		ID:    "scratch",
+		Store: &testStoreURL,
+	}
+	_, err = storageLayer.WriteImage(context.TODO(), &parent, testImageID, nil, testImageSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// a container that has been created from the image but not yet committed
+	h := exec.NewContainer(exec.ParseID("deleteimagecon"))
+	h.Container.ExecConfig.ImageID = testImageID
+
+	params := storage.DeleteImageParams{
+		StoreName: testStoreName,
+		ID:        testImageID,
+	}
+
+	result := s.DeleteImage(params)
+	assert.IsType(t, &storage.DeleteImageLocked{}, result)
+}
+
+func TestCreateVolumeInvalidName(t *testing.T) {
+	s := &StorageHandlersImpl{}
+
+	params := storage.CreateVolumeParams{
+		VolumeRequest: &models.VolumeRequest{
+			Name:     "../outside",
+			Store:    "default",
+			Capacity: 1024,
+		},
+	}
+
+	// the name is rejected before the volume stores are consulted
+	result, ok := s.CreateVolume(params).(*storage.CreateVolumeDefault)
+	if assert.True(t, ok) {
+		assert.EqualValues(t, http.StatusBadRequest, *result.Payload.Code)
+	}
 }
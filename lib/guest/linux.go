@@ -30,9 +30,12 @@ const (
 	linuxGuestID = "other3xLinux64Guest"
 
 	scsiBusNumber = 0
-	scsiKey       = 100
 	ideKey        = 200
 
+	// SCSIKey is the key of the paravirtual SCSI controller that the
+	// container's disk and volumes are attached to
+	SCSIKey = 100
+
 	UUIDPath   = "/sys/class/dmi/id/product_serial"
 	UUIDPrefix = "VMware-"
 )
@@ -54,7 +57,7 @@ func NewLinuxGuest(ctx context.Context, session *session.Session, config *spec.V
 	}
 
 	// SCSI controller
-	scsi := spec.NewVirtualSCSIController(scsiBusNumber, scsiKey)
+	scsi := spec.NewVirtualSCSIController(scsiBusNumber, SCSIKey)
 	// PV SCSI controller
 	pv := spec.NewParaVirtualSCSIController(scsi)
 	s.AddParaVirtualSCSIController(pv)
@@ -102,6 +102,48 @@ type ExecutorConfig struct {
 	// Key is the host key used during communicate back with the Interaction endpoint if any
 	// Used if the in-guest tether is responsible for authenticating the connection
 	Key []byte `vic:"0.1" scope:"read-only" key:"key"`
+
+	// ImageID is the image the executor's filesystem was created from, and RepoName the
+	// reference used to request it
+	ImageID  string `vic:"0.1" scope:"hidden" key:"image"`
+	RepoName string `vic:"0.1" scope:"hidden" key:"repo"`
+
+	// Annotations are freeform key/value pairs, such as docker labels, recorded against the executor
+	Annotations map[string]string `vic:"0.1" scope:"hidden" key:"annotations"`
+
+	// Stopping is set while the executor is being stopped so that restart policies are not
+	// applied to the session exits that result
+	Stopping bool `vic:"0.1" scope:"read-only" key:"stopping"`
+}
+
+// Restart policy names, matching those used by docker
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyAlways        = "always"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// RestartPolicy describes when a session should be relaunched after its process exits
+type RestartPolicy struct {
+	// Name is one of the RestartPolicy constants, with empty meaning "no"
+	Name string `vic:"0.1" scope:"read-only" key:"name"`
+
+	// MaximumRetryCount limits the number of on-failure restarts, zero meaning no limit
+	MaximumRetryCount int `vic:"0.1" scope:"read-only" key:"max"`
+}
+
+// ShouldRestart reports whether a session with this policy that exited with the given status,
+// having already been restarted count times, should be relaunched
+func (p RestartPolicy) ShouldRestart(exitStatus int, count int) bool {
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		return exitStatus != 0 && (p.MaximumRetryCount == 0 || count < p.MaximumRetryCount)
+	default:
+		return false
+	}
 }
 
 // Cmd is here because the encoding packages seem to have issues with the full exec.Cmd struct
@@ -135,16 +177,32 @@ type SessionConfig struct {
 	// Allocate a tty or not
 	Tty bool `vic:"0.1" scope:"read-only" key:"tty"`
 
+	// User is the user, and optionally group, the process runs as in "user[:group]" form, where
+	// each may be a name or numeric ID. Names are resolved within the executor.
+	User string `vic:"0.1" scope:"read-only" key:"user"`
+
+	// Groups are the supplementary groups of the process, by name or ID
+	Groups []string `vic:"0.1" scope:"read-only" key:"groups"`
+
 	ExitStatus int `vic:"0.1" scope:"read-write" key:"status"`
 
 	Started string `vic:"0.1" scope:"read-write" key:"started"`
 
+	// StartTime and StopTime record the lifetime of the session process as unix timestamps
+	StartTime int64 `vic:"0.1" scope:"read-write" key:"starttime"`
+	StopTime  int64 `vic:"0.1" scope:"read-write" key:"stoptime"`
+
+	// Restart determines whether the session is relaunched when its process exits
+	Restart RestartPolicy `vic:"0.1" scope:"read-only" key:"restart"`
+
+	// RestartCount is the number of times the session has been relaunched by its restart policy
+	RestartCount int `vic:"0.1" scope:"read-write" key:"restarts"`
+
 	// Maps the intent to the signal for this specific app
 	// Signals map[int]int
 
 	// Use struct composition to add in the guest specific portions
 	// http://attilaolah.eu/2014/09/10/json-and-struct-composition-in-go/
 	// ulimits
-	// user
 	// rootfs - within the container context
 }
@@ -25,12 +25,20 @@ type NetworkEndpoint struct {
 	// Common.ID - pci slot of the vnic allowing for interface identifcation in-guest
 	Common
 
-	// IP address to assign - nil if DHCP
+	// IPv4 or IPv6 address to assign - nil if DHCP
 	Static *net.IPNet `vic:"0.1" scope:"read-only" key:"staticip"`
 
-	// Actual IP address assigned
+	// Actual IP address assigned, of the same family as the network
 	Assigned net.IP `vic:"0.1" scope:"read-write" key:"ip"`
 
+	// Ports published on the VCH for this endpoint, as [hostPort:]containerPort/protocol - a host
+	// port is allocated for those without one while the container is bound to the network
+	Ports []string `vic:"0.1" scope:"read-only" key:"ports"`
+
+	// Ports as they were requested, without the host ports allocated while bound, so that they
+	// can be restored when the container is unbound
+	RequestedPorts []string `vic:"0.1" scope:"read-only" key:"requested_ports"`
+
 	// The network in which this information should be interpreted. This is embedded directly rather than
 	// as a pointer so that we can ensure the data is consistent
 	Network ContainerNetwork `vic:"0.1" scope:"read-only" key:"network"`
@@ -43,7 +51,7 @@ type ContainerNetwork struct {
 	// Common.ID - identifier of the underlay for the network
 	Common
 
-	// The network scope the IP belongs to.
+	// The network scope the IP belongs to, either IPv4 or IPv6.
 	// The IP address is the default gateway
 	Gateway net.IPNet `vic:"0.1" scope:"read-only" key:"gateway"`
 	// Should this gateway be the default route for containers on the network
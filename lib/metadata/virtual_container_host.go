@@ -91,9 +91,13 @@ type VirtualContainerHostConfigSpec struct {
 
 	// Imagec
 	// Whitelist of registries
-	RegistryWhitelist []url.URL `vic:"0.1" scope:"read-only" recurse:"depth=0"`
+	RegistryWhitelist []url.URL `vic:"0.1" scope:"read-only" key:"registry_whitelist"`
 	// Blacklist of registries
-	RegistryBlacklist []url.URL `vic:"0.1" scope:"read-only" recurse:"depth=0"`
+	RegistryBlacklist []url.URL `vic:"0.1" scope:"read-only" key:"registry_blacklist"`
+	// Registries that are reached without verifying their certificate, or over plain http
+	InsecureRegistries []url.URL `vic:"0.1" scope:"read-only" key:"insecure_registries"`
+	// Certificates for talking to specific registries
+	RegistryCertificates []RegistryCertificate `vic:"0.1" scope:"read-only" key:"registry_certificates"`
 
 	// Allow custom naming convention for containerVMs
 	ContainerNameConvention string
@@ -106,6 +110,16 @@ type RawCertificate struct {
 	Cert []byte
 }
 
+// RegistryCertificate holds the certificates used when talking to a registry
+type RegistryCertificate struct {
+	// The registry, as host[:port]
+	Registry string
+	// The CAs to validate the registry with
+	CertificateAuthorities []byte
+	// The certificate presented to the registry if it asks for one
+	ClientCertificate RawCertificate
+}
+
 // CustomerExperienceImprovementProgram provides configuration for the phone home mechanism
 // This is broken out so that we can have more granular configuration in here in the future
 // and so that it is insulated from changes to Virtual Container Host structure
@@ -187,9 +201,13 @@ func (t *VirtualContainerHostConfigSpec) AddImageStore(url *url.URL) {
 	}
 }
 
-func (t *VirtualContainerHostConfigSpec) AddVolumeLocation(name string, url *url.URL) {
-	if url != nil {
-		t.VolumeLocations[name] = *url
+func (t *VirtualContainerHostConfigSpec) AddVolumeLocation(name string, u *url.URL) {
+	if u != nil {
+		if t.VolumeLocations == nil {
+			t.VolumeLocations = make(map[string]url.URL)
+		}
+
+		t.VolumeLocations[name] = *u
 	}
 }
 
@@ -64,6 +64,20 @@ func SSHls(client *ssh.Client) ([]string, error) {
 	return ids.IDs, nil
 }
 
+// SSHReload requests that the remote tether reload its configuration
+func SSHReload(client *ssh.Client) error {
+	ok, reply, err := client.SendRequest(ReloadReq, true, nil)
+	if err != nil {
+		return fmt.Errorf("failed to request reload from remote: %s", err)
+	}
+
+	if !ok {
+		return fmt.Errorf("remote refused reload request: %s", string(reply))
+	}
+
+	return nil
+}
+
 // SSHAttach returns a stream connection to the requested session
 // The ssh client is assumed to be connected to the Executor hosting the session
 func SSHAttach(client *ssh.Client, id string) (SessionInteraction, error) {
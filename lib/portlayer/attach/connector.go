@@ -35,8 +35,11 @@ import (
 type Connection struct {
 	spty SessionInteraction
 
-	// the container's ID
+	// the session's ID
 	id string
+
+	// the client for the executor hosting the session, used to open additional sessions
+	client *ssh.Client
 }
 
 type Connector struct {
@@ -117,6 +120,64 @@ func (c *Connector) Get(ctx context.Context, id string, timeout time.Duration) (
 	}
 }
 
+// Reload requests that the executor hosting the session with the specified ID reloads its
+// configuration. The session must have an established connection.
+func (c *Connector) Reload(ctx context.Context, id string) error {
+	c.mutex.RLock()
+	conn := c.connections[id]
+	c.mutex.RUnlock()
+	if conn == nil {
+		return fmt.Errorf("no such connection")
+	}
+
+	return SSHReload(conn.client)
+}
+
+// Attach opens a connection to the session sid in the executor hosting the session with the specified ID,
+// returning the connection when it is established or the timeout expires, whichever occurs first.
+// This is used for sessions, such as exec, that are added after the executor has connected.
+func (c *Connector) Attach(ctx context.Context, id string, sid string, timeout time.Duration) (SessionInteraction, error) {
+	c.mutex.RLock()
+	conn := c.connections[id]
+	c.mutex.RUnlock()
+	if conn == nil {
+		return nil, fmt.Errorf("no such connection")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the session may not be known to the executor until its configuration reload completes
+	// so we retry until accepted
+	for {
+		si, err := SSHAttach(conn.client, sid)
+		if err == nil {
+			log.Infof("Established connection with session %s in container VM %s", sid, id)
+
+			c.mutex.Lock()
+			c.connections[sid] = &Connection{
+				spty:   si,
+				id:     sid,
+				client: conn.client,
+			}
+			c.cond.Broadcast()
+			c.mutex.Unlock()
+
+			return si, nil
+		}
+
+		log.Debugf("Attach to session %s not yet possible: %s", sid, err)
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			err = fmt.Errorf("id:%s: %s", sid, ctx.Err())
+			log.Error(err)
+			return nil, err
+		}
+	}
+}
+
 func (c *Connector) Remove(id string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -195,20 +256,24 @@ func (c *Connector) processIncoming(conn net.Conn) {
 		return
 	}
 
-	var si SessionInteraction
+	attached := 0
 	for _, id := range ids {
-		si, err = SSHAttach(client, id)
-		if err != nil {
-			log.Errorf("SSH connection could not be established (id=%s): %s", id, errors.ErrorStack(err))
-			return
+		// sessions that cannot be attached, such as exited exec sessions, shouldn't
+		// prevent attach to the others
+		si, aerr := SSHAttach(client, id)
+		if aerr != nil {
+			log.Errorf("SSH connection could not be established (id=%s): %s", id, errors.ErrorStack(aerr))
+			continue
 		}
 
 		log.Infof("Established connection with container VM: %s", id)
+		attached++
 
 		c.mutex.Lock()
 		connection := &Connection{
-			spty: si,
-			id:   id,
+			spty:   si,
+			id:     id,
+			client: client,
 		}
 
 		c.connections[connection.id] = connection
@@ -217,6 +282,11 @@ func (c *Connector) processIncoming(conn net.Conn) {
 		c.mutex.Unlock()
 	}
 
+	if attached == 0 {
+		err = fmt.Errorf("unable to attach to any session in container VM")
+		log.Error(err)
+	}
+
 	return
 }
 
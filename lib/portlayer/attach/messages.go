@@ -14,7 +14,11 @@
 
 package attach
 
-import "golang.org/x/crypto/ssh"
+import (
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
 
 // All of the messages passed over the ssh channel/global mux are (or will be)
 // defined here.
@@ -53,6 +57,8 @@ func (wc *WindowChangeMsg) Unmarshal(payload []byte) error {
 }
 
 var (
+	// Signals maps the RFC4254 signal names, and the names of the other linux signals in the
+	// same style, to their signal numbers. Signals without an entry are sent by number.
 	Signals = map[ssh.Signal]int{
 		ssh.SIGABRT: 6,
 		ssh.SIGALRM: 14,
@@ -67,9 +73,42 @@ var (
 		ssh.SIGTERM: 15,
 		ssh.SIGUSR1: 10,
 		ssh.SIGUSR2: 12,
+		"TRAP":      5,
+		"BUS":       7,
+		"STKFLT":    16,
+		"CHLD":      17,
+		"CONT":      18,
+		"STOP":      19,
+		"TSTP":      20,
+		"TTIN":      21,
+		"TTOU":      22,
+		"URG":       23,
+		"XCPU":      24,
+		"XFSZ":      25,
+		"VTALRM":    26,
+		"PROF":      27,
+		"WINCH":     28,
+		"IO":        29,
+		"PWR":       30,
+		"SYS":       31,
 	}
 )
 
+// MaxSignal is the highest signal number that can be delivered, the last of the real-time signals
+const MaxSignal = 64
+
+// SignalForNumber returns the signal name for the given signal number, or the number itself
+// in string form if the signal has no name
+func SignalForNumber(signum int) ssh.Signal {
+	for sig, num := range Signals {
+		if num == signum {
+			return sig
+		}
+	}
+
+	return ssh.Signal(strconv.Itoa(signum))
+}
+
 // SignalMsg
 const SignalReq = "signal"
 
@@ -89,8 +128,18 @@ func (s *SignalMsg) Unmarshal(payload []byte) error {
 	return ssh.Unmarshal(payload, s)
 }
 
+// Signum returns the signal number for the message, or 0 if the signal is not recognized
 func (s *SignalMsg) Signum() int {
-	return Signals[s.Signal]
+	if num, ok := Signals[s.Signal]; ok {
+		return num
+	}
+
+	num, err := strconv.Atoi(string(s.Signal))
+	if err != nil || num < 1 || num > MaxSignal {
+		return 0
+	}
+
+	return num
 }
 
 // ContainersMsg
@@ -111,3 +160,7 @@ func (s *ContainersMsg) Marshal() []byte {
 func (s *ContainersMsg) Unmarshal(payload []byte) error {
 	return ssh.Unmarshal(payload, s)
 }
+
+// ReloadReq requests that the tether reload its configuration, picking up any
+// modifications, such as new sessions, made while it was running
+const ReloadReq = "reload"
@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestWindowChange(t *testing.T) {
@@ -44,6 +45,24 @@ func TestSignal(t *testing.T) {
 	assert.Equal(t, s, out)
 }
 
+func TestSignum(t *testing.T) {
+	for sig, num := range Signals {
+		s := &SignalMsg{sig}
+		assert.Equal(t, num, s.Signum())
+		assert.Equal(t, sig, SignalForNumber(num))
+	}
+
+	// signals without a name are carried by number
+	s := &SignalMsg{SignalForNumber(34)}
+	assert.Equal(t, "34", string(s.Signal))
+	assert.Equal(t, 34, s.Signum())
+
+	for _, bad := range []string{"BOGUS", "0", "65", "-1"} {
+		s = &SignalMsg{ssh.Signal(bad)}
+		assert.Equal(t, 0, s.Signum(), bad)
+	}
+}
+
 func TestContainers(t *testing.T) {
 	s := &ContainersMsg{IDs: []string{"foo", "bar", "baz"}}
 
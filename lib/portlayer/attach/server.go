@@ -84,3 +84,16 @@ func (n *Server) Addr() string {
 func (n *Server) Get(ctx context.Context, id string, timeout time.Duration) (SessionInteraction, error) {
 	return n.connServer.Get(ctx, id, timeout)
 }
+
+// Reload requests that the executor hosting the given session reloads its configuration.
+// id is ID of the container.
+func (n *Server) Reload(ctx context.Context, id string) error {
+	return n.connServer.Reload(ctx, id)
+}
+
+// Attach returns the session interface for the session sid in the given container, waiting up
+// to the given timeout for the session to become available.
+// id is ID of the container.
+func (n *Server) Attach(ctx context.Context, id string, sid string, timeout time.Duration) (SessionInteraction, error) {
+	return n.connServer.Attach(ctx, id, sid, timeout)
+}
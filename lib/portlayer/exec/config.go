@@ -16,10 +16,14 @@ package exec
 
 import (
 	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
 
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/types"
 	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/attach"
 )
 
 var Config Configuration
@@ -46,4 +50,14 @@ type Configuration struct {
 
 	// Allow custom naming convention for containerVMs
 	ContainerNameConvention string
+
+	// Interaction provides the attach channels used to signal container processes
+	Interaction Interaction
+}
+
+// Interaction is the subset of the attach server used by exec to deliver signals to the
+// sessions running in containerVMs
+type Interaction interface {
+	Get(ctx context.Context, id string, timeout time.Duration) (attach.SessionInteraction, error)
+	Attach(ctx context.Context, id string, sid string, timeout time.Duration) (attach.SessionInteraction, error)
 }
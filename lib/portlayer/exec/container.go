@@ -18,21 +18,31 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/attach"
 	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/extraconfig"
 	"github.com/vmware/vic/pkg/vsphere/session"
 	"github.com/vmware/vic/pkg/vsphere/tasks"
 	"github.com/vmware/vic/pkg/vsphere/vm"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/context"
 )
 
 var containers map[ID]*Container
 var containersLock sync.Mutex
 
+// ErrWaitTimeout is returned by Wait if the container is still running when the timeout expires
+var ErrWaitTimeout = errors.New("timed out waiting for container to stop")
+
 func init() {
 	containers = make(map[ID]*Container)
 }
@@ -44,6 +54,14 @@ const (
 	StateStopped
 
 	propertyCollectorTimeout = 3 * time.Minute
+
+	// defaultStopTimeout is the time allowed for the container process to exit after being
+	// signaled if the caller doesn't specify one
+	defaultStopTimeout = 10 * time.Second
+	// signalTimeout bounds the wait for an attach channel over which to deliver a signal
+	signalTimeout = 5 * time.Second
+	// haltTimeout is the time allowed for the guest to power itself off once the process exits
+	haltTimeout = 30 * time.Second
 )
 
 type Container struct {
@@ -55,6 +73,12 @@ type Container struct {
 	State      State
 
 	vm *vm.VirtualMachine
+
+	// cancelMonitor stops the power state monitoring of a running container
+	cancelMonitor context.CancelFunc
+
+	// freezeLock is held from FreezeDisk until the disk is thawed
+	freezeLock sync.Mutex
 }
 
 func NewContainer(id ID) *Handle {
@@ -82,6 +106,86 @@ func GetContainer(id ID) *Handle {
 	return nil
 }
 
+// Containers returns the set of known containers
+func Containers() []*Container {
+	containersLock.Lock()
+	defer containersLock.Unlock()
+
+	list := make([]*Container, 0, len(containers))
+	for _, con := range containers {
+		list = append(list, con)
+	}
+
+	return list
+}
+
+// Sync rebuilds the set of known containers from the containerVMs in the VCH resource pool.
+// This allows the port layer to be restarted without losing track of existing containers.
+func Sync(ctx context.Context, sess *session.Session) error {
+	defer trace.End(trace.Begin("Sync"))
+
+	if Config.ResourcePool == nil {
+		return fmt.Errorf("resource pool not configured")
+	}
+
+	var rp mo.ResourcePool
+	if err := Config.ResourcePool.Properties(ctx, Config.ResourcePool.Reference(), []string{"vm"}, &rp); err != nil {
+		log.Errorf("Unable to list VMs in resource pool: %s", err)
+		return err
+	}
+
+	if len(rp.Vm) == 0 {
+		return nil
+	}
+
+	var vms []mo.VirtualMachine
+	pc := property.DefaultCollector(sess.Vim25())
+	if err := pc.Retrieve(ctx, rp.Vm, []string{"config.extraConfig", "runtime.powerState"}, &vms); err != nil {
+		log.Errorf("Unable to retrieve VM configuration from resource pool: %s", err)
+		return err
+	}
+
+	for _, v := range vms {
+		if v.Config == nil {
+			continue
+		}
+
+		ec := &metadata.ExecutorConfig{}
+		extraconfig.Decode(extraconfig.OptionValueSource(v.Config.ExtraConfig), ec)
+
+		// only containerVMs have a primary session - this skips the appliance and any
+		// unrelated VMs that share the pool
+		if _, ok := ec.Sessions[ec.ID]; ec.ID == "" || !ok {
+			log.Debugf("Skipping VM %s as it is not a containerVM", v.Reference())
+			continue
+		}
+
+		state := State(StateStopped)
+		if v.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+			state = StateRunning
+		}
+
+		con := &Container{
+			ID:         ParseID(ec.ID),
+			ExecConfig: ec,
+			State:      state,
+			vm:         vm.NewVirtualMachine(ctx, sess, v.Reference()),
+		}
+
+		log.Infof("Discovered container %s (state: %d)", con.ID, con.State)
+
+		if con.State == StateRunning {
+			con.startMonitor(sess)
+		}
+
+		containersLock.Lock()
+		containers[con.ID] = con
+		containersLock.Unlock()
+	}
+
+	return nil
+}
+
 func (c *Container) newHandle() *Handle {
 	return newHandle(c)
 }
@@ -93,7 +197,7 @@ func (c *Container) cacheExecConfig(ec *metadata.ExecutorConfig) {
 	c.ExecConfig = ec
 }
 
-func (c *Container) Commit(ctx context.Context, sess *session.Session, h *Handle) error {
+func (c *Container) Commit(ctx context.Context, sess *session.Session, h *Handle, waitTime *int32) error {
 	defer trace.End(trace.Begin("Committing handle"))
 
 	c.Lock()
@@ -151,10 +255,11 @@ func (c *Container) Commit(ctx context.Context, sess *session.Session, h *Handle
 			if err := h.Container.start(ctx); err != nil {
 				return err
 			}
+			c.startMonitor(sess)
 
 		case StateStopped:
 			// stop the container
-			if err := h.Container.stop(ctx); err != nil {
+			if err := h.Container.stop(ctx, waitTime); err != nil {
 				return err
 			}
 		}
@@ -201,23 +306,457 @@ func (c *Container) start(ctx context.Context) error {
 	return nil
 }
 
-func (c *Container) stop(ctx context.Context) error {
+func (c *Container) stop(ctx context.Context, waitTime *int32) error {
 	defer trace.End(trace.Begin("Container.stop"))
 
 	if c.vm == nil {
 		return fmt.Errorf("vm not set")
 	}
 
-	//TODO: make the shutdown much cleaner, right now we just pull the plug on the vm.(may need corresponding work in tether.)
+	// this power off is intended so must not trigger the restart policy
+	c.stopMonitor()
 
-	// Power off
-	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
-		return c.vm.PowerOff(ctx)
-	})
+	wait := defaultStopTimeout
+	if waitTime != nil && *waitTime >= 0 {
+		wait = time.Duration(*waitTime) * time.Second
+	}
+
+	// give the process the chance to exit cleanly before we pull the plug
+	if err := c.shutdown(ctx, wait); err != nil {
+		log.Warnf("Unable to stop container %s gracefully, powering off: %s", c.ID, err)
+	} else {
+		// the tether halts the guest once the primary process has exited
+		hctx, cancel := context.WithTimeout(ctx, haltTimeout)
+		err = c.vm.WaitForPowerState(hctx, types.VirtualMachinePowerStatePoweredOff)
+		cancel()
+		if err != nil {
+			log.Warnf("Container %s did not halt after the process exited, powering off: %s", c.ID, err)
+		}
+	}
+
+	state, err := c.vm.PowerState(ctx)
 	if err != nil {
 		return err
 	}
 
+	if state != types.VirtualMachinePowerStatePoweredOff {
+		// Power off
+		_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return c.vm.PowerOff(ctx)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// pick up the final state published by the guest
+	config, err := c.Refresh(ctx)
+	if err != nil {
+		log.Warnf("Unable to refresh configuration of stopped container %s: %s", c.ID, err)
+		return nil
+	}
+	c.ExecConfig = config
+
+	return nil
+}
+
+// shutdown sends SIGTERM to the primary process of the container, escalating to SIGKILL if it
+// has not exited within the wait period. It returns nil once the guest has reported the exit.
+func (c *Container) shutdown(ctx context.Context, wait time.Duration) error {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	for _, sig := range []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL} {
+		log.Infof("Sending signal %s to container %s", sig, c.ID)
+		if err := c.signal(ctx, c.ID.String(), sig); err != nil {
+			return err
+		}
+
+		if err := c.waitForExit(ctx, wait); err == nil {
+			return nil
+		}
+
+		log.Warnf("Container %s did not exit within %s of signal %s", c.ID, wait, sig)
+	}
+
+	return fmt.Errorf("process did not exit")
+}
+
+// Signal delivers the signal with the given number to the process of the session sid, or to the
+// primary process of the container if sid is empty
+func (c *Container) Signal(ctx context.Context, sid string, signum int64) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("%s:%s", c.ID, sid)))
+
+	if signum < 1 || signum > attach.MaxSignal {
+		return fmt.Errorf("invalid signal: %d", signum)
+	}
+
+	if sid == "" {
+		sid = c.ID.String()
+	}
+
+	c.Lock()
+	_, known := c.ExecConfig.Sessions[sid]
+	state := c.State
+	c.Unlock()
+
+	if !known {
+		return fmt.Errorf("no such session: %s", sid)
+	}
+
+	if state != StateRunning {
+		return fmt.Errorf("container %s is not running", c.ID)
+	}
+
+	return c.signal(ctx, sid, attach.SignalForNumber(int(signum)))
+}
+
+// signal delivers the signal to the process of session sid via its attach channel
+func (c *Container) signal(ctx context.Context, sid string, sig ssh.Signal) error {
+	if Config.Interaction == nil {
+		return fmt.Errorf("no interaction channel configured")
+	}
+
+	// reuse the connection to the session if there is one
+	interaction, err := Config.Interaction.Get(ctx, sid, 0)
+	if err != nil {
+		if sid == c.ID.String() {
+			// the primary session connects as soon as the container starts
+			interaction, err = Config.Interaction.Get(ctx, sid, signalTimeout)
+		} else {
+			interaction, err = Config.Interaction.Attach(ctx, c.ID.String(), sid, signalTimeout)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return interaction.Signal(sig)
+}
+
+// waitForExit waits for the guest to publish the stop time of the primary session
+func (c *Container) waitForExit(ctx context.Context, wait time.Duration) error {
+	// guestinfo key that we want to wait for
+	key := fmt.Sprintf("guestinfo..sessions|%s.stoptime", c.ID)
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	waitFunc := func(pc []types.PropertyChange) bool {
+		for _, c := range pc {
+			if c.Op != types.PropertyChangeOpAssign {
+				continue
+			}
+
+			values := c.Val.(types.ArrayOfOptionValue).OptionValue
+			for _, value := range values {
+				if key == value.GetOptionValue().Key {
+					// the stop time is zeroed when the session is launched
+					detail, _ := value.GetOptionValue().Value.(string)
+					return detail != "" && detail != "<nil>" && detail != "0"
+				}
+			}
+		}
+		return false
+	}
+
+	return c.vm.WaitForExtraConfig(ctx, waitFunc)
+}
+
+// Wait blocks until the primary session of the container exits or the containerVM powers off and
+// returns the exit status of the session. A negative timeout waits indefinitely.
+func (c *Container) Wait(ctx context.Context, sess *session.Session, timeout time.Duration) (int, error) {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	id := c.ID.String()
+
+	c.Lock()
+	running := c.vm != nil && c.State == StateRunning
+	status := c.ExecConfig.Sessions[id].ExitStatus
+	c.Unlock()
+
+	if !running {
+		return status, nil
+	}
+
+	var cancel context.CancelFunc
+	if timeout >= 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// guestinfo keys that we want to wait for
+	stopKey := fmt.Sprintf("guestinfo..sessions|%s.stoptime", id)
+	statusKey := fmt.Sprintf("guestinfo..sessions|%s.status", id)
+
+	// a VM that powers off without the session publishing its exit is treated as a failure
+	status = -1
+	exited := false
+
+	waitFunc := func(pc []types.PropertyChange) bool {
+		// the exit status and the power off can arrive in the same changeset, so read the whole
+		// set before deciding that the power off is final
+		poweredOff := false
+		for _, change := range pc {
+			switch val := change.Val.(type) {
+			case types.VirtualMachinePowerState:
+				poweredOff = val == types.VirtualMachinePowerStatePoweredOff
+			case types.ArrayOfOptionValue:
+				for _, value := range val.OptionValue {
+					option := value.GetOptionValue()
+					detail, _ := option.Value.(string)
+
+					switch option.Key {
+					case stopKey:
+						// the stop time is zeroed when the session is launched
+						exited = detail != "" && detail != "<nil>" && detail != "0"
+					case statusKey:
+						if s, err := strconv.Atoi(detail); err == nil {
+							status = s
+						}
+					}
+				}
+			}
+		}
+		return exited || poweredOff
+	}
+
+	pc := property.DefaultCollector(sess.Vim25())
+	err := property.Wait(ctx, pc, c.vm.Reference(), []string{"config.extraConfig", "runtime.powerState"}, waitFunc)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return 0, ErrWaitTimeout
+		}
+		return 0, err
+	}
+
+	if !exited {
+		log.Infof("Container %s powered off without publishing an exit status", c.ID)
+		return -1, nil
+	}
+
+	return status, nil
+}
+
+// startMonitor watches the power state of the running containerVM so that the restart policy
+// of the primary session can be applied if the VM powers off without the port layer stopping it,
+// such as after a guest crash. The caller must hold the container lock.
+func (c *Container) startMonitor(sess *session.Session) {
+	c.stopMonitor()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancelMonitor = cancel
+
+	go func() {
+		pc := property.DefaultCollector(sess.Vim25())
+		err := property.Wait(ctx, pc, c.vm.Reference(), []string{"runtime.powerState"}, func(pc []types.PropertyChange) bool {
+			for _, change := range pc {
+				if state, ok := change.Val.(types.VirtualMachinePowerState); ok && state == types.VirtualMachinePowerStatePoweredOff {
+					return true
+				}
+			}
+			return false
+		})
+
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("Unable to monitor power state of container %s: %s", c.ID, err)
+			}
+			return
+		}
+
+		c.poweredOff(ctx, sess)
+	}()
+}
+
+// stopMonitor ends power state monitoring of the container. The caller must hold the container lock.
+func (c *Container) stopMonitor() {
+	if c.cancelMonitor != nil {
+		c.cancelMonitor()
+		c.cancelMonitor = nil
+	}
+}
+
+// poweredOff handles the unexpected power off of a running containerVM, restarting it if the
+// restart policy of the primary session requires it
+func (c *Container) poweredOff(ctx context.Context, sess *session.Session) {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	c.Lock()
+	if ctx.Err() != nil {
+		// the port layer stopped the container while we were waiting for the lock
+		c.Unlock()
+		return
+	}
+	c.stopMonitor()
+	c.State = StateStopped
+	c.Unlock()
+
+	// pick up the final state published by the guest
+	config, err := c.Refresh(context.Background())
+	if err != nil {
+		log.Errorf("Unable to refresh configuration of container %s after power off: %s", c.ID, err)
+		return
+	}
+	c.cacheExecConfig(config)
+
+	primary, ok := config.Sessions[c.ID.String()]
+	if !ok || config.Stopping {
+		return
+	}
+
+	// a session without a stop time didn't exit cleanly, so the power off counts as a failure
+	status := primary.ExitStatus
+	if primary.StopTime == 0 {
+		status = -1
+	}
+
+	if !primary.Restart.ShouldRestart(status, primary.RestartCount) {
+		log.Infof("Container %s powered off (exit status %d)", c.ID, status)
+		return
+	}
+
+	log.Infof("Restarting container %s after unexpected power off (restart count %d)", c.ID, primary.RestartCount+1)
+
+	h := c.newHandle()
+	h.ExecConfig = *config
+	h.restart = true
+	h.SetState(StateRunning)
+	if err := h.Commit(context.Background(), sess, nil); err != nil {
+		log.Errorf("Unable to restart container %s: %s", c.ID, err)
+	}
+}
+
+// Refresh returns the executor configuration as currently published by the containerVM,
+// including the state updates made by the guest
+func (c *Container) Refresh(ctx context.Context) (*metadata.ExecutorConfig, error) {
+	defer trace.End(trace.Begin("Container.Refresh"))
+
+	if c.vm == nil {
+		return nil, fmt.Errorf("vm not set")
+	}
+
+	ec, err := c.vm.FetchExtraConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &metadata.ExecutorConfig{}
+	extraconfig.Decode(extraconfig.MapSource(ec), config)
+
+	return config, nil
+}
+
+// Update refreshes the cached configuration and state of the container from the containerVM,
+// picking up changes made outside of the port layer such as the exit of the primary process
+func (c *Container) Update(ctx context.Context) error {
+	defer trace.End(trace.Begin("Container.Update"))
+
+	if c.vm == nil {
+		return fmt.Errorf("vm not set")
+	}
+
+	var mvm mo.VirtualMachine
+	if err := c.vm.Properties(ctx, c.vm.Reference(), updateProperties, &mvm); err != nil {
+		return err
+	}
+
+	return c.update(mvm)
+}
+
+// UpdateContainers refreshes the given containers as Update does, fetching the properties of all
+// of their containerVMs in a single call
+func UpdateContainers(ctx context.Context, sess *session.Session, cons []*Container) {
+	defer trace.End(trace.Begin("UpdateContainers"))
+
+	byRef := make(map[types.ManagedObjectReference]*Container)
+	var refs []types.ManagedObjectReference
+	for _, c := range cons {
+		if c.vm == nil {
+			continue
+		}
+
+		ref := c.vm.Reference()
+		byRef[ref] = c
+		refs = append(refs, ref)
+	}
+
+	if len(refs) == 0 {
+		return
+	}
+
+	var vms []mo.VirtualMachine
+	pc := property.DefaultCollector(sess.Vim25())
+	if err := pc.Retrieve(ctx, refs, updateProperties, &vms); err != nil {
+		// a VM removed since the list was taken fails the whole retrieve, so fall back to
+		// updating the containers one at a time
+		log.Debugf("Unable to retrieve container VM properties, updating individually: %s", err)
+		for _, c := range byRef {
+			if err = c.Update(ctx); err != nil {
+				log.Debugf("Unable to update state for container %s: %s", c.ID, err)
+			}
+		}
+		return
+	}
+
+	for _, mvm := range vms {
+		if c, ok := byRef[mvm.Reference()]; ok {
+			if err := c.update(mvm); err != nil {
+				log.Debugf("Unable to update state for container %s: %s", c.ID, err)
+			}
+		}
+	}
+}
+
+// updateProperties are the containerVM properties that the container is refreshed from
+var updateProperties = []string{"config.extraConfig", "runtime.powerState"}
+
+func (c *Container) update(mvm mo.VirtualMachine) error {
+	if mvm.Config == nil {
+		return fmt.Errorf("no config available for container %s", c.ID)
+	}
+
+	config := &metadata.ExecutorConfig{}
+	extraconfig.Decode(extraconfig.OptionValueSource(mvm.Config.ExtraConfig), config)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.ExecConfig = config
+	if mvm.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		c.State = StateRunning
+	} else {
+		c.State = StateStopped
+	}
+
+	return nil
+}
+
+// WaitForSession waits for the guest to report the launch status of the session with the given ID
+func (c *Container) WaitForSession(ctx context.Context, id string) error {
+	defer trace.End(trace.Begin(id))
+
+	if c.vm == nil {
+		return fmt.Errorf("vm not set")
+	}
+
+	// guestinfo key that we want to wait for
+	key := fmt.Sprintf("guestinfo..sessions|%s.started", id)
+
+	ctx, cancel := context.WithTimeout(ctx, propertyCollectorTimeout)
+	defer cancel()
+
+	detail, err := c.vm.WaitForKeyInExtraConfig(ctx, key)
+	if err != nil {
+		return fmt.Errorf("unable to wait for process launch status: %s", err.Error())
+	}
+
+	if detail != "true" {
+		return errors.New(detail)
+	}
+
 	return nil
 }
 
@@ -229,6 +768,13 @@ func (c *Container) Remove(ctx context.Context) error {
 		return fmt.Errorf("VM has already been removed")
 	}
 
+	c.stopMonitor()
+
+	// volumes outlive the containers they're mounted in
+	if err := c.detachVolumes(ctx); err != nil {
+		return err
+	}
+
 	//removes the vm from vsphere
 	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
 		return c.vm.Destroy(ctx)
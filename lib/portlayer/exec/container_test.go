@@ -0,0 +1,471 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/context"
+
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/attach"
+	"github.com/vmware/vic/pkg/vsphere/extraconfig"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/simulator"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"github.com/vmware/vic/pkg/vsphere/vm"
+)
+
+func TestUpdateContainers(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.ESX()
+	if !assert.NoError(t, model.Create()) {
+		return
+	}
+	defer model.Remove()
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	config := &session.Config{
+		Service:  "http://user:pass@" + server.URL.Host + server.URL.Path,
+		Insecure: true,
+	}
+
+	sess, err := session.NewSession(config).Connect(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	finder := find.NewFinder(sess.Vim25(), false)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	finder.SetDatacenter(dc)
+
+	folders, err := dc.Folders(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pool, err := finder.DefaultResourcePool(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// powered off containerVMs whose guests have published a new name
+	var cons []*Container
+	for _, id := range []string{"first", "second"} {
+		cfg := make(map[string]string)
+		extraconfig.Encode(extraconfig.MapSink(cfg), &metadata.ExecutorConfig{
+			Common: metadata.Common{ID: id, Name: id},
+		})
+
+		spec := types.VirtualMachineConfigSpec{
+			Name:    id,
+			GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+			Files: &types.VirtualMachineFileInfo{
+				VmPathName: "[LocalDS_0] " + id,
+			},
+			ExtraConfig: extraconfig.OptionValueFromMap(cfg),
+		}
+
+		info, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return folders.VmFolder.CreateVM(ctx, spec, pool, nil)
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		cons = append(cons, &Container{
+			ID:         ParseID(id),
+			ExecConfig: &metadata.ExecutorConfig{Common: metadata.Common{Name: "stale"}},
+			State:      StateRunning,
+			vm:         vm.NewVirtualMachine(ctx, sess, info.Result.(types.ManagedObjectReference)),
+		})
+	}
+
+	// a container without a VM is left alone
+	detached := &Container{
+		ExecConfig: &metadata.ExecutorConfig{Common: metadata.Common{Name: "stale"}},
+		State:      StateRunning,
+	}
+
+	UpdateContainers(ctx, sess, append(cons, detached))
+
+	for _, c := range cons {
+		assert.Equal(t, State(StateStopped), c.State)
+		assert.Equal(t, c.ID.String(), c.ExecConfig.Name)
+	}
+	assert.Equal(t, State(StateRunning), detached.State)
+	assert.Equal(t, "stale", detached.ExecConfig.Name)
+
+	// a VM that has gone away doesn't stop the others being updated
+	cons[0].State = StateRunning
+	cons[0].ExecConfig.Name = "stale"
+	gone := &Container{
+		ExecConfig: &metadata.ExecutorConfig{},
+		State:      StateRunning,
+		vm: vm.NewVirtualMachine(ctx, sess, types.ManagedObjectReference{
+			Type:  "VirtualMachine",
+			Value: "vm-gone",
+		}),
+	}
+
+	UpdateContainers(ctx, sess, []*Container{gone, cons[0]})
+	assert.Equal(t, State(StateStopped), cons[0].State)
+	assert.Equal(t, "first", cons[0].ExecConfig.Name)
+	assert.Equal(t, State(StateRunning), gone.State)
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+
+	c, cleanup := testContainer(ctx, t)
+	defer cleanup()
+
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.PowerOn(ctx)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// publish the session exit the way the tether does
+	exit := func(status int) {
+		spec := types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{
+				&types.OptionValue{Key: "guestinfo..sessions|container.stoptime", Value: fmt.Sprint(time.Now().Unix())},
+				&types.OptionValue{Key: "guestinfo..sessions|container.status", Value: fmt.Sprint(status)},
+			},
+		}
+
+		_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return c.vm.Reconfigure(ctx, spec)
+		})
+		assert.NoError(t, err)
+	}
+
+	// the session is still running
+	_, err = c.Wait(ctx, c.vm.Session, 300*time.Millisecond)
+	assert.Equal(t, ErrWaitTimeout, err)
+
+	// the session exits while we wait
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		exit(3)
+	}()
+
+	status, err := c.Wait(ctx, c.vm.Session, 10*time.Second)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, status)
+	}
+
+	// a status published in the same update as the power off is not lost
+	exit(7)
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.PowerOff(ctx)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	status, err = c.Wait(ctx, c.vm.Session, 10*time.Second)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 7, status)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+
+	model := simulator.ESX()
+	if !assert.NoError(t, model.Create()) {
+		return
+	}
+	defer model.Remove()
+
+	server := model.Service.NewServer()
+	defer server.Close()
+
+	config := &session.Config{
+		Service:  "http://user:pass@" + server.URL.Host + server.URL.Path,
+		Insecure: true,
+	}
+
+	sess, err := session.NewSession(config).Connect(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	finder := find.NewFinder(sess.Vim25(), false)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	finder.SetDatacenter(dc)
+
+	folders, err := dc.Folders(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pool, err := finder.DefaultResourcePool(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// a running and a stopped containerVM, and a VM that isn't a container at all
+	create := func(id string, ec *metadata.ExecutorConfig, on bool) {
+		cfg := make(map[string]string)
+		extraconfig.Encode(extraconfig.MapSink(cfg), ec)
+
+		spec := types.VirtualMachineConfigSpec{
+			Name:    id,
+			GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+			Files: &types.VirtualMachineFileInfo{
+				VmPathName: "[LocalDS_0] " + id,
+			},
+			ExtraConfig: extraconfig.OptionValueFromMap(cfg),
+		}
+
+		info, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return folders.VmFolder.CreateVM(ctx, spec, pool, nil)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if on {
+			v := vm.NewVirtualMachine(ctx, sess, info.Result.(types.ManagedObjectReference))
+			if _, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+				return v.PowerOn(ctx)
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	container := func(id string) *metadata.ExecutorConfig {
+		return &metadata.ExecutorConfig{
+			Common:   metadata.Common{ID: id, Name: id},
+			Sessions: map[string]metadata.SessionConfig{id: {Common: metadata.Common{ID: id}}},
+		}
+	}
+
+	create("running", container("running"), true)
+	create("stopped", container("stopped"), false)
+	create("other", &metadata.ExecutorConfig{Common: metadata.Common{Name: "other"}}, false)
+
+	containersLock.Lock()
+	containers = make(map[ID]*Container)
+	containersLock.Unlock()
+
+	// the resource pool must be known to find the containers
+	Config.ResourcePool = nil
+	assert.Error(t, Sync(ctx, sess))
+
+	Config.ResourcePool = pool
+	if !assert.NoError(t, Sync(ctx, sess)) {
+		return
+	}
+	defer func() {
+		containersLock.Lock()
+		containers = make(map[ID]*Container)
+		containersLock.Unlock()
+	}()
+
+	assert.Len(t, Containers(), 2)
+	assert.Nil(t, GetContainer(ParseID("other")))
+
+	stopped := GetContainer(ParseID("stopped"))
+	if assert.NotNil(t, stopped) {
+		assert.Equal(t, State(StateStopped), stopped.Container.State)
+		assert.Equal(t, "stopped", stopped.Container.ExecConfig.Name)
+	}
+
+	running := GetContainer(ParseID("running"))
+	if !assert.NotNil(t, running) {
+		return
+	}
+	c := running.Container
+	assert.Equal(t, State(StateRunning), c.State)
+	assert.Equal(t, "running", c.ExecConfig.Name)
+
+	// the rediscovered container is monitored for the guest powering off
+	c.Lock()
+	assert.NotNil(t, c.cancelMonitor)
+	c.stopMonitor()
+	c.Unlock()
+}
+
+// mockInteraction records the signals sent to the sessions of a container and lets the test
+// decide how the process reacts to them
+type mockInteraction struct {
+	sync.Mutex
+
+	signals  []ssh.Signal
+	onSignal func(sig ssh.Signal)
+}
+
+func (m *mockInteraction) Get(ctx context.Context, id string, timeout time.Duration) (attach.SessionInteraction, error) {
+	return &mockSession{m}, nil
+}
+
+func (m *mockInteraction) Attach(ctx context.Context, id string, sid string, timeout time.Duration) (attach.SessionInteraction, error) {
+	return &mockSession{m}, nil
+}
+
+func (m *mockInteraction) sent() []ssh.Signal {
+	m.Lock()
+	defer m.Unlock()
+
+	return append([]ssh.Signal(nil), m.signals...)
+}
+
+type mockSession struct {
+	m *mockInteraction
+}
+
+func (s *mockSession) Signal(sig ssh.Signal) error {
+	s.m.Lock()
+	s.m.signals = append(s.m.signals, sig)
+	s.m.Unlock()
+
+	if s.m.onSignal != nil {
+		s.m.onSignal(sig)
+	}
+	return nil
+}
+
+func (s *mockSession) Stdout() io.Reader                                 { return nil }
+func (s *mockSession) Stderr() io.Reader                                 { return nil }
+func (s *mockSession) Stdin() io.WriteCloser                             { return nil }
+func (s *mockSession) Close() error                                      { return nil }
+func (s *mockSession) Resize(cols, rows, widthpx, heightpx uint32) error { return nil }
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+
+	c, cleanup := testContainer(ctx, t)
+	defer cleanup()
+
+	defer func(i Interaction) { Config.Interaction = i }(Config.Interaction)
+
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.PowerOn(ctx)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// publish the session exit the way the tether does
+	exit := func() {
+		spec := types.VirtualMachineConfigSpec{
+			ExtraConfig: []types.BaseOptionValue{
+				&types.OptionValue{Key: "guestinfo..sessions|container.stoptime", Value: fmt.Sprint(time.Now().Unix())},
+			},
+		}
+
+		_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return c.vm.Reconfigure(ctx, spec)
+		})
+		assert.NoError(t, err)
+	}
+
+	// without an interaction channel the process can't be signaled
+	Config.Interaction = nil
+	assert.Error(t, c.shutdown(ctx, 100*time.Millisecond))
+
+	// a process that ignores both signals
+	mock := &mockInteraction{}
+	Config.Interaction = mock
+	assert.Error(t, c.shutdown(ctx, 200*time.Millisecond))
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL}, mock.sent())
+
+	// a process that only exits once it's killed
+	mock = &mockInteraction{
+		onSignal: func(sig ssh.Signal) {
+			if sig == ssh.SIGKILL {
+				exit()
+			}
+		},
+	}
+	Config.Interaction = mock
+	assert.NoError(t, c.shutdown(ctx, 200*time.Millisecond))
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL}, mock.sent())
+
+	// the exit is already published, so the process isn't killed
+	mock = &mockInteraction{}
+	Config.Interaction = mock
+	assert.NoError(t, c.shutdown(ctx, 10*time.Second))
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM}, mock.sent())
+}
+
+func TestStopPowersOff(t *testing.T) {
+	ctx := context.Background()
+
+	c, cleanup := testContainer(ctx, t)
+	defer cleanup()
+
+	defer func(i Interaction) { Config.Interaction = i }(Config.Interaction)
+
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.PowerOn(ctx)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the session has been launched and not yet exited
+	spec := types.VirtualMachineConfigSpec{
+		ExtraConfig: []types.BaseOptionValue{
+			&types.OptionValue{Key: "guestinfo..sessions|container.stoptime", Value: "0"},
+		},
+	}
+	_, err = tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.Reconfigure(ctx, spec)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the process ignores its signals, so the containerVM is powered off once the wait expires
+	mock := &mockInteraction{}
+	Config.Interaction = mock
+
+	wait := int32(0)
+	start := time.Now()
+	if !assert.NoError(t, c.stop(ctx, &wait)) {
+		return
+	}
+	assert.True(t, time.Since(start) < defaultStopTimeout, "the requested wait was not used")
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM, ssh.SIGKILL}, mock.sent())
+
+	state, err := c.vm.PowerState(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, types.VirtualMachinePowerStatePoweredOff, state)
+	}
+}
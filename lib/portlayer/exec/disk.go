@@ -0,0 +1,149 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"fmt"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"golang.org/x/net/context"
+)
+
+// snapshotName is the name of the snapshot taken while the disk of a running container is read
+const snapshotName = "vic-freeze"
+
+// DiskPath returns the datastore path of the disk holding the container's filesystem,
+// a child of the disk of the image the container was created from.
+func (c *Container) DiskPath(ctx context.Context) (string, error) {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	if c.vm == nil {
+		return "", fmt.Errorf("vm not set")
+	}
+
+	devices, err := c.vm.Device(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	image := c.ExecConfig.ImageID + ".vmdk"
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		backing, ok := device.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+		if !ok || isIndependent(backing) {
+			// volumes are independent disks
+			continue
+		}
+
+		// a snapshot puts a delta disk in front of the container's disk, so look down the
+		// chain for the disk created as the child of the image
+		for ; backing.Parent != nil; backing = backing.Parent {
+			if path.Base(backing.Parent.FileName) == image {
+				return backing.FileName, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("container %s has no disk", c.ID)
+}
+
+// FreezeDisk returns the datastore path of the container's disk in a state that can be read from
+// another VM. A running container is snapshotted so that it carries on writing to a delta disk
+// while the returned disk, as it was when the snapshot was taken, is read. The filesystem is
+// captured as it would be after a crash. The returned func removes the snapshot and must be called
+// once the disk has been read; until then other calls for the same container wait.
+func (c *Container) FreezeDisk(ctx context.Context) (string, func(), error) {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	c.freezeLock.Lock()
+
+	// the disk has to be looked up before the snapshot swaps in the delta
+	disk, err := c.DiskPath(ctx)
+	if err != nil {
+		c.freezeLock.Unlock()
+		return "", nil, err
+	}
+
+	c.Lock()
+	running := c.State == StateRunning
+	c.Unlock()
+
+	if !running {
+		return disk, c.freezeLock.Unlock, nil
+	}
+
+	info, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.CreateSnapshot(ctx, snapshotName, "", false, false)
+	})
+	if err != nil {
+		c.freezeLock.Unlock()
+		return "", nil, err
+	}
+
+	// only our snapshot is removed, any others taken of the container are left alone
+	snapshot, ok := info.Result.(types.ManagedObjectReference)
+	if !ok {
+		c.freezeLock.Unlock()
+		return "", nil, fmt.Errorf("unexpected result %#v creating snapshot of %s", info.Result, c.ID)
+	}
+
+	thaw := func() {
+		defer c.freezeLock.Unlock()
+
+		consolidate := true
+		_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+			return c.vm.RemoveSnapshot(ctx, snapshot, false, &consolidate)
+		})
+		if err != nil {
+			log.Errorf("Failed to remove snapshot %s of %s: %s", snapshot.Value, c.ID, err)
+		}
+	}
+
+	return disk, thaw, nil
+}
+
+func isIndependent(backing *types.VirtualDiskFlatVer2BackingInfo) bool {
+	return backing.DiskMode == string(types.VirtualDiskModeIndependent_persistent) ||
+		backing.DiskMode == string(types.VirtualDiskModeIndependent_nonpersistent)
+}
+
+// detachVolumes removes the container's independent disks, which hold its volumes, from the VM
+// so that they aren't deleted along with it
+func (c *Container) detachVolumes(ctx context.Context) error {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	devices, err := c.vm.Device(ctx)
+	if err != nil {
+		return err
+	}
+
+	var volumes []types.BaseVirtualDevice
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		backing, ok := device.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+		if ok && backing.DiskMode == string(types.VirtualDiskModeIndependent_persistent) {
+			log.Debugf("Detaching volume %s from %s", backing.FileName, c.ID)
+			volumes = append(volumes, device)
+		}
+	}
+
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	return c.vm.RemoveDevice(ctx, true, volumes...)
+}
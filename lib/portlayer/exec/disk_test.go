@@ -0,0 +1,222 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/net/context"
+
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"github.com/vmware/vic/pkg/vsphere/simulator"
+	"github.com/vmware/vic/pkg/vsphere/tasks"
+	"github.com/vmware/vic/pkg/vsphere/vm"
+)
+
+const (
+	testImageDisk  = "[LocalDS_0] VIC/store/images/image/image.vmdk"
+	testDisk       = "[LocalDS_0] container/container.vmdk"
+	testVolumeDisk = "[LocalDS_0] VIC/volumes/volume/volume.vmdk"
+)
+
+// testContainer returns a running container backed by a simulated VM with a volume attached
+// ahead of the disk created from its image
+func testContainer(ctx context.Context, t *testing.T) (*Container, func()) {
+	model := simulator.ESX()
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := model.Service.NewServer()
+
+	cleanup := func() {
+		server.Close()
+		model.Remove()
+	}
+
+	config := &session.Config{
+		Service:  "http://user:pass@" + server.URL.Host + server.URL.Path,
+		Insecure: true,
+	}
+
+	sess, err := session.NewSession(config).Connect(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	finder := find.NewFinder(sess.Vim25(), false)
+	dc, err := finder.DefaultDatacenter(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	finder.SetDatacenter(dc)
+
+	folders, err := dc.Folders(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	pool, err := finder.DefaultResourcePool(ctx)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	disk := func(key int32, mode types.VirtualDiskMode, name string, parent *types.VirtualDiskFlatVer2BackingInfo) types.BaseVirtualDeviceConfigSpec {
+		return &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device: &types.VirtualDisk{
+				VirtualDevice: types.VirtualDevice{
+					Key: key,
+					Backing: &types.VirtualDiskFlatVer2BackingInfo{
+						DiskMode: string(mode),
+						Parent:   parent,
+						VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+							FileName: name,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	image := &types.VirtualDiskFlatVer2BackingInfo{
+		VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+			FileName: testImageDisk,
+		},
+	}
+
+	spec := types.VirtualMachineConfigSpec{
+		Name:    "container",
+		GuestId: string(types.VirtualMachineGuestOsIdentifierOtherGuest),
+		Files: &types.VirtualMachineFileInfo{
+			VmPathName: "[LocalDS_0] container",
+		},
+		DeviceChange: []types.BaseVirtualDeviceConfigSpec{
+			disk(-1, types.VirtualDiskModeIndependent_persistent, testVolumeDisk, nil),
+			disk(-2, types.VirtualDiskModePersistent, testDisk, image),
+		},
+	}
+
+	info, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return folders.VmFolder.CreateVM(ctx, spec, pool, nil)
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	c := &Container{
+		ID:         ParseID("container"),
+		ExecConfig: &metadata.ExecutorConfig{ImageID: "image"},
+		State:      StateRunning,
+		vm:         vm.NewVirtualMachine(ctx, sess, info.Result.(types.ManagedObjectReference)),
+	}
+
+	return c, cleanup
+}
+
+func snapshots(ctx context.Context, t *testing.T, c *Container) []string {
+	var mvm mo.VirtualMachine
+	if err := c.vm.Properties(ctx, c.vm.Reference(), []string{"snapshot"}, &mvm); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	if mvm.Snapshot != nil {
+		for _, tree := range mvm.Snapshot.RootSnapshotList {
+			names = append(names, tree.Name)
+		}
+	}
+
+	return names
+}
+
+func TestDiskPath(t *testing.T) {
+	ctx := context.Background()
+
+	c, cleanup := testContainer(ctx, t)
+	defer cleanup()
+
+	disk, err := c.DiskPath(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, testDisk, disk, "the volume should be skipped")
+	}
+
+	c.ExecConfig.ImageID = "other"
+	_, err = c.DiskPath(ctx)
+	assert.Error(t, err, "no disk is a child of the image")
+}
+
+func TestFreezeDisk(t *testing.T) {
+	ctx := context.Background()
+
+	c, cleanup := testContainer(ctx, t)
+	defer cleanup()
+
+	// a snapshot taken by someone else must survive the thaw
+	_, err := tasks.WaitForResult(ctx, func(ctx context.Context) (tasks.ResultWaiter, error) {
+		return c.vm.CreateSnapshot(ctx, "keep", "", false, false)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	disk, thaw, err := c.FreezeDisk(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, testDisk, disk)
+	assert.Equal(t, []string{"keep", snapshotName}, snapshots(ctx, t, c))
+
+	// a second freeze of the same container waits for the first to be thawed
+	frozen := make(chan func())
+	go func() {
+		_, thaw, ferr := c.FreezeDisk(ctx)
+		assert.NoError(t, ferr)
+		frozen <- thaw
+	}()
+
+	select {
+	case <-frozen:
+		t.Fatal("disk was frozen twice at once")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	thaw()
+
+	thaw = <-frozen
+	assert.Equal(t, []string{"keep", snapshotName}, snapshots(ctx, t, c))
+	thaw()
+
+	assert.Equal(t, []string{"keep"}, snapshots(ctx, t, c))
+
+	// a stopped container is read without a snapshot
+	c.State = StateStopped
+	_, thaw, err = c.FreezeDisk(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"keep"}, snapshots(ctx, t, c))
+		thaw()
+	}
+}
@@ -68,6 +68,10 @@ type Handle struct {
 
 	key       string
 	committed bool
+
+	// restart is set when the port layer starts the container to apply its restart policy
+	// rather than at a user's request
+	restart bool
 }
 
 func newHandleKey() string {
@@ -139,11 +143,33 @@ func (h *Handle) String() string {
 	return h.key
 }
 
-func (h *Handle) Commit(ctx context.Context, sess *session.Session) error {
+// Commit applies the changes accumulated in the handle to the container. If the handle stops
+// the container then waitTime is the number of seconds to allow the container process to exit
+// before it's killed; nil selects the default.
+func (h *Handle) Commit(ctx context.Context, sess *session.Session, waitTime *int32) error {
 	if h.committed {
 		return nil // already committed
 	}
 
+	// when updating or stopping a running container the guest has published state for the
+	// sessions that must not be overwritten by our stale copy
+	if h.Container.State == StateRunning && (h.State == nil || *h.State == StateStopped) {
+		if err := h.refreshSessions(ctx); err != nil {
+			return err
+		}
+	}
+
+	// exec sessions do not survive a restart of the container
+	if h.State != nil && *h.State == StateRunning && h.Container.State != StateRunning {
+		h.resetSessions()
+	}
+
+	// let the executor know whether session exits are the result of being stopped, so that
+	// restart policies are not applied to them
+	if h.State != nil {
+		h.ExecConfig.Stopping = *h.State == StateStopped
+	}
+
 	// make sure there is a spec
 	h.SetSpec(nil)
 	cfg := make(map[string]string)
@@ -151,7 +177,7 @@ func (h *Handle) Commit(ctx context.Context, sess *session.Session) error {
 	s := h.Spec.Spec()
 	s.ExtraConfig = append(s.ExtraConfig, extraconfig.OptionValueFromMap(cfg)...)
 
-	if err := h.Container.Commit(ctx, sess, h); err != nil {
+	if err := h.Container.Commit(ctx, sess, h, waitTime); err != nil {
 		return err
 	}
 
@@ -160,6 +186,71 @@ func (h *Handle) Commit(ctx context.Context, sess *session.Session) error {
 	return nil
 }
 
+// refreshSessions merges the guest published session state into the handle's configuration
+func (h *Handle) refreshSessions(ctx context.Context) error {
+	current, err := h.Container.Refresh(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessions := make(map[string]metadata.SessionConfig, len(h.ExecConfig.Sessions))
+	for id, session := range h.ExecConfig.Sessions {
+		if published, ok := current.Sessions[id]; ok {
+			session.ExitStatus = published.ExitStatus
+			session.Started = published.Started
+			session.StartTime = published.StartTime
+			session.StopTime = published.StopTime
+			session.RestartCount = published.RestartCount
+		}
+		sessions[id] = session
+	}
+	h.ExecConfig.Sessions = sessions
+
+	return nil
+}
+
+// resetSessions removes all but the primary session from the handle's configuration and
+// clears the state published by any previous run so that it's not mistaken for the new one.
+// The restart count accumulates across restarts applied by the port layer, but is cleared
+// when the container is started at a user's request.
+func (h *Handle) resetSessions() {
+	sessions := make(map[string]metadata.SessionConfig)
+	for id, session := range h.ExecConfig.Sessions {
+		if id == h.ExecConfig.ID {
+			session.Started = ""
+			session.ExitStatus = 0
+			session.StartTime = 0
+			session.StopTime = 0
+			if h.restart {
+				session.RestartCount++
+			} else {
+				session.RestartCount = 0
+			}
+			sessions[id] = session
+		}
+	}
+	h.ExecConfig.Sessions = sessions
+}
+
+// AddExecSession adds a new session to the handle's configuration, to be launched in the
+// running container when the handle is committed. It returns the ID of the new session.
+func (h *Handle) AddExecSession(config *metadata.SessionConfig) string {
+	defer trace.End(trace.Begin(h.ExecConfig.ID))
+
+	id := GenerateID().String()
+	config.ID = id
+
+	// copy the map so that we don't modify the container's cached configuration
+	sessions := make(map[string]metadata.SessionConfig, len(h.ExecConfig.Sessions)+1)
+	for k, v := range h.ExecConfig.Sessions {
+		sessions[k] = v
+	}
+	sessions[id] = *config
+	h.ExecConfig.Sessions = sessions
+
+	return id
+}
+
 func (h *Handle) SetState(s State) {
 	h.State = new(State)
 	*h.State = s
@@ -0,0 +1,138 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"golang.org/x/net/context"
+)
+
+// logPollInterval is how often a followed session log is checked for new output
+const logPollInterval = time.Second
+
+// LogReader returns a reader for the session log of the container's primary process, which the
+// tether writes via a serial port backed by a file in the container's datastore folder. Reading
+// starts at the given byte offset. If follow is set the reader tracks the log as it grows until
+// the container stops or the context is cancelled.
+func (c *Container) LogReader(ctx context.Context, sess *session.Session, offset int64, follow bool) (io.ReadCloser, error) {
+	defer trace.End(trace.Begin(c.ID.String()))
+
+	if c.vm == nil {
+		return nil, fmt.Errorf("vm not set")
+	}
+
+	folder, err := c.vm.FolderName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s/%s.log", folder, c.ID)
+
+	if !follow {
+		return readLog(ctx, sess, name, offset)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			// sample the state before reading so that output written before the container
+			// stopped is always picked up by a final read
+			c.Lock()
+			running := c.State == StateRunning
+			c.Unlock()
+
+			rc, err := readLog(ctx, sess, name, offset)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			n, err := io.Copy(pw, rc)
+			rc.Close()
+			offset += n
+
+			if err != nil {
+				// the reader has been closed
+				log.Debugf("stopped following log for %s: %s", c.ID, err)
+				pw.CloseWithError(err)
+				return
+			}
+
+			if !running {
+				pw.Close()
+				return
+			}
+
+			select {
+			case <-time.After(logPollInterval):
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	return pr, nil
+}
+
+// readLog returns the content of the named datastore file from the given byte offset. A log that
+// doesn't exist yet, or hasn't grown beyond the offset, is returned as empty.
+func readLog(ctx context.Context, sess *session.Session, name string, offset int64) (io.ReadCloser, error) {
+	u, ticket, err := sess.Datastore.ServiceTicket(ctx, name, "GET")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.AddCookie(ticket)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := sess.Vim25().Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		return res.Body, nil
+	case http.StatusOK:
+		// the range wasn't honoured so skip what has already been read
+		if _, err = io.CopyN(ioutil.Discard, res.Body, offset); err != nil && err != io.EOF {
+			res.Body.Close()
+			return nil, err
+		}
+		return res.Body, nil
+	case http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return ioutil.NopCloser(&bytes.Buffer{}), nil
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("unable to read log %s: %s", name, res.Status)
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/types"
 	"github.com/vmware/vic/lib/metadata"
@@ -47,6 +48,10 @@ type Context struct {
 	scopes       map[string]*Scope
 	containers   map[exec.ID]*Container
 	defaultScope *Scope
+
+	// the host ports published for containers on bridge scopes
+	ports      *portAllocator
+	portMapper PortMapper
 }
 
 func NewContext(bridgePool net.IPNet, bridgeMask net.IPMask) (*Context, error) {
@@ -61,6 +66,8 @@ func NewContext(bridgePool net.IPNet, bridgeMask net.IPMask) (*Context, error) {
 		defaultBridgePool: NewAddressSpaceFromNetwork(&bridgePool),
 		scopes:            make(map[string]*Scope),
 		containers:        make(map[exec.ID]*Container),
+		ports:             newPortAllocator(),
+		portMapper:        &iptablesMapper{},
 	}
 
 	s, err := ctx.NewScope("bridge", bridgeScopeType, nil, net.IPv4(0, 0, 0, 0), nil, nil)
@@ -73,22 +80,43 @@ func NewContext(bridgePool net.IPNet, bridgeMask net.IPMask) (*Context, error) {
 	return ctx, nil
 }
 
+// reserveBroadcastAndNetwork reserves the network and broadcast addresses
+// of an IPv4 space. IPv6 has no broadcast address, so only the network
+// address, the subnet-router anycast address, is reserved for an IPv6 space.
 func reserveBroadcastAndNetwork(space *AddressSpace) error {
 	if space.Network == nil {
 		return nil
 	}
 
-	if err := space.ReserveIP4(space.Network.IP); err != nil {
+	network := lowestIP(space.Network)
+	if err := space.ReserveIP(network); err != nil {
 		return err
 	}
 
-	if err := space.ReserveIP4(highestIP4(space.Network)); err != nil {
+	if !isIP4(network) {
+		return nil
+	}
+
+	if err := space.ReserveIP(highestIP(space.Network)); err != nil {
+		space.ReleaseIP(network)
 		return err
 	}
 
 	return nil
 }
 
+func releaseBroadcastAndNetwork(space *AddressSpace) {
+	if space.Network == nil {
+		return
+	}
+
+	network := lowestIP(space.Network)
+	space.ReleaseIP(network)
+	if isIP4(network) {
+		space.ReleaseIP(highestIP(space.Network))
+	}
+}
+
 func isUnspecifiedSubnet(n *net.IPNet) bool {
 	if n == nil {
 		return true
@@ -103,13 +131,17 @@ func (c *Context) newScopeCommon(id, name, scopeType string, subnet *net.IPNet,
 		subnet = defaultSubnet
 	}
 
+	if !gateway.IsUnspecified() && isIP4(gateway) != isIP4(subnet.IP) {
+		return nil, fmt.Errorf("gateway %s is not in the address family of subnet %s", gateway, subnet)
+	}
+
 	var err error
 
 	// allocate the subnet
 	space, defaultPool, err := c.reserveSubnet(subnet)
 	defer func() {
 		if err != nil && space != nil && defaultPool {
-			c.defaultBridgePool.ReleaseIP4Range(space)
+			c.defaultBridgePool.ReleaseIPRange(space)
 		}
 	}()
 
@@ -121,21 +153,16 @@ func (c *Context) newScopeCommon(id, name, scopeType string, subnet *net.IPNet,
 
 	// reserve the network and broadcast addresses
 	err = reserveBroadcastAndNetwork(space)
-	defer func() {
-		if err == nil || space.Network == nil {
-			return
-		}
-
-		lo := incrementIP4(space.Network.IP)
-		hi := decrementIP4(highestIP4(space.Network))
-		space.ReleaseIP4(lo)
-		space.ReleaseIP4(hi)
-	}()
-
 	if err != nil {
 		return nil, err
 	}
 
+	defer func() {
+		if err != nil {
+			releaseBroadcastAndNetwork(space)
+		}
+	}()
+
 	subSpaces, err := reservePools(space, ipam)
 	if err != nil {
 		return nil, err
@@ -144,10 +171,10 @@ func (c *Context) newScopeCommon(id, name, scopeType string, subnet *net.IPNet,
 	ipam.spaces = subSpaces
 
 	if gateway.IsUnspecified() {
-		gateway, err = ipam.spaces[0].ReserveNextIP4()
+		gateway, err = ipam.spaces[0].ReserveNextIP()
 		defer func() {
 			if err != nil && !gateway.IsUnspecified() {
-				ipam.spaces[0].ReleaseIP4(gateway)
+				ipam.spaces[0].ReleaseIP(gateway)
 			}
 		}()
 
@@ -201,7 +228,7 @@ func (c *Context) newExternalScope(id, name string, subnet *net.IPNet, gateway n
 
 	// cannot overlap with the default bridge pool
 	if c.defaultBridgePool.Network.Contains(subnet.IP) ||
-		c.defaultBridgePool.Network.Contains(highestIP4(subnet)) {
+		c.defaultBridgePool.Network.Contains(highestIP(subnet)) {
 		return nil, fmt.Errorf("external network cannot overlap with default bridge network")
 	}
 
@@ -225,8 +252,15 @@ func (c *Context) reserveSubnet(subnet *net.IPNet) (space *AddressSpace, default
 		return
 	}
 
+	// the default bridge pool only holds IPv4 addresses
+	if !isIP4(subnet.IP) {
+		defaultPool = false
+		space = NewAddressSpaceFromNetwork(subnet)
+		return
+	}
+
 	// reserve from the default pool first
-	space, err = c.defaultBridgePool.ReserveIP4Net(subnet)
+	space, err = c.defaultBridgePool.ReserveIPNet(subnet)
 	if err == nil {
 		return
 	}
@@ -239,9 +273,9 @@ func (c *Context) reserveSubnet(subnet *net.IPNet) (space *AddressSpace, default
 
 func (c *Context) checkNetOverlap(subnet *net.IPNet) error {
 	// check if the requested subnet is available
-	highestIP := highestIP4(subnet)
+	last := highestIP(subnet)
 	for _, scope := range c.scopes {
-		if scope.subnet.Contains(subnet.IP) || scope.subnet.Contains(highestIP) {
+		if scope.subnet.Contains(subnet.IP) || scope.subnet.Contains(last) {
 			return fmt.Errorf("could not allocate subnet for scope")
 		}
 	}
@@ -267,7 +301,7 @@ func reservePools(space *AddressSpace, ipam *IPAM) ([]*AddressSpace, error) {
 			if s == nil {
 				continue
 			}
-			space.ReleaseIP4Range(s)
+			space.ReleaseIPRange(s)
 
 		}
 	}()
@@ -276,7 +310,7 @@ func reservePools(space *AddressSpace, ipam *IPAM) ([]*AddressSpace, error) {
 		var nw *net.IPNet
 		_, nw, err = net.ParseCIDR(p)
 		if err == nil {
-			subSpaces[i], err = space.ReserveIP4Net(nw)
+			subSpaces[i], err = space.ReserveIPNet(nw)
 			if err != nil {
 				break
 			}
@@ -292,7 +326,7 @@ func reservePools(space *AddressSpace, ipam *IPAM) ([]*AddressSpace, error) {
 		}
 
 		var ss *AddressSpace
-		ss, err = space.ReserveIP4Range(r.FirstIP, r.LastIP)
+		ss, err = space.ReserveIPRange(r.FirstIP, r.LastIP)
 		if err != nil {
 			break
 		}
@@ -427,7 +461,26 @@ func (c *Context) BindContainer(h *exec.Handle) ([]*Endpoint, error) {
 		}
 	}
 
+	var published []*Endpoint
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for _, e := range published {
+			c.unpublishPorts(e)
+		}
+	}()
+
 	endpoints := con.Endpoints()
+	for _, e := range endpoints {
+		ne := h.ExecConfig.Networks[e.Scope().Name()]
+		if err = c.publishPorts(e, ne.Ports); err != nil {
+			return nil, err
+		}
+		published = append(published, e)
+	}
+
 	for _, e := range endpoints {
 		ne := h.ExecConfig.Networks[e.Scope().Name()]
 		ne.Static = &net.IPNet{
@@ -435,6 +488,7 @@ func (c *Context) BindContainer(h *exec.Handle) ([]*Endpoint, error) {
 			Mask: e.Scope().Subnet().Mask,
 		}
 		ne.Network.Gateway = net.IPNet{IP: e.gateway, Mask: e.subnet.Mask}
+		ne.Ports = portStrings(e.ports)
 	}
 
 	c.containers[con.id] = con
@@ -478,6 +532,11 @@ func (c *Context) UnbindContainer(h *exec.Handle) error {
 		if !e.static {
 			ne.Static = nil
 		}
+
+		// the ports are left as requested, so that those without a host port are
+		// allocated one again on the next bind
+		c.unpublishPorts(e)
+		ne.Ports = ne.RequestedPorts
 	}
 
 	delete(c.containers, h.Container.ID)
@@ -554,9 +613,67 @@ func (c *Context) resolveScope(scope string) (*Scope, error) {
 	return scopes[0], nil
 }
 
+// publishPorts reserves the host ports of the endpoint and forwards them to
+// the container.  Ports without a host port are allocated one.  When a
+// container is rebound, such as after a restart of the port layer, the specs
+// hold the host ports allocated before so that they're kept.
+func (c *Context) publishPorts(e *Endpoint, specs []string) error {
+	e.ports = nil
+	for _, spec := range specs {
+		p, err := ParsePort(spec)
+		if err != nil {
+			c.unpublishPorts(e)
+			return err
+		}
+
+		bound, err := c.ports.reserve(p)
+		if err != nil {
+			c.unpublishPorts(e)
+			return err
+		}
+
+		if err = c.portMapper.MapPort(e.IP(), bound); err != nil {
+			c.ports.release(bound)
+			c.unpublishPorts(e)
+			return err
+		}
+
+		e.ports = append(e.ports, bound)
+	}
+
+	return nil
+}
+
+// unpublishPorts stops forwarding the ports of the endpoint and releases
+// them.  Failures are logged rather than returned so that a container can
+// always be unbound.
+func (c *Context) unpublishPorts(e *Endpoint) {
+	for _, p := range e.ports {
+		if err := c.portMapper.UnmapPort(e.IP(), p); err != nil {
+			log.Warnf("Failed to stop forwarding port %s to %s: %s", p, e.IP(), err)
+		}
+		c.ports.release(p)
+	}
+
+	e.ports = nil
+}
+
+func portStrings(ports []Port) []string {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = p.String()
+	}
+	return s
+}
+
 // AddContainer add a container to the specified scope, optionally specifying an ip address
-// for the container in the scope
-func (c *Context) AddContainer(h *exec.Handle, scope string, ip *net.IP) error {
+// for the container in the scope and the ports to publish on the VCH.  Ports can only be
+// published for IPv4 bridge scopes.
+func (c *Context) AddContainer(h *exec.Handle, scope string, ip *net.IP, ports []string) error {
 	c.Lock()
 	defer c.Unlock()
 
@@ -576,6 +693,25 @@ func (c *Context) AddContainer(h *exec.Handle, scope string, ip *net.IP) error {
 		}
 	}
 
+	if len(ports) > 0 && s.Type() != bridgeScopeType {
+		return fmt.Errorf("ports can only be published on bridge networks")
+	}
+
+	// ports are forwarded with iptables, which only handles IPv4
+	if len(ports) > 0 && !isIP4(s.Subnet().IP) {
+		return fmt.Errorf("ports can only be published on IPv4 networks")
+	}
+
+	// normalize the ports so they're stored in one form
+	var published []string
+	for _, spec := range ports {
+		p, err := ParsePort(spec)
+		if err != nil {
+			return err
+		}
+		published = append(published, p.String())
+	}
+
 	if err := h.SetSpec(nil); err != nil {
 		return err
 	}
@@ -635,6 +771,8 @@ func (c *Context) AddContainer(h *exec.Handle, scope string, ip *net.IP) error {
 	if ip != nil {
 		ne.Static = &net.IPNet{IP: *ip}
 	}
+	ne.Ports = published
+	ne.RequestedPorts = published
 
 	h.ExecConfig.Networks[s.Name()] = ne
 	return nil
@@ -138,6 +138,18 @@ func TestContext(t *testing.T) {
 		{params{"external", "bar15", &net.IPNet{IP: net.IPv4(10, 14, 0, 0), Mask: net.CIDRMask(16, 32)}, net.IPv4(10, 14, 0, 1), nil, nil}, nil, nil},
 		// external networks cannot overlap bridge pool
 		{params{"external", "bar16", &net.IPNet{IP: net.IPv4(172, 20, 0, 0), Mask: net.CIDRMask(16, 32)}, net.IPv4(10, 14, 0, 1), nil, []string{"172.20.0.0/16"}}, nil, nil},
+		// ipv6 bridge network, the network address is reserved
+		{params{"bridge", "bar17", &net.IPNet{IP: net.ParseIP("fd00:17::"), Mask: net.CIDRMask(64, 128)}, net.IPv6unspecified, nil, nil},
+			&params{"bridge", "bar17", &net.IPNet{IP: net.ParseIP("fd00:17::"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00:17::1"), nil, nil},
+			nil},
+		// overlapping ipv6 network
+		{params{"bridge", "bar18", &net.IPNet{IP: net.ParseIP("fd00:17::"), Mask: net.CIDRMask(48, 128)}, net.IPv6unspecified, nil, nil}, nil, nil},
+		// ipv6 external network
+		{params{"external", "bar19", &net.IPNet{IP: net.ParseIP("fd00:19::"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00:19::1"), nil, []string{"fd00:19::100-fd00:19::1ff"}},
+			&params{"external", "bar19", &net.IPNet{IP: net.ParseIP("fd00:19::"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00:19::1"), nil, nil},
+			nil},
+		// gateway must be of the same family as the subnet
+		{params{"bridge", "bar20", &net.IPNet{IP: net.ParseIP("fd00:20::"), Mask: net.CIDRMask(64, 128)}, net.IPv4(10, 14, 0, 1), nil, nil}, nil, nil},
 	}
 
 	tests = append(validScopeTests, tests...)
@@ -426,7 +438,7 @@ func TestContextAddContainer(t *testing.T) {
 			addEthernetCard = te.aec
 		}
 
-		err := ctx.AddContainer(te.h, te.scope, te.ip)
+		err := ctx.AddContainer(te.h, te.scope, te.ip, nil)
 		if te.err != nil {
 			// expect an error
 			if err == nil {
@@ -516,25 +528,25 @@ func TestContextBindUnbindContainer(t *testing.T) {
 	ipErr := exec.NewContainer("ipErr")
 
 	// add a container to the default scope
-	if err = ctx.AddContainer(added, ctx.DefaultScope().Name(), nil); err != nil {
+	if err = ctx.AddContainer(added, ctx.DefaultScope().Name(), nil, nil); err != nil {
 		t.Fatalf("ctx.AddContainer(%s, %s, nil) => %s", added, ctx.DefaultScope().Name(), err)
 	}
 
 	// add a container with a static IP
 	ip := net.IPv4(172, 16, 0, 10)
-	if err = ctx.AddContainer(staticIP, ctx.DefaultScope().Name(), &ip); err != nil {
+	if err = ctx.AddContainer(staticIP, ctx.DefaultScope().Name(), &ip, nil); err != nil {
 		t.Fatalf("ctx.AddContainer(%s, %s, nil) => %s", staticIP, ctx.DefaultScope().Name(), err)
 	}
 
-	if err = ctx.AddContainer(added, scope.Name(), nil); err != nil {
+	if err = ctx.AddContainer(added, scope.Name(), nil, nil); err != nil {
 		t.Fatalf("ctx.AddContainer(%s, %s, nil) => %s", added, scope.Name(), err)
 	}
 
 	// add a container with an ip that is already taken,
 	// causing Scope.BindContainer call to fail
 	gw := ctx.DefaultScope().Gateway()
-	ctx.AddContainer(ipErr, scope.Name(), nil)
-	ctx.AddContainer(ipErr, ctx.DefaultScope().Name(), &gw)
+	ctx.AddContainer(ipErr, scope.Name(), nil, nil)
+	ctx.AddContainer(ipErr, ctx.DefaultScope().Name(), &gw, nil)
 
 	var tests = []struct {
 		i      int
@@ -696,13 +708,13 @@ func TestContextRemoveContainer(t *testing.T) {
 		t.Fatalf("ctx.NewScope() => (nil, %s), want (scope, nil)", err)
 	}
 
-	ctx.AddContainer(hFoo, scope.Name(), nil)
+	ctx.AddContainer(hFoo, scope.Name(), nil, nil)
 	ctx.BindContainer(hFoo)
 
 	// container that is added to multiple bridge scopes
 	hBar := exec.NewContainer("bar")
-	ctx.AddContainer(hBar, "default", nil)
-	ctx.AddContainer(hBar, scope.Name(), nil)
+	ctx.AddContainer(hBar, "default", nil, nil)
+	ctx.AddContainer(hBar, scope.Name(), nil, nil)
 
 	var tests = []struct {
 		h     *exec.Handle
@@ -795,7 +807,7 @@ func TestDeleteScope(t *testing.T) {
 		t.Fatalf("ctx.NewScope(%s, \"foo\", nil, nil, nil, nil) => (nil, %#v), want (foo, nil)", bridgeScopeType, err)
 	}
 	h := exec.NewContainer("container")
-	ctx.AddContainer(h, foo.Name(), nil)
+	ctx.AddContainer(h, foo.Name(), nil, nil)
 
 	// bar is a scope with bound endpoints
 	bar, err := ctx.NewScope(bridgeScopeType, "bar", nil, nil, nil, nil)
@@ -804,7 +816,7 @@ func TestDeleteScope(t *testing.T) {
 	}
 
 	h = exec.NewContainer("container2")
-	ctx.AddContainer(h, bar.Name(), nil)
+	ctx.AddContainer(h, bar.Name(), nil, nil)
 	ctx.BindContainer(h)
 
 	var tests = []struct {
@@ -837,3 +849,177 @@ func TestDeleteScope(t *testing.T) {
 		}
 	}
 }
+
+func TestContextPublishPorts(t *testing.T) {
+	ctx, err := NewContext(net.IPNet{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)}, net.CIDRMask(16, 32))
+	if err != nil {
+		t.Fatalf("NewContext() => (nil, %s), want (ctx, nil)", err)
+	}
+
+	mapper := &mockMapper{}
+	ctx.portMapper = mapper
+
+	external, err := ctx.NewScope(externalScopeType, "ext", &net.IPNet{IP: net.IPv4(10, 20, 0, 0), Mask: net.CIDRMask(16, 32)}, net.IPv4(10, 20, 0, 1), nil, []string{"10.20.1.0/24"})
+	if err != nil {
+		t.Fatalf("ctx.NewScope() => (nil, %s)", err)
+	}
+
+	web := exec.NewContainer("web")
+	other := exec.NewContainer("other")
+
+	// ports can't be published on external scopes
+	if err = ctx.AddContainer(web, external.Name(), nil, []string{"80"}); err == nil {
+		t.Fatalf("ctx.AddContainer() with ports on external scope => nil, want err")
+	}
+
+	// nor can malformed ports be given
+	if err = ctx.AddContainer(web, ctx.DefaultScope().Name(), nil, []string{"80/foo"}); err == nil {
+		t.Fatalf("ctx.AddContainer() with bad port => nil, want err")
+	}
+
+	if err = ctx.AddContainer(web, ctx.DefaultScope().Name(), nil, []string{"8080:80", "443/tcp"}); err != nil {
+		t.Fatalf("ctx.AddContainer() => %s", err)
+	}
+	if err = ctx.AddContainer(other, ctx.DefaultScope().Name(), nil, []string{"8080:8000"}); err != nil {
+		t.Fatalf("ctx.AddContainer() => %s", err)
+	}
+
+	eps, err := ctx.BindContainer(web)
+	if err != nil {
+		t.Fatalf("ctx.BindContainer() => %s", err)
+	}
+
+	ip := eps[0].IP().String()
+	ports := eps[0].Ports()
+	if len(ports) != 2 || ports[0].HostPort != 8080 || ports[1].HostPort < dynamicPortBegin {
+		t.Fatalf("ctx.BindContainer() published %v", ports)
+	}
+	if mapper.mapped["8080/tcp"] != ip || mapper.mapped[ports[1].hostKey()] != ip {
+		t.Fatalf("ctx.BindContainer() forwarded %v, want ports forwarded to %s", mapper.mapped, ip)
+	}
+
+	// the allocated port is recorded in the container's config
+	ne := web.ExecConfig.Networks[ctx.DefaultScope().Name()]
+	if len(ne.Ports) != 2 || ne.Ports[1] != ports[1].String() {
+		t.Fatalf("ctx.BindContainer() => ports %v in config, want %v", ne.Ports, ports)
+	}
+
+	// host ports can't collide
+	if _, err = ctx.BindContainer(other); err == nil {
+		t.Fatalf("ctx.BindContainer() with port in use => nil, want err")
+	}
+	if con := ctx.Container(other.Container.ID); con != nil {
+		t.Fatalf("ctx.BindContainer() with port in use bound container")
+	}
+
+	if err = ctx.UnbindContainer(web); err != nil {
+		t.Fatalf("ctx.UnbindContainer() => %s", err)
+	}
+	if len(mapper.mapped) != 0 {
+		t.Fatalf("ctx.UnbindContainer() left %v forwarded", mapper.mapped)
+	}
+
+	// the allocated port is released
+	if len(ne.Ports) != 2 || ne.Ports[1] != "443/tcp" {
+		t.Fatalf("ctx.UnbindContainer() => ports %v in config, want allocated port removed", ne.Ports)
+	}
+
+	// and the host port can now be used by the other container
+	if _, err = ctx.BindContainer(other); err != nil {
+		t.Fatalf("ctx.BindContainer() => %s", err)
+	}
+
+	// a restarted port layer rebinds the running container with the ports it had allocated
+	if err = ctx.UnbindContainer(other); err != nil {
+		t.Fatalf("ctx.UnbindContainer() => %s", err)
+	}
+	if eps, err = ctx.BindContainer(web); err != nil {
+		t.Fatalf("ctx.BindContainer() => %s", err)
+	}
+	allocated := eps[0].Ports()[1]
+
+	restarted, err := NewContext(net.IPNet{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)}, net.CIDRMask(16, 32))
+	if err != nil {
+		t.Fatalf("NewContext() => (nil, %s), want (ctx, nil)", err)
+	}
+	restarted.portMapper = &mockMapper{}
+
+	if eps, err = restarted.BindContainer(web); err != nil {
+		t.Fatalf("restarted.BindContainer() => %s", err)
+	}
+	if ports = eps[0].Ports(); len(ports) != 2 || ports[1] != allocated {
+		t.Fatalf("restarted.BindContainer() published %v, want %s kept", ports, allocated)
+	}
+
+	// the port is requested as it was originally once the container is unbound
+	if err = restarted.UnbindContainer(web); err != nil {
+		t.Fatalf("restarted.UnbindContainer() => %s", err)
+	}
+	if len(ne.Ports) != 2 || ne.Ports[0] != "8080:80/tcp" || ne.Ports[1] != "443/tcp" {
+		t.Fatalf("restarted.UnbindContainer() => ports %v in config, want requested ports", ne.Ports)
+	}
+}
+
+func TestContextIP6(t *testing.T) {
+	ctx, err := NewContext(net.IPNet{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)}, net.CIDRMask(16, 32))
+	if err != nil {
+		t.Fatalf("NewContext() => (nil, %s), want (ctx, nil)", err)
+	}
+
+	subnet := &net.IPNet{IP: net.ParseIP("fd00:1::"), Mask: net.CIDRMask(64, 128)}
+	s, err := ctx.NewScope(bridgeScopeType, "v6", subnet, net.IPv6unspecified, nil, nil)
+	if err != nil {
+		t.Fatalf("ctx.NewScope() => (nil, %s)", err)
+	}
+
+	static := exec.NewContainer("static")
+	dynamic := exec.NewContainer("dynamic")
+
+	// ports are forwarded with iptables, so can't be published on an ipv6 scope
+	if err = ctx.AddContainer(dynamic, s.Name(), nil, []string{"80"}); err == nil {
+		t.Fatalf("ctx.AddContainer() with ports on ipv6 scope => nil, want err")
+	}
+
+	ip := net.ParseIP("fd00:1::10")
+	if err = ctx.AddContainer(static, s.Name(), &ip, nil); err != nil {
+		t.Fatalf("ctx.AddContainer() => %s", err)
+	}
+	if err = ctx.AddContainer(dynamic, s.Name(), nil, nil); err != nil {
+		t.Fatalf("ctx.AddContainer() => %s", err)
+	}
+
+	for _, h := range []*exec.Handle{static, dynamic} {
+		if _, err = ctx.BindContainer(h); err != nil {
+			t.Fatalf("ctx.BindContainer() => %s", err)
+		}
+	}
+
+	ne := static.ExecConfig.Networks[s.Name()]
+	if !ne.Static.IP.Equal(ip) || ne.Static.String() != "fd00:1::10/64" {
+		t.Fatalf("ctx.BindContainer() => static ip %s, want fd00:1::10/64", ne.Static)
+	}
+
+	ne = dynamic.ExecConfig.Networks[s.Name()]
+	if !ne.Static.IP.Equal(net.ParseIP("fd00:1::2")) {
+		t.Fatalf("ctx.BindContainer() => ip %s, want fd00:1::2", ne.Static.IP)
+	}
+	if !ne.Network.Gateway.IP.Equal(net.ParseIP("fd00:1::1")) {
+		t.Fatalf("ctx.BindContainer() => gateway %s, want fd00:1::1", ne.Network.Gateway.IP)
+	}
+
+	// the address is released on unbind and can be reused
+	if err = ctx.UnbindContainer(dynamic); err != nil {
+		t.Fatalf("ctx.UnbindContainer() => %s", err)
+	}
+
+	other := exec.NewContainer("other")
+	if err = ctx.AddContainer(other, s.Name(), nil, nil); err != nil {
+		t.Fatalf("ctx.AddContainer() => %s", err)
+	}
+	if _, err = ctx.BindContainer(other); err != nil {
+		t.Fatalf("ctx.BindContainer() => %s", err)
+	}
+	if ne = other.ExecConfig.Networks[s.Name()]; !ne.Static.IP.Equal(net.ParseIP("fd00:1::2")) {
+		t.Fatalf("ctx.BindContainer() => ip %s, want fd00:1::2", ne.Static.IP)
+	}
+}
@@ -24,6 +24,9 @@ type Endpoint struct {
 	gateway   net.IP
 	subnet    net.IPNet
 	static    bool
+
+	// the ports published while the container is bound
+	ports []Port
 }
 
 func newEndpoint(container *Container, scope *Scope, ip *net.IP, subnet net.IPNet, gateway net.IP, pciSlot *int32) *Endpoint {
@@ -82,3 +85,10 @@ func (e *Endpoint) ID() string {
 func (e *Endpoint) Gateway() net.IP {
 	return e.gateway
 }
+
+// Ports returns the ports published on the VCH for the endpoint
+func (e *Endpoint) Ports() []Port {
+	ports := make([]Port, len(e.ports))
+	copy(ports, e.ports)
+	return ports
+}
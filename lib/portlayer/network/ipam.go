@@ -21,7 +21,11 @@
 // are available as valid addresses. This behavior can be
 // accomplished, however, by just reserving those two addresses
 // first thing after requesting a CIDR address space, by using
-// the ReserveIP4() call.
+// the ReserveIP() call.
+//
+// Address spaces may hold either IPv4 or IPv6 addresses. The
+// IP4 variants of the calls only accept IPv4 addresses and
+// networks.
 
 package network
 
@@ -68,10 +72,10 @@ func (r *IPRange) String() string {
 	return r.FirstIP.String() + "-" + r.LastIP.String()
 }
 
-// compareIPv4 compares two IPv4 addresses.
+// compareIP compares two IP addresses of the same family.
 // Returns -1 if ip1 < ip2, 0 if they are equal,
 // and 1 if ip1 > ip2
-func compareIP4(ip1 net.IP, ip2 net.IP) int {
+func compareIP(ip1 net.IP, ip2 net.IP) int {
 	ip1 = ip1.To16()
 	ip2 = ip2.To16()
 	return bytes.Compare(ip1, ip2)
@@ -117,6 +121,42 @@ func decrementIP4(ip net.IP) net.IP {
 	return newIP
 }
 
+// incrementIP returns the address following ip, wrapping
+// around within the address family.
+func incrementIP(ip net.IP) net.IP {
+	if isIP4(ip) {
+		return incrementIP4(ip)
+	}
+
+	newIP := copyIP(ip)
+	for i := len(newIP) - 1; i >= 0; i-- {
+		newIP[i]++
+		if newIP[i] > 0 {
+			break
+		}
+	}
+
+	return newIP
+}
+
+// decrementIP returns the address preceding ip, wrapping
+// around within the address family.
+func decrementIP(ip net.IP) net.IP {
+	if isIP4(ip) {
+		return decrementIP4(ip)
+	}
+
+	newIP := copyIP(ip)
+	for i := len(newIP) - 1; i >= 0; i-- {
+		newIP[i]--
+		if newIP[i] != 0xff {
+			break
+		}
+	}
+
+	return newIP
+}
+
 func copyIP(ip net.IP) net.IP {
 	newIP := make([]byte, len(ip))
 	copy(newIP, ip)
@@ -127,12 +167,18 @@ func isIP4(ip net.IP) bool {
 	return ip.To4() != nil
 }
 
-// lowestIP4 returns the lowest possible IP address
+// isIP4Mask returns true if mask is the mask of an IPv4 network
+func isIP4Mask(mask net.IPMask) bool {
+	_, bits := mask.Size()
+	return bits == 8*net.IPv4len
+}
+
+// lowestIP returns the lowest possible IP address
 // in an IP network. For example:
 //
-//     lowestIP4(net.IPNet{}IP: net.ParseIP("172.16.0.0"), Mask: net.CIDRMask(16, 32)}) -> 172.16.0.0
+//     lowestIP(net.IPNet{}IP: net.ParseIP("172.16.0.0"), Mask: net.CIDRMask(16, 32)}) -> 172.16.0.0
 //
-func lowestIP4(ipRange *net.IPNet) net.IP {
+func lowestIP(ipRange *net.IPNet) net.IP {
 	return ipRange.IP.Mask(ipRange.Mask).To16()
 }
 
@@ -155,17 +201,40 @@ func highestIP4(ipRange *net.IPNet) net.IP {
 	return newIP
 }
 
+// highestIP returns the highest possible IP address
+// in an IPv4 or IPv6 network. For example:
+//
+//     highestIP(net.IPNet{}IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}) -> fd00::ffff:ffff:ffff:ffff
+//
+func highestIP(ipRange *net.IPNet) net.IP {
+	if isIP4(ipRange.IP) {
+		return highestIP4(ipRange)
+	}
+
+	ip := ipRange.IP.To16()
+	if ip == nil || len(ipRange.Mask) != net.IPv6len {
+		return nil
+	}
+
+	newIP := make(net.IP, net.IPv6len)
+	for i := 0; i < net.IPv6len; i++ {
+		newIP[i] = ip[i] | ^ipRange.Mask[i]
+	}
+
+	return newIP
+}
+
 // NewAddressSpaceFromNetwork creates a new AddressSpace from a network specification.
 func NewAddressSpaceFromNetwork(ipRange *net.IPNet) *AddressSpace {
 	return &AddressSpace{
 		Network: ipRange,
 		availableRanges: []*IPRange{
-			{FirstIP: lowestIP4(ipRange), LastIP: highestIP4(ipRange)}}}
+			{FirstIP: lowestIP(ipRange), LastIP: highestIP(ipRange)}}}
 }
 
 // NewAddressSpaceFromRange creates a new AddressSpace from a range of IP addresses.
 func NewAddressSpaceFromRange(firstIP net.IP, lastIP net.IP) *AddressSpace {
-	if compareIP4(firstIP, lastIP) > 0 {
+	if isIP4(firstIP) != isIP4(lastIP) || compareIP(firstIP, lastIP) > 0 {
 		return nil
 	}
 
@@ -174,24 +243,40 @@ func NewAddressSpaceFromRange(firstIP net.IP, lastIP net.IP) *AddressSpace {
 		availableRanges: []*IPRange{{FirstIP: firstIP, LastIP: lastIP}}}
 }
 
-// ReserveNextIP4Net reserves a new sub address space within the given address
-// space, given a bitmask specifying the "width" of the requested space.
-func (s *AddressSpace) ReserveNextIP4Net(mask net.IPMask) (*AddressSpace, error) {
+// ReserveNextIPNet reserves a new sub address space within the given address
+// space, given a bitmask specifying the "width" of the requested space. The
+// family of the mask selects whether IPv4 or IPv6 ranges are considered.
+func (s *AddressSpace) ReserveNextIPNet(mask net.IPMask) (*AddressSpace, error) {
 	ones, _ := mask.Size()
+
+	// offset of the first octet of the address within the 16 byte form
+	offset := 0
+	if isIP4Mask(mask) {
+		offset = net.IPv6len - net.IPv4len
+	}
+
 	for i, r := range s.availableRanges {
+		if isIP4(r.FirstIP) != isIP4Mask(mask) {
+			continue
+		}
+
 		network := r.FirstIP.Mask(mask).To16()
+		if network == nil {
+			continue
+		}
+
 		var firstIP net.IP
 		// check if the start of the current range
 		// is lower than the network boundary
-		if compareIP4(network, r.FirstIP) >= 0 {
+		if compareIP(network, r.FirstIP) >= 0 {
 			// found the start of the range
 			firstIP = network
 		} else {
 			// network address is lower than the first
 			// ip in the range; try the next network
 			// in the mask
-			for i := len(network) - 1; i >= 12; i-- {
-				partialByteIndex := ones/8 + 12
+			for i := len(network) - 1; i >= offset; i-- {
+				partialByteIndex := ones/8 + offset
 				var inc byte
 				if i == partialByteIndex {
 					// this octet may only be occupied
@@ -230,8 +315,8 @@ func (s *AddressSpace) ReserveNextIP4Net(mask net.IPMask) (*AddressSpace, error)
 			// we found the first IP for the requested range,
 			// now check if the available range can accommodate
 			// the highest address given the first IP and the mask
-			lastIP := highestIP4(&net.IPNet{IP: firstIP, Mask: mask})
-			if compareIP4(lastIP, r.LastIP) <= 0 {
+			lastIP := highestIP(&net.IPNet{IP: firstIP, Mask: mask})
+			if compareIP(lastIP, r.LastIP) <= 0 {
 				s.reserveSubRange(firstIP, lastIP, i)
 				subSpace := NewAddressSpaceFromRange(firstIP, lastIP)
 				subSpace.Network = &net.IPNet{IP: firstIP, Mask: mask}
@@ -244,36 +329,46 @@ func (s *AddressSpace) ReserveNextIP4Net(mask net.IPMask) (*AddressSpace, error)
 	return nil, fmt.Errorf("could not find IP range for mask %s", mask)
 }
 
+// ReserveNextIP4Net reserves a new IPv4 sub address space within the given address
+// space, given a bitmask specifying the "width" of the requested space.
+func (s *AddressSpace) ReserveNextIP4Net(mask net.IPMask) (*AddressSpace, error) {
+	if !isIP4Mask(mask) {
+		return nil, fmt.Errorf("mask %s is not an IPv4 mask", mask)
+	}
+
+	return s.ReserveNextIPNet(mask)
+}
+
 func NewIPRange(firstIP net.IP, lastIP net.IP) *IPRange {
 	return &IPRange{FirstIP: firstIP.To16(), LastIP: lastIP.To16()}
 }
 
 func splitRange(parentRange *IPRange, firstIP net.IP, lastIP net.IP) (before, reserved, after *IPRange) {
 	if !firstIP.Equal(parentRange.FirstIP) {
-		before = NewIPRange(parentRange.FirstIP, decrementIP4(firstIP))
+		before = NewIPRange(parentRange.FirstIP, decrementIP(firstIP))
 	}
 	if !lastIP.Equal(parentRange.LastIP) {
-		after = NewIPRange(incrementIP4(lastIP), parentRange.LastIP)
+		after = NewIPRange(incrementIP(lastIP), parentRange.LastIP)
 	}
 
 	reserved = NewIPRange(firstIP, lastIP)
 	return
 }
 
-// ReserveIP4Net reserves a new sub address space given an IP and mask.
+// ReserveIPNet reserves a new sub address space given an IP and mask.
 // Mask is required.
-// If IP is nil or "0.0.0.0", same as calling ReserveNextIP4Net
-// with the mask.
-func (s *AddressSpace) ReserveIP4Net(ipNet *net.IPNet) (*AddressSpace, error) {
+// If IP is nil or unspecified ("0.0.0.0" or "::"), same as calling
+// ReserveNextIPNet with the mask.
+func (s *AddressSpace) ReserveIPNet(ipNet *net.IPNet) (*AddressSpace, error) {
 	if ipNet.Mask == nil {
 		return nil, fmt.Errorf("network mask not specified")
 	}
 
-	if ipNet.IP == nil || ipNet.IP.Equal(net.ParseIP("0.0.0.0")) {
-		return s.ReserveNextIP4Net(ipNet.Mask)
+	if ipNet.IP == nil || ipNet.IP.IsUnspecified() {
+		return s.ReserveNextIPNet(ipNet.Mask)
 	}
 
-	sub, err := s.ReserveIP4Range(lowestIP4(ipNet), highestIP4(ipNet))
+	sub, err := s.ReserveIPRange(lowestIP(ipNet), highestIP(ipNet))
 	if err != nil {
 		return nil, err
 	}
@@ -282,6 +377,22 @@ func (s *AddressSpace) ReserveIP4Net(ipNet *net.IPNet) (*AddressSpace, error) {
 	return sub, nil
 }
 
+// ReserveIP4Net reserves a new IPv4 sub address space given an IP and mask.
+// Mask is required.
+// If IP is nil or "0.0.0.0", same as calling ReserveNextIP4Net
+// with the mask.
+func (s *AddressSpace) ReserveIP4Net(ipNet *net.IPNet) (*AddressSpace, error) {
+	if ipNet.IP != nil && !isIP4(ipNet.IP) {
+		return nil, fmt.Errorf("%s is not an IPv4 network", ipNet)
+	}
+
+	if ipNet.Mask != nil && !isIP4Mask(ipNet.Mask) {
+		return nil, fmt.Errorf("mask %s is not an IPv4 mask", ipNet.Mask)
+	}
+
+	return s.ReserveIPNet(ipNet)
+}
+
 func (s *AddressSpace) reserveSubRange(firstIP net.IP, lastIP net.IP, index int) {
 	before, _, after := splitRange(s.availableRanges[index], firstIP, lastIP)
 	s.availableRanges = append(s.availableRanges[:index], s.availableRanges[index+1:]...)
@@ -294,11 +405,19 @@ func (s *AddressSpace) reserveSubRange(firstIP net.IP, lastIP net.IP, index int)
 	}
 }
 
-// ReserveIP4Range reserves a sub address space given a first and last IP.
-func (s *AddressSpace) ReserveIP4Range(firstIP net.IP, lastIP net.IP) (*AddressSpace, error) {
+// ReserveIPRange reserves a sub address space given a first and last IP.
+func (s *AddressSpace) ReserveIPRange(firstIP net.IP, lastIP net.IP) (*AddressSpace, error) {
+	if isIP4(firstIP) != isIP4(lastIP) {
+		return nil, fmt.Errorf("%s and %s are not of the same address family", firstIP, lastIP)
+	}
+
 	for i, r := range s.availableRanges {
-		if compareIP4(firstIP, r.FirstIP) < 0 ||
-			compareIP4(lastIP, r.LastIP) > 0 {
+		if isIP4(firstIP) != isIP4(r.FirstIP) {
+			continue
+		}
+
+		if compareIP(firstIP, r.FirstIP) < 0 ||
+			compareIP(lastIP, r.LastIP) > 0 {
 			continue
 		}
 
@@ -312,6 +431,15 @@ func (s *AddressSpace) ReserveIP4Range(firstIP net.IP, lastIP net.IP) (*AddressS
 	return nil, fmt.Errorf("could not find IP range")
 }
 
+// ReserveIP4Range reserves a sub address space given a first and last IPv4 address.
+func (s *AddressSpace) ReserveIP4Range(firstIP net.IP, lastIP net.IP) (*AddressSpace, error) {
+	if !isIP4(firstIP) || !isIP4(lastIP) {
+		return nil, fmt.Errorf("%s-%s is not an IPv4 range", firstIP, lastIP)
+	}
+
+	return s.ReserveIPRange(firstIP, lastIP)
+}
+
 func insertAddressRanges(r []*IPRange, index int, ranges ...*IPRange) []*IPRange {
 	if index == len(r) {
 		return append(r, ranges...)
@@ -329,6 +457,26 @@ func insertAddressRanges(r []*IPRange, index int, ranges ...*IPRange) []*IPRange
 	return r
 }
 
+// ReserveNextIP reserves the next available address. The address
+// is of the family of the first available range in the space.
+func (s *AddressSpace) ReserveNextIP() (net.IP, error) {
+	if len(s.availableRanges) == 0 {
+		return nil, fmt.Errorf("could not find IP range")
+	}
+
+	mask := net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)
+	if isIP4(s.availableRanges[0].FirstIP) {
+		mask = net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)
+	}
+
+	space, err := s.ReserveIPNet(&net.IPNet{Mask: mask})
+	if err != nil {
+		return nil, err
+	}
+
+	return space.availableRanges[0].FirstIP, nil
+}
+
 // ReserveNextIP4 reserves the next available IPv4 address.
 func (s *AddressSpace) ReserveNextIP4() (net.IP, error) {
 	space, err := s.ReserveIP4Net(&net.IPNet{Mask: net.CIDRMask(32, 32)})
@@ -339,15 +487,21 @@ func (s *AddressSpace) ReserveNextIP4() (net.IP, error) {
 	return space.availableRanges[0].FirstIP, nil
 }
 
+// ReserveIP reserves the given IP address.
+func (s *AddressSpace) ReserveIP(ip net.IP) error {
+	_, err := s.ReserveIPRange(ip, ip)
+	return err
+}
+
 // ReserveIP4 reserves the given IPv4 address.
 func (s *AddressSpace) ReserveIP4(ip net.IP) error {
 	_, err := s.ReserveIP4Range(ip, ip)
 	return err
 }
 
-// ReleaseIP4Range releases a sub address space into the parent address space.
+// ReleaseIPRange releases a sub address space into the parent address space.
 // Sub address space has to have only a single available range.
-func (s *AddressSpace) ReleaseIP4Range(space *AddressSpace) error {
+func (s *AddressSpace) ReleaseIPRange(space *AddressSpace) error {
 	// nothing to release
 	if space == nil || len(space.availableRanges) == 0 {
 		return nil
@@ -364,25 +518,25 @@ func (s *AddressSpace) ReleaseIP4Range(space *AddressSpace) error {
 
 	firstIP := space.availableRanges[0].FirstIP
 	lastIP := space.availableRanges[0].LastIP
-	if compareIP4(firstIP, lastIP) > 0 {
+	if compareIP(firstIP, lastIP) > 0 {
 		return fmt.Errorf("address space first ip %s is greater than last ip %s", firstIP, lastIP)
 	}
 
 	i := 0
 	for ; i < len(s.availableRanges); i++ {
-		if compareIP4(lastIP, s.availableRanges[i].FirstIP) < 0 {
+		if compareIP(lastIP, s.availableRanges[i].FirstIP) < 0 {
 			if i == 0 {
 				break
 			}
 
-			if i > 0 && compareIP4(firstIP, s.availableRanges[i-1].LastIP) > 0 {
+			if i > 0 && compareIP(firstIP, s.availableRanges[i-1].LastIP) > 0 {
 				break
 			}
 		}
 	}
 
 	if i > 0 && i == len(s.availableRanges) {
-		if compareIP4(firstIP, s.availableRanges[i-1].LastIP) <= 0 {
+		if compareIP(firstIP, s.availableRanges[i-1].LastIP) <= 0 {
 			return fmt.Errorf("Could not release IP range")
 		}
 	}
@@ -392,18 +546,37 @@ func (s *AddressSpace) ReleaseIP4Range(space *AddressSpace) error {
 	return nil
 }
 
-// ReleaseIP4 releases the given IPv4 address.
-func (s *AddressSpace) ReleaseIP4(ip net.IP) error {
+// ReleaseIP4Range releases an IPv4 sub address space into the parent address space.
+// Sub address space has to have only a single available range.
+func (s *AddressSpace) ReleaseIP4Range(space *AddressSpace) error {
+	if space != nil && len(space.availableRanges) > 0 && !isIP4(space.availableRanges[0].FirstIP) {
+		return fmt.Errorf("%s is not an IPv4 range", space.availableRanges[0])
+	}
+
+	return s.ReleaseIPRange(space)
+}
+
+// ReleaseIP releases the given IP address.
+func (s *AddressSpace) ReleaseIP(ip net.IP) error {
 	tmp := NewAddressSpaceFromRange(ip, ip)
 	tmp.Parent = s
-	return s.ReleaseIP4Range(tmp)
+	return s.ReleaseIPRange(tmp)
+}
+
+// ReleaseIP4 releases the given IPv4 address.
+func (s *AddressSpace) ReleaseIP4(ip net.IP) error {
+	if !isIP4(ip) {
+		return fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+
+	return s.ReleaseIP(ip)
 }
 
 func (s *AddressSpace) Defragment() error {
 	for i := 1; i < len(s.availableRanges); {
 		first := s.availableRanges[i-1]
 		second := s.availableRanges[i]
-		if incrementIP4(first.LastIP).Equal(second.FirstIP) {
+		if isIP4(first.LastIP) == isIP4(second.FirstIP) && incrementIP(first.LastIP).Equal(second.FirstIP) {
 			first.LastIP = second.LastIP
 			s.availableRanges = append(s.availableRanges[:i], s.availableRanges[i+1:]...)
 		} else {
@@ -421,8 +594,8 @@ func (s *AddressSpace) Equal(other *AddressSpace) bool {
 	}
 
 	for i := 0; i < len(s.availableRanges); i++ {
-		if compareIP4(s.availableRanges[i].FirstIP, other.availableRanges[i].FirstIP) != 0 ||
-			compareIP4(s.availableRanges[i].LastIP, other.availableRanges[i].LastIP) != 0 {
+		if compareIP(s.availableRanges[i].FirstIP, other.availableRanges[i].FirstIP) != 0 ||
+			compareIP(s.availableRanges[i].LastIP, other.availableRanges[i].LastIP) != 0 {
 			return false
 		}
 	}
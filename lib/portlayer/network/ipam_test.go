@@ -59,7 +59,7 @@ func TestDecrementIP4(t *testing.T) {
 	}
 }
 
-func TestCompareIP4(t *testing.T) {
+func TestCompareIP(t *testing.T) {
 	ips := []net.IP{
 		net.ParseIP("10.10.10.10"),
 		net.ParseIP("10.10.10.9"),
@@ -68,13 +68,13 @@ func TestCompareIP4(t *testing.T) {
 		net.ParseIP("9.9.9.9")}
 
 	for i := 0; i < len(ips)-1; i++ {
-		if res := compareIP4(ips[i+1], ips[i]); res != -1 {
+		if res := compareIP(ips[i+1], ips[i]); res != -1 {
 			t.Fatalf("comparing %s %s got: %v, expected: -1", ips[i+1], ips[i], res)
 		}
-		if res := compareIP4(ips[i], ips[i+1]); res != 1 {
+		if res := compareIP(ips[i], ips[i+1]); res != 1 {
 			t.Fatalf("comparing %s %s got: %v, expected: 1", ips[i], ips[i+1], res)
 		}
-		if res := compareIP4(ips[i], ips[i]); res != 0 {
+		if res := compareIP(ips[i], ips[i]); res != 0 {
 			t.Fatalf("comparing %s %s got: %v expected: 0", ips[i], ips[i], res)
 		}
 	}
@@ -91,10 +91,10 @@ func TestIsIP4(t *testing.T) {
 	}
 }
 
-func TestLowestIP4(t *testing.T) {
+func TestLowestIP(t *testing.T) {
 	r := &net.IPNet{IP: net.ParseIP("10.10.10.10").To4(), Mask: net.CIDRMask(24, 32)}
 	ip := net.ParseIP("10.10.10.0")
-	if res := lowestIP4(r); !res.Equal(ip) {
+	if res := lowestIP(r); !res.Equal(ip) {
 		t.Errorf("range %s got: %s expected %s", r, res, ip)
 	}
 }
@@ -115,6 +115,47 @@ func TestHighestIP4(t *testing.T) {
 	}
 }
 
+func TestIncrementIP(t *testing.T) {
+	var tests = []struct {
+		in  net.IP
+		out net.IP
+	}{
+		{net.ParseIP("10.10.10.255"), net.ParseIP("10.10.11.0")},
+		{net.ParseIP("fd00::ffff"), net.ParseIP("fd00::1:0")},
+		{net.ParseIP("fd00::ffff:ffff:ffff:ffff"), net.ParseIP("fd00:0:0:1::")},
+		{net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"), net.ParseIP("::")},
+	}
+
+	for _, te := range tests {
+		ip := incrementIP(te.in)
+		if !te.out.Equal(ip) {
+			t.Errorf("got: %s, expected: %s", ip, te.out)
+		}
+
+		if ip = decrementIP(te.out); !te.in.Equal(ip) {
+			t.Errorf("got: %s, expected: %s", ip, te.in)
+		}
+	}
+}
+
+func TestHighestIP(t *testing.T) {
+	var tests = []struct {
+		in  *net.IPNet
+		out net.IP
+	}{
+		{&net.IPNet{IP: net.ParseIP("10.10.10.10").To4(), Mask: net.CIDRMask(24, 32)}, net.ParseIP("10.10.10.255")},
+		{&net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00::ffff:ffff:ffff:ffff")},
+		{&net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(120, 128)}, net.ParseIP("fd00::ff")},
+		{&net.IPNet{IP: net.ParseIP("fd00::")}, nil},
+	}
+
+	for _, te := range tests {
+		if res := highestIP(te.in); !res.Equal(te.out) {
+			t.Errorf("range %s got: %s expected %s", te.in, res, te.out)
+		}
+	}
+}
+
 func TestReserveIP4(t *testing.T) {
 	space := NewAddressSpaceFromRange(net.ParseIP("10.10.10.10"),
 		net.ParseIP("10.10.10.11"))
@@ -190,10 +231,10 @@ func TestReserveNextIP4Net(t *testing.T) {
 	subspace, err := space.ReserveNextIP4Net(net.CIDRMask(16, 32))
 	for err == nil {
 		totalSubspaces++
-		if compareIP4(firstIP, subspace.availableRanges[0].FirstIP) != 0 {
+		if compareIP(firstIP, subspace.availableRanges[0].FirstIP) != 0 {
 			t.Errorf("got: %s, expected: %s", subspace.availableRanges[0].FirstIP, firstIP)
 		}
-		if compareIP4(lastIP, subspace.availableRanges[0].LastIP) != 0 {
+		if compareIP(lastIP, subspace.availableRanges[0].LastIP) != 0 {
 			t.Errorf("got: %s, expected: %s", subspace.availableRanges[0].LastIP, lastIP)
 		}
 		firstIP = net.IPv4(172, firstIP[13]+1, 0, 0)
@@ -213,13 +254,13 @@ func TestReserveNextIP4Net(t *testing.T) {
 	}
 	subSpace, err := space.ReserveNextIP4Net(net.CIDRMask(16, 32))
 	ip, err = subSpace.ReserveNextIP4()
-	if compareIP4(ip, net.ParseIP("172.17.0.0")) != 0 {
+	if compareIP(ip, net.ParseIP("172.17.0.0")) != 0 {
 		t.Errorf("got: %s, expected: %s", ip, net.ParseIP("172.17.0.0"))
 	}
 
 	subSpace, err = space.ReserveNextIP4Net(net.CIDRMask(15, 32))
 	ip, err = subSpace.ReserveNextIP4()
-	if compareIP4(ip, net.ParseIP("172.18.0.0")) != 0 {
+	if compareIP(ip, net.ParseIP("172.18.0.0")) != 0 {
 		t.Errorf("got: %s, expected: %s", ip, net.ParseIP("172.18.0.0"))
 	}
 }
@@ -353,7 +394,7 @@ func TestDefragment(t *testing.T) {
 	_, net1, _ := net.ParseCIDR("172.16.0.0/24")
 	space := NewAddressSpaceFromNetwork(net1)
 	ip, _ := space.ReserveNextIP4()
-	if compareIP4(ip, net.ParseIP("172.16.0.0")) != 0 {
+	if compareIP(ip, net.ParseIP("172.16.0.0")) != 0 {
 		t.Errorf("got: %s, expected: %s", ip, net.ParseIP("172.16.0.0"))
 	}
 
@@ -424,3 +465,100 @@ func TestIPRangeString(t *testing.T) {
 		}
 	}
 }
+
+func TestReserveIP6(t *testing.T) {
+	_, net1, _ := net.ParseCIDR("fd00:1::/120")
+	space := NewAddressSpaceFromNetwork(net1)
+
+	ip, err := space.ReserveNextIP()
+	expected := net.ParseIP("fd00:1::")
+	if err != nil || !ip.Equal(expected) {
+		t.Errorf("got: %s, %s expected: %s, nil", ip, err, expected)
+	}
+
+	if err = space.ReserveIP(net.ParseIP("fd00:1::1")); err != nil {
+		t.Errorf("got: %s expected: nil", err)
+	}
+
+	ip, err = space.ReserveNextIP()
+	expected = net.ParseIP("fd00:1::2")
+	if err != nil || !ip.Equal(expected) {
+		t.Errorf("got: %s, %s expected: %s, nil", ip, err, expected)
+	}
+
+	// outside the space
+	if err = space.ReserveIP(net.ParseIP("fd00:1::100")); err == nil {
+		t.Errorf("got: nil expected: error")
+	}
+
+	// the IPv4 calls do not take IPv6 addresses
+	if err = space.ReserveIP4(net.ParseIP("fd00:1::3")); err == nil {
+		t.Errorf("got: nil expected: error")
+	}
+	if _, err = space.ReserveNextIP4(); err == nil {
+		t.Errorf("got: nil expected: error")
+	}
+
+	if err = space.ReleaseIP(net.ParseIP("fd00:1::1")); err != nil {
+		t.Errorf("got: %s expected: nil", err)
+	}
+	if err = space.ReleaseIP(net.ParseIP("fd00:1::1")); err == nil {
+		t.Errorf("got: nil expected: error")
+	}
+
+	ip, err = space.ReserveNextIP()
+	expected = net.ParseIP("fd00:1::1")
+	if err != nil || !ip.Equal(expected) {
+		t.Errorf("got: %s, %s expected: %s, nil", ip, err, expected)
+	}
+}
+
+func TestReserveIP6Net(t *testing.T) {
+	_, net1, _ := net.ParseCIDR("fd00::/48")
+	space := NewAddressSpaceFromNetwork(net1)
+
+	// peal off one ip from the range
+	if _, err := space.ReserveNextIP(); err != nil {
+		t.Fatalf("got: %s, expected: nil", err)
+	}
+
+	// the next /64 starts after the reserved address
+	sub, err := space.ReserveNextIPNet(net.CIDRMask(64, 128))
+	if err != nil {
+		t.Fatalf("got: %s, expected: nil", err)
+	}
+	if expected := net.ParseIP("fd00:0:0:1::"); !sub.Network.IP.Equal(expected) {
+		t.Errorf("got: %s, expected: %s", sub.Network.IP, expected)
+	}
+	if expected := net.ParseIP("fd00:0:0:1:ffff:ffff:ffff:ffff"); !sub.availableRanges[0].LastIP.Equal(expected) {
+		t.Errorf("got: %s, expected: %s", sub.availableRanges[0].LastIP, expected)
+	}
+
+	_, net2, _ := net.ParseCIDR("fd00:0:0:2::/64")
+	sub2, err := space.ReserveIPNet(net2)
+	if err != nil {
+		t.Fatalf("got: %s, expected: nil", err)
+	}
+
+	// already reserved
+	if _, err = space.ReserveIPNet(net2); err == nil {
+		t.Errorf("got: nil, expected: error")
+	}
+
+	// an IPv4 mask does not match any IPv6 range
+	if _, err = space.ReserveNextIPNet(net.CIDRMask(24, 32)); err == nil {
+		t.Errorf("got: nil, expected: error")
+	}
+
+	if err = space.ReleaseIPRange(sub2); err != nil {
+		t.Errorf("got: %s, expected: nil", err)
+	}
+	if err = space.ReleaseIPRange(sub); err != nil {
+		t.Errorf("got: %s, expected: nil", err)
+	}
+
+	// the released ranges are merged back
+	if len(space.availableRanges) != 1 {
+		t.Errorf("got: %d, expected: 1", len(space.availableRanges))
+	}
+}
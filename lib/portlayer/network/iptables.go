@@ -0,0 +1,116 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// the names of the VCH appliance's interfaces, as set by the tether
+	externalInterface = "external"
+	bridgeInterface   = "bridge"
+
+	ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+)
+
+// iptablesMapper forwards ports on the VCH's external interface to
+// containers on the bridge network with DNAT rules. Connections made from
+// the VCH itself to one of its own addresses are forwarded as well.
+type iptablesMapper struct {
+	forwarding sync.Once
+}
+
+// rules returns the iptables rules that forward the port, less the operation
+func (m *iptablesMapper) rules(ip net.IP, port Port) [][]string {
+	hostPort := strconv.Itoa(port.HostPort)
+	containerPort := strconv.Itoa(port.ContainerPort)
+
+	return [][]string{
+		{"PREROUTING", "-t", "nat", "-i", externalInterface, "-p", port.Protocol,
+			"--dport", hostPort, "-j", "DNAT", "--to-destination", net.JoinHostPort(ip.String(), containerPort)},
+		// locally generated connections don't pass through PREROUTING; loopback is left
+		// alone as the kernel won't route it to another interface
+		{"OUTPUT", "-t", "nat", "!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL", "-p", port.Protocol,
+			"--dport", hostPort, "-j", "DNAT", "--to-destination", net.JoinHostPort(ip.String(), containerPort)},
+		{"FORWARD", "-i", externalInterface, "-o", bridgeInterface, "-p", port.Protocol,
+			"-d", ip.String(), "--dport", containerPort, "-j", "ACCEPT"},
+	}
+}
+
+func iptables(op string, rule []string) error {
+	args := append([]string{op}, rule...)
+
+	log.Debugf("iptables %s", strings.Join(args, " "))
+	if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables %s failed: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// MapPort adds the rules forwarding the port to ip
+func (m *iptablesMapper) MapPort(ip net.IP, port Port) error {
+	m.forwarding.Do(func() {
+		if err := ioutil.WriteFile(ipForwardPath, []byte("1"), 0644); err != nil {
+			log.Errorf("Failed to enable IP forwarding: %s", err)
+		}
+	})
+
+	var added [][]string
+	for _, rule := range m.rules(ip, port) {
+		// the rule is already in place when a running container is rebound
+		// after a restart of the port layer, so don't add a duplicate
+		if iptables("-C", rule) == nil {
+			continue
+		}
+
+		if err := iptables("-A", rule); err != nil {
+			// remove the rules added so far
+			for _, rule := range added {
+				if uerr := iptables("-D", rule); uerr != nil {
+					log.Warnf("Failed to remove rule: %s", uerr)
+				}
+			}
+			return err
+		}
+
+		added = append(added, rule)
+	}
+
+	return nil
+}
+
+// UnmapPort removes the rules forwarding the port to ip
+func (m *iptablesMapper) UnmapPort(ip net.IP, port Port) error {
+	var err error
+	for _, rule := range m.rules(ip, port) {
+		// carry on so that as much as possible is removed
+		if rerr := iptables("-D", rule); rerr != nil {
+			log.Warnf("Failed to remove rule: %s", rerr)
+			err = rerr
+		}
+	}
+
+	return err
+}
@@ -27,3 +27,13 @@ type ResourceNotFoundError struct {
 func (e DuplicateResourceError) Error() string {
 	return fmt.Sprintf("%s already exists", e.resID)
 }
+
+// PortInUseError is returned when a host port is already forwarded to
+// another container
+type PortInUseError struct {
+	port Port
+}
+
+func (e PortInUseError) Error() string {
+	return fmt.Sprintf("port %d/%s is already allocated", e.port.HostPort, e.port.Protocol)
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the range host ports are allocated from when none is given, the same as
+	// the linux default ephemeral port range
+	dynamicPortBegin = 32768
+	dynamicPortEnd   = 60999
+)
+
+// reservedPorts are used by the services of the VCH appliance itself, so
+// can't be forwarded to containers
+var reservedPorts = map[int]bool{
+	2375: true, // docker API
+	2376: true, // docker API with TLS
+	2377: true, // port layer API
+	2378: true, // vicadmin
+}
+
+// Port is a container port published on a port of the VCH
+type Port struct {
+	Protocol      string
+	HostPort      int
+	ContainerPort int
+}
+
+// ParsePort parses a port given as [hostPort:]containerPort[/protocol].  The
+// protocol is tcp unless given, and a host port of 0 is allocated when the
+// container is bound.
+func ParsePort(spec string) (Port, error) {
+	p := Port{Protocol: "tcp"}
+
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, p.Protocol = spec[:i], strings.ToLower(spec[i+1:])
+	}
+
+	if p.Protocol != "tcp" && p.Protocol != "udp" {
+		return Port{}, fmt.Errorf("invalid protocol in port %s", spec)
+	}
+
+	host, container := "", ports
+	if i := strings.LastIndex(ports, ":"); i >= 0 {
+		host, container = ports[:i], ports[i+1:]
+	}
+
+	var err error
+	if p.ContainerPort, err = parsePortNumber(container); err != nil || p.ContainerPort == 0 {
+		return Port{}, fmt.Errorf("invalid container port in port %s", spec)
+	}
+
+	if host != "" {
+		if p.HostPort, err = parsePortNumber(host); err != nil {
+			return Port{}, fmt.Errorf("invalid host port in port %s", spec)
+		}
+	}
+
+	return p, nil
+}
+
+func parsePortNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 0 || n > 65535 {
+		return 0, fmt.Errorf("port %d is out of range", n)
+	}
+
+	return n, nil
+}
+
+// String returns the port in the form accepted by ParsePort
+func (p Port) String() string {
+	if p.HostPort == 0 {
+		return fmt.Sprintf("%d/%s", p.ContainerPort, p.Protocol)
+	}
+
+	return fmt.Sprintf("%d:%d/%s", p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+func (p Port) hostKey() string {
+	return fmt.Sprintf("%d/%s", p.HostPort, p.Protocol)
+}
+
+// PortMapper forwards traffic arriving on host ports of the VCH to containers
+type PortMapper interface {
+	MapPort(ip net.IP, port Port) error
+	UnmapPort(ip net.IP, port Port) error
+}
+
+// portAllocator keeps track of the host ports in use, and allocates free
+// ports from the dynamic range
+type portAllocator struct {
+	used map[string]bool
+	next int
+}
+
+func newPortAllocator() *portAllocator {
+	return &portAllocator{
+		used: make(map[string]bool),
+		next: dynamicPortBegin,
+	}
+}
+
+// reserve marks the host port of p as used, allocating one if it has none.
+// The returned port has the host port filled in.
+func (a *portAllocator) reserve(p Port) (Port, error) {
+	if p.HostPort != 0 {
+		if reservedPorts[p.HostPort] || a.used[p.hostKey()] {
+			return Port{}, PortInUseError{port: p}
+		}
+
+		a.used[p.hostKey()] = true
+		return p, nil
+	}
+
+	for i := 0; i <= dynamicPortEnd-dynamicPortBegin; i++ {
+		p.HostPort = a.next
+		if a.next++; a.next > dynamicPortEnd {
+			a.next = dynamicPortBegin
+		}
+
+		if !a.used[p.hostKey()] {
+			a.used[p.hostKey()] = true
+			return p, nil
+		}
+	}
+
+	return Port{}, fmt.Errorf("no free %s ports to publish container port %d on", p.Protocol, p.ContainerPort)
+}
+
+func (a *portAllocator) release(p Port) {
+	delete(a.used, p.hostKey())
+}
@@ -0,0 +1,130 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+// mockMapper records the forwarded ports instead of programming iptables
+type mockMapper struct {
+	mapped map[string]string
+}
+
+func (m *mockMapper) MapPort(ip net.IP, port Port) error {
+	if m.mapped == nil {
+		m.mapped = make(map[string]string)
+	}
+
+	m.mapped[port.hostKey()] = ip.String()
+	return nil
+}
+
+func (m *mockMapper) UnmapPort(ip net.IP, port Port) error {
+	delete(m.mapped, port.hostKey())
+	return nil
+}
+
+func TestParsePort(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out *Port
+	}{
+		{"80", &Port{"tcp", 0, 80}},
+		{"8080:80", &Port{"tcp", 8080, 80}},
+		{"53/udp", &Port{"udp", 0, 53}},
+		{"5353:53/UDP", &Port{"udp", 5353, 53}},
+		{":80/tcp", &Port{"tcp", 0, 80}},
+		{"", nil},
+		{"0", nil},
+		{"foo", nil},
+		{"80/sctp", nil},
+		{"70000:80", nil},
+		{"8080:foo", nil},
+	}
+
+	for _, te := range tests {
+		p, err := ParsePort(te.in)
+		if te.out == nil {
+			if err == nil {
+				t.Errorf("ParsePort(%q) => (%v, nil), want error", te.in, p)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParsePort(%q) => %s", te.in, err)
+			continue
+		}
+
+		if p != *te.out {
+			t.Errorf("ParsePort(%q) => %v, want %v", te.in, p, *te.out)
+		}
+
+		// the string form parses to the same port
+		if p2, err := ParsePort(p.String()); err != nil || p2 != p {
+			t.Errorf("ParsePort(%q) => (%v, %v), want %v", p.String(), p2, err, p)
+		}
+	}
+}
+
+func TestPortAllocator(t *testing.T) {
+	a := newPortAllocator()
+
+	p, err := a.reserve(Port{"tcp", 8080, 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HostPort != 8080 {
+		t.Fatalf("reserve() => host port %d, want 8080", p.HostPort)
+	}
+
+	// the same host port can't be used twice for a protocol
+	if _, err = a.reserve(Port{"tcp", 8080, 81}); err == nil {
+		t.Fatalf("reserve() of port in use succeeded")
+	}
+	if _, ok := err.(PortInUseError); !ok {
+		t.Fatalf("reserve() => %#v, want PortInUseError", err)
+	}
+
+	// but can for another protocol
+	if _, err = a.reserve(Port{"udp", 8080, 80}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the appliance's own ports can't be used
+	if _, err = a.reserve(Port{"tcp", 2376, 80}); err == nil {
+		t.Fatalf("reserve() of the docker API port succeeded")
+	}
+
+	// ports without a host port are allocated one from the dynamic range
+	p1, err := a.reserve(Port{"tcp", 0, 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := a.reserve(Port{"tcp", 0, 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1.HostPort < dynamicPortBegin || p1.HostPort > dynamicPortEnd || p1.HostPort == p2.HostPort {
+		t.Fatalf("reserve() allocated %d and %d", p1.HostPort, p2.HostPort)
+	}
+
+	a.release(p)
+	if _, err = a.reserve(Port{"tcp", 8080, 80}); err != nil {
+		t.Fatalf("reserve() of released port => %s", err)
+	}
+}
@@ -83,12 +83,12 @@ func (s *Scope) reserveEndpointIP(e *Endpoint) error {
 	var err error
 	for _, p := range s.ipam.spaces {
 		if e.static {
-			if err = p.ReserveIP4(e.ip); err == nil {
+			if err = p.ReserveIP(e.ip); err == nil {
 				break
 			}
 		} else {
 			var ip net.IP
-			if ip, err = p.ReserveNextIP4(); err == nil {
+			if ip, err = p.ReserveNextIP(); err == nil {
 				e.ip = ip
 				break
 			}
@@ -100,7 +100,7 @@ func (s *Scope) reserveEndpointIP(e *Endpoint) error {
 
 func (s *Scope) releaseEndpointIP(e *Endpoint) error {
 	for _, p := range s.ipam.spaces {
-		if err := p.ReleaseIP4(e.ip); err == nil {
+		if err := p.ReleaseIP(e.ip); err == nil {
 			if !e.static {
 				e.ip = net.IPv4(0, 0, 0, 0)
 			}
@@ -139,7 +139,7 @@ func TestScopeAddRemoveContainer(t *testing.T) {
 	}
 
 	bound := exec.NewContainer("bound")
-	ctx.AddContainer(bound, ctx.defaultScope.Name(), nil)
+	ctx.AddContainer(bound, ctx.defaultScope.Name(), nil, nil)
 	ctx.BindContainer(bound)
 
 	// test RemoveContainer
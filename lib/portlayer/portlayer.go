@@ -60,6 +60,15 @@ func Init(ctx context.Context, sess *session.Session) error {
 	}
 	exec.Config.ResourcePool = r.(*object.ResourcePool)
 
+	// rediscover any containers that already exist
+	if err = exec.Sync(ctx, sess); err != nil {
+		log.Errorf("could not sync containers from resource pool: %s", err)
+		return err
+	}
+
+	extraconfig.Decode(source, &storage.Config)
+	log.Debugf("Decoded VCH config for storage: %#v", storage.Config)
+
 	extraconfig.Decode(source, &network.Config)
 	log.Debugf("Decoded VCH config for network: %#v", network.Config)
 	for nn, n := range network.Config.ContainerNetworks {
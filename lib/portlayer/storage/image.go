@@ -16,6 +16,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"path/filepath"
@@ -72,9 +73,11 @@ type ImageStorer interface {
 	// parent - The parent image to create the new image from.
 	// ID - textual ID for the image to be written
 	// meta - metadata associated with the image
+	// sum - the expected sha256 digest of the image tar, as "sha256:<hex>".
+	// A mismatch is returned as a *DigestMismatchError and nothing is stored.
 	// r - the image tar to be written
-	WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, r io.Reader) (*Image,
-		error)
+	WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string,
+		r io.Reader) (*Image, error)
 
 	// GetImage queries the image store for the specified image.
 	//
@@ -85,6 +88,37 @@ type ImageStorer interface {
 	// ListImages returns a list of Images given a list of image IDs, or all
 	// images in the image store if no param is passed.
 	ListImages(ctx context.Context, store *url.URL, IDs []string) ([]*Image, error)
+
+	// GetImageTar returns the image layer as a tar stream holding the changes
+	// it makes to its parent, as used by docker to transfer layers.  The
+	// stream must be closed to release the resources backing it.
+	GetImageTar(ctx context.Context, image *Image) (io.ReadCloser, error)
+
+	// GetDiskTar returns the changes a disk descended from the parent image,
+	// such as the disk of a container, makes to the image as a tar stream in
+	// the same format as GetImageTar.  disk is a location in the backing store.
+	GetDiskTar(ctx context.Context, parent *Image, disk string) (io.ReadCloser, error)
+
+	// DeleteImage removes the image layer from the image store.  Layers that
+	// other layers are descended from are refused with ErrImageInUse.
+	DeleteImage(ctx context.Context, image *Image) error
+}
+
+// ErrImageInUse is returned when deleting an image layer that is still
+// referenced, either as the parent of another layer or by a container.
+var ErrImageInUse = errors.New("image in use")
+
+// DigestMismatchError is returned when the tar written for an image layer
+// doesn't match the expected digest, eg because it was corrupted in transit.
+// The layer isn't stored so the write can be retried.
+type DigestMismatchError struct {
+	ID       string
+	Expected string
+	Actual   string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("Failed to validate image %s checksum. Expected %s, got %s", e.ID, e.Expected, e.Actual)
 }
 
 func Parse(u *url.URL) (*Image, error) {
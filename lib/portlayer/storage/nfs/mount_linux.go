@@ -0,0 +1,76 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// kernelMountServer mounts exports with the kernel's NFS client
+type kernelMountServer struct{}
+
+// NewMountServer returns a MountServer that mounts exports in temporary
+// directories
+func NewMountServer() MountServer {
+	return &kernelMountServer{}
+}
+
+func (k *kernelMountServer) Mount(u *url.URL) (Target, error) {
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("%s is not an NFS URL", u.String())
+	}
+
+	// the kernel wants the address of the server as well as its name
+	ips, err := net.LookupIP(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "nfs")
+	if err != nil {
+		return nil, err
+	}
+
+	source := fmt.Sprintf("%s:%s", u.Host, exportPath(u))
+	data := fmt.Sprintf("addr=%s,%s", ips[0], MountOptions)
+
+	log.Debugf("Mounting %s on %s (%s)", source, dir, data)
+	if err = syscall.Mount(source, dir, "nfs", 0, data); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("mounting %s failed: %s", u.String(), err)
+	}
+
+	return DirTarget(dir), nil
+}
+
+func (k *kernelMountServer) Unmount(t Target) error {
+	dir, ok := t.(DirTarget)
+	if !ok {
+		return fmt.Errorf("%v was not mounted by this server", t)
+	}
+
+	if err := syscall.Unmount(string(dir), 0); err != nil {
+		return fmt.Errorf("unmounting %s failed: %s", dir, err)
+	}
+
+	return os.Remove(string(dir))
+}
@@ -0,0 +1,74 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Target is the filesystem of an NFS export.  Paths are relative to the
+// exported directory.
+type Target interface {
+	Mkdir(path string, perm os.FileMode) error
+	RemoveAll(path string) error
+	ReadDir(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+}
+
+// MountServer gives access to the exports of NFS servers
+type MountServer interface {
+	// Mount returns the filesystem of the export given as nfs://host/path
+	Mount(u *url.URL) (Target, error)
+
+	// Unmount releases a filesystem returned by Mount
+	Unmount(t Target) error
+}
+
+// DirTarget is a Target for an export that is available as a local
+// directory, such as where it's mounted
+type DirTarget string
+
+func (d DirTarget) path(p string) string {
+	return filepath.Join(string(d), filepath.FromSlash(p))
+}
+
+func (d DirTarget) Mkdir(path string, perm os.FileMode) error {
+	return os.Mkdir(d.path(path), perm)
+}
+
+func (d DirTarget) RemoveAll(path string) error {
+	return os.RemoveAll(d.path(path))
+}
+
+func (d DirTarget) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(d.path(path))
+}
+
+func (d DirTarget) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(d.path(path))
+}
+
+func (d DirTarget) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(path))
+}
+
+func (d DirTarget) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(d.path(path), data, perm)
+}
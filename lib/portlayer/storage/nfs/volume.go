@@ -0,0 +1,383 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	portlayer "github.com/vmware/vic/lib/portlayer/storage"
+	"github.com/vmware/vic/lib/portlayer/util"
+	"github.com/vmware/vic/pkg/trace"
+	"golang.org/x/net/context"
+)
+
+const (
+	// Scheme is the URL scheme of NFS volume locations and volumes
+	Scheme = "nfs"
+
+	// MountOptions are the options NFS exports are mounted with, both here
+	// and by the tether in the containerVM
+	MountOptions = "vers=3,proto=tcp,nolock"
+
+	// volumes live here under the exported directory
+	storageVolumeDir  = "volumes"
+	volumeMetadataDir = "volumes_metadata"
+)
+
+// VolumeStore creates volumes as directories on the NFS exports given as
+// volume locations in the VCH config.  A volume is laid out as
+// `<export>/volumes/<volume name>` with its metadata in
+// `<export>/volumes_metadata/<volume name>`.  The volume directory is mounted
+// directly by the containerVMs, so a volume can be mounted by several
+// containers at once.
+type VolumeStore struct {
+	mounter MountServer
+
+	// The exports backing the volume stores, keyed by volume store name
+	exports map[string]url.URL
+}
+
+// NewVolumeStore returns a VolumeStore for the volume locations, keyed by
+// volume store name.  A location is a URL of the form nfs://<host>/<export>.
+// Each export is mounted to check it's usable and lay out the store.
+func NewVolumeStore(ctx context.Context, locations map[string]url.URL, mounter MountServer) (*VolumeStore, error) {
+	v := &VolumeStore{
+		mounter: mounter,
+		exports: make(map[string]url.URL),
+	}
+
+	for name, location := range locations {
+		if location.Scheme != Scheme || location.Host == "" {
+			return nil, fmt.Errorf("volume store %s: %s is not an NFS location", name, location.String())
+		}
+
+		err := v.withTarget(&location, func(t Target) error {
+			for _, dir := range []string{storageVolumeDir, volumeMetadataDir} {
+				if err := t.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("volume store %s: %s", name, err)
+		}
+
+		log.Infof("Volume store %s is at %s", name, location.String())
+		v.exports[name] = location
+	}
+
+	return v, nil
+}
+
+// exportPath returns the path of the exported directory in an NFS URL
+func exportPath(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+// withTarget mounts the export for the duration of fn
+func (v *VolumeStore) withTarget(export *url.URL, fn func(t Target) error) error {
+	t, err := v.mounter.Mount(export)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := v.mounter.Unmount(t); err != nil {
+			log.Errorf("Failed to unmount %s: %s", export.String(), err)
+		}
+	}()
+
+	return fn(t)
+}
+
+// validID checks that the volume name can be used as a directory name
+func validID(ID string) error {
+	if ID == "" || ID == "." || ID == ".." || strings.Contains(ID, "/") {
+		return fmt.Errorf("%q is not a valid volume name", ID)
+	}
+	return nil
+}
+
+// Returns the path to the volume's directory, relative to the export
+func (v *VolumeStore) volumeDirPath(ID string) string {
+	return path.Join(storageVolumeDir, ID)
+}
+
+// Returns the path to the metadata directory for a volume, relative to the export
+func (v *VolumeStore) volumeMetadataDirPath(ID string) string {
+	return path.Join(volumeMetadataDir, ID)
+}
+
+// VolumeStoresList returns the URLs of the volume stores, keyed by name
+func (v *VolumeStore) VolumeStoresList(ctx context.Context) (map[string]url.URL, error) {
+	stores := make(map[string]url.URL)
+	for name := range v.exports {
+		u, err := util.VolumeStoreNameToURL(name)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = *u
+	}
+
+	return stores, nil
+}
+
+func (v *VolumeStore) store(store *url.URL) (string, *url.URL, error) {
+	storeName, err := util.VolumeStoreName(store)
+	if err != nil {
+		return "", nil, err
+	}
+
+	export, ok := v.exports[storeName]
+	if !ok {
+		return "", nil, fmt.Errorf("volume store %s not found", storeName)
+	}
+
+	return storeName, &export, nil
+}
+
+// VolumeCreate creates the volume's directory on the export.  The capacity is
+// ignored as the volume shares the space of the export.
+func (v *VolumeStore) VolumeCreate(ctx context.Context, ID string, store *url.URL, capacityKB uint64, info map[string][]byte) (*portlayer.Volume, error) {
+	if err := validID(ID); err != nil {
+		return nil, err
+	}
+
+	storeName, export, err := v.store(store)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Creating volume %s on %s", ID, export.String())
+	err = v.withTarget(export, func(t Target) error {
+		if err := t.Mkdir(v.volumeDirPath(ID), 0755); err != nil {
+			return err
+		}
+
+		if err := writeMetadata(t, v.volumeMetadataDirPath(ID), info); err != nil {
+			// don't leave a partial volume behind
+			log.Infof("Removing partially created volume %s", ID)
+			if rerr := t.RemoveAll(v.volumeDirPath(ID)); rerr != nil {
+				log.Errorf("Failed to remove volume %s: %s", ID, rerr)
+			}
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.newVolume(storeName, export, ID, info)
+}
+
+func (v *VolumeStore) newVolume(storeName string, export *url.URL, ID string, info map[string][]byte) (*portlayer.Volume, error) {
+	store, err := util.VolumeStoreNameToURL(storeName)
+	if err != nil {
+		return nil, err
+	}
+
+	selfLink, err := util.VolumeURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	device := *export
+	device.Path = path.Join(exportPath(export), v.volumeDirPath(ID))
+
+	return &portlayer.Volume{
+		ID:       ID,
+		Store:    store,
+		SelfLink: selfLink,
+		Device:   device.String(),
+		Info:     info,
+	}, nil
+}
+
+// VolumeDestroy removes the volume's directory, and everything in it, from
+// the export.  Nothing stops a volume from being removed while containers
+// have it mounted, the caller must check that it's not in use.
+func (v *VolumeStore) VolumeDestroy(ctx context.Context, vol *portlayer.Volume) error {
+	_, export, err := v.store(vol.Store)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Removing volume %s (%s)", vol.ID, vol.Device)
+	return v.withTarget(export, func(t Target) error {
+		if err := t.RemoveAll(v.volumeDirPath(vol.ID)); err != nil {
+			return err
+		}
+
+		return t.RemoveAll(v.volumeMetadataDirPath(vol.ID))
+	})
+}
+
+// VolumeGet returns the named volume from whichever store holds it
+func (v *VolumeStore) VolumeGet(ctx context.Context, ID string) (*portlayer.Volume, error) {
+	if err := validID(ID); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	for storeName := range v.exports {
+		export := v.exports[storeName]
+
+		var vol *portlayer.Volume
+		err := v.withTarget(&export, func(t Target) error {
+			fi, err := t.Stat(v.volumeDirPath(ID))
+			if err != nil {
+				return err
+			}
+
+			if !fi.IsDir() {
+				return os.ErrNotExist
+			}
+
+			vol, err = v.volumeGet(t, storeName, &export, ID)
+			return err
+		})
+		if err != nil {
+			continue
+		}
+
+		return vol, nil
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (v *VolumeStore) volumeGet(t Target, storeName string, export *url.URL, ID string) (*portlayer.Volume, error) {
+	info, err := getMetadata(t, v.volumeMetadataDirPath(ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return v.newVolume(storeName, export, ID, info)
+}
+
+// VolumesList returns the volumes in all of the stores
+func (v *VolumeStore) VolumesList(ctx context.Context) ([]*portlayer.Volume, error) {
+	var vols []*portlayer.Volume
+
+	for storeName := range v.exports {
+		export := v.exports[storeName]
+
+		err := v.withTarget(&export, func(t Target) error {
+			files, err := t.ReadDir(storageVolumeDir)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range files {
+				if !f.IsDir() {
+					continue
+				}
+
+				vol, err := v.volumeGet(t, storeName, &export, f.Name())
+				if err != nil {
+					log.Warnf("Skipping %s in volume store %s: %s", f.Name(), storeName, err)
+					continue
+				}
+
+				vols = append(vols, vol)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("volume store %s: %s", storeName, err)
+		}
+	}
+
+	return vols, nil
+}
+
+// writeMetadata writes each key of the metadata to a file of that name in dir
+func writeMetadata(t Target, dir string, info map[string][]byte) error {
+	if err := t.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	for key, value := range info {
+		if err := t.WriteFile(path.Join(dir, key), value, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getMetadata reads the metadata written by writeMetadata
+func getMetadata(t Target, dir string) (map[string][]byte, error) {
+	files, err := t.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string][]byte)
+	for _, f := range files {
+		value, err := t.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		info[f.Name()] = value
+	}
+
+	return info, nil
+}
+
+// VolumeJoin adds a mount spec to the container's config for the tether to
+// mount the volume's directory at mountPath with the given mode.  Unlike a
+// disk, the directory can be mounted by any number of containers at once.
+func VolumeJoin(h *exec.Handle, vol *portlayer.Volume, mountPath string, mode string) (*exec.Handle, error) {
+	defer trace.End(trace.Begin(vol.ID))
+
+	if _, ok := h.ExecConfig.Mounts[vol.ID]; ok {
+		return nil, fmt.Errorf("volume %s is already joined to container %s", vol.ID, h.ExecConfig.ID)
+	}
+
+	source, err := url.Parse(vol.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Scheme != Scheme {
+		return nil, fmt.Errorf("volume %s is not an NFS volume", vol.ID)
+	}
+
+	if h.ExecConfig.Mounts == nil {
+		h.ExecConfig.Mounts = make(map[string]metadata.MountSpec)
+	}
+
+	h.ExecConfig.Mounts[vol.ID] = metadata.MountSpec{
+		Source: *source,
+		Path:   mountPath,
+		Mode:   mode,
+	}
+
+	return h, nil
+}
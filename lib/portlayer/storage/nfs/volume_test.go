@@ -0,0 +1,191 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	portlayer "github.com/vmware/vic/lib/portlayer/storage"
+	"github.com/vmware/vic/lib/portlayer/util"
+	"golang.org/x/net/context"
+)
+
+// localServer stands in for NFS servers by serving exports from a local
+// directory, laid out as <root>/<host>/<export path>
+type localServer struct {
+	root    string
+	mounted int
+}
+
+func (l *localServer) Mount(u *url.URL) (Target, error) {
+	dir := filepath.Join(l.root, u.Host, filepath.FromSlash(u.Path))
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	l.mounted++
+	return DirTarget(dir), nil
+}
+
+func (l *localServer) Unmount(t Target) error {
+	l.mounted--
+	return nil
+}
+
+func setup(t *testing.T) (*localServer, *VolumeStore, func()) {
+	root, err := ioutil.TempDir("", "nfs-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, export := range []string{"server1/exports/vic", "server2/vols"} {
+		if err = os.MkdirAll(filepath.Join(root, filepath.FromSlash(export)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := &localServer{root: root}
+	locations := map[string]url.URL{
+		"shared": url.URL{Scheme: Scheme, Host: "server1", Path: "/exports/vic"},
+		"other":  url.URL{Scheme: Scheme, Host: "server2", Path: "/vols"},
+	}
+
+	vs, err := NewVolumeStore(context.TODO(), locations, server)
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+
+	return server, vs, func() { os.RemoveAll(root) }
+}
+
+func TestVolumeCreateGetListAndDestroy(t *testing.T) {
+	server, vs, cleanup := setup(t)
+	defer cleanup()
+
+	ctx := context.TODO()
+
+	stores, err := vs.VolumeStoresList(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, stores, 2)
+
+	store, err := util.VolumeStoreNameToURL("shared")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	info := map[string][]byte{"driver": []byte("vsphere")}
+	vol, err := vs.VolumeCreate(ctx, "foo", store, 0, info)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "nfs://server1/exports/vic/volumes/foo", vol.Device)
+	assert.Empty(t, vol.Label)
+
+	// the volume's directory is on the export
+	fi, err := os.Stat(filepath.Join(server.root, "server1", "exports", "vic", "volumes", "foo"))
+	if assert.NoError(t, err) {
+		assert.True(t, fi.IsDir())
+	}
+
+	// a volume can't be created twice
+	_, err = vs.VolumeCreate(ctx, "foo", store, 0, nil)
+	assert.Error(t, err)
+
+	// nor can it escape the store
+	_, err = vs.VolumeCreate(ctx, "../foo", store, 0, nil)
+	assert.Error(t, err)
+
+	got, err := vs.VolumeGet(ctx, "foo")
+	if assert.NoError(t, err) {
+		assert.Equal(t, vol, got)
+	}
+
+	_, err = vs.VolumeGet(ctx, "bar")
+	assert.True(t, os.IsNotExist(err))
+
+	vols, err := vs.VolumesList(ctx)
+	if assert.NoError(t, err) && assert.Len(t, vols, 1) {
+		assert.Equal(t, vol, vols[0])
+	}
+
+	if !assert.NoError(t, vs.VolumeDestroy(ctx, vol)) {
+		return
+	}
+
+	_, err = vs.VolumeGet(ctx, "foo")
+	assert.True(t, os.IsNotExist(err))
+
+	// every mount has been released
+	assert.Equal(t, 0, server.mounted)
+}
+
+func TestNewVolumeStore(t *testing.T) {
+	server, _, cleanup := setup(t)
+	defer cleanup()
+
+	// the export must exist
+	_, err := NewVolumeStore(context.TODO(), map[string]url.URL{
+		"missing": url.URL{Scheme: Scheme, Host: "server3", Path: "/vols"},
+	}, server)
+	assert.Error(t, err)
+
+	// and be an NFS location
+	_, err = NewVolumeStore(context.TODO(), map[string]url.URL{
+		"datastore": url.URL{Scheme: "ds", Host: "datastore1"},
+	}, server)
+	assert.Error(t, err)
+}
+
+func TestVolumeJoin(t *testing.T) {
+	vol := &portlayer.Volume{
+		ID:     "foo",
+		Device: "nfs://server1/exports/vic/volumes/foo",
+	}
+
+	// the same volume can be joined to any number of containers
+	for _, id := range []string{"c1", "c2"} {
+		h := &exec.Handle{}
+		h.ExecConfig.ID = id
+
+		h, err := VolumeJoin(h, vol, "/data", "rw")
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		mount, ok := h.ExecConfig.Mounts["foo"]
+		if !assert.True(t, ok) {
+			return
+		}
+		assert.Equal(t, vol.Device, mount.Source.String())
+		assert.Equal(t, "/data", mount.Path)
+		assert.Equal(t, "rw", mount.Mode)
+
+		// no disk is attached for an NFS volume
+		assert.Nil(t, h.Spec)
+
+		// but it can only be mounted once in each
+		_, err = VolumeJoin(h, vol, "/other", "ro")
+		assert.Error(t, err)
+	}
+}
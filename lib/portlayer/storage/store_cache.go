@@ -15,11 +15,11 @@
 package storage
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"path"
 	"sync"
 
 	"golang.org/x/net/context"
@@ -123,7 +123,7 @@ func (c *NameLookupCache) CreateImageStore(ctx context.Context, storeName string
 	defer c.storeCacheLock.Unlock()
 
 	// Create the root image
-	scratch, err := c.DataStore.WriteImage(ctx, &Image{Store: u}, Scratch.ID, nil, nil)
+	scratch, err := c.DataStore.WriteImage(ctx, &Image{Store: u}, Scratch.ID, nil, "", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -160,20 +160,11 @@ func (c *NameLookupCache) WriteImage(ctx context.Context, parent *Image, ID stri
 	}
 
 	// Definitely not in cache or image store, create image.
-	h := sha256.New()
-	t := io.TeeReader(r, h)
-
-	i, err = c.DataStore.WriteImage(ctx, p, ID, meta, t)
+	i, err = c.DataStore.WriteImage(ctx, p, ID, meta, sum, r)
 	if err != nil {
 		return nil, err
 	}
 
-	actualSum := fmt.Sprintf("sha256:%x", h.Sum(nil))
-	if actualSum != sum {
-		// TODO(jzt): cleanup?
-		return nil, fmt.Errorf("Failed to validate image checksum. Expected %s, got %s", sum, actualSum)
-	}
-
 	// Add the new image to the cache
 	c.storeCacheLock.Lock()
 	defer c.storeCacheLock.Unlock()
@@ -251,3 +242,64 @@ func (c *NameLookupCache) ListImages(ctx context.Context, store *url.URL, IDs []
 
 	return imageList, nil
 }
+
+// GetImageTar returns the image layer as a tar stream of its changes
+func (c *NameLookupCache) GetImageTar(ctx context.Context, image *Image) (io.ReadCloser, error) {
+	// Check the image exists.  This will populate the cache if it's empty.
+	i, err := c.GetImage(ctx, image.Store, image.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.DataStore.GetImageTar(ctx, i)
+}
+
+// GetDiskTar returns the changes a disk descended from the parent image makes to it as a tar stream
+func (c *NameLookupCache) GetDiskTar(ctx context.Context, parent *Image, disk string) (io.ReadCloser, error) {
+	// Check the parent exists.  This will populate the cache if it's empty.
+	p, err := c.GetImage(ctx, parent.Store, parent.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.DataStore.GetDiskTar(ctx, p, disk)
+}
+
+// DeleteImage removes the image layer from the image store, provided no other
+// layer in the store is descended from it.
+func (c *NameLookupCache) DeleteImage(ctx context.Context, image *Image) error {
+	// scratch is the root of every layer in the store
+	if image.ID == Scratch.ID {
+		return ErrImageInUse
+	}
+
+	// Check the image exists.  This will populate the cache if it's empty.
+	i, err := c.GetImage(ctx, image.Store, image.ID)
+	if err != nil {
+		return err
+	}
+
+	c.storeCacheLock.Lock()
+	for _, v := range c.storeCache[*i.Store] {
+		if v.Parent != nil && path.Base(v.Parent.Path) == i.ID {
+			c.storeCacheLock.Unlock()
+			log.Infof("Image %s is the parent of %s", i.ID, v.ID)
+			return ErrImageInUse
+		}
+	}
+
+	// Drop the image from the cache before deleting it so lookups and new
+	// children can't race with the delete, which can be slow on the
+	// datastore and must not hold the lock.
+	delete(c.storeCache[*i.Store], i.ID)
+	c.storeCacheLock.Unlock()
+
+	if err = c.DataStore.DeleteImage(ctx, i); err != nil {
+		c.storeCacheLock.Lock()
+		c.storeCache[*i.Store][i.ID] = *i
+		c.storeCacheLock.Unlock()
+		return err
+	}
+
+	return nil
+}
@@ -59,7 +59,7 @@ func (c *MockDataStore) ListImageStores(ctx context.Context) ([]*url.URL, error)
 	return nil, nil
 }
 
-func (c *MockDataStore) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, r io.Reader) (*Image, error) {
+func (c *MockDataStore) WriteImage(ctx context.Context, parent *Image, ID string, meta map[string][]byte, sum string, r io.Reader) (*Image, error) {
 	i := &Image{
 		ID:       ID,
 		Store:    parent.Store,
@@ -90,6 +90,24 @@ func (c *MockDataStore) ListImages(ctx context.Context, store *url.URL, IDs []st
 	return imageList, nil
 }
 
+func (c *MockDataStore) GetImageTar(ctx context.Context, image *Image) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (c *MockDataStore) GetDiskTar(ctx context.Context, parent *Image, disk string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// DeleteImage removes the image from the store
+func (c *MockDataStore) DeleteImage(ctx context.Context, image *Image) error {
+	if _, ok := c.db[*image.Store][image.ID]; !ok {
+		return fmt.Errorf("not found")
+	}
+
+	delete(c.db[*image.Store], image.ID)
+	return nil
+}
+
 func TestListImages(t *testing.T) {
 	s := NewLookupCache(NewMockDataStore())
 
@@ -169,6 +187,8 @@ func TestOutsideCacheWriteImage(t *testing.T) {
 		return
 	}
 
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
 	// Create a set of images
 	images := make(map[string]*Image)
 	parent := Scratch
@@ -177,7 +197,7 @@ func TestOutsideCacheWriteImage(t *testing.T) {
 		id := fmt.Sprintf("ID-%d", i)
 
 		// Write to the datastore creating images
-		img, werr := s.DataStore.WriteImage(context.TODO(), &parent, id, nil, nil)
+		img, werr := s.DataStore.WriteImage(context.TODO(), &parent, id, nil, testSum, nil)
 		if !assert.NoError(t, werr) {
 			return
 		}
@@ -188,7 +208,6 @@ func TestOutsideCacheWriteImage(t *testing.T) {
 		images[id] = img
 	}
 
-	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 	// Try to write the same images as above, but this time via the cache.  WriteImage should return right away without any data written.
 	for i := 1; i < 50; i++ {
 		id := fmt.Sprintf("ID-%d", i)
@@ -283,3 +302,86 @@ func TestImageStoreRestart(t *testing.T) {
 		}
 	}
 }
+
+func TestDeleteImage(t *testing.T) {
+	ds := NewMockDataStore()
+	s := NewLookupCache(ds)
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Create a chain of images
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	parent, err := s.GetImage(context.TODO(), storeURL, Scratch.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var chain []*Image
+	for i := 1; i < 4; i++ {
+		img, werr := s.WriteImage(context.TODO(), parent, fmt.Sprintf("ID-%d", i), nil, testSum, nil)
+		if !assert.NoError(t, werr) {
+			return
+		}
+
+		// the mock doesn't fill in the self link so do it here to give the children a parent
+		img.SelfLink, _ = util.ImageURL("testStore", img.ID)
+		s.storeCache[*storeURL][img.ID] = *img
+
+		chain = append(chain, img)
+		parent = img
+	}
+
+	// neither scratch nor a layer with children can be deleted
+	assert.Equal(t, ErrImageInUse, s.DeleteImage(context.TODO(), &Image{ID: Scratch.ID, Store: storeURL}))
+	assert.Equal(t, ErrImageInUse, s.DeleteImage(context.TODO(), chain[1]))
+
+	// the chain can be removed from the leaf up
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !assert.NoError(t, s.DeleteImage(context.TODO(), chain[i])) {
+			return
+		}
+
+		_, err = s.GetImage(context.TODO(), storeURL, chain[i].ID)
+		assert.Error(t, err, "image should be gone from both the cache and the datastore")
+	}
+
+	assert.Error(t, s.DeleteImage(context.TODO(), chain[0]), "deleting a missing image should fail")
+}
+
+// failingDeleteStore is a MockDataStore whose deletes always fail
+type failingDeleteStore struct {
+	*MockDataStore
+}
+
+func (c *failingDeleteStore) DeleteImage(ctx context.Context, image *Image) error {
+	return fmt.Errorf("delete failed")
+}
+
+func TestDeleteImageFailureKeepsCache(t *testing.T) {
+	s := NewLookupCache(&failingDeleteStore{NewMockDataStore()})
+
+	storeURL, err := s.CreateImageStore(context.TODO(), "testStore")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parent, err := s.GetImage(context.TODO(), storeURL, Scratch.ID)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testSum := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	img, err := s.WriteImage(context.TODO(), parent, "ID-1", nil, testSum, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Error(t, s.DeleteImage(context.TODO(), img))
+
+	// the image is put back in the cache when the datastore delete fails
+	_, ok := s.storeCache[*storeURL][img.ID]
+	assert.True(t, ok, "image should still be cached after a failed delete")
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"golang.org/x/net/context"
+)
+
+// volumeNamePattern is the pattern docker restricts volume names to.  Names
+// are used in paths on the volume stores so this keeps them in their store.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// ValidVolumeName returns an error if the name can't be used for a volume
+func ValidVolumeName(name string) error {
+	if !volumeNamePattern.MatchString(name) {
+		return fmt.Errorf("%q is not a valid volume name, only %s are allowed", name, volumeNamePattern.String())
+	}
+
+	return nil
+}
+
+// Volume is the handle to identify a volume on the backing store.  The URI
+// namespace used to identify the Volume in the storage layer has the
+// following path scheme:
+//
+// `/storage/volumes/<volume store identifier>/<volume name>`
+//
+type Volume struct {
+	// Identifier for this volume, the name it was created with.  Volume
+	// names are unique across the volume stores.
+	ID string
+
+	// Label of the filesystem on the volume, used to find it once attached
+	Label string
+
+	// The volume store the volume lives in
+	Store *url.URL
+
+	// location of the volume.  Filled in by the runtime.
+	SelfLink *url.URL
+
+	// Device is the location of the volume on the backing store, the
+	// datastore path of its disk or the nfs:// URL of its directory
+	Device string
+
+	// Metadata associated with the volume, eg the options it was created with
+	Info map[string][]byte
+}
+
+// VolumeStorer is an interface to create, remove and look up volumes in the
+// volume stores
+type VolumeStorer interface {
+
+	// VolumeStoresList returns the URLs of the volume stores, keyed by name
+	VolumeStoresList(ctx context.Context) (map[string]url.URL, error)
+
+	// VolumeCreate creates a volume with an empty filesystem in the volume
+	// store.
+	//
+	// ID - the name of the volume, which must be unique across the stores
+	// store - the volume store to create the volume in
+	// capacityKB - the size of the volume
+	// info - metadata associated with the volume
+	VolumeCreate(ctx context.Context, ID string, store *url.URL, capacityKB uint64, info map[string][]byte) (*Volume, error)
+
+	// VolumeDestroy removes the volume, and everything on it, from its store
+	VolumeDestroy(ctx context.Context, vol *Volume) error
+
+	// VolumeGet returns the named volume from whichever store holds it
+	VolumeGet(ctx context.Context, ID string) (*Volume, error)
+
+	// VolumesList returns the volumes in all of the stores
+	VolumesList(ctx context.Context) ([]*Volume, error)
+}
+
+// ErrVolumeInUse is returned when removing a volume that is attached to a
+// containerVM.
+var ErrVolumeInUse = errors.New("volume in use")
@@ -0,0 +1,207 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/vic/lib/portlayer/util"
+)
+
+// VolumeLookupCache keeps an in memory map of the volumes in the volume
+// stores, keyed by name, to avoid walking the datastores on every lookup.  It
+// also keeps volume names unique across the stores, which may be backed by
+// different VolumeStorer implementations.
+type VolumeLookupCache struct {
+
+	// The volumes by name.  The values are copies so they can't be changed
+	// outside of the API calls.
+	vlc     map[string]Volume
+	vlcLock sync.Mutex
+
+	// whether vlc has been loaded from the volume stores
+	loaded bool
+
+	// The volume store implementations.  These mutate the actual volumes.
+	VolumeStores []VolumeStorer
+}
+
+func NewVolumeLookupCache(vs ...VolumeStorer) *VolumeLookupCache {
+	return &VolumeLookupCache{
+		VolumeStores: vs,
+		vlc:          make(map[string]Volume),
+	}
+}
+
+// volumeStore returns the implementation holding the named volume store
+func (v *VolumeLookupCache) volumeStore(ctx context.Context, store *url.URL) (VolumeStorer, error) {
+	storeName, err := util.VolumeStoreName(store)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vs := range v.VolumeStores {
+		stores, err := vs.VolumeStoresList(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := stores[storeName]; ok {
+			return vs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("volume store %s not found", storeName)
+}
+
+// load fills the cache from the volume stores the first time it's called.
+// The caller must hold vlcLock.
+func (v *VolumeLookupCache) load(ctx context.Context) error {
+	if v.loaded {
+		return nil
+	}
+
+	log.Info("Refreshing volume cache from datastore.")
+	for _, vs := range v.VolumeStores {
+		vols, err := vs.VolumesList(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, vol := range vols {
+			log.Infof("Volumestore: Found volume %s on datastore.", vol.ID)
+			v.vlc[vol.ID] = *vol
+		}
+	}
+
+	v.loaded = true
+	return nil
+}
+
+// VolumeStoresList returns the URLs of the volume stores, keyed by name
+func (v *VolumeLookupCache) VolumeStoresList(ctx context.Context) (map[string]url.URL, error) {
+	all := make(map[string]url.URL)
+	for _, vs := range v.VolumeStores {
+		stores, err := vs.VolumeStoresList(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, u := range stores {
+			all[name] = u
+		}
+	}
+
+	return all, nil
+}
+
+// VolumeCreate creates the volume, provided no store already holds a volume
+// of the same name.  os.ErrExist is returned if one does.
+func (v *VolumeLookupCache) VolumeCreate(ctx context.Context, ID string, store *url.URL, capacityKB uint64, info map[string][]byte) (*Volume, error) {
+	vs, err := v.volumeStore(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	// hold the lock over the create so that two volumes of the same name
+	// can't be created at once
+	v.vlcLock.Lock()
+	defer v.vlcLock.Unlock()
+
+	if err = v.load(ctx); err != nil {
+		return nil, err
+	}
+
+	if _, ok := v.vlc[ID]; ok {
+		return nil, os.ErrExist
+	}
+
+	vol, err := vs.VolumeCreate(ctx, ID, store, capacityKB, info)
+	if err != nil {
+		return nil, err
+	}
+
+	v.vlc[vol.ID] = *vol
+	return vol, nil
+}
+
+// VolumeDestroy removes the volume from its store and the cache
+func (v *VolumeLookupCache) VolumeDestroy(ctx context.Context, vol *Volume) error {
+	v.vlcLock.Lock()
+	defer v.vlcLock.Unlock()
+
+	if err := v.load(ctx); err != nil {
+		return err
+	}
+
+	cached, ok := v.vlc[vol.ID]
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	vs, err := v.volumeStore(ctx, cached.Store)
+	if err != nil {
+		return err
+	}
+
+	if err = vs.VolumeDestroy(ctx, &cached); err != nil {
+		return err
+	}
+
+	delete(v.vlc, vol.ID)
+	return nil
+}
+
+// VolumeGet returns the named volume, or os.ErrNotExist if there is no such
+// volume
+func (v *VolumeLookupCache) VolumeGet(ctx context.Context, ID string) (*Volume, error) {
+	v.vlcLock.Lock()
+	defer v.vlcLock.Unlock()
+
+	if err := v.load(ctx); err != nil {
+		return nil, err
+	}
+
+	vol, ok := v.vlc[ID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return &vol, nil
+}
+
+// VolumesList returns the volumes in all of the stores
+func (v *VolumeLookupCache) VolumesList(ctx context.Context) ([]*Volume, error) {
+	v.vlcLock.Lock()
+	defer v.vlcLock.Unlock()
+
+	if err := v.load(ctx); err != nil {
+		return nil, err
+	}
+
+	vols := make([]*Volume, 0, len(v.vlc))
+	for _, vol := range v.vlc {
+		newVol := vol
+		vols = append(vols, &newVol)
+	}
+
+	return vols, nil
+}
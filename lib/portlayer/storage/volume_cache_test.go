@@ -0,0 +1,226 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"net/url"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/lib/portlayer/util"
+)
+
+type MockVolumeStore struct {
+	// store name -> volume name -> volume
+	db map[string]map[string]*Volume
+}
+
+func NewMockVolumeStore(stores ...string) *MockVolumeStore {
+	m := &MockVolumeStore{
+		db: make(map[string]map[string]*Volume),
+	}
+
+	for _, store := range stores {
+		m.db[store] = make(map[string]*Volume)
+	}
+
+	return m
+}
+
+func (m *MockVolumeStore) VolumeStoresList(ctx context.Context) (map[string]url.URL, error) {
+	stores := make(map[string]url.URL)
+	for name := range m.db {
+		u, err := util.VolumeStoreNameToURL(name)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = *u
+	}
+
+	return stores, nil
+}
+
+func (m *MockVolumeStore) VolumeCreate(ctx context.Context, ID string, store *url.URL, capacityKB uint64, info map[string][]byte) (*Volume, error) {
+	storeName, err := util.VolumeStoreName(store)
+	if err != nil {
+		return nil, err
+	}
+
+	selfLink, err := util.VolumeURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	vol := &Volume{
+		ID:       ID,
+		Store:    store,
+		SelfLink: selfLink,
+		Info:     info,
+	}
+
+	m.db[storeName][ID] = vol
+	return vol, nil
+}
+
+func (m *MockVolumeStore) VolumeDestroy(ctx context.Context, vol *Volume) error {
+	storeName, err := util.VolumeStoreName(vol.Store)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := m.db[storeName][vol.ID]; !ok {
+		return os.ErrNotExist
+	}
+
+	delete(m.db[storeName], vol.ID)
+	return nil
+}
+
+func (m *MockVolumeStore) VolumeGet(ctx context.Context, ID string) (*Volume, error) {
+	for _, vols := range m.db {
+		if vol, ok := vols[ID]; ok {
+			return vol, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (m *MockVolumeStore) VolumesList(ctx context.Context) ([]*Volume, error) {
+	var vols []*Volume
+	for _, store := range m.db {
+		for _, vol := range store {
+			vols = append(vols, vol)
+		}
+	}
+
+	return vols, nil
+}
+
+func TestVolumeCreateListAndDestroy(t *testing.T) {
+	mvs := NewMockVolumeStore("default", "fast")
+	v := NewVolumeLookupCache(mvs)
+
+	ctx := context.TODO()
+	defaultStore, _ := util.VolumeStoreNameToURL("default")
+	fastStore, _ := util.VolumeStoreNameToURL("fast")
+
+	info := map[string][]byte{"foo": []byte("bar")}
+	vol, err := v.VolumeCreate(ctx, "vol1", defaultStore, 1024, info)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "vol1", vol.ID)
+	assert.Equal(t, info, vol.Info)
+
+	// names are unique across the stores
+	_, err = v.VolumeCreate(ctx, "vol1", fastStore, 1024, nil)
+	assert.True(t, os.IsExist(err))
+
+	// the store has to exist
+	missing, _ := util.VolumeStoreNameToURL("missing")
+	_, err = v.VolumeCreate(ctx, "vol2", missing, 1024, nil)
+	assert.Error(t, err)
+
+	if _, err = v.VolumeCreate(ctx, "vol2", fastStore, 1024, nil); !assert.NoError(t, err) {
+		return
+	}
+
+	vols, err := v.VolumesList(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, vols, 2)
+
+	vol, err = v.VolumeGet(ctx, "vol2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, *fastStore, *vol.Store)
+
+	if !assert.NoError(t, v.VolumeDestroy(ctx, vol)) {
+		return
+	}
+	_, err = v.VolumeGet(ctx, "vol2")
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, os.IsNotExist(v.VolumeDestroy(ctx, vol)))
+	assert.Empty(t, mvs.db["fast"])
+}
+
+// The cache is filled from the volume stores on first use so volumes created
+// before a restart are still found
+func TestVolumeCacheLoad(t *testing.T) {
+	mvs := NewMockVolumeStore("default")
+
+	ctx := context.TODO()
+	store, _ := util.VolumeStoreNameToURL("default")
+	if _, err := mvs.VolumeCreate(ctx, "existing", store, 1024, nil); !assert.NoError(t, err) {
+		return
+	}
+
+	v := NewVolumeLookupCache(mvs)
+
+	vol, err := v.VolumeGet(ctx, "existing")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "existing", vol.ID)
+
+	_, err = v.VolumeCreate(ctx, "existing", store, 1024, nil)
+	assert.True(t, os.IsExist(err))
+}
+
+// Each volume store is served by the implementation that holds it, and names
+// stay unique across implementations
+func TestVolumeCacheMultipleStorers(t *testing.T) {
+	disks := NewMockVolumeStore("default")
+	shared := NewMockVolumeStore("shared")
+	v := NewVolumeLookupCache(disks, shared)
+
+	ctx := context.TODO()
+	defaultStore, _ := util.VolumeStoreNameToURL("default")
+	sharedStore, _ := util.VolumeStoreNameToURL("shared")
+
+	stores, err := v.VolumeStoresList(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, stores, 2)
+
+	if _, err = v.VolumeCreate(ctx, "vol1", defaultStore, 1024, nil); !assert.NoError(t, err) {
+		return
+	}
+	if _, err = v.VolumeCreate(ctx, "vol2", sharedStore, 1024, nil); !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, disks.db["default"], 1)
+	assert.Len(t, shared.db["shared"], 1)
+
+	_, err = v.VolumeCreate(ctx, "vol1", sharedStore, 1024, nil)
+	assert.True(t, os.IsExist(err))
+
+	vol, err := v.VolumeGet(ctx, "vol2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, v.VolumeDestroy(ctx, vol)) {
+		return
+	}
+	assert.Empty(t, shared.db["shared"])
+	assert.Len(t, disks.db["default"], 1)
+}
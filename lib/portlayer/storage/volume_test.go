@@ -0,0 +1,31 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidVolumeName(t *testing.T) {
+	for _, name := range []string{"data", "my-volume_1.0", "0123456789abcdef"} {
+		assert.NoError(t, ValidVolumeName(name), name)
+	}
+
+	for _, name := range []string{"", "a", "../x", "x/../../y", ".hidden", "-x", "with space"} {
+		assert.Error(t, ValidVolumeName(name), name)
+	}
+}
@@ -189,6 +189,18 @@ func (d *datastore) Mv(ctx context.Context, fromPath, toPath string) error {
 	return err
 }
 
+// Rm removes the given file or directory, and everything beneath it, from the datastore
+func (d *datastore) Rm(ctx context.Context, pth string) error {
+	f := path.Join(d.rooturl, pth)
+	log.Infof("Removing %s", f)
+
+	err := tasks.Wait(ctx, func(context.Context) (tasks.Waiter, error) {
+		return d.fm.DeleteDatastoreFile(ctx, f, d.s.Datacenter)
+	})
+
+	return err
+}
+
 func (d *datastore) IsVSAN(ctx context.Context) bool {
 	dsType, _ := d.ds.Type(ctx)
 	return dsType == types.HostFileSystemVolumeFileSystemTypeVsan
@@ -256,7 +268,7 @@ func (d *datastore) mkRootDir(ctx context.Context, rootdir string) error {
 	// dir does not exist
 	if err != nil && types.IsFileNotFound(err) {
 		log.Infof("Creating image store parent directory %s", d.rooturl)
-		err = d.fm.MakeDirectory(ctx, d.rooturl, d.s.Datacenter, false)
+		err = d.fm.MakeDirectory(ctx, d.rooturl, d.s.Datacenter, true)
 		if err != nil {
 			return err
 		}
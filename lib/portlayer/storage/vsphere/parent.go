@@ -82,6 +82,29 @@ func (p *parentM) Get(i string) string {
 	return p.db[i]
 }
 
+// Remove drops the parent relationship of image i
+func (p *parentM) Remove(i string) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	delete(p.db, i)
+}
+
+// Children returns the images whose parent is i
+func (p *parentM) Children(i string) []string {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	var children []string
+	for child, parent := range p.db {
+		if parent == i {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
 // Save persists the parent map to the datastore
 func (p *parentM) Save(ctx context.Context) error {
 	p.l.Lock()
@@ -99,3 +99,21 @@ func TestParentSaveRestore(t *testing.T) {
 		return
 	}
 }
+
+func TestParentChildrenRemove(t *testing.T) {
+	par := &parentM{
+		db: map[string]string{
+			"c1": "p",
+			"c2": "p",
+			"p":  "scratch",
+		},
+	}
+
+	assert.Len(t, par.Children("p"), 2)
+	assert.Equal(t, []string{"p"}, par.Children("scratch"))
+	assert.Empty(t, par.Children("c1"))
+
+	par.Remove("c1")
+	assert.Equal(t, []string{"c2"}, par.Children("p"))
+	assert.Equal(t, "", par.Get("c1"))
+}
@@ -16,6 +16,7 @@ package vsphere
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -25,6 +26,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/ioutils"
 	"github.com/vmware/govmomi/vim25/types"
 	portlayer "github.com/vmware/vic/lib/portlayer/storage"
 	"github.com/vmware/vic/lib/portlayer/util"
@@ -180,7 +182,7 @@ func (v *ImageStore) ListImageStores(ctx context.Context) ([]*url.URL, error) {
 // meta - metadata associated with the image
 // Tag - the tag of the image to be written
 func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID string, meta map[string][]byte,
-	r io.Reader) (*portlayer.Image, error) {
+	sum string, r io.Reader) (*portlayer.Image, error) {
 
 	storeName, err := util.ImageStoreName(parent.Store)
 	if err != nil {
@@ -199,70 +201,38 @@ func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID
 		return nil, err
 	}
 
-	imageDiskDsURI := v.imageDiskPath(storeName, ID)
-	log.Infof("Creating image %s (%s)", ID, imageDiskDsURI)
-
 	// If this is scratch, then it's the root of the image store.  All images
 	// will be descended from this created and prepared fs.
 	if ID == portlayer.Scratch.ID {
-		// Create the disk
-		vmdisk, cerr := v.dm.CreateAndAttach(ctx, imageDiskDsURI, "", defaultDiskSize, os.O_RDWR)
-		if cerr != nil {
-			return nil, cerr
-		}
-		defer v.dm.Detach(ctx, vmdisk)
-
-		// Make the filesystem and set its label to defaultDiskLabel
-		if cerr = vmdisk.Mkfs(defaultDiskLabel); cerr != nil {
-			return nil, cerr
-		}
+		err = v.writeScratch(ctx, storeName)
 	} else {
+		err = v.writeLayer(ctx, storeName, parent, ID, sum, r)
+	}
 
-		if parent.ID == "" {
-			return nil, fmt.Errorf("parent ID is empty")
-		}
-
-		// datastore path to the parent
-		parentDiskDsURI := v.imageDiskPath(storeName, parent.ID)
-
-		// Create the disk
-		vmdisk, cerr := v.dm.CreateAndAttach(ctx, imageDiskDsURI, parentDiskDsURI, 0, os.O_RDWR)
-		if cerr != nil {
-			return nil, cerr
-		}
-		defer v.dm.Detach(ctx, vmdisk)
-
-		dir, cerr := ioutil.TempDir("", "mnt-"+ID)
-		if cerr != nil {
-			return nil, cerr
-		}
-		defer os.RemoveAll(dir)
-
-		if merr := vmdisk.Mount(dir, nil); merr != nil {
-			return nil, merr
-		}
-		defer vmdisk.Unmount()
+	if err == nil {
+		// Write the metadata to the datastore
+		err = v.writeMeta(ctx, storeName, ID, meta)
+	}
 
-		// Untar the archive
-		cerr = archive.Untar(r, dir, &archive.TarOptions{})
-		if cerr != nil {
-			return nil, cerr
+	if err != nil {
+		// don't leave a partial layer behind, it would be picked up as a
+		// complete image on restart
+		log.Infof("Removing partially written image %s", ID)
+		if rerr := v.ds.Rm(ctx, imageDirDsURI); rerr != nil {
+			log.Errorf("Failed to remove image %s: %s", ID, rerr)
 		}
+		return nil, err
+	}
 
+	if ID != portlayer.Scratch.ID {
 		// persist the relationship
 		v.parents.Add(ID, parent.ID)
 
-		if cerr = v.parents.Save(ctx); cerr != nil {
-			return nil, cerr
+		if err = v.parents.Save(ctx); err != nil {
+			return nil, err
 		}
 	}
 
-	// Write the metadata to the datastore
-	err = v.writeMeta(ctx, storeName, ID, meta)
-	if err != nil {
-		return nil, err
-	}
-
 	newImage := &portlayer.Image{
 		ID:       ID,
 		SelfLink: imageURL,
@@ -274,6 +244,81 @@ func (v *ImageStore) WriteImage(ctx context.Context, parent *portlayer.Image, ID
 	return newImage, nil
 }
 
+// writeScratch creates the root disk of the image store with an empty filesystem
+func (v *ImageStore) writeScratch(ctx context.Context, storeName string) error {
+	imageDiskDsURI := v.imageDiskPath(storeName, portlayer.Scratch.ID)
+	log.Infof("Creating image %s (%s)", portlayer.Scratch.ID, imageDiskDsURI)
+
+	// Create the disk
+	vmdisk, err := v.dm.CreateAndAttach(ctx, imageDiskDsURI, "", defaultDiskSize, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer v.dm.Detach(ctx, vmdisk)
+
+	// Make the filesystem and set its label to defaultDiskLabel
+	return vmdisk.Mkfs(defaultDiskLabel)
+}
+
+// writeLayer creates a disk for the layer descended from the parent's disk
+// and extracts the tar into it.  The tar is hashed as it's extracted and the
+// layer is rejected if the digest doesn't match sum.  The disk is detached
+// by the time this returns so the caller can remove it on error.
+func (v *ImageStore) writeLayer(ctx context.Context, storeName string, parent *portlayer.Image, ID, sum string, r io.Reader) error {
+	if parent.ID == "" {
+		return fmt.Errorf("parent ID is empty")
+	}
+
+	imageDiskDsURI := v.imageDiskPath(storeName, ID)
+	log.Infof("Creating image %s (%s)", ID, imageDiskDsURI)
+
+	// datastore path to the parent
+	parentDiskDsURI := v.imageDiskPath(storeName, parent.ID)
+
+	// Create the disk
+	vmdisk, err := v.dm.CreateAndAttach(ctx, imageDiskDsURI, parentDiskDsURI, 0, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer v.dm.Detach(ctx, vmdisk)
+
+	dir, err := ioutil.TempDir("", "mnt-"+ID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err = vmdisk.Mount(dir, nil); err != nil {
+		return err
+	}
+	defer vmdisk.Unmount()
+
+	h := sha256.New()
+	t := io.TeeReader(r, h)
+
+	// Untar the archive
+	if err = archive.Untar(t, dir, &archive.TarOptions{}); err != nil {
+		return err
+	}
+
+	// the digest covers the whole stream, including any padding after the
+	// end of the archive which Untar leaves unread
+	if _, err = io.Copy(ioutil.Discard, t); err != nil {
+		return err
+	}
+
+	actualSum := fmt.Sprintf("sha256:%x", h.Sum(nil))
+	if actualSum != sum {
+		return &portlayer.DigestMismatchError{
+			ID:       ID,
+			Expected: sum,
+			Actual:   actualSum,
+		}
+	}
+
+	return nil
+}
+
 func (v *ImageStore) GetImage(ctx context.Context, store *url.URL, ID string) (*portlayer.Image, error) {
 
 	storeName, err := util.ImageStoreName(store)
@@ -356,28 +401,164 @@ func (v *ImageStore) ListImages(ctx context.Context, store *url.URL, IDs []strin
 	return images, nil
 }
 
-// Write the opaque metadata blobs (by name) for an image.  We create a
-// directory under the image's parent directory.  Each blob in the metadata map
-// is written to a file with the corresponding name.  Likewise, when we read it
-// back (on restart) we populate the map accordingly.
+// GetImageTar returns the layer as a tar stream in the docker layer format,
+// ie the changes it makes to its parent with removed files recorded as
+// whiteouts.  The layer and its parent are attached to this vm until the
+// returned stream is closed.
+func (v *ImageStore) GetImageTar(ctx context.Context, image *portlayer.Image) (io.ReadCloser, error) {
+	storeName, err := util.ImageStoreName(image.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	// scratch has no parent, its changes are relative to an empty directory
+	var parentDiskDsURI string
+	if parent := v.parents.Get(image.ID); parent != "" {
+		parentDiskDsURI = v.imageDiskPath(storeName, parent)
+	}
+
+	return v.exportDiff(ctx, image.ID, v.imageDiskPath(storeName, image.ID), parentDiskDsURI)
+}
+
+// GetDiskTar returns the changes the disk, given as a datastore path, makes
+// to the parent image as a tar stream in the docker layer format.  The disk
+// must be a descendant of the parent's disk and not be in use by another vm.
+func (v *ImageStore) GetDiskTar(ctx context.Context, parent *portlayer.Image, disk string) (io.ReadCloser, error) {
+	storeName, err := util.ImageStoreName(parent.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.exportDiff(ctx, path.Base(disk), disk, v.imageDiskPath(storeName, parent.ID))
+}
+
+// exportDiff attaches the disks read-only and returns a tar of the changes
+// between their filesystems.  An empty parent is treated as an empty
+// filesystem.  The disks stay attached until the returned stream is closed.
+func (v *ImageStore) exportDiff(ctx context.Context, name, diskDsURI, parentDiskDsURI string) (io.ReadCloser, error) {
+	// undo whatever has been set up when returning an error
+	var cleanup []func()
+	unwind := func() {
+		for i := len(cleanup) - 1; i >= 0; i-- {
+			cleanup[i]()
+		}
+	}
+
+	mount := func(diskDsURI string) (string, error) {
+		dir, err := ioutil.TempDir("", "mnt-"+name)
+		if err != nil {
+			return "", err
+		}
+		cleanup = append(cleanup, func() { os.RemoveAll(dir) })
+
+		if diskDsURI == "" {
+			return dir, nil
+		}
+
+		vmdisk, err := v.dm.Attach(ctx, diskDsURI)
+		if err != nil {
+			return "", err
+		}
+		cleanup = append(cleanup, func() { v.dm.Detach(ctx, vmdisk) })
+
+		if err = vmdisk.Mount(dir, []string{"ro"}); err != nil {
+			return "", err
+		}
+		cleanup = append(cleanup, func() { vmdisk.Unmount() })
+
+		return dir, nil
+	}
+
+	dir, err := mount(diskDsURI)
+	if err != nil {
+		unwind()
+		return nil, err
+	}
+
+	parentDir, err := mount(parentDiskDsURI)
+	if err != nil {
+		unwind()
+		return nil, err
+	}
+
+	changes, err := archive.ChangesDirs(dir, parentDir)
+	if err != nil {
+		unwind()
+		return nil, err
+	}
+
+	tar, err := archive.ExportChanges(dir, changes, nil, nil)
+	if err != nil {
+		unwind()
+		return nil, err
+	}
+
+	return ioutils.NewReadCloserWrapper(tar, func() error {
+		err := tar.Close()
+		unwind()
+		return err
+	}), nil
+}
+
+// DeleteImage removes the image's directory, including its disk and
+// metadata, from the datastore.  Layers that are the parent of other layers
+// are refused as deleting them would break the disk chain of their children.
+func (v *ImageStore) DeleteImage(ctx context.Context, image *portlayer.Image) error {
+	if image.ID == portlayer.Scratch.ID {
+		return portlayer.ErrImageInUse
+	}
+
+	storeName, err := util.ImageStoreName(image.Store)
+	if err != nil {
+		return err
+	}
+
+	if children := v.parents.Children(image.ID); len(children) > 0 {
+		log.Infof("Image %s is the parent of %v", image.ID, children)
+		return portlayer.ErrImageInUse
+	}
+
+	log.Infof("Deleting image %s (%s)", image.ID, v.imageDiskPath(storeName, image.ID))
+	if err = v.ds.Rm(ctx, v.imageDirPath(storeName, image.ID)); err != nil {
+		return err
+	}
+
+	// persist the relationship
+	v.parents.Remove(image.ID)
+
+	return v.parents.Save(ctx)
+}
+
+// Write the opaque metadata blobs (by name) for an image to a directory under
+// the image's directory.
 func (v *ImageStore) writeMeta(ctx context.Context, storeName string, ID string,
 	meta map[string][]byte) error {
+	return writeMetadata(ctx, v.ds, v.imageMetadataDirPath(storeName, ID), meta)
+}
+
+func (v *ImageStore) getMeta(ctx context.Context, storeName string, ID string) (map[string][]byte, error) {
+	return getMetadata(ctx, v.ds, v.imageMetadataDirPath(storeName, ID))
+}
+
+// writeMetadata writes the opaque metadata blobs (by name) to the given
+// directory.  Each blob in the metadata map is written to a file with the
+// corresponding name.  Likewise, when we read it back (on restart) we
+// populate the map accordingly.
+func writeMetadata(ctx context.Context, ds *datastore, metaDataDir string, meta map[string][]byte) error {
 	// XXX this should be done via disklib so this meta follows the disk in
 	// case of motion.
 
-	metaDataDir := v.imageMetadataDirPath(storeName, ID)
-
 	if meta != nil && len(meta) != 0 {
 		for name, value := range meta {
 			r := bytes.NewReader(value)
 			pth := path.Join(metaDataDir, name)
 			log.Infof("Writing metadata %s", pth)
-			if err := v.ds.Upload(ctx, r, pth); err != nil {
+			if err := ds.Upload(ctx, r, pth); err != nil {
 				return err
 			}
 		}
 	} else {
-		if _, err := v.ds.Mkdir(ctx, false, metaDataDir); err != nil {
+		if _, err := ds.Mkdir(ctx, false, metaDataDir); err != nil {
 			return err
 		}
 	}
@@ -385,10 +566,9 @@ func (v *ImageStore) writeMeta(ctx context.Context, storeName string, ID string,
 	return nil
 }
 
-func (v *ImageStore) getMeta(ctx context.Context, storeName string, ID string) (map[string][]byte, error) {
-	metaDataDir := v.imageMetadataDirPath(storeName, ID)
-
-	res, err := v.ds.Ls(ctx, metaDataDir)
+// getMetadata reads back the metadata blobs written by writeMetadata
+func getMetadata(ctx context.Context, ds *datastore, metaDataDir string) (map[string][]byte, error) {
+	res, err := ds.Ls(ctx, metaDataDir)
 	if err != nil {
 		return nil, err
 	}
@@ -401,8 +581,8 @@ func (v *ImageStore) getMeta(ctx context.Context, storeName string, ID string) (
 		}
 
 		p := path.Join(metaDataDir, finfo.Path)
-		log.Infof("Getting meta for image (%s) %s", ID, p)
-		rc, err := v.ds.Download(ctx, p)
+		log.Infof("Getting metadata %s", p)
+		rc, err := ds.Download(ctx, p)
 		if err != nil {
 			return nil, err
 		}
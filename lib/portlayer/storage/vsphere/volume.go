@@ -0,0 +1,315 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vsphere
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/lib/guest"
+	"github.com/vmware/vic/lib/metadata"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	portlayer "github.com/vmware/vic/lib/portlayer/storage"
+	"github.com/vmware/vic/lib/portlayer/util"
+	"github.com/vmware/vic/pkg/trace"
+	"github.com/vmware/vic/pkg/vsphere/disk"
+	"github.com/vmware/vic/pkg/vsphere/session"
+	"golang.org/x/net/context"
+)
+
+const (
+	// volumes live here on a volume store's datastore if its URL has no path
+	storageVolumeDir  = "volumes"
+	volumeMetadataDir = "volumeMetadata"
+
+	// the longest filesystem label ext4 allows
+	maxLabelLen = 16
+)
+
+// VolumeStore creates volumes as independent persistent disks in the
+// datastore directories given as volume locations in the VCH config.  A
+// volume is laid out as
+// `<volume store root>/<volume name>/<volume name>.vmdk`
+type VolumeStore struct {
+	dm *disk.Manager
+
+	// govmomi session
+	s *session.Session
+
+	// The datastores backing the volume stores, keyed by volume store name
+	ds map[string]*datastore
+}
+
+// NewVolumeStore returns a VolumeStore for the volume locations, keyed by
+// volume store name.  A location is a URL of the form ds://<datastore>/<path>.
+func NewVolumeStore(ctx context.Context, s *session.Session, locations map[string]url.URL) (*VolumeStore, error) {
+	dm, err := disk.NewDiskManager(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &VolumeStore{
+		dm: dm,
+		s:  s,
+		ds: make(map[string]*datastore),
+	}
+
+	for name, location := range locations {
+		dso, err := s.Finder.Datastore(ctx, location.Host)
+		if err != nil {
+			return nil, fmt.Errorf("volume store %s: %s", name, err)
+		}
+
+		root := strings.Trim(location.Path, "/")
+		if root == "" {
+			root = path.Join(storageParentDir, storageVolumeDir)
+		}
+
+		ds, err := newDatastore(ctx, s, dso, root)
+		if err != nil {
+			return nil, fmt.Errorf("volume store %s: %s", name, err)
+		}
+
+		log.Infof("Volume store %s is at %s", name, ds.rooturl)
+		v.ds[name] = ds
+	}
+
+	return v, nil
+}
+
+// volumeLabel returns the label of the filesystem on the named volume.
+// Labels are limited in length so the label is derived from a hash of the
+// name rather than the name itself.
+func volumeLabel(ID string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(ID)))[:maxLabelLen]
+}
+
+// Returns the path to the volume's disk
+func (v *VolumeStore) volumeDiskPath(ds *datastore, ID string) string {
+	return path.Join(ds.rooturl, ID, ID+".vmdk")
+}
+
+// Returns the path to the metadata directory for a volume
+func (v *VolumeStore) volumeMetadataDirPath(ID string) string {
+	return path.Join(ID, volumeMetadataDir)
+}
+
+// VolumeStoresList returns the URLs of the volume stores, keyed by name
+func (v *VolumeStore) VolumeStoresList(ctx context.Context) (map[string]url.URL, error) {
+	stores := make(map[string]url.URL)
+	for name := range v.ds {
+		u, err := util.VolumeStoreNameToURL(name)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = *u
+	}
+
+	return stores, nil
+}
+
+func (v *VolumeStore) store(store *url.URL) (string, *datastore, error) {
+	storeName, err := util.VolumeStoreName(store)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ds, ok := v.ds[storeName]
+	if !ok {
+		return "", nil, fmt.Errorf("volume store %s not found", storeName)
+	}
+
+	return storeName, ds, nil
+}
+
+// VolumeCreate creates the volume's disk in the store and makes a filesystem
+// on it
+func (v *VolumeStore) VolumeCreate(ctx context.Context, ID string, store *url.URL, capacityKB uint64, info map[string][]byte) (*portlayer.Volume, error) {
+	storeName, ds, err := v.store(store)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ds.Mkdir(ctx, false, ID); err != nil {
+		return nil, err
+	}
+
+	vol, err := v.volumeCreate(ctx, storeName, ds, ID, capacityKB, info)
+	if err != nil {
+		// don't leave a partial volume behind, it would be picked up as a
+		// complete volume on restart
+		log.Infof("Removing partially created volume %s", ID)
+		if rerr := ds.Rm(ctx, ID); rerr != nil {
+			log.Errorf("Failed to remove volume %s: %s", ID, rerr)
+		}
+		return nil, err
+	}
+
+	return vol, nil
+}
+
+func (v *VolumeStore) volumeCreate(ctx context.Context, storeName string, ds *datastore, ID string, capacityKB uint64, info map[string][]byte) (*portlayer.Volume, error) {
+	diskDsURI := v.volumeDiskPath(ds, ID)
+	log.Infof("Creating volume %s (%s)", ID, diskDsURI)
+
+	vmdisk, err := v.dm.CreateAndAttach(ctx, diskDsURI, "", int64(capacityKB), os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	defer v.dm.Detach(ctx, vmdisk)
+
+	if err = vmdisk.Mkfs(volumeLabel(ID)); err != nil {
+		return nil, err
+	}
+
+	if err = writeMetadata(ctx, ds, v.volumeMetadataDirPath(ID), info); err != nil {
+		return nil, err
+	}
+
+	return v.newVolume(storeName, ds, ID, info)
+}
+
+func (v *VolumeStore) newVolume(storeName string, ds *datastore, ID string, info map[string][]byte) (*portlayer.Volume, error) {
+	store, err := util.VolumeStoreNameToURL(storeName)
+	if err != nil {
+		return nil, err
+	}
+
+	selfLink, err := util.VolumeURL(storeName, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &portlayer.Volume{
+		ID:       ID,
+		Label:    volumeLabel(ID),
+		Store:    store,
+		SelfLink: selfLink,
+		Device:   v.volumeDiskPath(ds, ID),
+		Info:     info,
+	}, nil
+}
+
+// VolumeDestroy removes the volume's directory, including its disk and
+// metadata, from the datastore.  vSphere refuses to remove the disk of a
+// volume that is attached to a vm.
+func (v *VolumeStore) VolumeDestroy(ctx context.Context, vol *portlayer.Volume) error {
+	_, ds, err := v.store(vol.Store)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Removing volume %s (%s)", vol.ID, v.volumeDiskPath(ds, vol.ID))
+	if err = ds.Rm(ctx, vol.ID); err != nil {
+		if f, ok := err.(types.HasFault); ok {
+			if _, locked := f.Fault().(*types.FileLocked); locked {
+				return portlayer.ErrVolumeInUse
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// VolumeGet returns the named volume from whichever store holds it
+func (v *VolumeStore) VolumeGet(ctx context.Context, ID string) (*portlayer.Volume, error) {
+	for storeName, ds := range v.ds {
+		info, err := ds.Stat(ctx, ID)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := info.(*types.FolderFileInfo); !ok {
+			continue
+		}
+
+		return v.volumeGet(ctx, storeName, ds, ID)
+	}
+
+	return nil, os.ErrNotExist
+}
+
+func (v *VolumeStore) volumeGet(ctx context.Context, storeName string, ds *datastore, ID string) (*portlayer.Volume, error) {
+	info, err := getMetadata(ctx, ds, v.volumeMetadataDirPath(ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return v.newVolume(storeName, ds, ID, info)
+}
+
+// VolumesList returns the volumes in all of the stores
+func (v *VolumeStore) VolumesList(ctx context.Context) ([]*portlayer.Volume, error) {
+	var vols []*portlayer.Volume
+
+	for storeName, ds := range v.ds {
+		res, err := ds.Ls(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("volume store %s: %s", storeName, err)
+		}
+
+		for _, f := range res.File {
+			ID := f.GetFileInfo().Path
+
+			vol, err := v.volumeGet(ctx, storeName, ds, ID)
+			if err != nil {
+				log.Warnf("Skipping %s in volume store %s: %s", ID, storeName, err)
+				continue
+			}
+
+			vols = append(vols, vol)
+		}
+	}
+
+	return vols, nil
+}
+
+// VolumeJoin adds the volume's disk to the container's config, and a mount
+// spec for the tether to mount it at mountPath with the given mode.  The disk
+// is found in the guest by its filesystem label.
+func VolumeJoin(h *exec.Handle, vol *portlayer.Volume, mountPath string, mode string) (*exec.Handle, error) {
+	defer trace.End(trace.Begin(vol.ID))
+
+	if _, ok := h.ExecConfig.Mounts[vol.ID]; ok {
+		return nil, fmt.Errorf("volume %s is already joined to container %s", vol.ID, h.ExecConfig.ID)
+	}
+
+	if err := h.SetSpec(nil); err != nil {
+		return nil, err
+	}
+	h.Spec.AddVirtualVolume(guest.SCSIKey, vol.Device)
+
+	if h.ExecConfig.Mounts == nil {
+		h.ExecConfig.Mounts = make(map[string]metadata.MountSpec)
+	}
+
+	h.ExecConfig.Mounts[vol.ID] = metadata.MountSpec{
+		Source: url.URL{
+			Scheme: "label",
+			Host:   vol.Label,
+		},
+		Path: mountPath,
+		Mode: mode,
+	}
+
+	return h, nil
+}
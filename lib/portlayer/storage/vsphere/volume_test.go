@@ -0,0 +1,64 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vsphere
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/vic/lib/guest"
+	"github.com/vmware/vic/lib/portlayer/exec"
+	portlayer "github.com/vmware/vic/lib/portlayer/storage"
+)
+
+func TestVolumeJoin(t *testing.T) {
+	vol := &portlayer.Volume{
+		ID:     "foo",
+		Label:  volumeLabel("foo"),
+		Device: "[datastore1] VIC/volumes/foo/foo.vmdk",
+	}
+
+	h, err := VolumeJoin(&exec.Handle{}, vol, "/data", "ro")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	mount, ok := h.ExecConfig.Mounts["foo"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "label://"+vol.Label, mount.Source.String())
+	assert.Equal(t, "/data", mount.Path)
+	assert.Equal(t, "ro", mount.Mode)
+
+	if !assert.Len(t, h.Spec.DeviceChange, 1) {
+		return
+	}
+	disk := h.Spec.DeviceChange[0].GetVirtualDeviceConfigSpec().Device.(*types.VirtualDisk)
+	assert.EqualValues(t, guest.SCSIKey, disk.ControllerKey)
+	assert.Equal(t, vol.Device, disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo).FileName)
+
+	// a volume can only be mounted once
+	_, err = VolumeJoin(h, vol, "/other", "rw")
+	assert.Error(t, err)
+}
+
+func TestVolumeLabel(t *testing.T) {
+	label := volumeLabel("a volume name that is far longer than a filesystem label can be")
+	assert.Len(t, label, maxLabelLen)
+	assert.Equal(t, label, volumeLabel("a volume name that is far longer than a filesystem label can be"))
+	assert.NotEqual(t, label, volumeLabel("another volume"))
+}
@@ -66,3 +66,36 @@ func ImageURL(storeName, imageName string) (*url.URL, error) {
 func AppendDir(u *url.URL, dir string) {
 	u.Path = path.Join(u.Path, dir)
 }
+
+// VolumeStoreNameToURL returns the URL of the volume store in the form /storage/volumes/<volume store>
+func VolumeStoreNameToURL(storeName string) (*url.URL, error) {
+	a := ServiceURL(VolumeURLPath)
+	AppendDir(a, storeName)
+	return a, nil
+}
+
+// VolumeStoreName returns the name of the volume store from its URL, or the URL of a volume in it
+func VolumeStoreName(u *url.URL) (string, error) {
+	// Check the path isn't malformed.
+	if !filepath.IsAbs(u.Path) {
+		return "", errors.New("invalid uri path")
+	}
+
+	segments := strings.Split(filepath.Clean(u.Path), "/")[1:]
+
+	if len(segments) < 3 || path.Join(segments[:2]...) != VolumeURLPath {
+		return "", errors.New("not a volume store path")
+	}
+
+	return segments[2], nil
+}
+
+// VolumeURL returns the URL of the volume in the form /storage/volumes/<volume store>/<volume name>
+func VolumeURL(storeName, volumeName string) (*url.URL, error) {
+	u, err := VolumeStoreNameToURL(storeName)
+	if err != nil {
+		return nil, err
+	}
+	AppendDir(u, volumeName)
+	return u, nil
+}
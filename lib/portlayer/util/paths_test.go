@@ -71,3 +71,25 @@ func TestImageURL(t *testing.T) {
 		return
 	}
 }
+
+func TestVolumeStoreName(t *testing.T) {
+	DefaultHost, _ = url.Parse("http://foo.com/")
+
+	u, err := VolumeURL("default", "volume")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "http://foo.com/storage/volumes/default/volume", u.String())
+
+	store, err := VolumeStoreName(u)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "default", store)
+
+	for _, p := range []string{"fail", "/storage/volumes", "/storage/images/imgstore"} {
+		u, _ = url.Parse(p)
+		_, err = VolumeStoreName(u)
+		assert.Error(t, err, p)
+	}
+}
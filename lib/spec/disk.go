@@ -90,6 +90,32 @@ func (s *VirtualMachineConfigSpec) AddVirtualDisk(device *types.VirtualDisk) *Vi
 	return s.AddAndCreateVirtualDevice(device)
 }
 
+// AddVirtualVolume attaches an existing disk, such as a volume, to a virtual machine.
+// The disk is independent so that it is left out of snapshots of the virtual machine.
+func (s *VirtualMachineConfigSpec) AddVirtualVolume(controllerKey int32, fileName string) *VirtualMachineConfigSpec {
+	defer trace.End(trace.Begin(fileName))
+
+	// a negative unit number lets vSphere pick a free unit on the controller
+	unitNumber := int32(-1)
+
+	device := &types.VirtualDisk{
+		VirtualDevice: types.VirtualDevice{
+			Key:           s.generateNextKey(),
+			ControllerKey: controllerKey,
+			UnitNumber:    &unitNumber,
+			Backing: &types.VirtualDiskFlatVer2BackingInfo{
+				DiskMode: string(types.VirtualDiskModeIndependent_persistent),
+
+				VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{
+					FileName: fileName,
+				},
+			},
+		},
+	}
+
+	return s.AddVirtualDevice(device)
+}
+
 // RemoveVirtualDisk remvoes the virtual disk from a virtual machine.
 func (s *VirtualMachineConfigSpec) RemoveVirtualDisk(device *types.VirtualDisk) *VirtualMachineConfigSpec {
 	defer trace.End(trace.Begin(s.ID()))
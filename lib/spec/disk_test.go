@@ -0,0 +1,49 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestAddVirtualVolume(t *testing.T) {
+	s := &VirtualMachineConfigSpec{
+		VirtualMachineConfigSpec: &types.VirtualMachineConfigSpec{},
+	}
+
+	s.AddVirtualVolume(100, "[datastore1] volumes/foo/foo.vmdk")
+	if !assert.Len(t, s.DeviceChange, 1) {
+		return
+	}
+
+	change := s.DeviceChange[0].GetVirtualDeviceConfigSpec()
+	assert.Equal(t, types.VirtualDeviceConfigSpecOperationAdd, change.Operation)
+	// the disk already exists
+	assert.Equal(t, types.VirtualDeviceConfigSpecFileOperation(""), change.FileOperation)
+
+	disk, ok := change.Device.(*types.VirtualDisk)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.EqualValues(t, 100, disk.ControllerKey)
+	assert.True(t, disk.Key < 0)
+
+	backing := disk.Backing.(*types.VirtualDiskFlatVer2BackingInfo)
+	assert.Equal(t, "[datastore1] volumes/foo/foo.vmdk", backing.FileName)
+	assert.Equal(t, string(types.VirtualDiskModeIndependent_persistent), backing.DiskMode)
+}
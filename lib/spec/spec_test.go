@@ -23,7 +23,6 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/vim25/types"
-	"github.com/vmware/vic/lib/spec"
 	"github.com/vmware/vic/pkg/vsphere/session"
 	"github.com/vmware/vic/pkg/vsphere/test/env"
 	"golang.org/x/net/context"
@@ -48,7 +47,7 @@ func TestVirtualMachineConfigSpec(t *testing.T) {
 	if err != nil {
 		t.Logf("%+v", err.Error())
 		if _, ok := err.(*find.MultipleFoundError); !ok {
-			t.Errorf(err.Error())
+			t.Error(err)
 		} else {
 			t.SkipNow()
 		}
@@ -126,24 +125,24 @@ func TestCollectSlotNumbers(t *testing.T) {
 		VirtualMachineConfigSpec: &types.VirtualMachineConfigSpec{},
 	}
 
-	slots := s.CollectSlotNumbers()
+	slots := s.CollectSlotNumbers(nil)
 	assert.Empty(t, slots)
 
 	s.AddVirtualVmxnet3(NewVirtualVmxnet3())
 	s.DeviceChange[0].GetVirtualDeviceConfigSpec().Device.GetVirtualDevice().SlotInfo = &types.VirtualDevicePciBusSlotInfo{PciSlotNumber: 32}
-	slots = s.CollectSlotNumbers()
-	assert.EqualValues(t, []int32{32}, slots)
+	slots = s.CollectSlotNumbers(nil)
+	assert.Equal(t, map[int32]bool{32: true}, slots)
 
 	// add a device without a slot number
 	s.AddVirtualVmxnet3(NewVirtualVmxnet3())
-	slots = s.CollectSlotNumbers()
-	assert.EqualValues(t, []int32{32}, slots)
+	slots = s.CollectSlotNumbers(nil)
+	assert.Equal(t, map[int32]bool{32: true}, slots)
 
 	// add another device with slot number
 	s.AddVirtualVmxnet3(NewVirtualVmxnet3())
 	s.DeviceChange[len(s.DeviceChange)-1].GetVirtualDeviceConfigSpec().Device.GetVirtualDevice().SlotInfo = &types.VirtualDevicePciBusSlotInfo{PciSlotNumber: 33}
-	slots = s.CollectSlotNumbers()
-	assert.EqualValues(t, []int32{32, 33}, slots)
+	slots = s.CollectSlotNumbers(nil)
+	assert.Equal(t, map[int32]bool{32: true, 33: true}, slots)
 }
 
 func TestFindSlotNumber(t *testing.T) {
@@ -180,7 +179,7 @@ func TestFindSlotNumber(t *testing.T) {
 		out   int32
 	}{
 		{make(map[int32]bool), pciSlotNumberBegin},
-		{allSlots, spec.NilSlot},
+		{allSlots, NilSlot},
 		{missingFirstSlot, pciSlotNumberBegin},
 		{missingLastSlot, pciSlotNumberEnd - pciSlotNumberInc},
 		{missingMiddleSlot, missingSlot},
@@ -215,6 +215,50 @@ func TestHalt(t *testing.T) {
 	}
 }
 
+func TestSplitStreams(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "streams",
+			Name: "tether_test_executor",
+		},
+
+		Sessions: map[string]metadata.SessionConfig{
+			"streams": metadata.SessionConfig{
+				Common: metadata.Common{
+					ID:   "streams",
+					Name: "tether_test_session",
+				},
+				Tty: false,
+				Cmd: metadata.Cmd{
+					Path: "/bin/sh",
+					Args: []string{"/bin/sh", "-c", "echo out; echo err >&2"},
+					Env:  []string{},
+					Dir:  "/",
+				},
+			},
+		},
+	}
+
+	_, src, err := RunTether(t, &cfg)
+	assert.NoError(t, err, "Didn't expected error from RunTether")
+
+	// block until tether exits
+	<-Mocked.Cleaned
+
+	result := ExecutorConfig{}
+	extraconfig.Decode(src, &result)
+
+	assert.Equal(t, "true", result.Sessions["streams"].Started, "Expected command to have been started successfully")
+	assert.Equal(t, 0, result.Sessions["streams"].ExitStatus, "Expected command to have exited cleanly")
+
+	// stdout and stderr must reach their own writers
+	assert.Equal(t, "out\n", Mocked.SessionLogBuffer.String())
+	assert.Equal(t, "err\n", Mocked.SessionErrLogBuffer.String())
+}
+
 func TestAbsPathRepeat(t *testing.T) {
 	t.Skip("Occasional issues with output not being flushed to log - #577")
 
@@ -317,5 +361,88 @@ func TestMissingRelativeBinary(t *testing.T) {
 	assert.Equal(t, "notthere: no such executable in PATH", status, "Expected status to have a command not found error message")
 }
 
+func TestRestartOnFailure(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "restart",
+			Name: "tether_test_executor",
+		},
+
+		Sessions: map[string]metadata.SessionConfig{
+			"restart": metadata.SessionConfig{
+				Common: metadata.Common{
+					ID:   "restart",
+					Name: "tether_test_session",
+				},
+				Tty: false,
+				Cmd: metadata.Cmd{
+					Path: "/bin/false",
+					Args: []string{"/bin/false"},
+					Env:  []string{},
+					Dir:  "/",
+				},
+				Restart: metadata.RestartPolicy{
+					Name:              metadata.RestartPolicyOnFailure,
+					MaximumRetryCount: 2,
+				},
+			},
+		},
+	}
+
+	_, src, err := RunTether(t, &cfg)
+	assert.NoError(t, err, "Didn't expected error from RunTether")
+
+	result := ExecutorConfig{}
+	extraconfig.Decode(src, &result)
+
+	assert.Equal(t, 2, result.Sessions["restart"].RestartCount, "Expected session to have been restarted up to the retry limit")
+	assert.Equal(t, 1, result.Sessions["restart"].ExitStatus, "Expected command to have failed")
+	assert.NotEqual(t, int64(0), result.Sessions["restart"].StopTime, "Expected the final exit to have been recorded")
+}
+
+func TestRestartWhileStopping(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "stopping",
+			Name: "tether_test_executor",
+		},
+
+		Sessions: map[string]metadata.SessionConfig{
+			"stopping": metadata.SessionConfig{
+				Common: metadata.Common{
+					ID:   "stopping",
+					Name: "tether_test_session",
+				},
+				Tty: false,
+				Cmd: metadata.Cmd{
+					Path: "/bin/false",
+					Args: []string{"/bin/false"},
+					Env:  []string{},
+					Dir:  "/",
+				},
+				Restart: metadata.RestartPolicy{
+					Name: metadata.RestartPolicyAlways,
+				},
+			},
+		},
+
+		Stopping: true,
+	}
+
+	_, src, err := RunTether(t, &cfg)
+	assert.NoError(t, err, "Didn't expected error from RunTether")
+
+	result := ExecutorConfig{}
+	extraconfig.Decode(src, &result)
+
+	assert.Equal(t, 0, result.Sessions["stopping"].RestartCount, "Expected no restart of a stopping executor")
+}
+
 //
 /////////////////////////////////////////////////////////////////////////////////////
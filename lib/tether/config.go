@@ -50,6 +50,10 @@ type ExecutorConfig struct {
 	// Key is the host key used during communicate back with the Interaction endpoint if any
 	// Used if the in-guest tether is responsible for authenticating the connection
 	Key []byte `vic:"0.1" scope:"read-only" key:"key"`
+
+	// Stopping is set while the executor is being stopped so that restart policies are not
+	// applied to the session exits that result
+	Stopping bool `vic:"0.1" scope:"read-only" key:"stopping"`
 }
 
 // SessionConfig defines the content of a session - this maps to the root of a process tree
@@ -67,12 +71,29 @@ type SessionConfig struct {
 
 	Started string `vic:"0.1" scope:"read-write" key:"started"`
 
+	// StartTime and StopTime record the lifetime of the session process as unix timestamps
+	StartTime int64 `vic:"0.1" scope:"read-write" key:"starttime"`
+	StopTime  int64 `vic:"0.1" scope:"read-write" key:"stoptime"`
+
+	// Restart determines whether the session is relaunched when its process exits
+	Restart metadata.RestartPolicy `vic:"0.1" scope:"read-only" key:"restart"`
+
+	// RestartCount is the number of times the session has been relaunched by its restart policy
+	RestartCount int `vic:"0.1" scope:"read-write" key:"restarts"`
+
 	// Allow attach
 	Attach bool `vic:"0.1" scope:"read-only" key:"attach"`
 
 	// Allocate a tty or not
 	Tty bool `vic:"0.1" scope:"read-only" key:"tty"`
 
+	// User is the user, and optionally group, the process runs as in "user[:group]" form, where
+	// each may be a name or numeric ID. Names are resolved within the executor.
+	User string `vic:"0.1" scope:"read-only" key:"user"`
+
+	// Groups are the supplementary groups of the process, by name or ID
+	Groups []string `vic:"0.1" scope:"read-only" key:"groups"`
+
 	// if there's a pty then we need additional management data
 	Pty       *os.File
 	Outwriter dio.DynamicMultiWriter
@@ -54,7 +54,9 @@ func TestToExtraConfig(t *testing.T) {
 		},
 		Networks: map[string]*metadata.NetworkEndpoint{
 			"eth0": &metadata.NetworkEndpoint{
-				Static: &net.IPNet{IP: localhost, Mask: lmask.Mask},
+				Static:         &net.IPNet{IP: localhost, Mask: lmask.Mask},
+				Ports:          []string{},
+				RequestedPorts: []string{},
 				Network: metadata.ContainerNetwork{
 					Common: metadata.Common{
 						Name: "notsure",
@@ -16,6 +16,7 @@ package tether
 
 import (
 	"io"
+	"net/url"
 
 	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/pkg/dio"
@@ -34,10 +35,13 @@ type Operations interface {
 
 	SetHostname(hostname string, aliases ...string) error
 	Apply(endpoint *metadata.NetworkEndpoint) error
-	MountLabel(label, target string, ctx context.Context) error
+	MountLabel(label, target string, readOnly bool, ctx context.Context) error
+	MountTarget(source url.URL, target string, readOnly bool, ctx context.Context) error
+	Unmount(target string) error
 	Fork() error
 
-	SessionLog(session *SessionConfig) (dio.DynamicMultiWriter, error)
+	// SessionLog returns the stdout and stderr writers that persist the session output
+	SessionLog(session *SessionConfig) (dio.DynamicMultiWriter, dio.DynamicMultiWriter, error)
 	HandleSessionExit(config *ExecutorConfig, session *SessionConfig) bool
 	ProcessEnv(env []string) []string
 }
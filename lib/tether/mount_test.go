@@ -0,0 +1,222 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tether
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/vic/lib/metadata"
+)
+
+func TestMountVolume(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	dir, err := ioutil.TempDir("", "tether-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "mountvolume",
+			Name: "tether_test_executor",
+		},
+		Mounts: map[string]metadata.MountSpec{
+			"vol1": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "0123456789abcdef"},
+				Path:   filepath.Join(dir, "data"),
+				Mode:   "ro",
+			},
+		},
+	}
+
+	tthr, _ := StartTether(t, &cfg)
+
+	<-Mocked.Started
+
+	// prevent indefinite wait in tether - normally session exit would trigger this
+	tthr.Stop()
+
+	// wait for tether to exit
+	<-Mocked.Cleaned
+
+	assert.Equal(t, map[string]string{"0123456789abcdef": filepath.Join(dir, "data")}, Mocked.Mounts)
+}
+
+func TestPopulateVolume(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	dir, err := ioutil.TempDir("", "tether-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the mocked mounts leave the staging directory as a plain directory
+	volumeStagingDir = filepath.Join(dir, "staging")
+	defer func() { volumeStagingDir = "/.tether/volumes" }()
+
+	// content at the mount point in the image
+	target := filepath.Join(dir, "data")
+	if err = os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(target, "content"), []byte("image content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "populatevolume",
+			Name: "tether_test_executor",
+		},
+		Mounts: map[string]metadata.MountSpec{
+			"vol1": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "0123456789abcdef"},
+				Path:   target,
+				Mode:   "rw",
+			},
+			"vol2": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "fedcba9876543210"},
+				Path:   target,
+				Mode:   "rw,nocopy",
+			},
+		},
+	}
+
+	tthr, _ := StartTether(t, &cfg)
+
+	<-Mocked.Started
+
+	// prevent indefinite wait in tether - normally session exit would trigger this
+	tthr.Stop()
+
+	// wait for tether to exit
+	<-Mocked.Cleaned
+
+	content, err := ioutil.ReadFile(filepath.Join(volumeStagingDir, "vol1", "content"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "image content", string(content))
+	}
+
+	// nothing is copied into a volume mounted with nocopy
+	_, err = os.Stat(filepath.Join(volumeStagingDir, "vol2"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the staging mounts are gone, leaving the volumes mounted at the target
+	assert.Equal(t, map[string]string{
+		"0123456789abcdef": target,
+		"fedcba9876543210": target,
+	}, Mocked.Mounts)
+}
+
+func TestMountNFSVolume(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	dir, err := ioutil.TempDir("", "tether-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := url.URL{Scheme: "nfs", Host: "nfs.example.com", Path: "/exports/vic/volumes/shared"}
+
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "mountnfsvolume",
+			Name: "tether_test_executor",
+		},
+		Mounts: map[string]metadata.MountSpec{
+			"shared": metadata.MountSpec{
+				Source: source,
+				Path:   filepath.Join(dir, "data"),
+				Mode:   "rw",
+			},
+		},
+	}
+
+	tthr, _ := StartTether(t, &cfg)
+
+	<-Mocked.Started
+
+	// prevent indefinite wait in tether - normally session exit would trigger this
+	tthr.Stop()
+
+	// wait for tether to exit
+	<-Mocked.Cleaned
+
+	assert.Equal(t, map[string]string{"nfs.example.com/exports/vic/volumes/shared": filepath.Join(dir, "data")}, Mocked.Mounts)
+}
+
+func TestMountNestedVolumes(t *testing.T) {
+	testSetup(t)
+	defer testTeardown(t)
+
+	dir, err := ioutil.TempDir("", "tether-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// names that sort against the nesting of the paths
+	cfg := metadata.ExecutorConfig{
+		Common: metadata.Common{
+			ID:   "mountnestedvolumes",
+			Name: "tether_test_executor",
+		},
+		Mounts: map[string]metadata.MountSpec{
+			"a": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "aaaaaaaaaaaaaaaa"},
+				Path:   filepath.Join(dir, "data", "logs", "app"),
+				Mode:   "rw,nocopy",
+			},
+			"b": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "bbbbbbbbbbbbbbbb"},
+				Path:   filepath.Join(dir, "data", "logs"),
+				Mode:   "rw,nocopy",
+			},
+			"c": metadata.MountSpec{
+				Source: url.URL{Scheme: "label", Host: "cccccccccccccccc"},
+				Path:   filepath.Join(dir, "data"),
+				Mode:   "rw,nocopy",
+			},
+		},
+	}
+
+	tthr, _ := StartTether(t, &cfg)
+
+	<-Mocked.Started
+
+	// prevent indefinite wait in tether - normally session exit would trigger this
+	tthr.Stop()
+
+	// wait for tether to exit
+	<-Mocked.Cleaned
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "data"),
+		filepath.Join(dir, "data", "logs"),
+		filepath.Join(dir, "data", "logs", "app"),
+	}, Mocked.MountTargets)
+}
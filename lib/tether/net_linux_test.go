@@ -69,7 +69,7 @@ func TestSetIpAddress(t *testing.T) {
 						Name: "bridge",
 					},
 					Default: true,
-					Gateway: gwIP,
+					Gateway: *gwIP,
 				},
 				Static: &net.IPNet{
 					IP:   localhost,
@@ -17,6 +17,7 @@ package tether
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 
@@ -44,12 +45,26 @@ func (t *BaseOperations) Apply(endpoint *metadata.NetworkEndpoint) error {
 
 // MountLabel performs a mount with the source treated as a disk label
 // This assumes that /dev/disk/by-label is being populated, probably by udev
-func (t *BaseOperations) MountLabel(label, target string, ctx context.Context) error {
+func (t *BaseOperations) MountLabel(label, target string, readOnly bool, ctx context.Context) error {
 	defer trace.End(trace.Begin(fmt.Sprintf("Mounting %s on %s", label, target)))
 
 	return errors.New("not implemented on OSX")
 }
 
+// MountTarget mounts a network filesystem given as a URL on target
+func (t *BaseOperations) MountTarget(source url.URL, target string, readOnly bool, ctx context.Context) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("Mounting %s on %s", source.String(), target)))
+
+	return errors.New("not implemented on OSX")
+}
+
+// Unmount unmounts the filesystem mounted on target
+func (t *BaseOperations) Unmount(target string) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("Unmounting %s", target)))
+
+	return errors.New("not implemented on OSX")
+}
+
 // ProcessEnv does OS specific checking and munging on the process environment prior to launch
 func (t *BaseOperations) ProcessEnv(env []string) []string {
 	// TODO: figure out how we're going to specify user and pass all the settings along
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -43,6 +44,16 @@ var byLabelDir = "/dev/disk/by-label"
 
 const pciDevPath = "/sys/bus/pci/devices"
 
+// labelPollInterval is how often MountLabel checks whether the labelled disk has appeared
+const labelPollInterval = 100 * time.Millisecond
+
+// nfsMountOptions are the options NFS volumes are mounted with, matching those the port layer
+// uses to lay out the volume stores
+const nfsMountOptions = "vers=3,proto=tcp,nolock"
+
+// nfsRetryInterval is how often MountTarget retries an NFS mount that failed
+const nfsRetryInterval = time.Second
+
 type BaseOperations struct {
 }
 
@@ -251,12 +262,37 @@ func renameLink(t Netlink, link netlink.Link, slot int32, endpoint *metadata.Net
 	return link, nil
 }
 
-// assignIP assigns an IP to a NIC, using a label to provide an associated between address and network role.
+// addrFamily returns the netlink address family of the IP
+func addrFamily(ip net.IP) int {
+	if ip.To4() == nil {
+		return netlink.FAMILY_V6
+	}
+
+	return netlink.FAMILY_V4
+}
+
+// endpointFamily returns the address family of the endpoint, taken from the static address
+// or the gateway, defaulting to IPv4
+func endpointFamily(endpoint *metadata.NetworkEndpoint) int {
+	if endpoint.Static != nil && len(endpoint.Static.IP) > 0 {
+		return addrFamily(endpoint.Static.IP)
+	}
+
+	if len(endpoint.Network.Gateway.IP) > 0 {
+		return addrFamily(endpoint.Network.Gateway.IP)
+	}
+
+	return netlink.FAMILY_V4
+}
+
+// assignIP assigns an IPv4 or IPv6 address to a NIC, using a label to provide an associated between address
+// and network role. Linux only supports labels on IPv4 addresses.
 // returns true if an address has been updated so that /etc/hosts can be updated.
 func assignIP(t Netlink, link netlink.Link, endpoint *metadata.NetworkEndpoint) (bool, error) {
+	family := endpointFamily(endpoint)
 
 	// get the current ip addresses on the link
-	active, err := t.AddrList(link, netlink.FAMILY_V4)
+	active, err := t.AddrList(link, family)
 	if err != nil {
 		detail := fmt.Sprintf("unable to confirm assigned IP address for net %s: %s", endpoint.Network.Name, err)
 		return false, errors.New(detail)
@@ -283,8 +319,15 @@ func assignIP(t Netlink, link netlink.Link, endpoint *metadata.NetworkEndpoint)
 			}
 		}
 
-		// add a label to identify the network
-		addr.Label = fmt.Sprintf("%s:%s", link.Attrs().Name, endpoint.Network.Name)
+		if family == netlink.FAMILY_V4 {
+			// add a label to identify the network
+			addr.Label = fmt.Sprintf("%s:%s", link.Attrs().Name, endpoint.Network.Name)
+		} else {
+			// the address is allocated by the port layer so skip duplicate address detection,
+			// which would leave it unusable until DAD completes
+			addr.Flags = syscall.IFA_F_NODAD
+		}
+
 		if err = t.AddrAdd(link, addr); err != nil {
 			detail := fmt.Sprintf("failed to add address to %s: %s", endpoint.Network.Name, err)
 			return false, errors.New(detail)
@@ -304,7 +347,7 @@ func assignIP(t Netlink, link netlink.Link, endpoint *metadata.NetworkEndpoint)
 	// if there's already an address assigned, obtain it otherwise wait for one
 	for {
 		// update the current ip addresses on the link
-		active, err = t.AddrList(link, netlink.FAMILY_V4)
+		active, err = t.AddrList(link, family)
 		if err != nil {
 			detail := fmt.Sprintf("unable to confirm assigned IP address for net %s: %s", endpoint.Network.Name, err)
 			return false, errors.New(detail)
@@ -386,6 +429,10 @@ func apply(t Netlink, endpoint *metadata.NetworkEndpoint) error {
 	// Add routes
 	if endpoint.Network.Default && len(endpoint.Network.Gateway.IP) > 0 {
 		_, defaultNet, _ := net.ParseCIDR("0.0.0.0/0")
+		if addrFamily(endpoint.Network.Gateway.IP) == netlink.FAMILY_V6 {
+			_, defaultNet, _ = net.ParseCIDR("::/0")
+		}
+
 		route := netlink.Route{LinkIndex: link.Attrs().Index, Dst: defaultNet, Gw: endpoint.Network.Gateway.IP}
 		err = t.RouteAdd(&route)
 		if err != nil {
@@ -450,9 +497,13 @@ func (t *BaseOperations) Apply(endpoint *metadata.NetworkEndpoint) error {
 	return apply(t, endpoint)
 }
 
-// MountLabel performs a mount with the source and target being absolute paths
-func (t *BaseOperations) MountLabel(source, target string, ctx context.Context) error {
-	defer trace.End(trace.Begin(fmt.Sprintf("Mounting %s on %s", source, target)))
+// MountLabel mounts the filesystem with the given label on target, read-only if requested.
+// This assumes that /dev/disk/by-label is being populated, probably by udev, and waits for the
+// disk to appear until the context is done.
+func (t *BaseOperations) MountLabel(label, target string, readOnly bool, ctx context.Context) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("Mounting %s on %s", label, target)))
+
+	source := filepath.Join(byLabelDir, label)
 
 	if err := os.MkdirAll(target, 0600); err != nil {
 		return fmt.Errorf("unable to create mount point %s: %s", target, err)
@@ -468,6 +519,9 @@ func (t *BaseOperations) MountLabel(source, target string, ctx context.Context)
 
 		deadline, ok := ctx.Deadline()
 		timeout = ok && time.Now().After(deadline)
+		if !timeout {
+			time.Sleep(labelPollInterval)
+		}
 	}
 
 	if timeout {
@@ -475,7 +529,12 @@ func (t *BaseOperations) MountLabel(source, target string, ctx context.Context)
 		return errors.New(detail)
 	}
 
-	if err := syscall.Mount(source, target, "ext4", syscall.MS_NOATIME, ""); err != nil {
+	flags := uintptr(syscall.MS_NOATIME)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	if err := syscall.Mount(source, target, "ext4", flags, ""); err != nil {
 		detail := fmt.Sprintf("mounting %s on %s failed: %s", source, target, err)
 		return errors.New(detail)
 	}
@@ -483,10 +542,67 @@ func (t *BaseOperations) MountLabel(source, target string, ctx context.Context)
 	return nil
 }
 
+// MountTarget mounts a network filesystem given as a URL on target, read-only if requested.
+// Only nfs://host/path is supported.  As the network may still be settling the mount is retried
+// until the context is done.
+func (t *BaseOperations) MountTarget(source url.URL, target string, readOnly bool, ctx context.Context) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("Mounting %s on %s", source.String(), target)))
+
+	if source.Scheme != "nfs" {
+		return fmt.Errorf("unsupported mount source %s", source.String())
+	}
+
+	if err := os.MkdirAll(target, 0600); err != nil {
+		return fmt.Errorf("unable to create mount point %s: %s", target, err)
+	}
+
+	path := source.Path
+	if path == "" {
+		path = "/"
+	}
+	device := fmt.Sprintf("%s:%s", source.Host, path)
+
+	flags := uintptr(syscall.MS_NOATIME)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+
+	for {
+		// the kernel wants the address of the server as well as its name
+		ips, err := net.LookupIP(source.Host)
+		if err == nil {
+			data := fmt.Sprintf("addr=%s,%s", ips[0], nfsMountOptions)
+			if err = syscall.Mount(device, target, "nfs", flags, data); err == nil {
+				return nil
+			}
+		}
+
+		log.Debugf("Mounting %s failed, retrying: %s", device, err)
+
+		select {
+		case <-ctx.Done():
+			detail := fmt.Sprintf("mounting %s on %s failed: %s", device, target, err)
+			return errors.New(detail)
+		case <-time.After(nfsRetryInterval):
+		}
+	}
+}
+
+// Unmount unmounts the filesystem mounted on target
+func (t *BaseOperations) Unmount(target string) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("Unmounting %s", target)))
+
+	if err := syscall.Unmount(target, 0); err != nil {
+		detail := fmt.Sprintf("unmounting %s failed: %s", target, err)
+		return errors.New(detail)
+	}
+
+	return nil
+}
+
 // ProcessEnv does OS specific checking and munging on the process environment prior to launch
 func (t *BaseOperations) ProcessEnv(env []string) []string {
-	// TODO: figure out how we're going to specify user and pass all the settings along
-	// in the meantime, hardcode HOME to /root
+	// HOME is set from the session user if there is one, so this only applies to root
 	homeIndex := -1
 	for i, tuple := range env {
 		if strings.HasPrefix(tuple, "HOME=") {
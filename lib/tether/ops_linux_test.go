@@ -19,10 +19,16 @@ package tether
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"path"
+	"syscall"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/vishvananda/netlink"
+	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/pkg/trace"
 )
 
@@ -93,7 +99,15 @@ func (t *Mocker) AddrList(link netlink.Link, family int) ([]netlink.Addr, error)
 	defer trace.End(trace.Begin(""))
 
 	iface := link.(*Interface)
-	return iface.Addrs, nil
+
+	var addrs []netlink.Addr
+	for _, addr := range iface.Addrs {
+		if family == netlink.FAMILY_ALL || addrFamily(addr.IP) == family {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs, nil
 }
 
 func (t *Mocker) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
@@ -105,7 +119,7 @@ func (t *Mocker) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
 		if addr.IP.String() == adr.IP.String() {
 			return fmt.Errorf("IP already assigned to %#v", adr)
 		}
-		if addr.Label == adr.Label {
+		if addr.Label != "" && addr.Label == adr.Label {
 			return fmt.Errorf("Label already assigned to %#v", adr)
 		}
 	}
@@ -115,9 +129,9 @@ func (t *Mocker) AddrAdd(link netlink.Link, addr *netlink.Addr) error {
 }
 
 func (t *Mocker) RouteAdd(route *netlink.Route) error {
-	defer trace.End(trace.Begin("no implemented"))
+	defer trace.End(trace.Begin(fmt.Sprintf("Adding route %s", route.String())))
 
-	// currently ignored
+	t.Routes = append(t.Routes, *route)
 	return nil
 }
 
@@ -161,3 +175,71 @@ func TestSlotToPciPath(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyIP6(t *testing.T) {
+	hosts, err := ioutil.TempFile("", "tether-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts.Close()
+	defer os.Remove(hosts.Name())
+
+	hostsFile = hosts.Name()
+	defer func() { hostsFile = "/etc/hosts" }()
+
+	m := &Mocker{
+		Interfaces: map[string]netlink.Link{
+			"eno1": &Interface{
+				LinkAttrs: netlink.LinkAttrs{Name: "eno1", Index: 1},
+				Up:        true,
+				Addrs: []netlink.Addr{
+					{IPNet: &net.IPNet{IP: net.ParseIP("172.16.0.10"), Mask: net.CIDRMask(16, 32)}, Label: "eno1:bridge"},
+				},
+			},
+		},
+	}
+
+	endpoint := &metadata.NetworkEndpoint{
+		Common: metadata.Common{
+			ID: "1",
+		},
+		Network: metadata.ContainerNetwork{
+			Common: metadata.Common{
+				Name: "v6net",
+			},
+			Default: true,
+			Gateway: net.IPNet{IP: net.ParseIP("fd00:1::1"), Mask: net.CIDRMask(64, 128)},
+		},
+		Static: &net.IPNet{IP: net.ParseIP("fd00:1::10"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	if !assert.NoError(t, apply(m, endpoint)) {
+		return
+	}
+
+	assert.Equal(t, "fd00:1::10", endpoint.Assigned.String())
+
+	addrs, _ := m.AddrList(m.Interfaces["eno1"], netlink.FAMILY_V6)
+	if assert.Len(t, addrs, 1) {
+		assert.Equal(t, "fd00:1::10/64", addrs[0].IPNet.String())
+		// labels are only supported for IPv4 addresses
+		assert.Empty(t, addrs[0].Label)
+		assert.Equal(t, syscall.IFA_F_NODAD, addrs[0].Flags)
+	}
+
+	if assert.Len(t, m.Routes, 1) {
+		assert.Equal(t, "::/0", m.Routes[0].Dst.String())
+		assert.Equal(t, "fd00:1::1", m.Routes[0].Gw.String())
+	}
+
+	content, err := ioutil.ReadFile(hosts.Name())
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(content), "fd00:1::10 v6net.localhost")
+	}
+
+	// applying again finds the address already assigned
+	if assert.NoError(t, apply(m, endpoint)) {
+		addrs, _ = m.AddrList(m.Interfaces["eno1"], netlink.FAMILY_ALL)
+		assert.Len(t, addrs, 2)
+	}
+}
@@ -18,17 +18,46 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/vmware/vic/lib/metadata"
 	"github.com/vmware/vic/pkg/dio"
 	"github.com/vmware/vic/pkg/trace"
 	"github.com/vmware/vic/pkg/vsphere/extraconfig"
 )
 
+const (
+	// attachLaunchTimeout is how long an attachable exec session is held waiting for an attach
+	attachLaunchTimeout = 10 * time.Second
+
+	// restartBackoff is the delay before the first restart of a session, doubling with each
+	// subsequent restart up to maxRestartBackoff
+	restartBackoff    = 100 * time.Millisecond
+	maxRestartBackoff = time.Minute
+
+	// mountTimeout is how long to wait for the disk or server of a volume to appear
+	mountTimeout = 30 * time.Second
+)
+
+// volumeStagingDir is where a volume is mounted while the content at its mount point in the
+// image is copied into it
+var volumeStagingDir = "/.tether/volumes"
+
 type tether struct {
 	// the implementation to use for tailored operations
 	ops Operations
@@ -42,6 +71,9 @@ type tether struct {
 	// a set of extensions that get to operate on the config
 	extensions map[string]Extension
 
+	// the volumes that have been mounted, by name
+	mounted map[string]bool
+
 	src  extraconfig.DataSource
 	sink extraconfig.DataSink
 
@@ -56,6 +88,7 @@ func New(src extraconfig.DataSource, sink extraconfig.DataSink, ops Operations)
 			pids: make(map[int]*SessionConfig),
 		},
 		extensions: make(map[string]Extension),
+		mounted:    make(map[string]bool),
 		src:        src,
 		sink:       sink,
 		incoming:   make(chan os.Signal, 10),
@@ -102,6 +135,7 @@ func (t *tether) setup() error {
 	t.config = &ExecutorConfig{
 		pids: make(map[int]*SessionConfig),
 	}
+	t.mounted = make(map[string]bool)
 
 	t.childReaper()
 
@@ -170,6 +204,13 @@ func (t *tether) Start() error {
 				return errors.New(detail)
 			}
 		}
+
+		// the volumes have to be in place before the sessions that use them start
+		if err := t.mountVolumes(); err != nil {
+			detail := fmt.Sprintf("failed to mount volumes: %s", err)
+			log.Error(detail)
+			return errors.New(detail)
+		}
 		extraconfig.Encode(t.sink, t.config)
 
 		// process the sessions and launch if needed
@@ -185,21 +226,30 @@ func (t *tether) Start() error {
 
 			// check if session has never been started
 			if proc == nil {
+				// the launch may already be in progress, waiting for an attach
+				if session.Outwriter != nil {
+					log.Debugf("Process for session %s is pending launch", session.ID)
+					continue
+				}
+
 				log.Infof("Launching process for session %s", session.ID)
 				err := t.launch(session)
 				if err != nil {
 					detail := fmt.Sprintf("failed to launch %s for %s: %s", session.Cmd.Path, id, err)
 					log.Error(detail)
 
+					// failure to launch an exec session is reported via its started key and
+					// doesn't affect the rest of the containerVM
+					if session.ID != t.config.ID {
+						continue
+					}
+
 					// TODO: check if failure to launch this is fatal to everything in this containerVM
 					return errors.New(detail)
 				}
-
-				// TODO: decide how to handle restart - probably needs to glue into the child reaping
 			}
 
-			// handle exited session
-			// TODO
+			// exited sessions are restarted, if their policy requires it, by handleSessionExit
 		}
 
 		for name, ext := range t.extensions {
@@ -215,6 +265,141 @@ func (t *tether) Start() error {
 	return nil
 }
 
+// mountVolumes mounts the volumes in the config that aren't mounted yet
+func (t *tether) mountVolumes() error {
+	// mount parents before the volumes nested within them so the nested mounts aren't hidden
+	names := make([]string, 0, len(t.config.Mounts))
+	for name := range t.config.Mounts {
+		names = append(names, name)
+	}
+	sort.Sort(mountsByDepth{names, t.config.Mounts})
+
+	for _, name := range names {
+		mount := t.config.Mounts[name]
+		if t.mounted[name] {
+			continue
+		}
+
+		switch mount.Source.Scheme {
+		case "label", "nfs":
+		default:
+			return fmt.Errorf("unsupported source %s for volume %s", mount.Source.String(), name)
+		}
+
+		log.Infof("Mounting volume %s on %s (%s)", name, mount.Path, mount.Mode)
+		if err := t.mountVolume(name, mount.Source, mount.Path, mount.Mode); err != nil {
+			return err
+		}
+		t.mounted[name] = true
+	}
+
+	return nil
+}
+
+// mountsByDepth orders volume names by the depth of their mount paths, shallowest first
+type mountsByDepth struct {
+	names  []string
+	mounts map[string]metadata.MountSpec
+}
+
+func (m mountsByDepth) Len() int {
+	return len(m.names)
+}
+
+func (m mountsByDepth) Swap(i, j int) {
+	m.names[i], m.names[j] = m.names[j], m.names[i]
+}
+
+func (m mountsByDepth) Less(i, j int) bool {
+	pi := filepath.Clean(m.mounts[m.names[i]].Path)
+	pj := filepath.Clean(m.mounts[m.names[j]].Path)
+
+	di := strings.Count(pi, string(filepath.Separator))
+	dj := strings.Count(pj, string(filepath.Separator))
+	if di != dj {
+		return di < dj
+	}
+
+	// keep the order stable for volumes at the same depth
+	if pi != pj {
+		return pi < pj
+	}
+	return m.names[i] < m.names[j]
+}
+
+// mountSource mounts a volume's source, either the label of its disk or the URL of a network
+// filesystem, on target
+func (t *tether) mountSource(source url.URL, target string, readOnly bool, ctx context.Context) error {
+	if source.Scheme == "label" {
+		return t.ops.MountLabel(source.Host, target, readOnly, ctx)
+	}
+
+	return t.ops.MountTarget(source, target, readOnly, ctx)
+}
+
+// mountVolume mounts the source of a volume on target. As with docker, an empty volume is first
+// populated with the content at target in the image unless the mode includes nocopy.
+func (t *tether) mountVolume(name string, source url.URL, target, mode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mountTimeout)
+	defer cancel()
+
+	readOnly := false
+	copyData := true
+	for _, opt := range strings.Split(mode, ",") {
+		switch opt {
+		case "ro":
+			readOnly = true
+		case "nocopy":
+			copyData = false
+		}
+	}
+
+	if copyData && !isEmptyDir(target) {
+		stage := filepath.Join(volumeStagingDir, name)
+		if err := t.mountSource(source, stage, false, ctx); err != nil {
+			return err
+		}
+
+		err := populateVolume(stage, target)
+		if uerr := t.ops.Unmount(stage); err == nil {
+			err = uerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %s into volume %s: %s", target, name, err)
+		}
+	}
+
+	return t.mountSource(source, target, readOnly, ctx)
+}
+
+// populateVolume copies the content of dir into the volume mounted on stage, provided the
+// volume is empty
+func populateVolume(stage, dir string) error {
+	if !isEmptyDir(stage) {
+		log.Debugf("Not copying %s into %s as it is not empty", dir, stage)
+		return nil
+	}
+
+	return archive.CopyWithTar(dir, stage)
+}
+
+// isEmptyDir reports whether dir is missing or holds nothing but the lost+found directory
+// of a new filesystem
+func isEmptyDir(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return true
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != "lost+found" {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (t *tether) Stop() error {
 	// TODO: kill all the children
 	if t.reload != nil {
@@ -238,6 +423,19 @@ func (t *tether) Register(name string, extension Extension) {
 func (t *tether) handleSessionExit(session *SessionConfig) {
 	defer trace.End(trace.Begin("handling exit of session " + session.ID))
 
+	// record exit status
+	// FIXME: we cannot have this embedded knowledge of the extraconfig encoding pattern, but not
+	// currently sure how to expose it neatly via a utility function
+	session.StopTime = time.Now().UTC().Unix()
+	extraconfig.EncodeWithPrefix(t.sink, session.ExitStatus, fmt.Sprintf("guestinfo..sessions|%s.status", session.ID))
+	extraconfig.EncodeWithPrefix(t.sink, session.StopTime, fmt.Sprintf("guestinfo..sessions|%s.stoptime", session.ID))
+	log.Infof("%s exit code: %d", session.ID, session.ExitStatus)
+
+	// the IO is retained across restarts so that attached clients remain connected
+	if t.restart(session) {
+		return
+	}
+
 	// close down the IO
 	session.Reader.Close()
 	// live.outwriter.Close()
@@ -245,42 +443,122 @@ func (t *tether) handleSessionExit(session *SessionConfig) {
 
 	// flush session log output
 
-	// record exit status
-	// FIXME: we cannot have this embedded knowledge of the extraconfig encoding pattern, but not
-	// currently sure how to expose it neatly via a utility function
-	extraconfig.EncodeWithPrefix(t.sink, session.ExitStatus, fmt.Sprintf("guestinfo..sessions|%s.status", session.ID))
-	log.Infof("%s exit code: %d", session.ID, session.ExitStatus)
-
 	if t.ops.HandleSessionExit(t.config, session) {
 		t.Stop()
 	}
 }
 
+// restart relaunches the session in place if its restart policy requires it, returning true
+// if the relaunch has been scheduled
+func (t *tether) restart(session *SessionConfig) bool {
+	// the port layer marks the executor as stopping before signaling the sessions, so check the
+	// current value rather than that from when the config was last loaded
+	current := &ExecutorConfig{}
+	extraconfig.Decode(t.src, current)
+	if current.Stopping {
+		log.Infof("Not restarting session %s as the executor is stopping", session.ID)
+		return false
+	}
+
+	if !session.Restart.ShouldRestart(session.ExitStatus, session.RestartCount) {
+		return false
+	}
+
+	session.RestartCount++
+	extraconfig.EncodeWithPrefix(t.sink, session.RestartCount, fmt.Sprintf("guestinfo..sessions|%s.restarts", session.ID))
+
+	// clear the stop time so the relaunch isn't mistaken for having exited
+	session.StopTime = 0
+	extraconfig.EncodeWithPrefix(t.sink, session.StopTime, fmt.Sprintf("guestinfo..sessions|%s.stoptime", session.ID))
+
+	// an exec.Cmd cannot be reused once started
+	session.Cmd = exec.Cmd{
+		Path: session.Cmd.Path,
+		Args: session.Cmd.Args,
+		Env:  session.Cmd.Env,
+		Dir:  session.Cmd.Dir,
+
+		// retains the credentials and process group configuration
+		SysProcAttr: session.Cmd.SysProcAttr,
+
+		Stdout: session.Outwriter,
+		Stderr: session.Errwriter,
+		Stdin:  session.Reader,
+	}
+
+	delay := restartBackoff
+	for i := 1; i < session.RestartCount && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
+	}
+
+	log.Infof("Restarting session %s in %s (restart count %d)", session.ID, delay, session.RestartCount)
+	go func() {
+		time.Sleep(delay)
+
+		if err := t.start(session); err != nil {
+			log.Errorf("Failed to restart %s for %s: %s", session.Cmd.Path, session.ID, err)
+
+			session.Reader.Close()
+			if t.ops.HandleSessionExit(t.config, session) {
+				t.Stop()
+			}
+		}
+	}()
+
+	return true
+}
+
 // launch will launch the command defined in the session.
 // This will return an error if the session fails to launch
 func (t *tether) launch(session *SessionConfig) error {
 	defer trace.End(trace.Begin("launching session " + session.ID))
 
-	// encode the result whether success or error
-	defer func() {
-		extraconfig.EncodeWithPrefix(t.sink, session.Started, fmt.Sprintf("guestinfo..sessions|%s.started", session.ID))
-	}()
+	// only the primary session contributes to the session log - exec output is available
+	// solely to whoever is attached
+	stdout, stderr := dio.MultiWriter(), dio.MultiWriter()
+	if session.ID == t.config.ID {
+		var err error
+		stdout, stderr, err = t.ops.SessionLog(session)
+		if err != nil {
+			detail := fmt.Sprintf("failed to get log writer for session: %s", err)
+			log.Error(detail)
+			session.Started = detail
+			t.encodeStarted(session)
 
-	logwriter, err := t.ops.SessionLog(session)
-	if err != nil {
-		detail := fmt.Sprintf("failed to get log writer for session: %s", err)
-		log.Error(detail)
-		session.Started = detail
+			return errors.New(detail)
+		}
+	}
 
-		return errors.New(detail)
+	// exec sessions that allow attach are held until the first attach so that the output
+	// of short lived commands isn't lost before anyone is listening
+	var notifier *attachNotifier
+	if session.ID != t.config.ID && session.Attach {
+		notifier = &attachNotifier{
+			DynamicMultiWriter: stdout,
+			attached:           make(chan struct{}),
+		}
+		stdout = notifier
 	}
 
 	// we store these outside of the session.Cmd struct so that there's consistent
 	// handling between tty & non-tty paths
-	session.Outwriter = logwriter
-	session.Errwriter = logwriter
+	session.Outwriter = stdout
+	session.Errwriter = stderr
 	session.Reader = dio.MultiReader()
 
+	// resolve the user before the environment is processed so that HOME can reflect it
+	if err := setCredentials(session); err != nil {
+		detail := fmt.Sprintf("failed to set user for session: %s", err)
+		log.Error(detail)
+		session.Started = detail
+		t.encodeStarted(session)
+
+		return errors.New(detail)
+	}
+
 	session.Cmd.Env = t.ops.ProcessEnv(session.Cmd.Env)
 	session.Cmd.Stdout = session.Outwriter
 	session.Cmd.Stderr = session.Errwriter
@@ -290,21 +568,52 @@ func (t *tether) launch(session *SessionConfig) error {
 	if err != nil {
 		log.Errorf("Path lookup failed for %s: %s", session.Cmd.Path, err)
 		session.Started = err.Error()
+		t.encodeStarted(session)
 		return err
 	}
 	log.Debugf("Resolved %s to %s", session.Cmd.Path, resolved)
 	session.Cmd.Path = resolved
 
+	if notifier == nil {
+		return t.start(session)
+	}
+
+	go func() {
+		select {
+		case <-notifier.attached:
+			log.Debugf("Session %s has been attached", session.ID)
+		case <-time.After(attachLaunchTimeout):
+			log.Warnf("Session %s was not attached within %s, launching regardless", session.ID, attachLaunchTimeout)
+		}
+
+		if err := t.start(session); err != nil {
+			log.Errorf("Failed to launch %s for %s: %s", session.Cmd.Path, session.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// start creates the process for a session that has been prepared by launch.
+func (t *tether) start(session *SessionConfig) error {
+	defer trace.End(trace.Begin("starting session " + session.ID))
+
+	// encode the result whether success or error
+	defer t.encodeStarted(session)
+
 	// Use the mutex to make creating a child and adding the child pid into the
 	// childPidTable appear atomic to the reaper function. Use a anonymous function
 	// so we can defer unlocking locally
 	// logging is done after the function to keep the locked time as low as possible
-	err = func() error {
+	err := func() error {
 		t.config.pidMutex.Lock()
 		defer t.config.pidMutex.Unlock()
 
+		var err error
 		log.Infof("Launching command %#v", session.Cmd.Args)
 		if !session.Tty {
+			// the pty path creates a new session for the process, so we only need to do this here
+			isolateProcess(&session.Cmd)
 			err = session.Cmd.Start()
 		} else {
 			err = establishPty(session)
@@ -332,11 +641,31 @@ func (t *tether) launch(session *SessionConfig) error {
 
 	// Set the Started key to "true" - this indicates a successful launch
 	session.Started = "true"
+	session.StartTime = time.Now().UTC().Unix()
 	log.Debugf("Launched command with pid %d", session.Cmd.Process.Pid)
 
 	return nil
 }
 
+// encodeStarted publishes the launch status of the session
+func (t *tether) encodeStarted(session *SessionConfig) {
+	extraconfig.EncodeWithPrefix(t.sink, session.StartTime, fmt.Sprintf("guestinfo..sessions|%s.starttime", session.ID))
+	extraconfig.EncodeWithPrefix(t.sink, session.Started, fmt.Sprintf("guestinfo..sessions|%s.started", session.ID))
+}
+
+// attachNotifier signals the first time a writer is added to the wrapped writer
+type attachNotifier struct {
+	dio.DynamicMultiWriter
+
+	once     sync.Once
+	attached chan struct{}
+}
+
+func (n *attachNotifier) Add(writers ...io.Writer) {
+	n.DynamicMultiWriter.Add(writers...)
+	n.once.Do(func() { close(n.attached) })
+}
+
 func logConfig(config *ExecutorConfig) {
 	// just pretty print the json for now
 	log.Info("Loaded executor config")
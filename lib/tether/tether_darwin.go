@@ -17,7 +17,9 @@ package tether
 import (
 	"errors"
 	"os"
+	"os/exec"
 	"strings"
+	"syscall"
 
 	"golang.org/x/crypto/ssh"
 
@@ -66,6 +68,21 @@ func lookPath(file string, env []string, dir string) (string, error) {
 	return "", errors.New("unimplemented on OSX")
 }
 
+func isolateProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func setCredentials(session *SessionConfig) error {
+	if session.User == "" && len(session.Groups) == 0 {
+		return nil
+	}
+
+	return errors.New("unimplemented on OSX")
+}
+
 func establishPty(session *SessionConfig) error {
 	defer trace.End(trace.Begin("initializing pty handling for session " + session.ID))
 
@@ -19,6 +19,7 @@ import (
 	"io"
 	_ "net/http/pprof"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
@@ -26,9 +27,16 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/kr/pty"
+	"github.com/opencontainers/runc/libcontainer/user"
 	"github.com/vmware/vic/pkg/trace"
 )
 
+var (
+	// the user and group databases of the executor, used to resolve session users
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
 // Mkdev will hopefully get rolled into go.sys at some point
 func Mkdev(majorNumber int, minorNumber int) int {
 	return (majorNumber << 8) | (minorNumber & 0xff) | ((minorNumber & 0xfff00) << 12)
@@ -159,6 +167,68 @@ func lookPath(file string, env []string, dir string) (string, error) {
 	return "", fmt.Errorf("%s: no such executable in PATH", file)
 }
 
+// isolateProcess places the process in its own process group so that signals can be
+// delivered to it and any children it creates without affecting the tether
+func isolateProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// setCredentials resolves the user and groups of the session against the executor's
+// /etc/passwd and /etc/group, configuring the process to run with them. HOME is set to the
+// user's home directory if not otherwise specified.
+func setCredentials(session *SessionConfig) error {
+	if session.User == "" && len(session.Groups) == 0 {
+		// run as root
+		return nil
+	}
+
+	defaults := &user.ExecUser{
+		Uid:  0,
+		Gid:  0,
+		Home: "/root",
+	}
+
+	execUser, err := user.GetExecUserPath(session.User, defaults, passwdPath, groupPath)
+	if err != nil {
+		return err
+	}
+
+	sgids := execUser.Sgids
+	if len(session.Groups) > 0 {
+		additional, err := user.GetAdditionalGroupsPath(session.Groups, groupPath)
+		if err != nil {
+			return err
+		}
+		sgids = append(sgids, additional...)
+	}
+
+	groups := make([]uint32, len(sgids))
+	for i := range sgids {
+		groups[i] = uint32(sgids[i])
+	}
+
+	if session.Cmd.SysProcAttr == nil {
+		session.Cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	session.Cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    uint32(execUser.Uid),
+		Gid:    uint32(execUser.Gid),
+		Groups: groups,
+	}
+
+	for _, env := range session.Cmd.Env {
+		if strings.HasPrefix(env, "HOME=") {
+			return nil
+		}
+	}
+	session.Cmd.Env = append(session.Cmd.Env, "HOME="+execUser.Home)
+
+	return nil
+}
+
 func establishPty(session *SessionConfig) error {
 	defer trace.End(trace.Begin("initializing pty handling for session " + session.ID))
 
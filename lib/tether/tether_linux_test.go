@@ -0,0 +1,93 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package tether
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPasswd = `root:x:0:0:root:/root:/bin/sh
+daemon:x:1:1:daemon:/usr/sbin:/bin/false
+app:x:1000:1000:app:/home/app:/bin/sh
+`
+	testGroup = `root:x:0:
+daemon:x:1:
+app:x:1000:
+audio:x:29:app
+video:x:44:
+`
+)
+
+func setupUserDatabase(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "credentials")
+	if !assert.NoError(t, err) {
+		return func() {}
+	}
+
+	oldPasswd, oldGroup := passwdPath, groupPath
+	passwdPath = path.Join(dir, "passwd")
+	groupPath = path.Join(dir, "group")
+
+	assert.NoError(t, ioutil.WriteFile(passwdPath, []byte(testPasswd), 0644))
+	assert.NoError(t, ioutil.WriteFile(groupPath, []byte(testGroup), 0644))
+
+	return func() {
+		passwdPath, groupPath = oldPasswd, oldGroup
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSetCredentials(t *testing.T) {
+	defer setupUserDatabase(t)()
+
+	// no user leaves the process running as root
+	session := &SessionConfig{}
+	assert.NoError(t, setCredentials(session))
+	assert.Nil(t, session.Cmd.SysProcAttr)
+
+	// user by name picks up its primary group, membership groups and home
+	session = &SessionConfig{User: "app"}
+	assert.NoError(t, setCredentials(session))
+	cred := session.Cmd.SysProcAttr.Credential
+	assert.Equal(t, uint32(1000), cred.Uid)
+	assert.Equal(t, uint32(1000), cred.Gid)
+	assert.Equal(t, []uint32{29}, cred.Groups)
+	assert.Equal(t, []string{"HOME=/home/app"}, session.Cmd.Env)
+
+	// numeric user and group by name, with an explicit HOME and supplementary groups
+	session = &SessionConfig{User: "1:video", Groups: []string{"audio", "1000"}}
+	session.Cmd.Env = []string{"HOME=/tmp"}
+	assert.NoError(t, setCredentials(session))
+	cred = session.Cmd.SysProcAttr.Credential
+	assert.Equal(t, uint32(1), cred.Uid)
+	assert.Equal(t, uint32(44), cred.Gid)
+	assert.Equal(t, []uint32{29, 1000}, cred.Groups)
+	assert.Equal(t, []string{"HOME=/tmp"}, session.Cmd.Env)
+
+	// unknown names are an error
+	session = &SessionConfig{User: "nobody"}
+	assert.Error(t, setCredentials(session))
+
+	session = &SessionConfig{User: "app", Groups: []string{"missing"}}
+	assert.Error(t, setCredentials(session))
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"testing"
@@ -51,6 +52,8 @@ type Mocker struct {
 
 	// session output gets logged here
 	SessionLogBuffer bytes.Buffer
+	// session error output gets logged here
+	SessionErrLogBuffer bytes.Buffer
 
 	// the hostname of the system
 	Hostname string
@@ -59,8 +62,12 @@ type Mocker struct {
 	maxSlot int
 	// the interfaces in the system indexed by name
 	Interfaces map[string]netlink.Link
+	// the routes added to the system
+	Routes []netlink.Route
 	// filesystem mounts, indexed by disk label
 	Mounts map[string]string
+	// the mount targets in the order they were mounted
+	MountTargets []string
 
 	WindowCol uint32
 	WindowRow uint32
@@ -102,8 +109,8 @@ func (t *Mocker) Log() (io.Writer, error) {
 	return &t.LogBuffer, nil
 }
 
-func (t *Mocker) SessionLog(session *SessionConfig) (dio.DynamicMultiWriter, error) {
-	return dio.MultiWriter(&t.SessionLogBuffer, os.Stdout), nil
+func (t *Mocker) SessionLog(session *SessionConfig) (dio.DynamicMultiWriter, dio.DynamicMultiWriter, error) {
+	return dio.MultiWriter(&t.SessionLogBuffer, os.Stdout), dio.MultiWriter(&t.SessionErrLogBuffer, os.Stderr), nil
 }
 
 func (t *Mocker) HandleSessionExit(config *ExecutorConfig, session *SessionConfig) bool {
@@ -133,7 +140,7 @@ func (t *Mocker) Apply(endpoint *metadata.NetworkEndpoint) error {
 
 // MountLabel performs a mount with the source treated as a disk label
 // This assumes that /dev/disk/by-label is being populated, probably by udev
-func (t *Mocker) MountLabel(label, target string, ctx context.Context) error {
+func (t *Mocker) MountLabel(label, target string, readOnly bool, ctx context.Context) error {
 	defer trace.End(trace.Begin(fmt.Sprintf("mocking mounting %s on %s", label, target)))
 
 	if t.Mounts == nil {
@@ -141,6 +148,33 @@ func (t *Mocker) MountLabel(label, target string, ctx context.Context) error {
 	}
 
 	t.Mounts[label] = target
+	t.MountTargets = append(t.MountTargets, target)
+	return nil
+}
+
+// MountTarget mounts a network filesystem given as a URL on target
+func (t *Mocker) MountTarget(source url.URL, target string, readOnly bool, ctx context.Context) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("mocking mounting %s on %s", source.String(), target)))
+
+	if t.Mounts == nil {
+		t.Mounts = make(map[string]string)
+	}
+
+	// only the server and path matter, the user info doesn't survive the extraconfig round trip
+	t.Mounts[source.Host+source.Path] = target
+	t.MountTargets = append(t.MountTargets, target)
+	return nil
+}
+
+// Unmount unmounts the filesystem mounted on target
+func (t *Mocker) Unmount(target string) error {
+	defer trace.End(trace.Begin(fmt.Sprintf("mocking unmounting %s", target)))
+
+	for label, mounted := range t.Mounts {
+		if mounted == target {
+			delete(t.Mounts, label)
+		}
+	}
 	return nil
 }
 
@@ -17,6 +17,7 @@ package tether
 import (
 	"errors"
 	"os"
+	"os/exec"
 
 	"golang.org/x/crypto/ssh"
 
@@ -40,6 +41,18 @@ func signalProcess(process *os.Process, sig ssh.Signal) error {
 	return errors.New("unimplemented on windows")
 }
 
+func isolateProcess(cmd *exec.Cmd) {
+	// TODO: windows job objects
+}
+
+func setCredentials(session *SessionConfig) error {
+	if session.User == "" && len(session.Groups) == 0 {
+		return nil
+	}
+
+	return errors.New("unimplemented on windows")
+}
+
 func establishPty(session *SessionConfig) error {
 	return errors.New("unimplemented on windows")
 }
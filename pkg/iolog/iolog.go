@@ -0,0 +1,197 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package iolog implements the session log format used to persist the output of a container.
+//
+// The log is a sequence of entries, each holding a single line of output:
+//
+//	header    [16]byte{STREAM, 0, 0, 0, SIZE1, SIZE2, SIZE3, SIZE4, TIMESTAMP1, ..., TIMESTAMP8}
+//	payload   [SIZE]byte
+//
+// STREAM uses the same values as the docker stdcopy multiplexing, SIZE is the big endian length
+// of the payload and TIMESTAMP the big endian UnixNano time at which the output was written.
+package iolog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stream identifies the output stream an entry was written to
+type Stream byte
+
+const (
+	// Stdout is the standard output stream
+	Stdout Stream = 1
+	// Stderr is the standard error stream
+	Stderr Stream = 2
+
+	headerLen = 16
+
+	streamIndex    = 0
+	sizeIndex      = 4
+	timestampIndex = 8
+
+	// MaxEntrySize bounds the payload of a single entry, guarding against reading a corrupt log
+	MaxEntrySize = 1 << 20
+)
+
+// ErrCorrupt is returned when the log contains data that isn't a valid entry
+var ErrCorrupt = errors.New("corrupt log entry")
+
+// Entry is a single line of output recorded in the log
+type Entry struct {
+	Stream    Stream
+	Timestamp time.Time
+	Data      []byte
+}
+
+// WriteTo writes the entry to w in the log format
+func (e *Entry) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.encode(nil))
+	return int64(n), err
+}
+
+func (e *Entry) encode(buf []byte) []byte {
+	var header [headerLen]byte
+
+	header[streamIndex] = byte(e.Stream)
+	binary.BigEndian.PutUint32(header[sizeIndex:], uint32(len(e.Data)))
+	binary.BigEndian.PutUint64(header[timestampIndex:], uint64(e.Timestamp.UnixNano()))
+
+	buf = append(buf, header[:]...)
+	return append(buf, e.Data...)
+}
+
+type writer struct {
+	w      io.Writer
+	stream Stream
+
+	// clock is replaceable so that tests can control the timestamps
+	clock func() time.Time
+}
+
+// NewWriter returns a writer that records everything written to it as entries for the given
+// stream. Each line becomes an entry of its own and all the entries from a single Write are
+// passed to the underlying writer in a single call so that streams sharing it don't interleave.
+func NewWriter(w io.Writer, stream Stream) io.Writer {
+	return &writer{
+		w:      w,
+		stream: stream,
+		clock:  time.Now,
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	entry := Entry{
+		Stream:    w.stream,
+		Timestamp: w.clock(),
+	}
+
+	var buf []byte
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i != -1 {
+			line = rest[:i+1]
+		}
+
+		// split anything too large to be read back
+		if len(line) > MaxEntrySize {
+			line = line[:MaxEntrySize]
+		}
+
+		entry.Data = line
+		buf = entry.encode(buf)
+		rest = rest[len(line):]
+	}
+
+	if _, err := w.w.Write(buf); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Reader decodes the entries in a log
+type Reader struct {
+	r      io.Reader
+	offset int64
+}
+
+// NewReader returns a Reader that decodes entries from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next returns the next entry in the log. It returns io.EOF if the log ends cleanly and
+// io.ErrUnexpectedEOF if it ends part way through an entry.
+func (r *Reader) Next() (*Entry, error) {
+	var header [headerLen]byte
+
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return nil, err
+	}
+
+	stream := Stream(header[streamIndex])
+	if stream != Stdout && stream != Stderr {
+		return nil, ErrCorrupt
+	}
+
+	size := binary.BigEndian.Uint32(header[sizeIndex:])
+	if size > MaxEntrySize {
+		return nil, ErrCorrupt
+	}
+
+	entry := &Entry{
+		Stream:    stream,
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[timestampIndex:]))),
+		Data:      make([]byte, size),
+	}
+
+	if _, err := io.ReadFull(r.r, entry.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	r.offset += int64(headerLen + size)
+	return entry, nil
+}
+
+// Offset returns the number of bytes consumed by the complete entries returned so far. Reading of
+// a log that is still being written can be resumed from this offset.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// String returns the name of the stream
+func (s Stream) String() string {
+	switch s {
+	case Stdout:
+		return "stdout"
+	case Stderr:
+		return "stderr"
+	default:
+		return fmt.Sprintf("stream(%d)", byte(s))
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iolog
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+
+	now := time.Unix(1470000000, 123456789)
+	stdout := NewWriter(&buf, Stdout).(*writer)
+	stdout.clock = func() time.Time { return now }
+	stderr := NewWriter(&buf, Stderr).(*writer)
+	stderr.clock = func() time.Time { return now.Add(time.Second) }
+
+	n, err := stdout.Write([]byte("one\ntwo\nthr"))
+	assert.NoError(t, err)
+	assert.Equal(t, 11, n)
+
+	_, err = stderr.Write([]byte("err\n"))
+	assert.NoError(t, err)
+
+	expected := []Entry{
+		{Stdout, now, []byte("one\n")},
+		{Stdout, now, []byte("two\n")},
+		{Stdout, now, []byte("thr")},
+		{Stderr, now.Add(time.Second), []byte("err\n")},
+	}
+
+	size := int64(buf.Len())
+	r := NewReader(&buf)
+	for _, e := range expected {
+		entry, err := r.Next()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, e.Stream, entry.Stream)
+		assert.True(t, e.Timestamp.Equal(entry.Timestamp), "timestamp %s != %s", e.Timestamp, entry.Timestamp)
+		assert.Equal(t, e.Data, entry.Data)
+	}
+
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, size, r.Offset())
+}
+
+func TestPartialEntry(t *testing.T) {
+	var buf bytes.Buffer
+
+	entry := &Entry{Stream: Stdout, Timestamp: time.Now(), Data: []byte("complete\n")}
+	_, err := entry.WriteTo(&buf)
+	assert.NoError(t, err)
+	complete := int64(buf.Len())
+
+	entry.Data = []byte("partial\n")
+	_, err = entry.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	// drop the tail of the second entry as if it were still being written
+	r := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+
+	_, err = r.Next()
+	assert.NoError(t, err)
+
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, complete, r.Offset(), "offset should only cover complete entries")
+}
+
+func TestCorrupt(t *testing.T) {
+	r := NewReader(bytes.NewReader(bytes.Repeat([]byte{0xff}, headerLen)))
+
+	_, err := r.Next()
+	assert.Equal(t, ErrCorrupt, err)
+}
@@ -0,0 +1,182 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	caFileName         = "ca.crt"
+	clientCertFileName = "client.cert"
+	clientKeyFileName  = "client.key"
+)
+
+// Certificates holds the certificates used when talking to a registry
+type Certificates struct {
+	// Registry is the registry the certificates are for, as host[:port]
+	Registry string
+
+	// CAs holds the PEM encoded CAs to validate the registry with
+	CAs []byte
+
+	// Cert and Key hold the PEM encoded certificate presented to the registry
+	Cert []byte
+	Key  []byte
+}
+
+// ReadCertsDir reads the certificates for registries from a directory laid out
+// the way docker lays out /etc/docker/certs.d.  The directory holds a directory
+// named after each registry, as host[:port], with CAs in .crt files and a
+// client certificate in a .cert file next to a .key file of the same name.
+func ReadCertsDir(dir string) ([]Certificates, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []Certificates
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		c, err := readRegistryDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c.Registry = entry.Name()
+
+		certs = append(certs, *c)
+	}
+
+	return certs, nil
+}
+
+func readRegistryDir(dir string) (*Certificates, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Certificates{}
+	for _, f := range files {
+		name := filepath.Join(dir, f.Name())
+
+		switch filepath.Ext(f.Name()) {
+		case ".crt":
+			data, err := ioutil.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			c.CAs = append(c.CAs, data...)
+		case ".cert":
+			if c.Cert != nil {
+				return nil, fmt.Errorf("more than one client certificate in %s", dir)
+			}
+
+			// the key is read along with its certificate
+			keyName := strings.TrimSuffix(name, ".cert") + ".key"
+			if c.Cert, err = ioutil.ReadFile(name); err != nil {
+				return nil, err
+			}
+			if c.Key, err = ioutil.ReadFile(keyName); err != nil {
+				return nil, fmt.Errorf("missing key for client certificate %s: %s", name, err)
+			}
+
+			// fail here rather than when the registry is first used
+			if _, err = tls.X509KeyPair(c.Cert, c.Key); err != nil {
+				return nil, fmt.Errorf("invalid client certificate %s: %s", name, err)
+			}
+		}
+	}
+
+	if len(c.CAs) > 0 && !x509.NewCertPool().AppendCertsFromPEM(c.CAs) {
+		return nil, fmt.Errorf("no valid CA certificates in %s", dir)
+	}
+
+	return c, nil
+}
+
+// WriteCertsDir writes the certificates to dir in the layout ReadCertsDir reads
+func WriteCertsDir(dir string, certs []Certificates) error {
+	for _, c := range certs {
+		if c.Registry == "" || strings.ContainsAny(c.Registry, `/\`) {
+			return fmt.Errorf("invalid registry %q", c.Registry)
+		}
+
+		rdir := filepath.Join(dir, c.Registry)
+		if err := os.MkdirAll(rdir, 0700); err != nil {
+			return err
+		}
+
+		files := map[string][]byte{
+			caFileName:         c.CAs,
+			clientCertFileName: c.Cert,
+			clientKeyFileName:  c.Key,
+		}
+		for name, data := range files {
+			if len(data) == 0 {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(rdir, name), data, 0600); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadCertificates returns the CAs to validate the registry with, on top of
+// the system CAs, and the certificates to present to it, as held in a directory
+// laid out the way ReadCertsDir reads.  The pool is nil if there are no CAs
+// for the registry.
+func LoadCertificates(dir, registry string) (*x509.CertPool, []tls.Certificate, error) {
+	rdir := filepath.Join(dir, registry)
+	if _, err := os.Stat(rdir); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	c, err := readRegistryDir(rdir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pool *x509.CertPool
+	if len(c.CAs) > 0 {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AppendCertsFromPEM(c.CAs)
+	}
+
+	var certificates []tls.Certificate
+	if len(c.Cert) > 0 {
+		cert, err := tls.X509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		certificates = append(certificates, cert)
+	}
+
+	return pool, certificates, nil
+}
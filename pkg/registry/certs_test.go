@@ -0,0 +1,96 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keyPair returns a PEM encoded self signed certificate and its key
+func keyPair(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "registry.example.com"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestCertsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs.d")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := keyPair(t)
+	certs := []Certificates{
+		{Registry: "registry.example.com:5000", CAs: cert},
+		{Registry: "secure.example.com", CAs: cert, Cert: cert, Key: key},
+	}
+	if !assert.NoError(t, WriteCertsDir(dir, certs)) {
+		return
+	}
+
+	read, err := ReadCertsDir(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, certs, read)
+
+	pool, clientCerts, err := LoadCertificates(dir, "secure.example.com")
+	assert.NoError(t, err)
+	assert.NotNil(t, pool)
+	assert.Len(t, clientCerts, 1)
+
+	// nothing is held for a registry without a directory
+	pool, clientCerts, err = LoadCertificates(dir, "other.example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, pool)
+	assert.Empty(t, clientCerts)
+
+	// a registry can't escape the directory
+	assert.Error(t, WriteCertsDir(dir, []Certificates{{Registry: "../etc"}}))
+}
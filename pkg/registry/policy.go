@@ -0,0 +1,140 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry holds what the components of a VCH share about the image
+// registries they talk to.
+package registry
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// the names Docker Hub is known by, which are treated as one registry
+var hubHosts = map[string]bool{
+	"docker.io":            true,
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// IsDockerHub reports whether the registry, given as host[:port], is Docker Hub
+func IsDockerHub(registry string) bool {
+	host, _ := splitHostPort(registry)
+	return hubHosts[strings.ToLower(host)]
+}
+
+// Policy decides which registries images may be pulled from.  A registry on
+// the blacklist is refused, as is one missing from a non-empty whitelist.
+type Policy struct {
+	Whitelist []url.URL
+	Blacklist []url.URL
+}
+
+// ParseEntry parses a whitelist or blacklist entry.  An entry is a registry
+// host with an optional port, such as registry.example.com:5000, a URL, or a
+// wildcard covering the subdomains of a domain, such as *.example.com.
+func ParseEntry(entry string) (*url.URL, error) {
+	s := strings.TrimSpace(entry)
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry %q", entry)
+	}
+
+	return u, nil
+}
+
+// ParseEntries parses a list of whitelist or blacklist entries
+func ParseEntries(entries []string) ([]url.URL, error) {
+	var urls []url.URL
+	for _, entry := range entries {
+		u, err := ParseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, *u)
+	}
+
+	return urls, nil
+}
+
+// Hosts returns the registries the entries of a list refer to, as given to ParseEntry
+func Hosts(entries []url.URL) []string {
+	hosts := make([]string, len(entries))
+	for i := range entries {
+		hosts[i] = entries[i].Host
+	}
+
+	return hosts
+}
+
+// Allowed returns an error explaining why images can't be pulled from the
+// registry, given as host[:port], or nil if they can
+func (p *Policy) Allowed(registry string) error {
+	if Contains(p.Blacklist, registry) {
+		return fmt.Errorf("Access denied to registry %s: it is blacklisted", registry)
+	}
+
+	if len(p.Whitelist) == 0 || Contains(p.Whitelist, registry) {
+		return nil
+	}
+
+	return fmt.Errorf("Access denied to registry %s: it is not whitelisted", registry)
+}
+
+// Contains reports whether any of the entries covers the registry, given as host[:port]
+func Contains(entries []url.URL, registry string) bool {
+	for i := range entries {
+		if matches(&entries[i], registry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether an entry covers the registry.  An entry without a
+// port covers the registry on any port.
+func matches(entry *url.URL, registry string) bool {
+	host, port := splitHostPort(registry)
+	entryHost, entryPort := splitHostPort(entry.Host)
+
+	if entryPort != "" && entryPort != port {
+		return false
+	}
+
+	host = strings.ToLower(host)
+	entryHost = strings.ToLower(entryHost)
+
+	if strings.HasPrefix(entryHost, "*.") {
+		return strings.HasSuffix(host, entryHost[1:])
+	}
+
+	return host == entryHost || (hubHosts[host] && hubHosts[entryHost])
+}
+
+func splitHostPort(hostport string) (string, string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// there's no port
+		return strings.Trim(hostport, "[]"), ""
+	}
+
+	return host, port
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEntries(t *testing.T) {
+	entries, err := ParseEntries([]string{"registry.example.com:5000", "https://*.example.org", " docker.io "})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"registry.example.com:5000", "*.example.org", "docker.io"}, Hosts(entries))
+
+	_, err = ParseEntries([]string{""})
+	assert.Error(t, err)
+}
+
+func TestAllowed(t *testing.T) {
+	whitelist, err := ParseEntries([]string{"registry.example.com:5000", "*.example.org", "docker.io"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	blacklist, err := ParseEntries([]string{"bad.example.org"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// no lists allows everything
+	policy := &Policy{}
+	assert.NoError(t, policy.Allowed("anywhere.com"))
+
+	policy = &Policy{Whitelist: whitelist, Blacklist: blacklist}
+	assert.NoError(t, policy.Allowed("registry.example.com:5000"))
+	assert.NoError(t, policy.Allowed("good.example.org"))
+	assert.NoError(t, policy.Allowed("good.example.org:443"))
+	assert.NoError(t, policy.Allowed("registry-1.docker.io"))
+
+	// wrong port, not whitelisted and blacklisted
+	assert.Error(t, policy.Allowed("registry.example.com"))
+	assert.Error(t, policy.Allowed("anywhere.com"))
+	assert.Error(t, policy.Allowed("example.org"))
+	assert.Error(t, policy.Allowed("bad.example.org"))
+
+	// the blacklist alone refuses only what it lists
+	policy = &Policy{Blacklist: blacklist}
+	assert.NoError(t, policy.Allowed("anywhere.com"))
+	assert.Error(t, policy.Allowed("BAD.example.org:5000"))
+}
@@ -119,6 +119,13 @@ func (m *Manager) CreateAndAttach(ctx context.Context, newDiskURI,
 	return d, nil
 }
 
+// Attach attaches an existing disk, given as a Datastore URI path, to this
+// vm.  The disk is attached nonpersistent so anything written through the
+// returned VirtualDisk is discarded when it's detached.
+func (m *Manager) Attach(ctx context.Context, diskURI string) (*VirtualDisk, error) {
+	return m.CreateAndAttach(ctx, diskURI, "", 0, os.O_RDONLY)
+}
+
 func (m *Manager) createDiskSpec(childURI, parentURI string, capacity int64, flags int) *types.VirtualDisk {
 	// TODO: migrate this method to govmomi CreateDisk method
 	backing := &types.VirtualDiskFlatVer2BackingInfo{
@@ -19,7 +19,9 @@ import (
 	"log"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/methods"
@@ -30,6 +32,10 @@ import (
 
 type PropertyCollector struct {
 	mo.PropertyCollector
+
+	// version and last track the updates most recently returned by WaitForUpdatesEx
+	version int
+	last    []types.PropertyFilterUpdate
 }
 
 func NewPropertyCollector(ref types.ManagedObjectReference) object.Reference {
@@ -410,31 +416,23 @@ func (pc *PropertyCollector) RetrieveProperties(r *types.RetrieveProperties) soa
 	return body
 }
 
-func (pc *PropertyCollector) WaitForUpdatesEx(r *types.WaitForUpdatesEx) soap.HasFault {
-	body := &methods.WaitForUpdatesExBody{}
-
-	// At the moment we need to support Task completion.  Handlers can simply set the Task
-	// state before returning and the non-incremental update is enough for the client.
-	// We can wait for incremental updates to simulate timeouts, etc.
-	if r.Version != "" {
-		body.Fault_ = Fault("incremental updates not supported yet", &types.NotSupported{})
-		return body
-	}
-
-	update := &types.UpdateSet{
-		Version: "-",
-	}
+// updates collects the current values of each filter
+func (pc *PropertyCollector) updates() ([]types.PropertyFilterUpdate, types.BaseMethodFault) {
+	var set []types.PropertyFilterUpdate
 
 	for _, ref := range pc.Filter {
-		filter := Map.Get(ref).(*PropertyFilter)
+		// the collector may be destroyed while a wait is polling it
+		filter, ok := Map.Get(ref).(*PropertyFilter)
+		if !ok {
+			return nil, &types.ManagedObjectNotFound{Obj: ref}
+		}
 
 		r := &types.RetrievePropertiesEx{}
 		r.SpecSet = append(r.SpecSet, filter.Spec)
 
 		res, fault := pc.collect(r)
 		if fault != nil {
-			body.Fault_ = Fault("", fault)
-			return body
+			return nil, fault
 		}
 
 		fu := types.PropertyFilterUpdate{
@@ -458,12 +456,68 @@ func (pc *PropertyCollector) WaitForUpdatesEx(r *types.WaitForUpdatesEx) soap.Ha
 			fu.ObjectSet = append(fu.ObjectSet, ou)
 		}
 
-		update.FilterSet = append(update.FilterSet, fu)
+		set = append(set, fu)
 	}
 
-	body.Res = &types.WaitForUpdatesExResponse{
-		Returnval: update,
+	return set, nil
+}
+
+func (pc *PropertyCollector) WaitForUpdatesEx(r *types.WaitForUpdatesEx) soap.HasFault {
+	body := &methods.WaitForUpdatesExBody{}
+
+	// The first call returns the current values of all filters.  Incremental updates are
+	// simulated by polling the filters until their values differ from those last returned,
+	// giving up after MaxWaitSeconds (or a second if unset) with an empty result, which
+	// the client treats as a cue to wait again.
+	wait := time.Second
+	if r.Options != nil && r.Options.MaxWaitSeconds > 0 {
+		wait = time.Duration(r.Options.MaxWaitSeconds) * time.Second
 	}
+	deadline := time.Now().Add(wait)
 
-	return body
+	for {
+		set, fault := pc.updates()
+		if fault != nil {
+			body.Fault_ = Fault("", fault)
+			return body
+		}
+
+		if r.Version == "" || !reflect.DeepEqual(set, pc.last) {
+			pc.last = set
+			pc.version++
+
+			// copy the update before marking it as a modification so the comparison
+			// against pc.last is not disturbed on the next call
+			update := make([]types.PropertyFilterUpdate, len(set))
+			copy(update, set)
+
+			if r.Version != "" {
+				for i := range update {
+					objects := make([]types.ObjectUpdate, len(update[i].ObjectSet))
+					copy(objects, update[i].ObjectSet)
+
+					for j := range objects {
+						objects[j].Kind = types.ObjectUpdateKindModify
+					}
+					update[i].ObjectSet = objects
+				}
+			}
+
+			body.Res = &types.WaitForUpdatesExResponse{
+				Returnval: &types.UpdateSet{
+					Version:   strconv.Itoa(pc.version),
+					FilterSet: update,
+				},
+			}
+
+			return body
+		}
+
+		if time.Now().After(deadline) {
+			body.Res = &types.WaitForUpdatesExResponse{}
+			return body
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
 }
@@ -17,6 +17,7 @@ package simulator
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -277,10 +278,12 @@ func TestWaitForUpdates(t *testing.T) {
 		t.Error(err)
 	}
 
-	// incremental updates not yet suppported
-	err = property.Wait(ctx, pc, folder.Reference(), props, cb(false))
-	if err == nil {
-		t.Error("expected error")
+	// incremental updates wait for a change until the caller gives up
+	tctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	err = property.Wait(tctx, pc, folder.Reference(), props, cb(false))
+	cancel()
+	if tctx.Err() != context.DeadlineExceeded {
+		t.Errorf("expected timeout, got %s", err)
 	}
 
 	// test object not found
@@ -98,6 +98,12 @@ func (vm *VirtualMachine) configure(spec *types.VirtualMachineConfigSpec) types.
 		return err
 	}
 
+	// a reconfigure spec usually leaves the files alone
+	files := spec.Files
+	if files == nil {
+		files = new(types.VirtualMachineFileInfo)
+	}
+
 	apply := []struct {
 		src string
 		dst *string
@@ -112,9 +118,9 @@ func (vm *VirtualMachine) configure(spec *types.VirtualMachineConfigSpec) types.
 		{spec.GuestId, &vm.Summary.Config.GuestFullName},
 		{spec.Uuid, &vm.Config.Uuid},
 		{spec.Version, &vm.Config.Version},
-		{spec.Files.VmPathName, &vm.Config.Files.VmPathName},
-		{spec.Files.SnapshotDirectory, &vm.Config.Files.SnapshotDirectory},
-		{spec.Files.LogDirectory, &vm.Config.Files.LogDirectory},
+		{files.VmPathName, &vm.Config.Files.VmPathName},
+		{files.SnapshotDirectory, &vm.Config.Files.SnapshotDirectory},
+		{files.LogDirectory, &vm.Config.Files.LogDirectory},
 	}
 
 	for _, f := range apply {
@@ -133,11 +139,29 @@ func (vm *VirtualMachine) configure(spec *types.VirtualMachineConfigSpec) types.
 		vm.Summary.Config.NumCpu = vm.Config.Hardware.NumCPU
 	}
 
+	for _, option := range spec.ExtraConfig {
+		vm.setExtraConfig(option)
+	}
+
 	vm.Config.Modified = time.Now()
 
 	return nil
 }
 
+// setExtraConfig replaces the value of an existing key or adds the key if it's new
+func (vm *VirtualMachine) setExtraConfig(option types.BaseOptionValue) {
+	key := option.GetOptionValue().Key
+
+	for i, existing := range vm.Config.ExtraConfig {
+		if existing.GetOptionValue().Key == key {
+			vm.Config.ExtraConfig[i] = option
+			return
+		}
+	}
+
+	vm.Config.ExtraConfig = append(vm.Config.ExtraConfig, option)
+}
+
 func (vm *VirtualMachine) useDatastore(name string) *Datastore {
 	host := Map.Get(*vm.Runtime.Host).(*HostSystem)
 
@@ -237,7 +261,7 @@ func (vm *VirtualMachine) configureDevices(spec *types.VirtualMachineConfigSpec)
 			if devices.FindByKey(device.Key) != nil {
 				return invalid
 			}
-			devices = append(devices, device)
+			devices = append(devices, dspec.Device)
 		}
 	}
 
@@ -305,6 +329,30 @@ func (vm *VirtualMachine) PowerOffVMTask(c *types.PowerOffVM_Task) soap.HasFault
 	return r
 }
 
+type reconfigVMTask struct {
+	*VirtualMachine
+
+	spec *types.VirtualMachineConfigSpec
+}
+
+func (c *reconfigVMTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
+	return nil, c.configure(c.spec)
+}
+
+func (vm *VirtualMachine) ReconfigVMTask(c *types.ReconfigVM_Task) soap.HasFault {
+	r := &methods.ReconfigVM_TaskBody{}
+
+	task := NewTask(&reconfigVMTask{vm, &c.Spec})
+
+	r.Res = &types.ReconfigVM_TaskResponse{
+		Returnval: task.Self,
+	}
+
+	task.Run()
+
+	return r
+}
+
 type destroyVMTask struct {
 	*VirtualMachine
 }
@@ -336,3 +384,49 @@ func (vm *VirtualMachine) DestroyTask(c *types.Destroy_Task) soap.HasFault {
 
 	return r
 }
+
+type createSnapshotTask struct {
+	*VirtualMachine
+
+	req *types.CreateSnapshot_Task
+}
+
+func (c *createSnapshotTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
+	snapshot := &VirtualMachineSnapshot{}
+	snapshot.Vm = c.Reference()
+	snapshot.Config = *c.Config
+
+	Map.Put(snapshot)
+
+	if c.Snapshot == nil {
+		c.Snapshot = &types.VirtualMachineSnapshotInfo{}
+	}
+
+	// snapshots are kept as a flat list rather than a tree
+	c.Snapshot.RootSnapshotList = append(c.Snapshot.RootSnapshotList, types.VirtualMachineSnapshotTree{
+		Snapshot:    snapshot.Self,
+		Vm:          snapshot.Vm,
+		Name:        c.req.Name,
+		Description: c.req.Description,
+		CreateTime:  time.Now(),
+		State:       c.Runtime.PowerState,
+		Quiesced:    c.req.Quiesce,
+	})
+	c.Snapshot.CurrentSnapshot = &snapshot.Self
+
+	return snapshot.Self, nil
+}
+
+func (vm *VirtualMachine) CreateSnapshotTask(c *types.CreateSnapshot_Task) soap.HasFault {
+	r := &methods.CreateSnapshot_TaskBody{}
+
+	task := NewTask(&createSnapshotTask{vm, c})
+
+	r.Res = &types.CreateSnapshot_TaskResponse{
+		Returnval: task.Self,
+	}
+
+	task.Run()
+
+	return r
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simulator
+
+import (
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type VirtualMachineSnapshot struct {
+	mo.VirtualMachineSnapshot
+}
+
+type removeSnapshotTask struct {
+	*VirtualMachineSnapshot
+}
+
+func (c *removeSnapshotTask) Run(task *Task) (types.AnyType, types.BaseMethodFault) {
+	vm := Map.Get(c.Vm).(*VirtualMachine)
+
+	var snapshots []types.VirtualMachineSnapshotTree
+	for _, tree := range vm.Snapshot.RootSnapshotList {
+		if tree.Snapshot != c.Self {
+			snapshots = append(snapshots, tree)
+		}
+	}
+
+	if len(snapshots) == 0 {
+		vm.Snapshot = nil
+	} else {
+		vm.Snapshot.RootSnapshotList = snapshots
+		vm.Snapshot.CurrentSnapshot = &snapshots[len(snapshots)-1].Snapshot
+	}
+
+	Map.Remove(c.Self)
+
+	return nil, nil
+}
+
+func (s *VirtualMachineSnapshot) RemoveSnapshotTask(c *types.RemoveSnapshot_Task) soap.HasFault {
+	r := &methods.RemoveSnapshot_TaskBody{}
+
+	task := NewTask(&removeSnapshotTask{s})
+
+	r.Res = &types.RemoveSnapshot_TaskResponse{
+		Returnval: task.Self,
+	}
+
+	task.Run()
+
+	return r
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 	"golang.org/x/net/context"
@@ -209,3 +210,19 @@ func (vm *VirtualMachine) WaitForKeyInExtraConfig(ctx context.Context, key strin
 	}
 	return detail, nil
 }
+
+// RemoveSnapshot removes the given snapshot of the virtual machine, leaving any other snapshots in place
+func (vm *VirtualMachine) RemoveSnapshot(ctx context.Context, snapshot types.ManagedObjectReference, removeChildren bool, consolidate *bool) (*object.Task, error) {
+	req := types.RemoveSnapshot_Task{
+		This:           snapshot,
+		RemoveChildren: removeChildren,
+		Consolidate:    consolidate,
+	}
+
+	res, err := methods.RemoveSnapshot_Task(ctx, vm.Vim25(), &req)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.NewTask(vm.Vim25(), res.Returnval), nil
+}